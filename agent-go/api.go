@@ -0,0 +1,307 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Agent 本地 HTTP 接口：查看本节点实例（进程 / 容器、资源占用、日志）、手动停止 / 重启实例和在实例中执行命令。
+// 配置了 AGENT_API_TOKEN 时所有请求须携带 Authorization: Bearer <token>；
+// 未配置时只开放只读接口。
+
+var (
+	errNotAssigned = errors.New("instance not assigned to this node")
+	errNotDesired  = errors.New("instance desired state is not Running")
+	errNoManager   = errors.New("no app manager for instance type")
+	errStartFailed = errors.New("instance failed to start")
+	errBusy        = errors.New("instance is being started or stopped")
+)
+
+// InstanceInfo 本地接口返回的实例信息
+type InstanceInfo struct {
+	InstanceID   string    `json:"instanceId"`
+	DeploymentID string    `json:"deploymentId,omitempty"`
+	AppName      string    `json:"appName,omitempty"`
+	Type         string    `json:"type"`              // zip | image
+	Desired      string    `json:"desired,omitempty"` // 空表示不在当前分配中
+	Running      bool      `json:"running"`
+	Pid          int       `json:"pid,omitempty"`
+	ContainerID  string    `json:"containerId,omitempty"`
+	ExitCode     int       `json:"exitCode"`
+	Ready        bool      `json:"ready"`
+	RestartCount int       `json:"restartCount"`
+	ManualStop   bool      `json:"manualStop,omitempty"` // 已通过本地接口停止
+	Usage        *AppUsage `json:"usage,omitempty"`
+	// 生命周期钩子最近一次执行结果
+	Hooks []HookResult `json:"hooks,omitempty"`
+	// 分配的宿主机端口（服务名 -> 端口）
+	Ports map[string]int `json:"ports,omitempty"`
+}
+
+// StartAPIServer 启动 Agent 本地 HTTP 接口（Controller 按需调用，如转发实例日志）
+func StartAPIServer(addr string, r *Reconciler) {
+	token := os.Getenv("AGENT_API_TOKEN")
+	if token == "" {
+		LogWarn("AGENT_API_TOKEN not set: agent API is read-only and unauthenticated")
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/node", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, r.CollectResources())
+	})
+	mux.HandleFunc("/v1/instances", func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, map[string]any{"items": r.listInstances()})
+	})
+	mux.HandleFunc("/v1/instances/", func(w http.ResponseWriter, req *http.Request) {
+		rest := strings.TrimPrefix(req.URL.Path, "/v1/instances/")
+		id, sub, _ := strings.Cut(rest, "/")
+		if id == "" || strings.Contains(id, "..") {
+			http.NotFound(w, req)
+			return
+		}
+		switch sub {
+		case "":
+			if req.Method != http.MethodGet {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			info, ok := r.instanceInfo(id)
+			if !ok {
+				http.Error(w, "instance not found", http.StatusNotFound)
+				return
+			}
+			writeJSON(w, info)
+		case "logs":
+			r.handleInstanceLogs(w, req, id)
+		case "exec":
+			if token == "" {
+				http.Error(w, "exec requires AGENT_API_TOKEN", http.StatusForbidden)
+				return
+			}
+			r.handleInstanceExec(w, req, id)
+		case "stop", "restart":
+			if req.Method != http.MethodPost {
+				http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+				return
+			}
+			if token == "" {
+				http.Error(w, "actions require AGENT_API_TOKEN", http.StatusForbidden)
+				return
+			}
+			var err error
+			if sub == "stop" {
+				err = r.stopInstance(id)
+			} else {
+				err = r.restartInstance(id)
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			}
+			info, _ := r.instanceInfo(id)
+			writeJSON(w, info)
+		default:
+			http.NotFound(w, req)
+		}
+	})
+	go func() {
+		LogInfo("Agent API listening on %s", addr)
+		if err := http.ListenAndServe(addr, withToken(token, mux)); err != nil {
+			LogError("Agent API server stopped: %v", err)
+		}
+	}()
+}
+
+// withToken 校验共享令牌（未配置令牌时不校验）
+func withToken(token string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if token != "" {
+			got := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// listInstances 当前分配中的实例以及本节点上仍在运行的其它实例
+func (r *Reconciler) listInstances() []InstanceInfo {
+	ids := r.runningInstances()
+	r.mu.Lock()
+	for id := range r.assignments {
+		ids[id] = true
+	}
+	r.mu.Unlock()
+	out := make([]InstanceInfo, 0, len(ids))
+	for id := range ids {
+		if info, ok := r.instanceInfo(id); ok {
+			out = append(out, info)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].InstanceID < out[j].InstanceID })
+	return out
+}
+
+// instanceInfo 实例的运行状态和资源占用（既不在分配中也未运行时返回 false）。
+// 持锁只读取协调器状态，管理器查询（Docker 接口、/proc）在锁外进行
+func (r *Reconciler) instanceInfo(id string) (InstanceInfo, bool) {
+	r.mu.Lock()
+	a, assigned := r.assignments[id]
+	artifactType, known := r.instanceTypes[id]
+	info := InstanceInfo{
+		InstanceID:   id,
+		DeploymentID: a.DeploymentID,
+		AppName:      a.AppName,
+		Type:         "zip",
+		Desired:      a.Desired,
+		ExitCode:     -1,
+		RestartCount: r.restartCount(id),
+		ManualStop:   r.held[id],
+		Hooks:        r.hookResults(id),
+		Ports:        r.ports.byInstance[id],
+	}
+	if a.ArtifactType == "image" || artifactType == "image" {
+		info.Type = "image"
+	}
+	r.mu.Unlock()
+
+	var appManager AppManager
+	switch {
+	case assigned:
+		appManager = r.getAppManager(a.ArtifactType)
+	case known:
+		appManager = r.getAppManager(artifactType)
+	default:
+		appManager = r.findAppManager(id)
+	}
+	if appManager == nil {
+		return InstanceInfo{}, false
+	}
+	running := appManager.IsRunning(id)
+	if !assigned && !running {
+		return InstanceInfo{}, false
+	}
+	info.Running = running
+	info.Ready = running && r.prober.Ready(id)
+	if status, err := appManager.GetStatus(id); err == nil {
+		info.Pid = status.Pid
+		info.ContainerID = status.ContainerID
+		info.ExitCode = status.ExitCode
+	}
+	if pm, ok := appManager.(*ProcessManager); ok && running && info.Pid == 0 {
+		info.Pid, _ = pm.readPID(id)
+	}
+	if running {
+		if u, err := appManager.Usage(id); err == nil {
+			info.Usage = &u
+		}
+	}
+	return info, true
+}
+
+// stopInstance 手动停止实例：停止后不再自动拉起，直到手动重启或期望状态变化
+func (r *Reconciler) stopInstance(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.assignments[id]
+	if !ok {
+		return errNotAssigned
+	}
+	appManager := r.getAppManager(a.ArtifactType)
+	if appManager == nil {
+		return errNoManager
+	}
+	if r.busy[id] {
+		return errBusy
+	}
+	r.held[id] = true
+	r.haltInstance(id, appManager)
+	r.postStatus(id, "Stopped", 0, false)
+	r.persistState()
+	LogInfo("Instance %s stopped via agent API", id)
+	return nil
+}
+
+// restartInstance 手动重启实例（清除手动停止标记和崩溃退避，立即启动）
+func (r *Reconciler) restartInstance(id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	a, ok := r.assignments[id]
+	if !ok {
+		return errNotAssigned
+	}
+	if a.Desired != "Running" {
+		return errNotDesired
+	}
+	appManager := r.getAppManager(a.ArtifactType)
+	if appManager == nil {
+		return errNoManager
+	}
+	if r.busy[id] {
+		return errBusy
+	}
+	if appManager.IsRunning(id) {
+		r.restartStartTimes[id] = time.Now()
+		r.haltInstance(id, appManager)
+		// 停止期间释放了锁，分配可能已变化
+		if a, ok = r.assignments[id]; !ok {
+			return errNotAssigned
+		}
+		if a.Desired != "Running" {
+			return errNotDesired
+		}
+	}
+	delete(r.held, id)
+	if st, ok := r.restarts[id]; ok {
+		st.crashes = 0
+		st.finished = false
+		st.nextStart = time.Time{}
+	}
+	r.persistState()
+	LogInfo("Instance %s restarting via agent API", id)
+	launch := r.prepareStart(a)
+	if launch == nil {
+		return errStartFailed
+	}
+	r.runInstance(id, launch)
+	if !appManager.IsRunning(id) {
+		return errStartFailed
+	}
+	return nil
+}
+
+// haltInstance 停止实例并摘除探针和服务（不视为崩溃）。调用时持有 r.mu，
+// 执行 preStop 钩子和等待退出期间释放锁，实例标记为 busy 使同步跳过它
+func (r *Reconciler) haltInstance(id string, appManager AppManager) {
+	r.forgetProbes(id)
+	r.deleteServices(id)
+	if st, ok := r.restarts[id]; ok {
+		st.running = false
+	}
+	h := r.hooks[id]
+	r.runInstance(id, func() {
+		if err := r.terminate(appManager, id, h); err != nil {
+			LogError("Failed to stop instance %s: %v", id, err)
+		}
+	})
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"strings"
+	"time"
 )
 
 // AppManager 应用管理器接口
@@ -17,7 +19,8 @@ type AppManager interface {
 
 	// StopApp 停止应用
 	// instanceID: 实例ID
-	StopApp(instanceID string) error
+	// grace: 发送 SIGTERM 后等待退出的时间，超时强制结束（0 使用管理器默认值）
+	StopApp(instanceID string, grace time.Duration) error
 
 	// IsRunning 检查应用是否正在运行
 	// instanceID: 实例ID
@@ -32,6 +35,20 @@ type AppManager interface {
 	// ListRunning 列出所有运行中的实例ID
 	// 返回：运行中的实例ID列表
 	ListRunning() []string
+
+	// Usage 获取应用的资源使用情况
+	// instanceID: 实例ID
+	Usage(instanceID string) (AppUsage, error)
+
+	// UpdateLimits 就地更新运行中应用的资源限制
+	// instanceID: 实例ID
+	// limits: 新的资源限制（零值表示不限制）
+	UpdateLimits(instanceID string, limits Limits) error
+
+	// Exec 在运行中的实例里执行命令，返回退出码（ctx 取消时结束命令）
+	// instanceID: 实例ID
+	// opts: 命令及标准输入输出
+	Exec(ctx context.Context, instanceID string, opts ExecOptions) (int, error)
 }
 
 // AppStatus 应用状态
@@ -41,6 +58,14 @@ type AppStatus struct {
 	Pid         int    // 进程模式：进程ID；容器模式：容器PID（通常为0）
 	ContainerID string // 容器模式：容器ID；进程模式：空
 	ExitCode    int    // 退出码（仅当 Running=false 时有效，0表示正常退出，非0表示异常退出）
+	OOMKilled   bool   // 因超出内存限制被杀死（仅当 Running=false 时有效）
+}
+
+// AppUsage 应用资源使用情况
+type AppUsage struct {
+	CPUSeconds float64 `json:"cpuSeconds"` // 累计 CPU 时间
+	MemoryMB   float64 `json:"memoryMB"`   // 常驻内存
+	Processes  int     `json:"processes"`  // 进程数
 }
 
 // ManagerConfig 管理器配置
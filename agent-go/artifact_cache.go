@@ -0,0 +1,295 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 制品缓存：ZIP 制品按 SHA256 内容寻址存放在 <缓存目录>/<sha256>.zip，同一制品的实例共享，
+// 超过容量上限时按最近使用时间（文件 mtime）淘汰。未缓存的制品在后台下载（不阻塞同步循环），
+// 先写入 .part 文件，中断后用 Range 请求续传（弱网下的大文件），完成后校验 SHA256，不一致则丢弃并上报。
+// 下载失败（续传次数用尽、服务端拒绝或校验不一致）后退避，期间不再下载该制品。
+
+const ReasonChecksumMismatch = "ArtifactChecksumMismatch"
+
+var (
+	errChecksumMismatch = errors.New("artifact checksum mismatch")
+	errDownloadBackoff  = errors.New("artifact download failed recently, waiting before retry")
+	errDownloadPending  = errors.New("artifact download in progress")
+	errDownloadRejected = errors.New("artifact download rejected")
+)
+
+// ArtifactCache 内容寻址的制品缓存
+type ArtifactCache struct {
+	dir         string
+	maxBytes    int64
+	retries     int           // 单次获取中断后的续传次数
+	idleTimeout time.Duration // 下载无进展超时
+
+	mu       sync.Mutex
+	pending  map[string]bool             // 正在后台下载的制品
+	failures map[string]*downloadFailure // 下载或校验失败的制品（退避后重试）
+	onDone   func()                      // 后台下载结束时的回调（触发下一轮同步）
+}
+
+type downloadFailure struct {
+	count     int
+	nextRetry time.Time
+	err       error // 尚未返回给调用方的失败原因（只返回一次，之后返回 errDownloadBackoff）
+}
+
+func NewArtifactCache(dir string) *ArtifactCache {
+	c := &ArtifactCache{
+		dir:         dir,
+		maxBytes:    2048 << 20,
+		retries:     5,
+		idleTimeout: 60 * time.Second,
+		pending:     make(map[string]bool),
+		failures:    make(map[string]*downloadFailure),
+	}
+	if n, err := strconv.Atoi(os.Getenv("AGENT_ARTIFACT_CACHE_MAX_MB")); err == nil && n > 0 {
+		c.maxBytes = int64(n) << 20
+	}
+	if n, err := strconv.Atoi(os.Getenv("ARTIFACT_DOWNLOAD_RETRIES")); err == nil && n >= 0 {
+		c.retries = n
+	}
+	EnsureDir(dir)
+	return c
+}
+
+// cacheKey 有校验和时按内容寻址；旧版 Controller 未下发校验和时按 URL 寻址（不校验）
+func cacheKey(url, sha string) string {
+	if sha != "" {
+		return strings.ToLower(sha)
+	}
+	sum := sha256.Sum256([]byte(url))
+	return "url-" + hex.EncodeToString(sum[:])
+}
+
+// SetOnDone 后台下载结束（成功或失败）时的回调
+func (c *ArtifactCache) SetOnDone(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onDone = fn
+}
+
+// Fetch 返回制品在缓存中的路径。未缓存时启动后台下载并返回 errDownloadPending，调用方在下载结束后重试；
+// 最近一次下载失败后的退避期内不下载：首次返回失败原因，之后返回 errDownloadBackoff
+func (c *ArtifactCache) Fetch(url, sha string, size int64) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	key := cacheKey(url, sha)
+	path := filepath.Join(c.dir, key+".zip")
+	if fi, err := os.Stat(path); err == nil && (size <= 0 || fi.Size() == size) {
+		now := time.Now()
+		os.Chtimes(path, now, now) // 记录最近使用时间
+		return path, nil
+	}
+	if c.pending[key] {
+		return "", errDownloadPending
+	}
+	if f, ok := c.failures[key]; ok && time.Now().Before(f.nextRetry) {
+		if err := f.err; err != nil {
+			f.err = nil
+			return "", err
+		}
+		return "", errDownloadBackoff
+	}
+	c.pending[key] = true
+	go c.fetch(key, url, sha, path)
+	return "", errDownloadPending
+}
+
+// fetch 后台下载并校验制品（不持有锁），失败时记录退避
+func (c *ArtifactCache) fetch(key, url, sha, path string) {
+	err := c.downloadVerified(url, sha, path+".part")
+	c.mu.Lock()
+	delete(c.pending, key)
+	if err == nil {
+		delete(c.failures, key)
+		err = os.Rename(path+".part", path)
+		if err == nil {
+			c.evict(path)
+		}
+	}
+	if err != nil {
+		f := c.failures[key]
+		if f == nil {
+			f = &downloadFailure{}
+			c.failures[key] = f
+		}
+		f.count++
+		f.nextRetry = time.Now().Add(min(30*time.Second<<(f.count-1), 10*time.Minute))
+		f.err = err
+	}
+	onDone := c.onDone
+	c.mu.Unlock()
+	if onDone != nil {
+		onDone()
+	}
+}
+
+// downloadVerified 下载到 part 文件并校验 SHA256：中断后续传，服务端拒绝（4xx）时不重试
+func (c *ArtifactCache) downloadVerified(url, sha, part string) error {
+	var err error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * time.Second)
+			LogWarn("Resuming artifact download %s (attempt %d): %v", url, attempt+1, err)
+		}
+		if err = c.download(url, part); err == nil || errors.Is(err, errDownloadRejected) {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if sha == "" {
+		return nil
+	}
+	got, err := fileSHA256(part)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(got, sha) {
+		os.Remove(part)
+		return fmt.Errorf("%w: %s expected sha256 %s, got %s", errChecksumMismatch, url, sha, got)
+	}
+	return nil
+}
+
+// download 下载到 part 文件；已有部分内容时通过 Range 请求续传
+func (c *ArtifactCache) download(url, part string) error {
+	f, err := os.OpenFile(part, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		LogInfo("Resuming artifact download %s from byte %d", url, offset)
+	case http.StatusOK:
+		// 服务端不支持续传：从头写入
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// part 文件已完整（或大于服务端文件），交由校验判断
+		return nil
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return fmt.Errorf("download %s: HTTP %d", url, resp.StatusCode)
+	default:
+		if resp.StatusCode >= 400 && resp.StatusCode < 500 {
+			// 制品不存在或无权访问，重试无意义
+			return fmt.Errorf("%w: %s: HTTP %d", errDownloadRejected, url, resp.StatusCode)
+		}
+		return fmt.Errorf("download %s: HTTP %d", url, resp.StatusCode)
+	}
+
+	// 长时间没有收到数据时中断，保留已下载部分供续传
+	idle := time.AfterFunc(c.idleTimeout, cancel)
+	defer idle.Stop()
+	buf := make([]byte, 256<<10)
+	for {
+		n, rerr := resp.Body.Read(buf)
+		if n > 0 {
+			idle.Reset(c.idleTimeout)
+			if _, err := f.Write(buf[:n]); err != nil {
+				return err
+			}
+		}
+		if rerr == io.EOF {
+			return nil
+		}
+		if rerr != nil {
+			return rerr
+		}
+	}
+}
+
+// evict 超过容量上限时按最近使用时间淘汰（keep 为刚使用的制品，不淘汰）
+func (c *ArtifactCache) evict(keep string) {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	type cached struct {
+		path  string
+		size  int64
+		mtime time.Time
+	}
+	var files []cached
+	var total int64
+	for _, e := range entries {
+		if !strings.HasSuffix(e.Name(), ".zip") {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, cached{filepath.Join(c.dir, e.Name()), fi.Size(), fi.ModTime()})
+		total += fi.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].mtime.Before(files[j].mtime) })
+	for _, f := range files {
+		if total <= c.maxBytes {
+			break
+		}
+		if f.path == keep {
+			continue
+		}
+		if err := os.Remove(f.path); err == nil {
+			total -= f.size
+			LogInfo("Evicted cached artifact %s (%d bytes)", filepath.Base(f.path), f.size)
+		}
+	}
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
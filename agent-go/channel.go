@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/manxisuo/plum/agent/agentpb"
+)
+
+// 控制通道：与 Controller 保持一条 gRPC 双向流（AgentService.Connect）。
+// Controller 在分配变化时立即推送增量（亚秒级生效，弱网下只传变化的分配），
+// 心跳、实例状态和服务端点也经由该流发送。未连接（或 Controller 不支持）时回退到 HTTP 轮询。
+
+var errChannelDown = errors.New("control channel not connected")
+
+// ControlChannel Agent 控制通道
+type ControlChannel struct {
+	addr    string
+	nodeID  string
+	nudge   func()             // 分配变化或连接断开时唤醒主循环
+	onLease func(ack LeaseAck) // 收到心跳应答
+
+	sendMu sync.Mutex
+	stream agentpb.AgentService_ConnectClient
+
+	mu          sync.Mutex
+	connected   bool
+	synced      bool          // 本次连接已收到第一条增量，assignments 为最新
+	up          chan struct{} // 连接建立时关闭
+	token       string        // 续传令牌（跨连接保留）
+	assignments map[string]Assignment
+}
+
+// NewControlChannel 创建控制通道；AGENT_CONTROL_CHANNEL=http 时不启用（Start 不连接）
+func NewControlChannel(controllerBase, nodeID string, nudge func(), onLease func(LeaseAck)) *ControlChannel {
+	c := &ControlChannel{
+		nodeID:      nodeID,
+		nudge:       nudge,
+		onLease:     onLease,
+		up:          make(chan struct{}),
+		assignments: make(map[string]Assignment),
+	}
+	if strings.EqualFold(getEnv("AGENT_CONTROL_CHANNEL", "grpc"), "grpc") {
+		c.addr = getEnv("CONTROLLER_GRPC_ADDR", grpcAddrFromBase(controllerBase))
+	}
+	return c
+}
+
+// grpcAddrFromBase 从 CONTROLLER_BASE 推导 gRPC 地址（主机名 + 9090）
+func grpcAddrFromBase(base string) string {
+	u, err := url.Parse(base)
+	if err != nil || u.Hostname() == "" {
+		return "127.0.0.1:9090"
+	}
+	return u.Hostname() + ":9090"
+}
+
+// Start 在后台保持连接（断开后退避重连）
+func (c *ControlChannel) Start(ctx context.Context) {
+	if c.addr == "" {
+		LogInfo("Control channel disabled, using HTTP polling")
+		return
+	}
+	LogInfo("Control channel: %s", c.addr)
+	go func() {
+		backoff := time.Second
+		for {
+			started := time.Now()
+			err := c.run(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			wait := backoff
+			if status.Code(err) == codes.Unimplemented {
+				// 旧版 Controller 没有 AgentService，继续使用 HTTP，偶尔重试
+				LogWarn("Controller does not support the control channel, using HTTP polling")
+				wait = 5 * time.Minute
+			} else {
+				LogWarn("Control channel disconnected: %v (retry in %v)", err, wait)
+			}
+			if time.Since(started) > time.Minute {
+				backoff = time.Second
+			} else if backoff < 30*time.Second {
+				backoff *= 2
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(wait):
+			}
+		}
+	}()
+}
+
+// run 建立一次连接并处理 Controller 推送，直到断开
+func (c *ControlChannel) run(ctx context.Context) error {
+	conn, err := grpc.NewClient(c.addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := agentpb.NewAgentServiceClient(conn).Connect(streamCtx)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	token := c.token
+	c.mu.Unlock()
+	hello := &agentpb.AgentMessage{Message: &agentpb.AgentMessage_Hello{Hello: &agentpb.AgentHello{NodeId: c.nodeID, ResumeToken: token}}}
+	if err := stream.Send(hello); err != nil {
+		return err
+	}
+	c.sendMu.Lock()
+	c.stream = stream
+	c.sendMu.Unlock()
+	defer c.disconnect()
+
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		switch m := msg.Message.(type) {
+		case *agentpb.ControllerMessage_Delta:
+			c.applyDelta(m.Delta)
+		case *agentpb.ControllerMessage_Lease:
+			if c.onLease != nil {
+				c.onLease(LeaseAck{TTLSec: m.Lease.GetTtlSec(), Fenced: m.Lease.GetFenced()})
+			}
+		}
+	}
+}
+
+// applyDelta 应用期望状态增量，并唤醒主循环
+func (c *ControlChannel) applyDelta(d *agentpb.DesiredStateDelta) {
+	c.mu.Lock()
+	if d.GetFull() {
+		c.assignments = make(map[string]Assignment)
+	}
+	for _, raw := range d.GetUpserts() {
+		var a Assignment
+		if err := json.Unmarshal(raw, &a); err != nil {
+			LogWarn("Ignoring malformed assignment from control channel: %v", err)
+			continue
+		}
+		c.assignments[a.InstanceID] = a
+	}
+	for _, id := range d.GetRemoved() {
+		delete(c.assignments, id)
+	}
+	c.token = d.GetResumeToken()
+	if !c.connected {
+		c.connected = true
+		close(c.up)
+		LogInfo("Control channel connected (full=%v, %d assignments)", d.GetFull(), len(c.assignments))
+	}
+	c.synced = true
+	c.mu.Unlock()
+	LogDebug("Applied desired state delta: full=%v upserts=%d removed=%d", d.GetFull(), len(d.GetUpserts()), len(d.GetRemoved()))
+	c.nudge()
+}
+
+func (c *ControlChannel) disconnect() {
+	c.sendMu.Lock()
+	c.stream = nil
+	c.sendMu.Unlock()
+	c.mu.Lock()
+	if c.connected {
+		c.up = make(chan struct{})
+	}
+	c.connected, c.synced = false, false
+	c.mu.Unlock()
+	c.nudge() // 立即回退到 HTTP
+}
+
+// Connected 控制通道是否可用
+func (c *ControlChannel) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connected
+}
+
+// Up 连接建立时关闭的通道（用于停止 SSE 监听）
+func (c *ControlChannel) Up() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.up
+}
+
+// Assignments 控制通道维护的当前分配；未连接或尚未同步时返回 false
+func (c *ControlChannel) Assignments() ([]Assignment, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.synced {
+		return nil, false
+	}
+	items := make([]Assignment, 0, len(c.assignments))
+	for _, a := range c.assignments {
+		items = append(items, a)
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].InstanceID < items[j].InstanceID })
+	return items, true
+}
+
+func (c *ControlChannel) send(msg *agentpb.AgentMessage) error {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if c.stream == nil {
+		return errChannelDown
+	}
+	return c.stream.Send(msg)
+}
+
+// SendHeartbeat 通过控制通道发送节点心跳（应答经 onLease 返回）
+func (c *ControlChannel) SendHeartbeat(hb NodeHeartbeat) error {
+	msg := &agentpb.NodeHeartbeat{NodeId: hb.NodeID, Ip: hb.IP, ApiPort: int32(hb.APIPort)}
+	if hb.Resources != nil {
+		msg.ResourcesJson, _ = json.Marshal(hb.Resources)
+	}
+	return c.send(&agentpb.AgentMessage{Message: &agentpb.AgentMessage_Heartbeat{Heartbeat: msg}})
+}
+
+// SendStatus 通过控制通道上报实例状态
+func (c *ControlChannel) SendStatus(st InstanceStatus) error {
+	return c.send(&agentpb.AgentMessage{Message: &agentpb.AgentMessage_Status{Status: &agentpb.InstanceStatus{
+		InstanceId:   st.InstanceID,
+		Phase:        st.Phase,
+		ExitCode:     int32(st.ExitCode),
+		Healthy:      st.Healthy,
+		Ready:        st.Ready,
+		TsUnix:       st.TsUnix,
+		RestartCount: int32(st.RestartCount),
+		Reason:       st.Reason,
+		Message:      st.Message,
+	}}})
+}
+
+// SendEndpoints 通过控制通道注册（增量）、心跳或删除实例的服务端点
+func (c *ControlChannel) SendEndpoints(op agentpb.EndpointRegistration_Op, reg ServiceRegistration) error {
+	msg := &agentpb.EndpointRegistration{Op: op, InstanceId: reg.InstanceID, NodeId: reg.NodeID, Ip: reg.IP}
+	for _, e := range reg.Endpoints {
+		msg.Endpoints = append(msg.Endpoints, &agentpb.ServiceEndpoint{ServiceName: e.ServiceName, Protocol: e.Protocol, Port: int32(e.Port)})
+	}
+	return c.send(&agentpb.AgentMessage{Message: &agentpb.AgentMessage_Endpoints{Endpoints: msg}})
+}
+
+// SetControlChannel 设置控制通道（在主循环开始前调用）
+func (r *Reconciler) SetControlChannel(c *ControlChannel) {
+	r.channel = c
+}
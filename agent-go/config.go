@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// 配置注入：Controller 随分配下发部署条目的环境变量（env）和配置文件（configFiles）。
+// 配置文件写入 <实例目录>/config/<mountPath>/<key>（密钥文件权限 0600），
+// 容器模式只读挂载到容器内的 mountPath；进程模式通过 PLUM_CONFIG_DIR 指向该目录，
+// 应用以 $PLUM_CONFIG_DIR<mountPath>/<key> 读取（容器内 PLUM_CONFIG_DIR 为空，路径相同）。
+
+// ConfigFile 注入实例的配置文件
+type ConfigFile struct {
+	MountPath string `json:"mountPath"`
+	Name      string `json:"name"`
+	Content   []byte `json:"content"`
+	Secret    bool   `json:"secret,omitempty"`
+}
+
+// instanceConfigDir 实例配置文件的宿主机根目录
+func instanceConfigDir(baseDir, instanceID string) string {
+	return filepath.Join(baseDir, instanceID, "config")
+}
+
+// writeInstanceConfig 重建实例的配置目录（启动前调用，旧文件全部删除）
+func writeInstanceConfig(baseDir string, a Assignment) error {
+	root := instanceConfigDir(baseDir, a.InstanceID)
+	if err := os.RemoveAll(root); err != nil {
+		return err
+	}
+	if len(a.ConfigFiles) == 0 {
+		return nil
+	}
+	for _, f := range a.ConfigFiles {
+		dir, err := configMountDir(root, f.MountPath)
+		if err != nil {
+			return err
+		}
+		if f.Name == "" || f.Name == "." || f.Name == ".." || strings.ContainsRune(f.Name, '/') {
+			return fmt.Errorf("invalid config file name %q", f.Name)
+		}
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+		mode := os.FileMode(0644)
+		if f.Secret {
+			mode = 0600
+		}
+		if err := os.WriteFile(filepath.Join(dir, f.Name), f.Content, mode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configMountDir mountPath 对应的宿主机目录（不允许逃逸出配置根目录）
+func configMountDir(root, mountPath string) (string, error) {
+	clean := filepath.Clean("/" + mountPath)
+	if !strings.HasPrefix(mountPath, "/") || clean == "/" {
+		return "", fmt.Errorf("invalid config mountPath %q", mountPath)
+	}
+	return filepath.Join(root, clean), nil
+}
+
+// configMounts 配置文件挂载：宿主机目录 -> 容器内 mountPath
+func configMounts(baseDir string, a Assignment) map[string]string {
+	root := instanceConfigDir(baseDir, a.InstanceID)
+	mounts := map[string]string{}
+	for _, f := range a.ConfigFiles {
+		if dir, err := configMountDir(root, f.MountPath); err == nil {
+			mounts[filepath.Clean(f.MountPath)] = dir
+		}
+	}
+	return mounts
+}
+
+// configEnv 注入的环境变量（KEY=VALUE，按键排序）
+func configEnv(a Assignment) []string {
+	keys := make([]string, 0, len(a.Env))
+	for k := range a.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]string, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, k+"="+a.Env[k])
+	}
+	return out
+}
+
+// removeInstanceConfig 实例停止后删除其配置文件（含密钥）
+func removeInstanceConfig(baseDir, instanceID string) {
+	if err := os.RemoveAll(instanceConfigDir(baseDir, instanceID)); err != nil {
+		LogWarn("Failed to remove config of instance %s: %v", instanceID, err)
+	}
+}
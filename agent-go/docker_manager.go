@@ -14,12 +14,14 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
 )
 
@@ -29,7 +31,11 @@ type DockerManager struct {
 	config     ManagerConfig
 	client     *client.Client
 	ctx        context.Context
+	mu         sync.Mutex        // 保护 containers（同步循环、停止与本地接口并发调用）
 	containers map[string]string // instanceID -> containerID
+
+	logMu     sync.Mutex
+	capturing map[string]bool // 正在采集日志的容器
 }
 
 // NewDockerManager 创建Docker管理器
@@ -52,13 +58,37 @@ func NewDockerManager(config ManagerConfig) (*DockerManager, error) {
 		client:     cli,
 		ctx:        ctx,
 		containers: make(map[string]string),
+		capturing:  make(map[string]bool),
 	}, nil
 }
 
+// container 实例记录的容器 ID
+func (m *DockerManager) container(instanceID string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	containerID, ok := m.containers[instanceID]
+	return containerID, ok
+}
+
+func (m *DockerManager) setContainer(instanceID, containerID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.containers[instanceID] = containerID
+}
+
+// forgetContainer 清除实例的容器记录（记录已指向其它容器时保留）
+func (m *DockerManager) forgetContainer(instanceID, containerID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.containers[instanceID] == containerID {
+		delete(m.containers, instanceID)
+	}
+}
+
 // StartApp 启动应用容器
 func (m *DockerManager) StartApp(instanceID string, app Assignment, appDir string) error {
 	// 检查容器是否已存在并运行
-	if containerID, exists := m.containers[instanceID]; exists {
+	if containerID, exists := m.container(instanceID); exists {
 		info, err := m.client.ContainerInspect(m.ctx, containerID)
 		if err == nil {
 			if info.State.Running {
@@ -67,7 +97,7 @@ func (m *DockerManager) StartApp(instanceID string, app Assignment, appDir strin
 			}
 		}
 		// 容器已停止，清理记录
-		delete(m.containers, instanceID)
+		m.forgetContainer(instanceID, containerID)
 	}
 
 	// 容器命名：plum-app-{instanceID}
@@ -132,6 +162,18 @@ func (m *DockerManager) StartApp(instanceID string, app Assignment, appDir strin
 			cmdParts = []string{"./start.sh"}
 		}
 	}
+	// preStart 钩子在容器内先于启动命令执行，失败时容器以钩子的退出码退出
+	hooks := Hooks{}
+	if app.Hooks != nil {
+		hooks = *app.Hooks
+	}
+	if hook := hookCommand(hooks, appDir, HookPreStart); hook != "" {
+		if len(cmdParts) > 0 {
+			cmdParts = []string{"sh", "-c", hook + " && exec " + strings.Join(cmdParts, " ")}
+		} else {
+			LogWarn("Instance %s: preStart hook ignored, image apps need startCmd to run it", instanceID)
+		}
+	}
 
 	// 构建环境变量列表
 	envVars := []string{
@@ -258,6 +300,10 @@ func (m *DockerManager) StartApp(instanceID string, app Assignment, appDir strin
 		}
 	}
 
+	// 部署条目注入的环境变量（优先于节点级的 PLUM_CONTAINER_ENV）
+	envVars = append(envVars, configEnv(app)...)
+	envVars = append(envVars, portEnv(app)...)
+
 	// 自动添加 LD_LIBRARY_PATH（仅对 ZIP 应用，如果应用目录有lib子目录）
 	// 这对于Qt等需要共享库的应用很有用
 	if !isImageApp && appDir != "" {
@@ -284,33 +330,34 @@ func (m *DockerManager) StartApp(instanceID string, app Assignment, appDir strin
 	portBindings := nat.PortMap{}
 
 	if isImageApp && app.PortMappings != "" {
-		// 镜像应用：从 PortMappings JSON 解析端口映射
-		var portMaps []map[string]interface{}
-		if err := json.Unmarshal([]byte(app.PortMappings), &portMaps); err == nil {
-			for _, pm := range portMaps {
-				hostPort, _ := pm["host"].(float64)
-				containerPort, _ := pm["container"].(float64)
-				if hostPort > 0 && containerPort > 0 {
-					port, err := nat.NewPort("tcp", strconv.Itoa(int(containerPort)))
-					if err != nil {
-						log.Printf("Failed to parse container port %d for instance %s: %v", int(containerPort), instanceID, err)
-						continue
-					}
-					exposedPorts[port] = struct{}{}
-					portBindings[port] = []nat.PortBinding{
-						{HostIP: "0.0.0.0", HostPort: strconv.Itoa(int(hostPort))},
-					}
-					log.Printf("Port mapping for instance %s: %d -> %d", instanceID, int(hostPort), int(containerPort))
+		// 镜像应用：从 PortMappings JSON 解析端口映射（host 为 auto 时使用 Agent 分配的端口）
+		for _, pm := range parsePortMappings(app) {
+			hostPort := pm.Host
+			if pm.Auto {
+				hostPort = app.HostPorts[pm.ServiceName]
+			}
+			if hostPort > 0 && pm.Container > 0 {
+				port, err := nat.NewPort("tcp", strconv.Itoa(pm.Container))
+				if err != nil {
+					log.Printf("Failed to parse container port %d for instance %s: %v", pm.Container, instanceID, err)
+					continue
 				}
+				exposedPorts[port] = struct{}{}
+				portBindings[port] = []nat.PortBinding{
+					{HostIP: "0.0.0.0", HostPort: strconv.Itoa(hostPort)},
+				}
+				log.Printf("Port mapping for instance %s: %d -> %d", instanceID, hostPort, pm.Container)
 			}
-		} else {
-			log.Printf("Failed to parse port mappings for instance %s: %v", instanceID, err)
 		}
 	} else if !isImageApp && appDir != "" {
 		// ZIP 应用：根据 meta.ini 暴露端口
 		metaPath := filepath.Join(appDir, "meta.ini")
 		if endpoints, err := ParseMetaINI(metaPath); err == nil && len(endpoints) > 0 {
 			for _, ep := range endpoints {
+				// auto 端口：容器内外使用同一个分配的端口
+				if port, ok := app.HostPorts[ep.ServiceName]; ok {
+					ep.Port = port
+				}
 				if ep.Port <= 0 {
 					continue
 				}
@@ -394,6 +441,16 @@ func (m *DockerManager) StartApp(instanceID string, app Assignment, appDir strin
 		}
 	}
 
+	// 配置文件：只读挂载到容器内的 mountPath
+	for target, source := range configMounts(m.config.BaseDir, app) {
+		mounts = append(mounts, mount.Mount{
+			Type:     mount.TypeBind,
+			Source:   source,
+			Target:   target,
+			ReadOnly: true,
+		})
+	}
+
 	// 可选：挂载宿主机的库路径（仅对 ZIP 应用，用于共享系统库）
 	// 这样可以避免每个应用都自包含相同的库，减少重复
 	if !isImageApp {
@@ -418,11 +475,8 @@ func (m *DockerManager) StartApp(instanceID string, app Assignment, appDir strin
 	// 创建主机配置（挂载应用目录和库路径）
 	hostConfig := &container.HostConfig{
 		Mounts: mounts,
-		// 资源限制（可选，从环境变量读取）
-		Resources: container.Resources{
-			Memory:   getMemoryLimit(), // 从环境变量或默认值
-			NanoCPUs: getCPULimit(),    // CPU限制
-		},
+		// 资源限制：部署中指定的限制优先，否则使用环境变量中的节点默认值
+		Resources:    containerResources(limitsOf(app), m.config.BaseDir),
 		PortBindings: portBindings,
 		// 网络模式：从环境变量读取，默认为 bridge（已在前面定义 networkMode）
 		NetworkMode: networkMode,
@@ -503,7 +557,8 @@ func (m *DockerManager) StartApp(instanceID string, app Assignment, appDir strin
 	}
 
 	LogInfo("Started container %s for instance %s", containerID[:12], instanceID)
-	m.containers[instanceID] = containerID
+	m.setContainer(instanceID, containerID)
+	go m.captureLogs(instanceID, containerID, time.Time{})
 
 	// 延迟检查容器状态（给应用一点启动时间）
 	// 如果容器立即退出，说明应用有问题，记录日志帮助调试
@@ -539,9 +594,101 @@ func (m *DockerManager) StartApp(instanceID string, app Assignment, appDir strin
 	return nil
 }
 
-// StopApp 停止应用容器
-func (m *DockerManager) StopApp(instanceID string) error {
-	containerID, exists := m.containers[instanceID]
+// UpdateLimits 通过 docker update 就地更新容器的 CPU / 内存 / 进程数限制（IO 限制需重建容器才能生效）
+func (m *DockerManager) UpdateLimits(instanceID string, limits Limits) error {
+	containerID, ok := m.container(instanceID)
+	if !ok {
+		containerID = fmt.Sprintf("plum-app-%s", instanceID)
+	}
+	res := containerResources(limits, m.config.BaseDir)
+	res.BlkioDeviceReadBps, res.BlkioDeviceWriteBps = nil, nil
+	if res.PidsLimit == nil {
+		unlimited := int64(-1)
+		res.PidsLimit = &unlimited
+	}
+	if res.Memory > 0 && res.MemorySwap == 0 {
+		res.MemorySwap = -1
+	}
+	_, err := m.client.ContainerUpdate(m.ctx, containerID, container.UpdateConfig{Resources: res})
+	return err
+}
+
+// Usage 从容器统计信息读取 CPU 时间、内存和进程数
+func (m *DockerManager) Usage(instanceID string) (AppUsage, error) {
+	containerID, ok := m.container(instanceID)
+	if !ok {
+		containerID = fmt.Sprintf("plum-app-%s", instanceID)
+	}
+	resp, err := m.client.ContainerStatsOneShot(m.ctx, containerID)
+	if err != nil {
+		return AppUsage{}, err
+	}
+	defer resp.Body.Close()
+	var stats types.StatsJSON
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return AppUsage{}, err
+	}
+	return AppUsage{
+		CPUSeconds: float64(stats.CPUStats.CPUUsage.TotalUsage) / 1e9,
+		MemoryMB:   float64(stats.MemoryStats.Usage) / (1 << 20),
+		Processes:  int(stats.PidsStats.Current),
+	}, nil
+}
+
+// adopt 记录不是由本进程启动的运行中容器（Agent 重启后接管），并从实例日志上次写入处继续采集日志
+func (m *DockerManager) adopt(instanceID, containerID string) {
+	m.setContainer(instanceID, containerID)
+	var since time.Time
+	if fi, err := os.Stat(instanceLogPath(m.config.BaseDir, instanceID)); err == nil {
+		since = fi.ModTime()
+	}
+	go m.captureLogs(instanceID, containerID, since)
+}
+
+// captureLogs 跟随容器日志写入实例日志文件，容器停止后结束；since 非零时只取其后的日志。
+// 同一容器同时只有一个采集
+func (m *DockerManager) captureLogs(instanceID, containerID string, since time.Time) {
+	m.logMu.Lock()
+	if m.capturing[containerID] {
+		m.logMu.Unlock()
+		return
+	}
+	m.capturing[containerID] = true
+	m.logMu.Unlock()
+	defer func() {
+		m.logMu.Lock()
+		delete(m.capturing, containerID)
+		m.logMu.Unlock()
+	}()
+	logFile, err := openInstanceLog(m.config.BaseDir, instanceID)
+	if err != nil {
+		LogWarn("Failed to open log file for instance %s: %v", instanceID, err)
+		return
+	}
+	defer logFile.Close()
+	opts := types.ContainerLogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     true,
+	}
+	if !since.IsZero() {
+		opts.Since = fmt.Sprintf("%d.%09d", since.Unix(), since.Nanosecond())
+	}
+	logs, err := m.client.ContainerLogs(m.ctx, containerID, opts)
+	if err != nil {
+		LogWarn("Failed to follow logs of container %s: %v", containerID[:12], err)
+		return
+	}
+	defer logs.Close()
+	// 未分配 TTY 的容器日志为 stdout/stderr 多路复用格式
+	if _, err := stdcopy.StdCopy(logFile, logFile, logs); err != nil {
+		LogDebug("Stopped capturing logs of container %s: %v", containerID[:12], err)
+	}
+}
+
+// StopApp 停止应用容器：grace（默认 5 秒）内未退出则由 Docker 强制结束
+func (m *DockerManager) StopApp(instanceID string, grace time.Duration) error {
+	containerID, exists := m.container(instanceID)
 	if !exists {
 		// 尝试通过容器名查找
 		containerName := fmt.Sprintf("plum-app-%s", instanceID)
@@ -557,6 +704,9 @@ func (m *DockerManager) StopApp(instanceID string) error {
 
 	// 先尝试优雅停止（SIGTERM）
 	timeoutSeconds := 5
+	if grace > 0 {
+		timeoutSeconds = int((grace + time.Second - 1) / time.Second)
+	}
 	if err := m.client.ContainerStop(m.ctx, containerID, container.StopOptions{Timeout: &timeoutSeconds}); err != nil {
 		log.Printf("Failed to stop container %s: %v", containerID[:12], err)
 		// 如果优雅停止失败，强制停止（但不删除容器，保留以便调试）
@@ -581,13 +731,13 @@ func (m *DockerManager) StopApp(instanceID string) error {
 		m.cleanupInstanceData(instanceID)
 	}
 
-	delete(m.containers, instanceID)
+	m.forgetContainer(instanceID, containerID)
 	return nil
 }
 
 // IsRunning 检查容器是否正在运行
 func (m *DockerManager) IsRunning(instanceID string) bool {
-	containerID, exists := m.containers[instanceID]
+	containerID, exists := m.container(instanceID)
 	containerName := fmt.Sprintf("plum-app-%s", instanceID)
 
 	// 如果没有记录，尝试通过容器名查找
@@ -600,13 +750,13 @@ func (m *DockerManager) IsRunning(instanceID string) bool {
 		// 检查容器状态
 		if !info.State.Running {
 			// 容器已停止，清理记录
-			delete(m.containers, instanceID)
+			m.forgetContainer(instanceID, containerID)
 			LogDebug("Container %s for instance %s is not running (status: %s)",
 				containerID[:12], instanceID, info.State.Status)
 			return false
 		}
 		// 更新记录
-		m.containers[instanceID] = containerID
+		m.adopt(instanceID, containerID)
 		return true
 	}
 
@@ -614,7 +764,7 @@ func (m *DockerManager) IsRunning(instanceID string) bool {
 	info, err := m.client.ContainerInspect(m.ctx, containerID)
 	if err != nil {
 		// 容器不存在（可能被删除），清理记录
-		delete(m.containers, instanceID)
+		m.forgetContainer(instanceID, containerID)
 		log.Printf("Container %s for instance %s not found, cleaned up", containerID[:12], instanceID)
 		return false
 	}
@@ -622,7 +772,7 @@ func (m *DockerManager) IsRunning(instanceID string) bool {
 	// 检查容器是否真的在运行
 	if !info.State.Running {
 		// 容器已停止，清理记录
-		delete(m.containers, instanceID)
+		m.forgetContainer(instanceID, containerID)
 		LogDebug("Container %s for instance %s is not running (status: %s, exitCode: %d)",
 			containerID[:12], instanceID, info.State.Status, info.State.ExitCode)
 		return false
@@ -633,7 +783,7 @@ func (m *DockerManager) IsRunning(instanceID string) bool {
 
 // GetStatus 获取容器状态
 func (m *DockerManager) GetStatus(instanceID string) (AppStatus, error) {
-	containerID := m.containers[instanceID]
+	containerID, _ := m.container(instanceID)
 	containerName := fmt.Sprintf("plum-app-%s", instanceID)
 
 	// 如果没有记录，尝试通过容器名查找
@@ -654,10 +804,11 @@ func (m *DockerManager) GetStatus(instanceID string) (AppStatus, error) {
 				Running:     false,
 				ContainerID: containerID,
 				ExitCode:    info.State.ExitCode,
+				OOMKilled:   info.State.OOMKilled,
 			}, nil
 		}
 		// 更新记录
-		m.containers[instanceID] = containerID
+		m.adopt(instanceID, containerID)
 		return AppStatus{
 			InstanceID:  instanceID,
 			Running:     true,
@@ -669,7 +820,7 @@ func (m *DockerManager) GetStatus(instanceID string) (AppStatus, error) {
 	info, err := m.client.ContainerInspect(m.ctx, containerID)
 	if err != nil {
 		// 容器不存在，清理记录
-		delete(m.containers, instanceID)
+		m.forgetContainer(instanceID, containerID)
 		return AppStatus{
 			InstanceID: instanceID,
 			Running:    false,
@@ -684,6 +835,7 @@ func (m *DockerManager) GetStatus(instanceID string) (AppStatus, error) {
 			Running:     false,
 			ContainerID: containerID,
 			ExitCode:    info.State.ExitCode,
+			OOMKilled:   info.State.OOMKilled,
 		}, nil
 	}
 
@@ -699,7 +851,13 @@ func (m *DockerManager) GetStatus(instanceID string) (AppStatus, error) {
 func (m *DockerManager) ListRunning() []string {
 	var running []string
 	// 检查所有已知的容器
+	m.mu.Lock()
+	known := make([]string, 0, len(m.containers))
 	for instanceID := range m.containers {
+		known = append(known, instanceID)
+	}
+	m.mu.Unlock()
+	for _, instanceID := range known {
 		if m.IsRunning(instanceID) {
 			running = append(running, instanceID)
 		}
@@ -730,7 +888,7 @@ func (m *DockerManager) ListRunning() []string {
 				if !found && container.State == "running" {
 					running = append(running, instanceID)
 					// 更新容器记录
-					m.containers[instanceID] = container.ID
+					m.adopt(instanceID, container.ID)
 				}
 			}
 		}
@@ -738,6 +896,24 @@ func (m *DockerManager) ListRunning() []string {
 	return running
 }
 
+// ListImages 列出本地已有镜像（repository:tag）
+func (m *DockerManager) ListImages() []string {
+	images, err := m.client.ImageList(m.ctx, types.ImageListOptions{})
+	if err != nil {
+		LogDebug("Failed to list images: %v", err)
+		return nil
+	}
+	var out []string
+	for _, img := range images {
+		for _, tag := range img.RepoTags {
+			if tag != "<none>:<none>" {
+				out = append(out, tag)
+			}
+		}
+	}
+	return out
+}
+
 // getMemoryLimit 从环境变量获取内存限制（字节）
 func getMemoryLimit() int64 {
 	memoryStr := os.Getenv("PLUM_CONTAINER_MEMORY")
@@ -871,6 +1047,108 @@ func (m *DockerManager) readFileFromContainer(containerID, filePath string) (str
 	return strings.TrimSpace(content), nil
 }
 
+// probeTarget 容器实例的探测环境：host 网络模式下探测本机地址，否则探测容器 IP；exec 探针在容器内执行
+func (m *DockerManager) probeTarget(instanceID string) probeTarget {
+	containerName := fmt.Sprintf("plum-app-%s", instanceID)
+	t := probeTarget{
+		host: "127.0.0.1",
+		execIn: func(ctx context.Context, command string) (int, error) {
+			return m.execExitCode(ctx, containerName, []string{"sh", "-c", command})
+		},
+	}
+	if getNetworkMode() != container.NetworkMode("host") {
+		if info, err := m.client.ContainerInspect(m.ctx, containerName); err == nil && info.NetworkSettings != nil {
+			if info.NetworkSettings.IPAddress != "" {
+				t.host = info.NetworkSettings.IPAddress
+			} else {
+				for _, n := range info.NetworkSettings.Networks {
+					if n != nil && n.IPAddress != "" {
+						t.host = n.IPAddress
+						break
+					}
+				}
+			}
+		}
+	}
+	return t
+}
+
+// execExitCode 在容器内执行命令并等待其退出，返回退出码
+func (m *DockerManager) execExitCode(ctx context.Context, containerName string, cmd []string) (int, error) {
+	execResp, err := m.client.ContainerExecCreate(ctx, containerName, types.ExecConfig{Cmd: cmd})
+	if err != nil {
+		return -1, fmt.Errorf("failed to create exec: %w", err)
+	}
+	if err := m.client.ContainerExecStart(ctx, execResp.ID, types.ExecStartCheck{}); err != nil {
+		return -1, fmt.Errorf("failed to start exec: %w", err)
+	}
+	for {
+		execInspect, err := m.client.ContainerExecInspect(ctx, execResp.ID)
+		if err != nil {
+			return -1, fmt.Errorf("failed to inspect exec: %w", err)
+		}
+		if !execInspect.Running {
+			return execInspect.ExitCode, nil
+		}
+		select {
+		case <-ctx.Done():
+			return -1, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// Exec 在容器内执行命令（docker exec），输出实时写入 opts.Stdout / opts.Stderr。
+// ctx 取消时断开连接并返回，Docker 不支持结束 exec 进程，命令可能仍在容器内运行。
+func (m *DockerManager) Exec(ctx context.Context, instanceID string, opts ExecOptions) (int, error) {
+	containerName := fmt.Sprintf("plum-app-%s", instanceID)
+	execResp, err := m.client.ContainerExecCreate(ctx, containerName, types.ExecConfig{
+		Cmd:          opts.Command,
+		Tty:          opts.TTY,
+		AttachStdin:  opts.Stdin != nil,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return -1, fmt.Errorf("failed to create exec: %w", err)
+	}
+	attachResp, err := m.client.ContainerExecAttach(ctx, execResp.ID, types.ExecStartCheck{Tty: opts.TTY})
+	if err != nil {
+		return -1, fmt.Errorf("failed to attach exec: %w", err)
+	}
+	defer attachResp.Close()
+	if opts.Stdin != nil {
+		go func() {
+			_, _ = io.Copy(attachResp.Conn, opts.Stdin)
+			_ = attachResp.CloseWrite()
+		}()
+	}
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		if opts.TTY {
+			// 终端模式下输出不分流
+			_, err = io.Copy(opts.Stdout, attachResp.Reader)
+		} else {
+			_, err = stdcopy.StdCopy(opts.Stdout, opts.Stderr, attachResp.Reader)
+		}
+		done <- err
+	}()
+	select {
+	case <-ctx.Done():
+		return -1, ctx.Err()
+	case err := <-done:
+		if err != nil {
+			return -1, err
+		}
+	}
+	execInspect, err := m.client.ContainerExecInspect(m.ctx, execResp.ID)
+	if err != nil {
+		return -1, fmt.Errorf("failed to inspect exec: %w", err)
+	}
+	return execInspect.ExitCode, nil
+}
+
 // getNetworkMode 从环境变量获取 Docker 容器网络模式
 // 支持的值：host, bridge, none（默认：bridge）
 func getNetworkMode() container.NetworkMode {
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/websocket"
+)
+
+// 在实例中执行命令（调试用）：容器模式通过 Docker exec 在容器内执行，
+// 进程模式在实例进程的工作目录中以相同的环境变量执行。
+//   - POST /v1/instances/{id}/exec：非交互，等待命令结束后返回输出和退出码
+//   - GET  /v1/instances/{id}/exec（WebSocket）：交互式，客户端发送的帧写入标准输入，
+//     输出以二进制帧返回，命令结束时发送文本帧 {"exitCode": N} 后关闭
+// 需要配置 AGENT_API_TOKEN；AGENT_EXEC_ENABLED=false 时禁用。
+
+const (
+	execDefaultTimeout = 30 * time.Second
+	execMaxTimeout     = 10 * time.Minute
+	execMaxOutput      = 1 << 20 // 非交互执行每路输出的上限
+)
+
+var errNotRunning = errors.New("instance is not running")
+
+// ExecOptions 在实例中执行命令的参数
+type ExecOptions struct {
+	Command []string
+	TTY     bool      // 分配终端（仅容器模式）
+	Stdin   io.Reader // nil 表示不附加标准输入
+	Stdout  io.Writer
+	Stderr  io.Writer
+}
+
+// ExecRequest 非交互执行请求
+type ExecRequest struct {
+	Command    []string `json:"command"`
+	Stdin      string   `json:"stdin,omitempty"`
+	TimeoutSec int      `json:"timeoutSec,omitempty"` // 默认 30，最长 600
+}
+
+// ExecResult 非交互执行结果
+type ExecResult struct {
+	ExitCode  int    `json:"exitCode"`
+	Stdout    string `json:"stdout"`
+	Stderr    string `json:"stderr"`
+	TimedOut  bool   `json:"timedOut,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"` // 输出超过 1 MiB 被截断
+}
+
+// cappedBuffer 超过上限后丢弃多余输出
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := execMaxOutput - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:max(room, 0)])
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// execEnabled AGENT_EXEC_ENABLED 无法解析时按禁用处理
+func execEnabled() bool {
+	raw := getEnv("AGENT_EXEC_ENABLED", "true")
+	v, err := strconv.ParseBool(raw)
+	if err != nil {
+		LogWarn("Invalid AGENT_EXEC_ENABLED %q, exec disabled", raw)
+		return false
+	}
+	return v
+}
+
+// execInstance 在运行中的实例里执行命令（不持有 Reconciler 锁等待命令结束）
+func (r *Reconciler) execInstance(ctx context.Context, id string, opts ExecOptions) (int, error) {
+	r.mu.Lock()
+	appManager := r.getAppManagerByInstanceID(id)
+	if a, ok := r.assignments[id]; ok {
+		appManager = r.getAppManager(a.ArtifactType)
+	}
+	running := appManager != nil && appManager.IsRunning(id)
+	r.mu.Unlock()
+	if !running {
+		return -1, errNotRunning
+	}
+	LogInfo("Exec in instance %s: %q", id, opts.Command)
+	return appManager.Exec(ctx, id, opts)
+}
+
+// handleInstanceExec POST（非交互）或 WebSocket（交互）
+func (r *Reconciler) handleInstanceExec(w http.ResponseWriter, req *http.Request, id string) {
+	if !execEnabled() {
+		http.Error(w, "exec disabled on this node", http.StatusForbidden)
+		return
+	}
+	if req.Method == http.MethodGet && strings.EqualFold(req.Header.Get("Upgrade"), "websocket") {
+		r.handleExecStream(w, req, id)
+		return
+	}
+	if req.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var body ExecRequest
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil || len(body.Command) == 0 {
+		http.Error(w, "command required", http.StatusBadRequest)
+		return
+	}
+	timeout := execDefaultTimeout
+	if body.TimeoutSec > 0 {
+		timeout = min(time.Duration(body.TimeoutSec)*time.Second, execMaxTimeout)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+
+	var stdout, stderr cappedBuffer
+	opts := ExecOptions{Command: body.Command, Stdout: &stdout, Stderr: &stderr}
+	if body.Stdin != "" {
+		opts.Stdin = strings.NewReader(body.Stdin)
+	}
+	code, err := r.execInstance(ctx, id, opts)
+	res := ExecResult{ExitCode: code, Truncated: stdout.truncated || stderr.truncated}
+	if err != nil {
+		switch {
+		case errors.Is(err, errNotRunning):
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		case ctx.Err() == context.DeadlineExceeded:
+			res.TimedOut = true
+		default:
+			http.Error(w, "exec failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+	res.Stdout, res.Stderr = stdout.buf.String(), stderr.buf.String()
+	writeJSON(w, res)
+}
+
+// handleExecStream 交互式执行：?command=sh&command=-c&command=...&tty=true
+func (r *Reconciler) handleExecStream(w http.ResponseWriter, req *http.Request, id string) {
+	command := req.URL.Query()["command"]
+	if len(command) == 0 {
+		command = []string{"sh"}
+	}
+	tty, _ := strconv.ParseBool(req.URL.Query().Get("tty"))
+	websocket.Server{Handshake: checkSameOrigin, Handler: func(ws *websocket.Conn) {
+		defer ws.Close()
+		ws.PayloadType = websocket.BinaryFrame
+		ctx, cancel := context.WithCancel(req.Context())
+		defer cancel()
+
+		// 客户端断开时结束命令
+		stdinR, stdinW := io.Pipe()
+		go func() {
+			defer cancel()
+			defer stdinW.Close()
+			for {
+				var data []byte
+				if err := websocket.Message.Receive(ws, &data); err != nil {
+					return
+				}
+				if _, err := stdinW.Write(data); err != nil {
+					return
+				}
+			}
+		}()
+
+		out := &wsWriter{ws: ws}
+		code, err := r.execInstance(ctx, id, ExecOptions{Command: command, TTY: tty, Stdin: stdinR, Stdout: out, Stderr: out})
+		stdinR.Close()
+		result := map[string]any{"exitCode": code}
+		if err != nil {
+			result["error"] = err.Error()
+		}
+		_ = websocket.JSON.Send(ws, result)
+	}}.ServeHTTP(w, req)
+}
+
+// checkSameOrigin 拒绝跨源的 WebSocket 升级（浏览器发起时 Origin 须与 Host 一致，非浏览器客户端不发送 Origin）
+func checkSameOrigin(config *websocket.Config, req *http.Request) error {
+	origin, err := websocket.Origin(config, req)
+	if err != nil {
+		return err
+	}
+	if origin != nil && !strings.EqualFold(origin.Host, req.Host) {
+		return fmt.Errorf("cross-origin websocket from %s rejected", origin)
+	}
+	config.Origin = origin
+	return nil
+}
+
+// wsWriter 将输出作为二进制帧写入 WebSocket（stdout 与 stderr 并发写入）
+type wsWriter struct {
+	mu sync.Mutex
+	ws *websocket.Conn
+}
+
+func (w *wsWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := websocket.Message.Send(w.ws, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -7,6 +7,8 @@ toolchain go1.24.9
 require (
 	github.com/docker/docker v25.0.5+incompatible
 	github.com/joho/godotenv v1.5.1
+	golang.org/x/net v0.43.0
+	google.golang.org/grpc v1.75.0
 )
 
 require (
@@ -31,6 +33,9 @@ require (
 	go.opentelemetry.io/otel/metric v1.38.0 // indirect
 	go.opentelemetry.io/otel/trace v1.38.0 // indirect
 	golang.org/x/sys v0.35.0 // indirect
+	golang.org/x/text v0.28.0 // indirect
 	golang.org/x/time v0.14.0 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20250825161204-c5933d9347a5 // indirect
+	google.golang.org/protobuf v1.36.8 // indirect
 	gotest.tools/v3 v3.5.2 // indirect
 )
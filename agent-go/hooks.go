@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// 生命周期钩子：部署条目中配置的命令优先，未配置时使用 ZIP 制品中的同名脚本。
+//   - preStart（prestart.sh）：进程模式在启动前于应用目录中执行，失败则不启动并按重启策略退避重试；
+//     容器模式在容器内先于启动命令执行（失败时容器以钩子的退出码退出）
+//   - postStart（poststart.sh）：启动后在实例中执行，失败则停止实例
+//   - preStop（prestop.sh）：停止前在实例中执行，耗时计入部署的停止宽限期
+// 钩子在实例的后台启动 / 停止任务中执行，不占用同步循环（心跳独立发送）。
+// 钩子输出同时写入实例日志，最近一次结果可通过本地接口 /v1/instances/{id} 查看。
+
+const (
+	HookPreStart  = "preStart"
+	HookPostStart = "postStart"
+	HookPreStop   = "preStop"
+
+	ReasonPreStartHookFailed  = "PreStartHookFailed"
+	ReasonPostStartHookFailed = "PostStartHookFailed"
+
+	hookDefaultTimeout = 60 * time.Second
+	hookOutputTail     = 4 << 10 // 结果中保留的输出末尾
+)
+
+// hookScripts 各钩子对应的制品脚本
+var hookScripts = map[string]string{
+	HookPreStart:  "prestart.sh",
+	HookPostStart: "poststart.sh",
+	HookPreStop:   "prestop.sh",
+}
+
+// Hooks 部署条目中的钩子命令（通过 sh -c 执行）
+type Hooks struct {
+	PreStart   string `json:"preStart,omitempty"`
+	PostStart  string `json:"postStart,omitempty"`
+	PreStop    string `json:"preStop,omitempty"`
+	TimeoutSec int    `json:"timeoutSec,omitempty"` // preStart / postStart 的超时，默认 60
+}
+
+// HookResult 钩子的一次执行结果
+type HookResult struct {
+	Hook       string `json:"hook"`
+	Command    string `json:"command"`
+	ExitCode   int    `json:"exitCode"`
+	Output     string `json:"output,omitempty"` // 输出末尾（最多 4 KiB）
+	Error      string `json:"error,omitempty"`  // 超时或无法执行
+	StartedAt  int64  `json:"startedAt"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+func (h HookResult) failed() bool { return h.Error != "" || h.ExitCode != 0 }
+
+// message 上报给 Controller 的失败说明
+func (h HookResult) message() string {
+	msg := fmt.Sprintf("%s hook %q exited with %d", h.Hook, h.Command, h.ExitCode)
+	if h.Error != "" {
+		msg = fmt.Sprintf("%s hook %q: %s", h.Hook, h.Command, h.Error)
+	}
+	if out := strings.TrimSpace(h.Output); out != "" {
+		msg += ": " + out
+	}
+	return msg
+}
+
+// instanceHooks 实例的钩子配置与执行结果（分配删除后停止实例时仍需 preStop 和宽限期）。
+// 配置在每次同步时整体替换而不就地修改，持锁取得的指针可在锁外执行钩子和停止；
+// results 在各次同步间共享，由 Reconciler.hookMu 保护
+type instanceHooks struct {
+	hooks   Hooks
+	grace   time.Duration // 0 表示使用管理器的默认停止等待时间
+	appDir  string        // ZIP 应用目录（钩子脚本所在），镜像应用为空
+	results map[string]HookResult
+}
+
+// tailBuffer 只保留最后 hookOutputTail 字节
+type tailBuffer struct{ buf []byte }
+
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.buf = append(b.buf, p...)
+	if over := len(b.buf) - hookOutputTail; over > 0 {
+		b.buf = append(b.buf[:0], b.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+// trackHooks 同步实例的钩子配置（每次同步分配时调用）
+func (r *Reconciler) trackHooks(a Assignment) {
+	h := &instanceHooks{
+		grace:   time.Duration(a.TerminationGracePeriodSec) * time.Second,
+		results: map[string]HookResult{},
+	}
+	if prev, ok := r.hooks[a.InstanceID]; ok {
+		h.results = prev.results
+	}
+	if a.Hooks != nil {
+		h.hooks = *a.Hooks
+	}
+	if a.ArtifactType != "image" {
+		h.appDir = filepath.Join(r.baseDir, a.InstanceID, "app")
+	}
+	r.hooks[a.InstanceID] = h
+}
+
+// pruneHooks 清理既不在分配中也未运行的实例的钩子状态
+func (r *Reconciler) pruneHooks(running map[string]bool) {
+	for id := range r.hooks {
+		if !r.knownInstances[id] && !running[id] {
+			delete(r.hooks, id)
+		}
+	}
+}
+
+// hookCommand 钩子命令：部署中配置的命令优先，否则为制品中存在的脚本（appDir 为空时不查找脚本）
+func hookCommand(hooks Hooks, appDir, hook string) string {
+	var cmd string
+	switch hook {
+	case HookPreStart:
+		cmd = hooks.PreStart
+	case HookPostStart:
+		cmd = hooks.PostStart
+	case HookPreStop:
+		cmd = hooks.PreStop
+	}
+	if cmd = strings.TrimSpace(cmd); cmd != "" || appDir == "" {
+		return cmd
+	}
+	script := hookScripts[hook]
+	if FileExists(filepath.Join(appDir, script)) {
+		return "./" + script
+	}
+	return ""
+}
+
+func (h *instanceHooks) command(hook string) string {
+	return hookCommand(h.hooks, h.appDir, hook)
+}
+
+func (h *instanceHooks) timeout() time.Duration {
+	if h.hooks.TimeoutSec > 0 {
+		return time.Duration(h.hooks.TimeoutSec) * time.Second
+	}
+	return hookDefaultTimeout
+}
+
+// prepareHookScripts 为制品中的钩子脚本加上执行权限
+func prepareHookScripts(appDir string) {
+	for _, script := range hookScripts {
+		path := filepath.Join(appDir, script)
+		if FileExists(path) {
+			if err := os.Chmod(path, 0755); err != nil {
+				LogWarn("failed to chmod %s: %v", script, err)
+			}
+		}
+	}
+}
+
+// runHook 执行钩子并记录到 h 的结果中，输出同时写入实例日志（不需要持有 r.mu）
+func (r *Reconciler) runHook(h *instanceHooks, instanceID, hook, command string, timeout time.Duration,
+	run func(ctx context.Context, out io.Writer) (int, error)) HookResult {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	var tail tailBuffer
+	var out io.Writer = &tail
+	if logFile, err := openInstanceLog(r.baseDir, instanceID); err == nil {
+		defer logFile.Close()
+		fmt.Fprintf(logFile, "[plum] running %s hook: %s\n", hook, command)
+		out = io.MultiWriter(&tail, logFile)
+	}
+	start := time.Now()
+	code, err := run(ctx, out)
+	res := HookResult{
+		Hook:       hook,
+		Command:    command,
+		ExitCode:   code,
+		Output:     strings.ToValidUTF8(string(tail.buf), ""),
+		StartedAt:  start.Unix(),
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		res.Error = fmt.Sprintf("timed out after %v", timeout)
+	} else if err != nil {
+		res.Error = err.Error()
+	}
+	r.hookMu.Lock()
+	h.results[hook] = res
+	r.hookMu.Unlock()
+	if res.failed() {
+		LogWarn("Instance %s %s hook failed: %s", instanceID, hook, res.message())
+	} else {
+		LogInfo("Instance %s %s hook completed in %dms", instanceID, hook, res.DurationMs)
+	}
+	return res
+}
+
+// execHook 在运行中的实例里执行钩子命令
+func execHook(appManager AppManager, instanceID, command string) func(ctx context.Context, out io.Writer) (int, error) {
+	return func(ctx context.Context, out io.Writer) (int, error) {
+		return appManager.Exec(ctx, instanceID, ExecOptions{Command: []string{"sh", "-c", command}, Stdout: out, Stderr: out})
+	}
+}
+
+// runPreStart 进程模式下执行 preStart 钩子（不持有 r.mu）；失败时按一次崩溃处理并上报，返回 false
+// （容器模式的 preStart 由 DockerManager 合并到容器启动命令中）
+func (r *Reconciler) runPreStart(a Assignment, appManager AppManager, appDir string, h *instanceHooks) bool {
+	pm, ok := appManager.(*ProcessManager)
+	if !ok || h == nil {
+		return true
+	}
+	command := h.command(HookPreStart)
+	if command == "" {
+		return true
+	}
+	res := r.runHook(h, a.InstanceID, HookPreStart, command, h.timeout(), func(ctx context.Context, out io.Writer) (int, error) {
+		return pm.RunHook(ctx, a, appDir, command, out)
+	})
+	if !res.failed() {
+		return true
+	}
+	r.mu.Lock()
+	r.failStart(a, res.ExitCode, ReasonPreStartHookFailed, res.message())
+	r.mu.Unlock()
+	return false
+}
+
+// runPostStart 执行 postStart 钩子（不持有 r.mu）；失败时停止实例并按一次崩溃上报，返回 false
+func (r *Reconciler) runPostStart(a Assignment, appManager AppManager, h *instanceHooks) bool {
+	if h == nil {
+		return true
+	}
+	command := h.command(HookPostStart)
+	if command == "" {
+		return true
+	}
+	res := r.runHook(h, a.InstanceID, HookPostStart, command, h.timeout(), execHook(appManager, a.InstanceID, command))
+	if !res.failed() {
+		return true
+	}
+	if err := appManager.StopApp(a.InstanceID, h.grace); err != nil {
+		LogError("Failed to stop instance %s after postStart hook failure: %v", a.InstanceID, err)
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	phase := r.onExit(a, res.ExitCode)
+	if phase == "Completed" {
+		phase = "Failed"
+	}
+	r.postStatusDetail(a.InstanceID, phase, res.ExitCode, false, ReasonPostStartHookFailed, res.message())
+	return false
+}
+
+// terminate 停止实例：先执行 preStop 钩子，宽限期的剩余时间用于等待进程优雅退出。
+// h 为持锁时取得的 r.hooks[instanceID]（可为 nil），停止本身不需要持有 r.mu
+func (r *Reconciler) terminate(appManager AppManager, instanceID string, h *instanceHooks) error {
+	if h == nil {
+		return appManager.StopApp(instanceID, 0)
+	}
+	grace := h.grace
+	if command := h.command(HookPreStop); command != "" && appManager.IsRunning(instanceID) {
+		budget := grace
+		if budget == 0 {
+			budget = h.timeout()
+		}
+		start := time.Now()
+		r.runHook(h, instanceID, HookPreStop, command, budget, execHook(appManager, instanceID, command))
+		if grace > 0 {
+			// 钩子用尽宽限期时仍给进程 1 秒响应 SIGTERM
+			grace = max(grace-time.Since(start), time.Second)
+		}
+	}
+	return appManager.StopApp(instanceID, grace)
+}
+
+// hookResults 实例各钩子最近一次执行结果（按 preStart、postStart、preStop 排序）
+func (r *Reconciler) hookResults(instanceID string) []HookResult {
+	h, ok := r.hooks[instanceID]
+	if !ok {
+		return nil
+	}
+	r.hookMu.Lock()
+	defer r.hookMu.Unlock()
+	if len(h.results) == 0 {
+		return nil
+	}
+	order := map[string]int{HookPreStart: 0, HookPostStart: 1, HookPreStop: 2}
+	out := make([]HookResult, 0, len(h.results))
+	for _, res := range h.results {
+		out = append(out, res)
+	}
+	sort.Slice(out, func(i, j int) bool { return order[out[i].Hook] < order[out[j].Hook] })
+	return out
+}
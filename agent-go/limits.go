@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/docker/docker/api/types/blkiodev"
+	"github.com/docker/docker/api/types/container"
+)
+
+// 实例资源限制：容器模式由 Docker 施加，进程模式通过 cgroup v2 施加
+// （每个实例一个子 cgroup：<AGENT_CGROUP_ROOT>/<实例ID>，需要 root 权限和 cgroup v2）。
+// 内存超限时整个实例被杀死（memory.oom.group），以 OOMKilled 原因上报。
+
+// Limits 资源限制（与 Controller 的 deployment.Limits 对应，0 表示不限制）
+type Limits struct {
+	CPUs       float64 `json:"cpus,omitempty"`
+	MemoryMB   int64   `json:"memoryMB,omitempty"`
+	Pids       int64   `json:"pids,omitempty"`
+	IOReadBps  int64   `json:"ioReadBps,omitempty"`
+	IOWriteBps int64   `json:"ioWriteBps,omitempty"`
+}
+
+// limitsOf 取分配中的资源限制（未设置时为零值）
+func limitsOf(a Assignment) Limits {
+	if a.Limits == nil {
+		return Limits{}
+	}
+	return *a.Limits
+}
+
+const (
+	ReasonOOMKilled = "OOMKilled"
+	ReasonError     = "Error"
+	ReasonCompleted = "Completed"
+
+	cgroup2SuperMagic = 0x63677270
+)
+
+var cgroupWarnOnce sync.Once // cgroup v2 不可用的告警只打印一次
+
+// cgroupRoot 实例 cgroup 的父目录
+func cgroupRoot() string {
+	return getEnv("AGENT_CGROUP_ROOT", "/sys/fs/cgroup/plum.slice")
+}
+
+func instanceCgroup(instanceID string) string {
+	return filepath.Join(cgroupRoot(), instanceID)
+}
+
+// cgroupAvailable cgroup 根目录所在文件系统是否为 cgroup v2
+func cgroupAvailable() bool {
+	var st syscall.Statfs_t
+	dir := cgroupRoot()
+	for {
+		if err := syscall.Statfs(dir, &st); err == nil {
+			return st.Type == cgroup2SuperMagic
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return false
+		}
+		dir = parent
+	}
+}
+
+// ensureCgroup 创建实例 cgroup（沿途开启 cpu / memory / pids / io 控制器）
+func ensureCgroup(instanceID string) (string, error) {
+	root := cgroupRoot()
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return "", err
+	}
+	// 从挂载点到 root 逐级开启子 cgroup 的控制器（不可用的控制器忽略）
+	var chain []string
+	for dir := root; ; dir = filepath.Dir(dir) {
+		chain = append([]string{dir}, chain...)
+		if _, err := os.Stat(filepath.Join(filepath.Dir(dir), "cgroup.subtree_control")); err != nil || filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	for _, dir := range chain {
+		if parent := filepath.Dir(dir); parent != dir {
+			for _, c := range []string{"+cpu", "+memory", "+pids", "+io"} {
+				os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte(c), 0644)
+			}
+		}
+	}
+	for _, c := range []string{"+cpu", "+memory", "+pids", "+io"} {
+		os.WriteFile(filepath.Join(root, "cgroup.subtree_control"), []byte(c), 0644)
+	}
+	dir := instanceCgroup(instanceID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// writeCgroupLimits 写入 cgroup 限制（0 值恢复为不限制）
+func writeCgroupLimits(dir string, l Limits, dataDir string) error {
+	cpu := "max 100000"
+	if l.CPUs > 0 {
+		cpu = fmt.Sprintf("%d 100000", int64(l.CPUs*100000))
+	}
+	mem, swap := "max", "max"
+	if l.MemoryMB > 0 {
+		mem, swap = strconv.FormatInt(l.MemoryMB<<20, 10), "0"
+	}
+	pids := "max"
+	if l.Pids > 0 {
+		pids = strconv.FormatInt(l.Pids, 10)
+	}
+	for _, f := range [][2]string{{"cpu.max", cpu}, {"memory.max", mem}, {"pids.max", pids}} {
+		if err := os.WriteFile(filepath.Join(dir, f[0]), []byte(f[1]), 0644); err != nil {
+			return fmt.Errorf("write %s: %w", f[0], err)
+		}
+	}
+	// 以下为尽力而为：无 swap 统计或 io 控制器时忽略
+	os.WriteFile(filepath.Join(dir, "memory.swap.max"), []byte(swap), 0644)
+	os.WriteFile(filepath.Join(dir, "memory.oom.group"), []byte("1"), 0644)
+	if majMin, _, ok := blockDevice(dataDir); ok {
+		rbps, wbps := "max", "max"
+		if l.IOReadBps > 0 {
+			rbps = strconv.FormatInt(l.IOReadBps, 10)
+		}
+		if l.IOWriteBps > 0 {
+			wbps = strconv.FormatInt(l.IOWriteBps, 10)
+		}
+		line := fmt.Sprintf("%s rbps=%s wbps=%s", majMin, rbps, wbps)
+		if err := os.WriteFile(filepath.Join(dir, "io.max"), []byte(line), 0644); err != nil && (l.IOReadBps > 0 || l.IOWriteBps > 0) {
+			LogWarn("Failed to set io.max (%s) in %s: %v", line, dir, err)
+		}
+	}
+	return nil
+}
+
+// removeCgroup 删除实例 cgroup（仍有残留进程时先通过 cgroup.kill 杀死）
+func removeCgroup(instanceID string) {
+	dir := instanceCgroup(instanceID)
+	if _, err := os.Stat(dir); err != nil {
+		return
+	}
+	if err := os.Remove(dir); err == nil {
+		return
+	}
+	os.WriteFile(filepath.Join(dir, "cgroup.kill"), []byte("1"), 0644)
+	for i := 0; i < 10; i++ {
+		time.Sleep(50 * time.Millisecond)
+		if err := os.Remove(dir); err == nil {
+			return
+		}
+	}
+	LogWarn("Failed to remove cgroup %s", dir)
+}
+
+// cgroupOOMKilled 实例 cgroup 中是否发生过 OOM kill
+func cgroupOOMKilled(instanceID string) bool {
+	data, err := os.ReadFile(filepath.Join(instanceCgroup(instanceID), "memory.events"))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if f := strings.Fields(line); len(f) == 2 && f[0] == "oom_kill" && f[1] != "0" {
+			return true
+		}
+	}
+	return false
+}
+
+// blockDevice 路径所在的磁盘（分区取其所属磁盘），返回 "major:minor" 和 /dev 路径
+func blockDevice(path string) (string, string, bool) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return "", "", false
+	}
+	dev := uint64(st.Dev)
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+	sysDir := fmt.Sprintf("/sys/dev/block/%d:%d", major, minor)
+	if _, err := os.Stat(filepath.Join(sysDir, "partition")); err == nil {
+		sysDir = filepath.Join(sysDir, "..")
+	}
+	majMin, err := os.ReadFile(filepath.Join(sysDir, "dev"))
+	if err != nil {
+		return "", "", false
+	}
+	devPath := ""
+	if uevent, err := os.ReadFile(filepath.Join(sysDir, "uevent")); err == nil {
+		for _, line := range strings.Split(string(uevent), "\n") {
+			if name, ok := strings.CutPrefix(line, "DEVNAME="); ok {
+				devPath = "/dev/" + name
+			}
+		}
+	}
+	return strings.TrimSpace(string(majMin)), devPath, true
+}
+
+// containerResources 容器资源限制：部署中的限制优先，其余使用 PLUM_CONTAINER_* 节点默认值
+func containerResources(l Limits, dataDir string) container.Resources {
+	res := container.Resources{
+		Memory:   getMemoryLimit(),
+		NanoCPUs: getCPULimit(),
+	}
+	if l.CPUs > 0 {
+		res.NanoCPUs = int64(l.CPUs * 1e9)
+	}
+	if l.MemoryMB > 0 {
+		res.Memory = l.MemoryMB << 20
+		res.MemorySwap = res.Memory // 不使用 swap
+	}
+	if l.Pids > 0 {
+		pids := l.Pids
+		res.PidsLimit = &pids
+	}
+	if l.IOReadBps > 0 || l.IOWriteBps > 0 {
+		if _, devPath, ok := blockDevice(dataDir); ok && devPath != "" {
+			if l.IOReadBps > 0 {
+				res.BlkioDeviceReadBps = []*blkiodev.ThrottleDevice{{Path: devPath, Rate: uint64(l.IOReadBps)}}
+			}
+			if l.IOWriteBps > 0 {
+				res.BlkioDeviceWriteBps = []*blkiodev.ThrottleDevice{{Path: devPath, Rate: uint64(l.IOWriteBps)}}
+			}
+		}
+	}
+	return res
+}
+
+// exitReason 实例退出原因
+func exitReason(status AppStatus) string {
+	switch {
+	case status.OOMKilled:
+		return ReasonOOMKilled
+	case status.ExitCode == 0:
+		return ReasonCompleted
+	default:
+		return ReasonError
+	}
+}
+
+// syncLimits 运行中实例的资源限制变化时就地更新（不重启实例）
+func (r *Reconciler) syncLimits(assignments []Assignment, keep map[string]bool) {
+	for id := range r.appliedLimits {
+		if !keep[id] {
+			delete(r.appliedLimits, id)
+		}
+	}
+	for _, a := range assignments {
+		applied, ok := r.appliedLimits[a.InstanceID]
+		if !ok || !keep[a.InstanceID] || r.busy[a.InstanceID] || applied == limitsOf(a) {
+			continue
+		}
+		appManager := r.getAppManager(a.ArtifactType)
+		if appManager == nil || !appManager.IsRunning(a.InstanceID) {
+			continue
+		}
+		if err := appManager.UpdateLimits(a.InstanceID, limitsOf(a)); err != nil {
+			LogWarn("Failed to update resource limits of instance %s: %v", a.InstanceID, err)
+		} else {
+			LogInfo("Updated resource limits of instance %s: %+v", a.InstanceID, limitsOf(a))
+		}
+		r.appliedLimits[a.InstanceID] = limitsOf(a)
+	}
+}
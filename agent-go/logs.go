@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+// 实例日志：应用的 stdout/stderr 写入 <实例目录>/logs/app.log（进程模式直接重定向，
+// 容器模式由 Agent 跟随容器日志写入）。后台按大小轮转（copytruncate：复制为 app.log.1 后截断，
+// 应用无需重新打开文件），并按数量和时间清理旧文件。
+
+const instanceLogName = "app.log"
+
+// logConfig 日志轮转配置
+type logConfig struct {
+	maxSize  int64         // 单个文件上限
+	maxFiles int           // 保留的轮转文件数
+	maxAge   time.Duration // 轮转文件最长保留时间
+}
+
+func loadLogConfig() logConfig {
+	cfg := logConfig{maxSize: 10 << 20, maxFiles: 3, maxAge: 72 * time.Hour}
+	if n, err := strconv.Atoi(os.Getenv("APP_LOG_MAX_SIZE_MB")); err == nil && n > 0 {
+		cfg.maxSize = int64(n) << 20
+	}
+	if n, err := strconv.Atoi(os.Getenv("APP_LOG_MAX_FILES")); err == nil && n >= 0 {
+		cfg.maxFiles = n
+	}
+	if n, err := strconv.Atoi(os.Getenv("APP_LOG_MAX_AGE_HOURS")); err == nil && n > 0 {
+		cfg.maxAge = time.Duration(n) * time.Hour
+	}
+	return cfg
+}
+
+// instanceLogPath 实例当前日志文件路径
+func instanceLogPath(baseDir, instanceID string) string {
+	return filepath.Join(baseDir, instanceID, "logs", instanceLogName)
+}
+
+// openInstanceLog 以追加方式打开实例日志（截断后写入位置自动回到文件开头）
+func openInstanceLog(baseDir, instanceID string) (*os.File, error) {
+	path := instanceLogPath(baseDir, instanceID)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// RunLogRotation 周期性轮转 baseDir 下所有实例的日志
+func RunLogRotation(baseDir string, stopCh <-chan struct{}) {
+	cfg := loadLogConfig()
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		matches, _ := filepath.Glob(filepath.Join(baseDir, "*", "logs", instanceLogName))
+		for _, path := range matches {
+			if err := rotateLog(path, cfg); err != nil {
+				LogWarn("Failed to rotate log %s: %v", path, err)
+			}
+		}
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// rotateLog 超过大小上限时轮转：app.log.N-1 → app.log.N …，app.log 复制为 app.log.1 后截断
+func rotateLog(path string, cfg logConfig) error {
+	// 清理过期的轮转文件
+	olds, _ := filepath.Glob(path + ".*")
+	for _, old := range olds {
+		if fi, err := os.Stat(old); err == nil && time.Since(fi.ModTime()) > cfg.maxAge {
+			os.Remove(old)
+		}
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil || fi.Size() < cfg.maxSize {
+		return nil
+	}
+	if cfg.maxFiles == 0 {
+		return os.Truncate(path, 0)
+	}
+	os.Remove(fmt.Sprintf("%s.%d", path, cfg.maxFiles))
+	for i := cfg.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", path, i), fmt.Sprintf("%s.%d", path, i+1))
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.Create(path + ".1")
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Truncate(path, 0)
+}
+
+// tailLog 读取实例日志的最后 n 行（当前文件不足时继续读取轮转文件），返回内容和当前文件的读取位置
+func tailLog(path string, n int) ([]byte, int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	offset := int64(len(data))
+	lines := splitLines(data)
+	for i := 1; len(lines) < n; i++ {
+		older, err := os.ReadFile(fmt.Sprintf("%s.%d", path, i))
+		if err != nil {
+			break
+		}
+		lines = append(splitLines(older), lines...)
+	}
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return bytes.Join(lines, nil), offset, nil
+}
+
+// splitLines 按行切分并保留换行符
+func splitLines(data []byte) [][]byte {
+	var lines [][]byte
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, data)
+			break
+		}
+		lines = append(lines, data[:i+1])
+		data = data[i+1:]
+	}
+	return lines
+}
+
+// handleInstanceLogs GET /v1/instances/{id}/logs?tail=N&follow=true
+func (r *Reconciler) handleInstanceLogs(w http.ResponseWriter, req *http.Request, instanceID string) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	tail := 100
+	if v := req.URL.Query().Get("tail"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid tail", http.StatusBadRequest)
+			return
+		}
+		tail = n
+	}
+	follow := req.URL.Query().Get("follow") == "true"
+
+	path := instanceLogPath(r.baseDir, instanceID)
+	data, offset, err := tailLog(path, tail)
+	if err != nil {
+		if os.IsNotExist(err) {
+			http.Error(w, "no logs for instance", http.StatusNotFound)
+			return
+		}
+		http.Error(w, "read log failed", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(data)
+	if !follow {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return
+	}
+	flusher.Flush()
+
+	// 跟随：轮询文件增长；文件变小说明已被轮转截断，从头读取
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-req.Context().Done():
+			return
+		case <-ticker.C:
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+		if fi, err := f.Stat(); err == nil && fi.Size() < offset {
+			offset = 0
+		}
+		if _, err := f.Seek(offset, io.SeekStart); err == nil {
+			n, _ := io.Copy(w, bufio.NewReader(f))
+			offset += n
+			if n > 0 {
+				flusher.Flush()
+			}
+		}
+		f.Close()
+	}
+}
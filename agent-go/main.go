@@ -2,6 +2,7 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
@@ -71,9 +72,42 @@ func main() {
 	httpClient := NewHTTPClient()
 	reconciler := NewReconciler(fmt.Sprintf("%s/%s", dataDir, nodeID), httpClient, controller, nodeID)
 
+	// 本地 HTTP 接口（实例日志等，由 Controller 转发调用）；端口为 0 时不启用
+	apiPort, _ := strconv.Atoi(getEnv("AGENT_API_PORT", "18081"))
+	if apiPort > 0 {
+		StartAPIServer(fmt.Sprintf(":%d", apiPort), reconciler)
+	}
+	// 实例日志轮转
+	logRotateStop := make(chan struct{})
+	defer close(logRotateStop)
+	go RunLogRotation(reconciler.baseDir, logRotateStop)
+
 	// 信号处理
 	stopCh := make(chan bool, 1)
 	nudgeCh := make(chan bool, 100)
+	// 探测结果变化（就绪/存活失败）或制品下载结束时立即同步
+	reconciler.SetNotify(func() {
+		select {
+		case nudgeCh <- true:
+		default:
+		}
+	})
+	// 控制通道：已连接时由 Controller 推送分配增量，心跳 / 状态 / 服务注册也经由该通道
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	channel := NewControlChannel(controller, nodeID, func() {
+		select {
+		case nudgeCh <- true:
+		default:
+		}
+	}, func(ack LeaseAck) {
+		if len(ack.Fenced) > 0 {
+			reconciler.Fence(ack.Fenced)
+		}
+	})
+	reconciler.SetControlChannel(channel)
+	channel.Start(ctx)
+
 	sigCh := make(chan os.Signal, 1)
 	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM, syscall.SIGTSTP)
 
@@ -83,7 +117,7 @@ func main() {
 		stopCh <- true
 	}()
 
-	// SSE监听
+	// SSE监听（控制通道已连接时不需要）
 	go func() {
 		for {
 			select {
@@ -91,11 +125,29 @@ func main() {
 				return
 			default:
 			}
+			if channel.Connected() {
+				time.Sleep(1 * time.Second)
+				continue
+			}
 
 			url := fmt.Sprintf("%s/v1/stream?nodeId=%s", controller, nodeID)
-			resp, err := http.Get(url)
+			sseCtx, sseCancel := context.WithCancel(ctx)
+			up := channel.Up()
+			go func() {
+				// 控制通道连接后断开 SSE
+				select {
+				case <-up:
+					sseCancel()
+				case <-sseCtx.Done():
+				}
+			}()
+			req, _ := http.NewRequestWithContext(sseCtx, http.MethodGet, url, nil)
+			resp, err := http.DefaultClient.Do(req)
 			if err != nil {
-				log.Printf("SSE connection failed: %v", err)
+				sseCancel()
+				if !channel.Connected() {
+					log.Printf("SSE connection failed: %v", err)
+				}
 				time.Sleep(1 * time.Second)
 				continue
 			}
@@ -108,6 +160,7 @@ func main() {
 				}
 			}
 			resp.Body.Close()
+			sseCancel()
 
 			select {
 			case <-stopCh:
@@ -128,53 +181,93 @@ func main() {
 	log.Printf("Agent sync interval: %v", syncInterval)
 	ticker := time.NewTicker(syncInterval)
 	defer ticker.Stop()
+	offline := false // 正在按最近一次的分配离线协调
+
+	// 心跳（附带节点资源）由独立的 goroutine 按同步间隔发送，实例启动 / 停止较慢时不会延误
+	go func() {
+		heartbeatTicker := time.NewTicker(syncInterval)
+		defer heartbeatTicker.Stop()
+		for {
+			heartbeat := NodeHeartbeat{
+				NodeID:    nodeID,
+				IP:        agentIP,
+				Resources: reconciler.CollectResources(),
+				APIPort:   apiPort,
+			}
+			// 控制通道可用时经由通道发送（应答中的隔离实例由通道回调处理），否则使用 HTTP
+			if err := channel.SendHeartbeat(heartbeat); err != nil {
+				url := controller + "/v1/nodes/heartbeat"
+				var ack LeaseAck
+				if err := httpClient.PostJSONResponse(url, heartbeat, &ack); err != nil {
+					log.Printf("Heartbeat failed: %v", err)
+				} else if len(ack.Fenced) > 0 {
+					reconciler.Fence(ack.Fenced)
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-heartbeatTicker.C:
+			}
+		}
+	}()
 
 	for {
-		// 发送心跳
-		heartbeat := map[string]string{
-			"nodeId": nodeID,
-			"ip":     agentIP,
+		// 获取分配：优先使用控制通道推送的分配；
+		// 否则 HTTP 获取，Controller 不可达时按最近一次（或本地保存）的分配继续协调
+		var result struct {
+			Items []Assignment `json:"items"`
 		}
-		url := controller + "/v1/nodes/heartbeat"
-		if err := httpClient.PostJSON(url, heartbeat); err != nil {
-			log.Printf("Heartbeat failed: %v", err)
+		var err error
+		if items, ok := channel.Assignments(); ok {
+			result.Items = items
+		} else {
+			assignURL := fmt.Sprintf("%s/v1/assignments?nodeId=%s", controller, nodeID)
+			var data []byte
+			data, err = httpClient.Get(assignURL)
+			if err == nil {
+				if err = json.Unmarshal(data, &result); err != nil {
+					err = fmt.Errorf("parse assignments: %w", err)
+				}
+			}
 		}
-
-		// 获取分配
-		assignURL := fmt.Sprintf("%s/v1/assignments?nodeId=%s", controller, nodeID)
-		data, err := httpClient.Get(assignURL)
 		if err != nil {
 			log.Printf("Failed to get assignments: %v", err)
-		}
-		if err == nil && len(data) > 0 {
-			var result struct {
-				Items []Assignment `json:"items"`
+			if _, savedAt, ok := reconciler.LastKnownAssignments(); ok {
+				if !offline {
+					LogWarn("Controller unreachable, reconciling with last-known assignments from %s", savedAt.Format(time.RFC3339))
+					offline = true
+				}
+				reconciler.SyncLastKnown()
+			}
+		} else {
+			assignments := result.Items
+			// 同步状态
+			reconciler.Sync(assignments)
+			reconciler.SaveState(assignments)
+			if offline {
+				LogInfo("Controller reachable again, resuming normal reconciliation")
+				reconciler.ReportRunning()
+				offline = false
 			}
-			if err := json.Unmarshal(data, &result); err != nil {
-				log.Printf("Failed to parse assignments: %v", err)
-			} else {
-				assignments := result.Items
-				// 同步状态
-				reconciler.Sync(assignments)
-
-				// 注册服务
-				for _, a := range assignments {
-					if a.Desired == "Running" {
-						reconciler.RegisterServices(a.InstanceID, nodeID, agentIP, &a)
-						reconciler.HeartbeatServices(a.InstanceID)
-					}
+
+			// 注册服务
+			for _, a := range assignments {
+				if a.Desired == "Running" {
+					reconciler.RegisterServices(a.InstanceID, nodeID, agentIP, &a)
+					reconciler.HeartbeatServices(a.InstanceID)
 				}
 			}
 		}
 
-		// 等待5秒或被SSE唤醒
+		// 等待同步间隔，或被控制通道 / SSE 唤醒
 		select {
 		case <-stopCh:
 			log.Println("Stopping agent...")
 			reconciler.StopAll()
 			return
 		case <-nudgeCh:
-			// SSE事件触发，立即执行下一轮
+			// 分配变化（控制通道 / SSE 事件）触发，立即执行下一轮
 		case <-ticker.C:
 			// 定时触发
 		}
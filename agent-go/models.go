@@ -14,6 +14,28 @@ type Assignment struct {
 	ImageRepository string `json:"imageRepository,omitempty"`
 	ImageTag        string `json:"imageTag,omitempty"`
 	PortMappings    string `json:"portMappings,omitempty"` // JSON string
+
+	Probes        *Probes `json:"probes,omitempty"`        // 部署规格中的探针（与 meta.ini 中的探针合并）
+	RestartPolicy string  `json:"restartPolicy,omitempty"` // Always（默认）| OnFailure | Never
+	RestartCount  int     `json:"restartCount,omitempty"`  // Controller 记录的重启次数（Agent 重启后继续累计）
+	Limits        *Limits `json:"limits,omitempty"`        // 资源限制（容器由 Docker、进程由 cgroup v2 施加）
+	Hooks         *Hooks  `json:"hooks,omitempty"`         // 生命周期钩子（见 hooks.go）
+
+	// TerminationGracePeriodSec 停止时 preStop 钩子与优雅退出的总时限（0 使用默认等待时间）
+	TerminationGracePeriodSec int `json:"terminationGracePeriodSec,omitempty"`
+
+	ArtifactSHA256 string `json:"artifactSha256,omitempty"` // ZIP 制品的 SHA256（下载后校验）
+	ArtifactSize   int64  `json:"artifactSize,omitempty"`   // ZIP 制品大小（字节）
+
+	// 部署条目注入的环境变量和配置文件（见 config.go）
+	Env         map[string]string `json:"env,omitempty"`
+	ConfigFiles []ConfigFile      `json:"configFiles,omitempty"`
+	ConfigHash  string            `json:"configHash,omitempty"`
+	// ConfigWithheld 从本地状态恢复的分配不含环境变量和配置文件（密钥不落盘），Controller 重新下发前不启动
+	ConfigWithheld bool `json:"configWithheld,omitempty"`
+
+	// HostPorts 启动前分配的宿主机端口（服务名 -> 端口，Agent 本地使用，见 ports.go）
+	HostPorts map[string]int `json:"-"`
 }
 
 // InstanceStatus 实例状态
@@ -21,8 +43,13 @@ type InstanceStatus struct {
 	InstanceID string `json:"instanceId"`
 	Phase      string `json:"phase"`
 	ExitCode   int    `json:"exitCode"`
-	Healthy    bool   `json:"healthy"`
+	Healthy    bool   `json:"healthy"` // 存活
+	Ready      bool   `json:"ready"`   // 就绪（就绪探针已通过）
 	TsUnix     int64  `json:"tsUnix"`
+
+	RestartCount int    `json:"restartCount"`      // 累计重启次数
+	Reason       string `json:"reason,omitempty"`  // 退出原因：Completed | Error | OOMKilled | PreStartHookFailed | PostStartHookFailed
+	Message      string `json:"message,omitempty"` // 原因的补充说明（如失败钩子的输出末尾）
 }
 
 // ServiceEndpoint 服务端点
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// NodeResources 心跳中上报的节点资源（与 Controller 的 store.NodeResources 对应）
+type NodeResources struct {
+	CPUCores       int      `json:"cpuCores"`
+	CPULoad        float64  `json:"cpuLoad"`
+	MemTotalMB     int64    `json:"memTotalMB"`
+	MemAvailableMB int64    `json:"memAvailableMB"`
+	DiskTotalMB    int64    `json:"diskTotalMB"`
+	DiskFreeMB     int64    `json:"diskFreeMB"`
+	Instances      int      `json:"instances"`
+	Arch           string   `json:"arch"`
+	OS             string   `json:"os"`
+	Docker         bool     `json:"docker"`
+	Images         []string `json:"images,omitempty"`
+}
+
+// NodeHeartbeat 节点心跳
+type NodeHeartbeat struct {
+	NodeID    string         `json:"nodeId"`
+	IP        string         `json:"ip"`
+	Resources *NodeResources `json:"resources,omitempty"`
+	APIPort   int            `json:"apiPort,omitempty"` // Agent 本地 HTTP 接口端口（0 表示未启用）
+}
+
+// LeaseAck 心跳响应
+type LeaseAck struct {
+	TTLSec int64    `json:"ttlSec"`
+	Fenced []string `json:"fenced,omitempty"` // 已被故障转移接替、须立即停止的实例
+}
+
+// CollectResources 采集节点资源：CPU / 内存来自 /proc，磁盘为数据目录所在文件系统。
+// 不持有 Reconciler 锁，心跳不等待进行中的同步、停止或本地接口请求
+func (r *Reconciler) CollectResources() *NodeResources {
+	res := &NodeResources{
+		CPUCores:  runtime.NumCPU(),
+		Arch:      runtime.GOARCH,
+		OS:        runtime.GOOS,
+		Instances: r.RunningCount(),
+	}
+	if data, err := os.ReadFile("/proc/loadavg"); err == nil {
+		if fields := strings.Fields(string(data)); len(fields) > 0 {
+			res.CPULoad, _ = strconv.ParseFloat(fields[0], 64)
+		}
+	}
+	res.MemTotalMB, res.MemAvailableMB = readMemInfo()
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(r.baseDir, &st); err == nil {
+		res.DiskTotalMB = int64(st.Blocks) * int64(st.Bsize) / (1 << 20)
+		res.DiskFreeMB = int64(st.Bavail) * int64(st.Bsize) / (1 << 20)
+	}
+	if dm, ok := r.dockerManager.(*DockerManager); ok && dm != nil {
+		res.Docker = true
+		res.Images = dm.ListImages()
+	}
+	return res
+}
+
+// readMemInfo 读取 /proc/meminfo 中的总内存和可用内存（MB）
+func readMemInfo() (int64, int64) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+	var total, avail int64
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		kb, _ := strconv.ParseInt(fields[1], 10, 64)
+		switch fields[0] {
+		case "MemTotal:":
+			total = kb / 1024
+		case "MemAvailable:":
+			avail = kb / 1024
+		}
+	}
+	return total, avail
+}
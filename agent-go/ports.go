@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 动态端口：meta.ini 中 service=<名称>:<协议>:auto 或镜像应用端口映射中 "host": "auto" 的服务，
+// 启动前从 AGENT_PORT_RANGE（默认 30000-32767）中分配空闲的宿主机端口。所有服务端口以
+// PLUM_PORT_<服务名> 注入实例；容器模式下 ZIP 应用映射到容器内同一端口，镜像应用映射到声明的容器端口；
+// 注册服务端点时使用实际端口。分配随本地状态保存，实例重启时尽量沿用，停止后释放。
+// 固定端口已被本节点其它实例占用或已被监听时不启动实例，以 PortConflict 原因上报。
+
+const ReasonPortConflict = "PortConflict"
+
+// servicePort 实例声明的服务端口
+type servicePort struct {
+	Name     string
+	Protocol string
+	Port     int // 0 表示 auto
+}
+
+// portAllocator 节点上各实例的宿主机端口（调用方持有 Reconciler 锁）
+type portAllocator struct {
+	lo, hi     int
+	next       int
+	byInstance map[string]map[string]int // instanceID -> 服务名 -> 端口
+}
+
+func newPortAllocator() *portAllocator {
+	lo, hi := 30000, 32767
+	if v := getEnv("AGENT_PORT_RANGE", ""); v != "" {
+		a, b, _ := strings.Cut(v, "-")
+		l, err1 := strconv.Atoi(strings.TrimSpace(a))
+		h, err2 := strconv.Atoi(strings.TrimSpace(b))
+		if err1 == nil && err2 == nil && 0 < l && l <= h && h <= 65535 {
+			lo, hi = l, h
+		} else {
+			LogWarn("Invalid AGENT_PORT_RANGE %q, using %d-%d", v, lo, hi)
+		}
+	}
+	return &portAllocator{lo: lo, hi: hi, next: lo, byInstance: map[string]map[string]int{}}
+}
+
+// owner 占用端口的其它实例
+func (p *portAllocator) owner(port int, exceptID string) string {
+	for id, ports := range p.byInstance {
+		if id == exceptID {
+			continue
+		}
+		for _, used := range ports {
+			if used == port {
+				return id
+			}
+		}
+	}
+	return ""
+}
+
+// allocate 为实例分配端口：固定端口校验冲突，auto 端口优先沿用上次分配
+func (p *portAllocator) allocate(instanceID string, ports []servicePort) (map[string]int, error) {
+	prev := p.byInstance[instanceID]
+	out := make(map[string]int, len(ports))
+	taken := func(port int) bool {
+		for _, used := range out {
+			if used == port {
+				return true
+			}
+		}
+		return p.owner(port, instanceID) != ""
+	}
+	for _, sp := range ports {
+		if sp.Port > 0 {
+			if owner := p.owner(sp.Port, instanceID); owner != "" {
+				return nil, fmt.Errorf("port %d of service %s is used by instance %s", sp.Port, sp.Name, owner)
+			}
+			if !portFree(sp.Port, sp.Protocol) {
+				return nil, fmt.Errorf("port %d of service %s is already in use on this node", sp.Port, sp.Name)
+			}
+			out[sp.Name] = sp.Port
+			continue
+		}
+		if port := prev[sp.Name]; port > 0 && !taken(port) && portFree(port, sp.Protocol) {
+			out[sp.Name] = port
+			continue
+		}
+		port := 0
+		for i := 0; i <= p.hi-p.lo; i++ {
+			candidate := p.next
+			if p.next++; p.next > p.hi {
+				p.next = p.lo
+			}
+			if !taken(candidate) && portFree(candidate, sp.Protocol) {
+				port = candidate
+				break
+			}
+		}
+		if port == 0 {
+			return nil, fmt.Errorf("no free port in %d-%d for service %s", p.lo, p.hi, sp.Name)
+		}
+		out[sp.Name] = port
+	}
+	if len(out) == 0 {
+		delete(p.byInstance, instanceID)
+		return nil, nil
+	}
+	p.byInstance[instanceID] = out
+	return out, nil
+}
+
+// portFree 端口当前是否可以监听
+func portFree(port int, protocol string) bool {
+	addr := ":" + strconv.Itoa(port)
+	if strings.EqualFold(protocol, "udp") {
+		c, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return false
+		}
+		c.Close()
+		return true
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// servicePortsOf 实例声明的服务端口：ZIP 应用读 meta.ini，镜像应用读端口映射中的宿主机端口
+func servicePortsOf(a Assignment, appDir string) []servicePort {
+	var out []servicePort
+	if a.ArtifactType != "image" {
+		if appDir == "" {
+			return nil
+		}
+		eps, _ := ParseMetaINI(filepath.Join(appDir, "meta.ini"))
+		for _, ep := range eps {
+			out = append(out, servicePort{Name: ep.ServiceName, Protocol: ep.Protocol, Port: ep.Port})
+		}
+		return out
+	}
+	for _, pm := range parsePortMappings(a) {
+		if pm.Auto || pm.Host > 0 {
+			out = append(out, servicePort{Name: pm.ServiceName, Protocol: pm.Protocol, Port: pm.Host})
+		}
+	}
+	return out
+}
+
+// portMapping 镜像应用的端口映射（host 为 "auto" 时由 Agent 分配）
+type portMapping struct {
+	ServiceName string
+	Protocol    string
+	Host        int
+	Container   int
+	Auto        bool
+}
+
+// parsePortMappings 解析分配中的端口映射 JSON，服务名默认为应用名、协议默认为 http
+func parsePortMappings(a Assignment) []portMapping {
+	if a.PortMappings == "" {
+		return nil
+	}
+	var raw []map[string]interface{}
+	if err := json.Unmarshal([]byte(a.PortMappings), &raw); err != nil {
+		LogWarn("Failed to parse port mappings for instance %s: %v", a.InstanceID, err)
+		return nil
+	}
+	out := make([]portMapping, 0, len(raw))
+	for _, m := range raw {
+		pm := portMapping{ServiceName: a.AppName, Protocol: "http"}
+		if s, _ := m["serviceName"].(string); s != "" {
+			pm.ServiceName = s
+		}
+		if pm.ServiceName == "" {
+			pm.ServiceName = "unknown"
+		}
+		if s, _ := m["protocol"].(string); s != "" {
+			pm.Protocol = s
+		}
+		switch v := m["host"].(type) {
+		case float64:
+			pm.Host = int(v)
+		case string:
+			pm.Auto = strings.EqualFold(v, "auto")
+		}
+		if v, ok := m["container"].(float64); ok {
+			pm.Container = int(v)
+		}
+		out = append(out, pm)
+	}
+	return out
+}
+
+// portEnvName 服务端口的环境变量名：PLUM_PORT_ 加大写服务名（非字母数字替换为 _）
+func portEnvName(service string) string {
+	var b strings.Builder
+	b.WriteString("PLUM_PORT_")
+	for _, c := range strings.ToUpper(service) {
+		if (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') {
+			b.WriteRune(c)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	return b.String()
+}
+
+// portEnv 注入实例的端口环境变量（按服务名排序）
+func portEnv(a Assignment) []string {
+	names := make([]string, 0, len(a.HostPorts))
+	for name := range a.HostPorts {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	out := make([]string, 0, len(names))
+	for _, name := range names {
+		out = append(out, portEnvName(name)+"="+strconv.Itoa(a.HostPorts[name]))
+	}
+	return out
+}
+
+// reservePorts 启动前为实例分配宿主机端口并写入 a.HostPorts；冲突或端口耗尽时按一次启动失败上报，返回 false
+func (r *Reconciler) reservePorts(a *Assignment, appDir string) bool {
+	ports, err := r.ports.allocate(a.InstanceID, servicePortsOf(*a, appDir))
+	if err != nil {
+		LogError("Cannot start instance %s: %v", a.InstanceID, err)
+		r.failStart(*a, -1, ReasonPortConflict, err.Error())
+		return false
+	}
+	a.HostPorts = ports
+	r.persistState()
+	return true
+}
+
+// releasePorts 释放既不应运行也未在运行的实例的端口
+func (r *Reconciler) releasePorts(keep, running map[string]bool) {
+	for id := range r.ports.byInstance {
+		if !keep[id] && !running[id] {
+			delete(r.ports.byInstance, id)
+		}
+	}
+}
+
+// resolveEndpointPorts 用实际分配的端口替换服务端点的端口，丢弃未分配的 auto 端点
+func (r *Reconciler) resolveEndpointPorts(instanceID string, endpoints []ServiceEndpoint) []ServiceEndpoint {
+	allocated := r.ports.byInstance[instanceID]
+	out := endpoints[:0]
+	for _, ep := range endpoints {
+		if port, ok := allocated[ep.ServiceName]; ok {
+			ep.Port = port
+		}
+		if ep.Port > 0 {
+			out = append(out, ep)
+		}
+	}
+	return out
+}
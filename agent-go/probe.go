@@ -0,0 +1,496 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// 实例健康探针：存活探针连续失败时重启实例，就绪探针通过前不注册服务端点。
+// 探针来自部署规格（Assignment.Probes）和应用 meta.ini，部署规格中的同类探针优先。
+
+// Probe 探针配置（与 Controller 的 deployment.Probe 对应）
+type Probe struct {
+	Type             string `json:"type"` // http | tcp | exec | grpc
+	Port             int    `json:"port,omitempty"`
+	Path             string `json:"path,omitempty"`
+	Command          string `json:"command,omitempty"`
+	Service          string `json:"service,omitempty"`
+	InitialDelaySec  int    `json:"initialDelaySec,omitempty"`
+	PeriodSec        int    `json:"periodSec,omitempty"`
+	TimeoutSec       int    `json:"timeoutSec,omitempty"`
+	FailureThreshold int    `json:"failureThreshold,omitempty"`
+	SuccessThreshold int    `json:"successThreshold,omitempty"`
+}
+
+// Probes 存活探针和就绪探针
+type Probes struct {
+	Liveness  *Probe `json:"liveness,omitempty"`
+	Readiness *Probe `json:"readiness,omitempty"`
+}
+
+func (p Probe) period() time.Duration {
+	if p.PeriodSec > 0 {
+		return time.Duration(p.PeriodSec) * time.Second
+	}
+	return 10 * time.Second
+}
+
+func (p Probe) timeout() time.Duration {
+	if p.TimeoutSec > 0 {
+		return time.Duration(p.TimeoutSec) * time.Second
+	}
+	return time.Second
+}
+
+func (p Probe) failureThreshold() int {
+	if p.FailureThreshold > 0 {
+		return p.FailureThreshold
+	}
+	return 3
+}
+
+func (p Probe) successThreshold() int {
+	if p.SuccessThreshold > 0 {
+		return p.SuccessThreshold
+	}
+	return 1
+}
+
+func (p Probe) String() string {
+	switch p.Type {
+	case "http":
+		return fmt.Sprintf("http :%d%s", p.Port, p.Path)
+	case "exec":
+		return "exec " + p.Command
+	default:
+		return fmt.Sprintf("%s :%d", p.Type, p.Port)
+	}
+}
+
+// mergeProbes 合并部署规格和 meta.ini 中的探针，部署规格优先
+func mergeProbes(spec *Probes, meta Probes) Probes {
+	out := meta
+	if spec != nil {
+		if spec.Liveness != nil {
+			out.Liveness = spec.Liveness
+		}
+		if spec.Readiness != nil {
+			out.Readiness = spec.Readiness
+		}
+	}
+	return out
+}
+
+// ParseMetaProbes 解析 meta.ini 中的探针声明：
+//
+//	liveness=http:8080/healthz      （http:<端口><路径>）
+//	readiness=tcp:8080              （tcp:<端口>）
+//	readiness=grpc:9090/my.Service  （grpc:<端口>[/<服务名>]）
+//	liveness=exec:./check.sh        （exec:<命令>，在应用目录中执行）
+//	liveness.periodSec=5            （initialDelaySec / periodSec / timeoutSec / failureThreshold / successThreshold）
+func ParseMetaProbes(content string) Probes {
+	var out Probes
+	opts := map[string]map[string]int{"liveness": {}, "readiness": {}}
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		key, val, ok := strings.Cut(line, "=")
+		if !ok || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if kind, opt, ok := strings.Cut(key, "."); ok {
+			if m, known := opts[kind]; known {
+				if n, err := strconv.Atoi(val); err == nil {
+					m[opt] = n
+				}
+			}
+			continue
+		}
+		if key != "liveness" && key != "readiness" {
+			continue
+		}
+		p, err := parseProbeSpec(val)
+		if err != nil {
+			LogWarn("Ignoring %s probe in meta.ini: %v", key, err)
+			continue
+		}
+		if key == "liveness" {
+			out.Liveness = p
+		} else {
+			out.Readiness = p
+		}
+	}
+	for kind, p := range map[string]*Probe{"liveness": out.Liveness, "readiness": out.Readiness} {
+		if p == nil {
+			continue
+		}
+		m := opts[kind]
+		p.InitialDelaySec = m["initialDelaySec"]
+		p.PeriodSec = m["periodSec"]
+		p.TimeoutSec = m["timeoutSec"]
+		p.FailureThreshold = m["failureThreshold"]
+		p.SuccessThreshold = m["successThreshold"]
+	}
+	return out
+}
+
+func parseProbeSpec(val string) (*Probe, error) {
+	typ, arg, ok := strings.Cut(val, ":")
+	if !ok || arg == "" {
+		return nil, fmt.Errorf("invalid probe %q", val)
+	}
+	p := &Probe{Type: typ}
+	switch typ {
+	case "exec":
+		p.Command = arg
+		return p, nil
+	case "http", "tcp", "grpc":
+		port, rest, _ := strings.Cut(arg, "/")
+		n, err := strconv.Atoi(port)
+		if err != nil || n <= 0 || n > 65535 {
+			return nil, fmt.Errorf("invalid port in %q", val)
+		}
+		p.Port = n
+		switch typ {
+		case "http":
+			p.Path = "/" + rest
+		case "grpc":
+			p.Service = rest
+		}
+		return p, nil
+	default:
+		return nil, fmt.Errorf("unknown probe type %q", typ)
+	}
+}
+
+// probeTarget 探针的执行环境
+type probeTarget struct {
+	host   string                                                 // http / tcp / grpc 探测的地址
+	dir    string                                                 // exec 探针的工作目录（进程模式）
+	execIn func(ctx context.Context, command string) (int, error) // exec 探针在容器内执行（容器模式）
+}
+
+// check 执行一次探测
+func (t probeTarget) check(p Probe) error {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout())
+	defer cancel()
+	addr := net.JoinHostPort(t.host, strconv.Itoa(p.Port))
+	switch p.Type {
+	case "http":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+p.Path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Errorf("http status %d", resp.StatusCode)
+		}
+		return nil
+	case "tcp":
+		conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	case "grpc":
+		conn, err := grpc.NewClient(addr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+		resp, err := healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{Service: p.Service})
+		if err != nil {
+			return err
+		}
+		if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+			return fmt.Errorf("grpc health %s", resp.GetStatus())
+		}
+		return nil
+	case "exec":
+		var code int
+		if t.execIn != nil {
+			var err error
+			if code, err = t.execIn(ctx, p.Command); err != nil {
+				return err
+			}
+		} else {
+			// 不注入 PLUM_INSTANCE_ID：进程管理器按该环境变量识别实例进程
+			cmd := exec.CommandContext(ctx, "sh", "-c", p.Command)
+			cmd.Dir = t.dir
+			cmd.Env = os.Environ()
+			if err := cmd.Run(); err != nil {
+				if exitErr, ok := err.(*exec.ExitError); ok {
+					code = exitErr.ExitCode()
+				} else {
+					return err
+				}
+			}
+		}
+		if code != 0 {
+			return fmt.Errorf("exit code %d", code)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown probe type %q", p.Type)
+}
+
+// probeWorker 一个实例的探测协程及结果
+type probeWorker struct {
+	probes Probes
+	stop   chan struct{}
+
+	mu         sync.Mutex
+	ready      bool   // 就绪探针已通过（没有就绪探针时始终为 true）
+	liveFailed bool   // 存活探针已判定失败
+	liveErr    string // 存活探针最后一次失败的原因
+}
+
+// Prober 管理所有实例的探测协程。探测结果变化时调用 onChange 触发一轮同步。
+type Prober struct {
+	mu       sync.Mutex
+	workers  map[string]*probeWorker
+	onChange func()
+}
+
+func NewProber() *Prober {
+	return &Prober{workers: make(map[string]*probeWorker), onChange: func() {}}
+}
+
+// SetOnChange 设置探测结果变化时的回调（需在启动探测前设置）
+func (p *Prober) SetOnChange(fn func()) {
+	p.onChange = fn
+}
+
+// Start 为实例启动探测（已有相同配置的探测时不重复启动）
+func (p *Prober) Start(instanceID string, probes Probes, target func() probeTarget) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if w, ok := p.workers[instanceID]; ok {
+		if sameProbes(w.probes, probes) {
+			return
+		}
+		close(w.stop)
+		delete(p.workers, instanceID)
+	}
+	if probes.Liveness == nil && probes.Readiness == nil {
+		return
+	}
+	w := &probeWorker{probes: probes, stop: make(chan struct{}), ready: probes.Readiness == nil}
+	p.workers[instanceID] = w
+	t := target()
+	if probes.Liveness != nil {
+		go p.run(instanceID, w, *probes.Liveness, true, t)
+	}
+	if probes.Readiness != nil {
+		go p.run(instanceID, w, *probes.Readiness, false, t)
+	}
+	LogInfo("Started probes for instance %s (liveness: %v, readiness: %v)", instanceID, probes.Liveness != nil, probes.Readiness != nil)
+}
+
+// Stop 停止实例的探测
+func (p *Prober) Stop(instanceID string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if w, ok := p.workers[instanceID]; ok {
+		close(w.stop)
+		delete(p.workers, instanceID)
+	}
+}
+
+// Instances 有探测在运行的实例
+func (p *Prober) Instances() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ids := make([]string, 0, len(p.workers))
+	for id := range p.workers {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// Ready 实例是否就绪（没有就绪探针的实例视为就绪）
+func (p *Prober) Ready(instanceID string) bool {
+	p.mu.Lock()
+	w, ok := p.workers[instanceID]
+	p.mu.Unlock()
+	if !ok {
+		return true
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.ready && !w.liveFailed
+}
+
+// LivenessFailed 实例的存活探针是否已判定失败，返回最后一次失败原因
+func (p *Prober) LivenessFailed(instanceID string) (bool, string) {
+	p.mu.Lock()
+	w, ok := p.workers[instanceID]
+	p.mu.Unlock()
+	if !ok {
+		return false, ""
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.liveFailed, w.liveErr
+}
+
+// run 按周期执行一个探针，连续成功/失败达到阈值时更新结果
+func (p *Prober) run(instanceID string, w *probeWorker, pr Probe, liveness bool, target probeTarget) {
+	kind := "readiness"
+	if liveness {
+		kind = "liveness"
+	}
+	select {
+	case <-w.stop:
+		return
+	case <-time.After(time.Duration(pr.InitialDelaySec) * time.Second):
+	}
+	ticker := time.NewTicker(pr.period())
+	defer ticker.Stop()
+	successes, failures := 0, 0
+	for {
+		err := target.check(pr)
+		if err == nil {
+			successes, failures = successes+1, 0
+		} else {
+			successes, failures = 0, failures+1
+			LogDebug("%s probe (%s) failed for instance %s: %v", kind, pr, instanceID, err)
+		}
+		changed := false
+		w.mu.Lock()
+		if liveness && err != nil {
+			w.liveErr = err.Error()
+		}
+		switch {
+		case liveness && failures >= pr.failureThreshold():
+			w.liveFailed, changed = true, true
+		case !liveness && !w.ready && successes >= pr.successThreshold():
+			w.ready, changed = true, true
+			LogInfo("Instance %s is ready (%s)", instanceID, pr)
+		case !liveness && w.ready && failures >= pr.failureThreshold():
+			w.ready, changed = false, true
+			LogWarn("Instance %s is not ready: readiness probe (%s) failed %d times: %v", instanceID, pr, failures, err)
+		}
+		w.mu.Unlock()
+		if changed {
+			p.onChange()
+		}
+		if liveness && failures >= pr.failureThreshold() {
+			return // 由 Reconciler 重启实例，重启后重新开始探测
+		}
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func sameProbes(a, b Probes) bool {
+	eq := func(x, y *Probe) bool {
+		if x == nil || y == nil {
+			return x == y
+		}
+		return *x == *y
+	}
+	return eq(a.Liveness, b.Liveness) && eq(a.Readiness, b.Readiness)
+}
+
+// startProbes 按部署规格和 meta.ini 为运行中的实例启动探测
+func (r *Reconciler) startProbes(a Assignment, appManager AppManager) {
+	meta, ok := r.metaProbes[a.InstanceID]
+	if !ok {
+		meta = r.readMetaProbes(a)
+		r.metaProbes[a.InstanceID] = meta
+	}
+	r.prober.Start(a.InstanceID, mergeProbes(a.Probes, meta), func() probeTarget {
+		if dm, ok := appManager.(*DockerManager); ok {
+			return dm.probeTarget(a.InstanceID)
+		}
+		return probeTarget{host: "127.0.0.1", dir: filepath.Join(r.baseDir, a.InstanceID, "app")}
+	})
+}
+
+// readMetaProbes 读取实例 meta.ini 中的探针：ZIP 应用读解压目录，镜像应用读容器内的 meta.ini
+func (r *Reconciler) readMetaProbes(a Assignment) Probes {
+	metaPath := filepath.Join(r.baseDir, a.InstanceID, "app", "meta.ini")
+	if data, err := os.ReadFile(metaPath); err == nil {
+		return ParseMetaProbes(string(data))
+	}
+	if a.ArtifactType == "image" {
+		if content := r.readMetaFromContainer(a.InstanceID); content != "" {
+			return ParseMetaProbes(content)
+		}
+	}
+	return Probes{}
+}
+
+// forgetProbes 停止实例的探测并清除相关状态
+func (r *Reconciler) forgetProbes(instanceID string) {
+	r.prober.Stop(instanceID)
+	delete(r.metaProbes, instanceID)
+	delete(r.reportedReady, instanceID)
+}
+
+// syncProbes 维护运行中实例的探测：存活探针判定失败时重启实例，
+// 就绪状态变化时上报，未就绪的实例从服务发现中摘除（就绪后重新注册）
+func (r *Reconciler) syncProbes(assignments []Assignment, keep map[string]bool) {
+	for _, id := range r.prober.Instances() {
+		if !keep[id] {
+			r.forgetProbes(id)
+		}
+	}
+	for _, a := range assignments {
+		if a.Desired != "Running" {
+			continue
+		}
+		if _, stopping := r.stopSentTimes[a.InstanceID]; stopping || r.busy[a.InstanceID] {
+			continue
+		}
+		appManager := r.getAppManagerByInstanceID(a.InstanceID)
+		if appManager == nil || !appManager.IsRunning(a.InstanceID) {
+			r.forgetProbes(a.InstanceID)
+			continue
+		}
+		r.startProbes(a, appManager)
+		if failed, reason := r.prober.LivenessFailed(a.InstanceID); failed {
+			LogWarn("Liveness probe failed for instance %s (%s), restarting", a.InstanceID, reason)
+			r.forgetProbes(a.InstanceID)
+			r.deleteServices(a.InstanceID)
+			r.postStatus(a.InstanceID, "Running", 0, false)
+			// 在后台停止，之后由 reapExited 上报退出状态，ensureRunning 重新启动
+			id, h := a.InstanceID, r.hooks[a.InstanceID]
+			r.goInstance(id, func() {
+				if err := r.terminate(appManager, id, h); err != nil {
+					LogError("Failed to stop instance %s after liveness failure: %v", id, err)
+				}
+			})
+			continue
+		}
+		ready := r.prober.Ready(a.InstanceID)
+		if prev, ok := r.reportedReady[a.InstanceID]; !ok || prev != ready {
+			r.reportedReady[a.InstanceID] = ready
+			if !ready {
+				r.deleteServices(a.InstanceID)
+			}
+			r.postStatus(a.InstanceID, "Running", 0, true)
+		}
+	}
+}
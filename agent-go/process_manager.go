@@ -2,8 +2,11 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
@@ -11,6 +14,7 @@ import (
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -19,7 +23,27 @@ import (
 // 封装现有的进程启动逻辑
 type ProcessManager struct {
 	config    ManagerConfig
+	mu        sync.Mutex           // 保护 processes 和 exitCodes（同步循环、停止与本地接口并发调用）
 	processes map[string]*exec.Cmd // instanceID -> cmd
+	exitCodes map[string]int       // 已退出进程的退出码（回收僵尸进程时记录）
+}
+
+// process 实例由本进程启动的 cmd（没有时返回 nil）
+func (m *ProcessManager) process(instanceID string) *exec.Cmd {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.processes[instanceID]
+}
+
+// dropProcess 移除实例的 cmd 记录（记录已被替换时不移除），返回是否移除
+func (m *ProcessManager) dropProcess(instanceID string, cmd *exec.Cmd) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.processes[instanceID] != cmd {
+		return false
+	}
+	delete(m.processes, instanceID)
+	return true
 }
 
 func (m *ProcessManager) pidFile(instanceID string) string {
@@ -64,9 +88,20 @@ func NewProcessManager(config ManagerConfig) *ProcessManager {
 	return &ProcessManager{
 		config:    config,
 		processes: make(map[string]*exec.Cmd),
+		exitCodes: make(map[string]int),
 	}
 }
 
+// isZombie 进程是否已退出但尚未被回收（kill(pid, 0) 对僵尸进程仍然成功）
+func isZombie(pid int) bool {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return false
+	}
+	idx := strings.LastIndex(string(data), ")")
+	return idx != -1 && idx+2 < len(data) && data[idx+2] == 'Z'
+}
+
 func (m *ProcessManager) findPIDByEnv(instanceID string) int {
 	for _, pid := range m.findPIDsByEnv(instanceID) {
 		if err := syscall.Kill(pid, syscall.Signal(0)); err == nil {
@@ -120,15 +155,13 @@ func (m *ProcessManager) findRunningProcessByInstanceID(instanceID string) int {
 		m.removePID(instanceID)
 	}
 
-	if cmd, exists := m.processes[instanceID]; exists {
-		if cmd != nil && cmd.Process != nil {
-			pid := cmd.Process.Pid
-			if err := syscall.Kill(pid, syscall.Signal(0)); err == nil {
-				m.writePID(instanceID, pid)
-				return pid
-			}
-			delete(m.processes, instanceID)
+	if cmd := m.process(instanceID); cmd != nil && cmd.Process != nil {
+		pid := cmd.Process.Pid
+		if err := syscall.Kill(pid, syscall.Signal(0)); err == nil {
+			m.writePID(instanceID, pid)
+			return pid
 		}
+		m.dropProcess(instanceID, cmd)
 	}
 
 	if pid := m.findPIDByEnv(instanceID); pid > 0 {
@@ -151,7 +184,10 @@ func (m *ProcessManager) StartApp(instanceID string, app Assignment, appDir stri
 	}
 
 	// 清理 processes map 中的无效记录
+	m.mu.Lock()
 	delete(m.processes, instanceID)
+	delete(m.exitCodes, instanceID)
+	m.mu.Unlock()
 
 	startSh := filepath.Join(appDir, "start.sh")
 	if err := os.Chmod(startSh, 0755); err != nil {
@@ -165,32 +201,37 @@ func (m *ProcessManager) StartApp(instanceID string, app Assignment, appDir stri
 
 	cmd := exec.Command("sh", "-c", cmdline)
 	cmd.Dir = appDir
-	envVars := []string{
-		"PLUM_INSTANCE_ID=" + app.InstanceID,
-		"PLUM_APP_NAME=" + app.AppName,
-		"PLUM_APP_VERSION=" + app.AppVersion,
-		"WORKER_NODE_ID=" + m.config.NodeID,
-	}
+	cmd.Env = append(os.Environ(), m.instanceEnv(app)...)
+	// 创建新的进程组
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
 
-	// 自动注入 MAIN_CONTROL_BASE 环境变量（如果 MainControl 服务可用）
-	// 所有应用都可以使用此环境变量来访问 MainControl 服务（如发送进度更新）
-	mainControlBase := discoverMainControlBase(m.config.Controller)
-	if mainControlBase != "" {
-		envVars = append(envVars, "MAIN_CONTROL_BASE="+mainControlBase)
-		log.Printf("Set MAIN_CONTROL_BASE=%s for instance %s", mainControlBase, instanceID)
+	// stdout/stderr 直接写入实例日志文件（不经过管道，子进程残留时也不会阻塞 Wait）
+	logFile, err := openInstanceLog(m.config.BaseDir, instanceID)
+	if err != nil {
+		log.Printf("Failed to open log file for instance %s: %v", instanceID, err)
+	} else {
+		defer logFile.Close()
+		cmd.Stdout = logFile
+		cmd.Stderr = logFile
 	}
-	// 注意：如果服务发现失败，不注入环境变量，应用可以使用默认值或通过其他方式获取地址
 
-	cmd.Env = append(os.Environ(), envVars...)
-	// 创建新的进程组
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	// 清理上次运行的 cgroup（重置 OOM 计数）
+	removeCgroup(instanceID)
 
 	if err := cmd.Start(); err != nil {
 		return fmt.Errorf("failed to start process: %w", err)
 	}
 
 	log.Printf("Started instance %s, PID=%d", instanceID, cmd.Process.Pid)
+	m.mu.Lock()
 	m.processes[instanceID] = cmd
+	m.mu.Unlock()
+	// 立即移入实例 cgroup，之后 fork 的子进程自动继承
+	if limits := limitsOf(app); limits != (Limits{}) {
+		if err := m.applyLimits(instanceID, limits, []int{cmd.Process.Pid}); err != nil {
+			log.Printf("Failed to apply resource limits to instance %s: %v", instanceID, err)
+		}
+	}
 
 	actualPID := cmd.Process.Pid
 	time.Sleep(100 * time.Millisecond)
@@ -201,10 +242,42 @@ func (m *ProcessManager) StartApp(instanceID string, app Assignment, appDir stri
 	return nil
 }
 
-// StopApp 停止应用进程
+// instanceEnv 实例进程的环境变量（追加在 Agent 自身环境变量之后）
+func (m *ProcessManager) instanceEnv(app Assignment) []string {
+	envVars := []string{
+		"PLUM_INSTANCE_ID=" + app.InstanceID,
+		"PLUM_APP_NAME=" + app.AppName,
+		"PLUM_APP_VERSION=" + app.AppVersion,
+		"WORKER_NODE_ID=" + m.config.NodeID,
+	}
+
+	// 自动注入 MAIN_CONTROL_BASE 环境变量（如果 MainControl 服务可用）
+	// 所有应用都可以使用此环境变量来访问 MainControl 服务（如发送进度更新）
+	mainControlBase := discoverMainControlBase(m.config.Controller)
+	if mainControlBase != "" {
+		envVars = append(envVars, "MAIN_CONTROL_BASE="+mainControlBase)
+		log.Printf("Set MAIN_CONTROL_BASE=%s for instance %s", mainControlBase, app.InstanceID)
+	}
+	// 注意：如果服务发现失败，不注入环境变量，应用可以使用默认值或通过其他方式获取地址
+
+	// 部署条目注入的环境变量；配置文件位于 $PLUM_CONFIG_DIR<mountPath>/<key>
+	envVars = append(envVars, configEnv(app)...)
+	envVars = append(envVars, portEnv(app)...)
+	if len(app.ConfigFiles) > 0 {
+		envVars = append(envVars, "PLUM_CONFIG_DIR="+instanceConfigDir(m.config.BaseDir, app.InstanceID))
+	}
+	return envVars
+}
+
+// StopApp 停止应用进程：发送 SIGTERM，grace（默认 3 秒）内未退出则 SIGKILL
 // 优化：优先使用 processes map，避免不必要的系统查找
-func (m *ProcessManager) StopApp(instanceID string) error {
+func (m *ProcessManager) StopApp(instanceID string, grace time.Duration) error {
 	log.Printf("StopApp: preparing to stop instance %s", instanceID)
+	// 停止期间由 StopApp 独占 cmd（等待并回收进程），并发的 IsRunning 不再对其调用 Wait
+	cmd := m.process(instanceID)
+	if cmd != nil {
+		m.dropProcess(instanceID, cmd)
+	}
 	// 查找所有运行中的进程（包括 processes map 和系统中实际运行的）
 	var (
 		pids   []int
@@ -227,14 +300,12 @@ func (m *ProcessManager) StopApp(instanceID string) error {
 		}
 	}
 
-	if cmd, exists := m.processes[instanceID]; exists {
-		if cmd != nil && cmd.Process != nil {
-			pid := cmd.Process.Pid
-			if err := syscall.Kill(pid, syscall.Signal(0)); err == nil {
-				addPID(pid, "processes_map")
-			} else {
-				log.Printf("StopApp: instance %s pid %d from processes_map not running (%v)", instanceID, pid, err)
-			}
+	if cmd != nil && cmd.Process != nil {
+		pid := cmd.Process.Pid
+		if err := syscall.Kill(pid, syscall.Signal(0)); err == nil {
+			addPID(pid, "processes_map")
+		} else {
+			log.Printf("StopApp: instance %s pid %d from processes_map not running (%v)", instanceID, pid, err)
 		}
 	}
 
@@ -246,8 +317,8 @@ func (m *ProcessManager) StopApp(instanceID string) error {
 
 	if len(pids) == 0 {
 		log.Printf("StopApp: instance %s no running pid found, nothing to stop", instanceID)
-		delete(m.processes, instanceID)
 		m.removePID(instanceID)
+		removeCgroup(instanceID)
 		return nil
 	}
 
@@ -264,15 +335,19 @@ func (m *ProcessManager) StopApp(instanceID string) error {
 		}
 	}
 
-	waitDone := make(chan struct{})
-	if cmd, exists := m.processes[instanceID]; exists && cmd != nil && cmd.Process != nil {
+	// 等待 cmd 回收进程（超时强制结束后由该 goroutine 完成回收）
+	waitDone := make(chan struct{}, 1)
+	if cmd != nil && cmd.Process != nil {
 		go func() {
 			cmd.Wait()
 			waitDone <- struct{}{}
 		}()
 	}
 
-	timeout := 3 * time.Second
+	timeout := grace
+	if timeout <= 0 {
+		timeout = 3 * time.Second
+	}
 	startTime := time.Now()
 	initialInterval := 50 * time.Millisecond
 	maxInterval := 200 * time.Millisecond
@@ -281,10 +356,11 @@ func (m *ProcessManager) StopApp(instanceID string) error {
 	lastCheck := time.Now()
 
 	for time.Since(startTime) < timeout {
+		// 外层 sh 退出后仍等待应用进程自身完成优雅退出
 		select {
 		case <-waitDone:
 			log.Printf("StopApp: instance %s cmd.Wait() completed", instanceID)
-			goto cleanup
+			waitDone = nil
 		default:
 		}
 
@@ -329,17 +405,11 @@ cleanup:
 		}
 	}
 
-	if cmd, exists := m.processes[instanceID]; exists {
-		if cmd != nil && cmd.Process != nil {
-			go cmd.Wait()
-		}
-	}
-
-	delete(m.processes, instanceID)
 	if remaining {
 		log.Printf("StopApp: instance %s still has remaining processes after SIGKILL", instanceID)
 	}
 	m.removePID(instanceID)
+	removeCgroup(instanceID)
 	log.Printf("StopApp: instance %s stop sequence complete", instanceID)
 	return nil
 }
@@ -348,26 +418,26 @@ cleanup:
 // 优化：优先使用 processes map，只在必要时才进行系统查找，避免每次都执行复杂的 ps 命令
 func (m *ProcessManager) IsRunning(instanceID string) bool {
 	if pid, err := m.readPID(instanceID); err == nil && pid > 0 {
-		if err := syscall.Kill(pid, syscall.Signal(0)); err == nil {
+		if err := syscall.Kill(pid, syscall.Signal(0)); err == nil && !isZombie(pid) {
 			return true
 		}
 		m.removePID(instanceID)
 	}
 
-	if cmd, exists := m.processes[instanceID]; exists && cmd != nil && cmd.Process != nil {
+	if cmd := m.process(instanceID); cmd != nil && cmd.Process != nil {
 		pid := cmd.Process.Pid
 
 		statPath := fmt.Sprintf("/proc/%d/stat", pid)
 		statData, err := os.ReadFile(statPath)
 		if err != nil {
-			delete(m.processes, instanceID)
+			m.dropProcess(instanceID, cmd)
 			return false
 		}
 
 		idx := strings.LastIndex(string(statData), ")")
 		if idx == -1 || idx+2 >= len(statData) {
 			if err := syscall.Kill(pid, syscall.Signal(0)); err != nil {
-				delete(m.processes, instanceID)
+				m.dropProcess(instanceID, cmd)
 				return false
 			}
 			m.writePID(instanceID, pid)
@@ -376,13 +446,20 @@ func (m *ProcessManager) IsRunning(instanceID string) bool {
 
 		state := statData[idx+2]
 		if state == 'Z' {
-			delete(m.processes, instanceID)
-			go cmd.Wait()
+			// 回收僵尸进程并记录退出码（被信号终止时为 -1）；并发调用时只由移除记录的一方回收
+			if m.dropProcess(instanceID, cmd) {
+				cmd.Wait()
+				if cmd.ProcessState != nil {
+					m.mu.Lock()
+					m.exitCodes[instanceID] = cmd.ProcessState.ExitCode()
+					m.mu.Unlock()
+				}
+			}
 			return false
 		}
 
 		if err := syscall.Kill(pid, syscall.Signal(0)); err != nil {
-			delete(m.processes, instanceID)
+			m.dropProcess(instanceID, cmd)
 			return false
 		}
 
@@ -405,8 +482,14 @@ func (m *ProcessManager) IsRunning(instanceID string) bool {
 
 // GetStatus 获取应用状态
 func (m *ProcessManager) GetStatus(instanceID string) (AppStatus, error) {
+	m.mu.Lock()
 	cmd, exists := m.processes[instanceID]
+	code, exited := m.exitCodes[instanceID]
+	m.mu.Unlock()
 	if !exists {
+		if exited {
+			return AppStatus{InstanceID: instanceID, Running: false, ExitCode: code, OOMKilled: cgroupOOMKilled(instanceID)}, nil
+		}
 		return AppStatus{
 			InstanceID: instanceID,
 			Running:    false,
@@ -443,15 +526,180 @@ func (m *ProcessManager) GetStatus(instanceID string) (AppStatus, error) {
 	}, nil
 }
 
+// UpdateLimits 更新实例 cgroup 的限制，并将实例的所有进程移入其中
+func (m *ProcessManager) UpdateLimits(instanceID string, limits Limits) error {
+	return m.applyLimits(instanceID, limits, m.findPIDsByEnv(instanceID))
+}
+
+// Exec 在实例主进程的工作目录中以相同的环境变量执行命令（不分配终端）。
+// 不传递 PLUM_INSTANCE_ID：进程管理器按该环境变量识别实例进程，调试命令不应被计入。
+func (m *ProcessManager) Exec(ctx context.Context, instanceID string, opts ExecOptions) (int, error) {
+	// 只读查找（不访问 processes，调用方不持有 Reconciler 锁）
+	pid, err := m.readPID(instanceID)
+	if err != nil || syscall.Kill(pid, 0) != nil {
+		pid = m.findPIDByEnv(instanceID)
+	}
+	if pid <= 0 {
+		return -1, errNotRunning
+	}
+	dir, err := os.Readlink(fmt.Sprintf("/proc/%d/cwd", pid))
+	if err != nil {
+		dir = filepath.Join(m.config.BaseDir, instanceID, "app")
+	}
+	env := os.Environ()
+	if data, err := os.ReadFile(fmt.Sprintf("/proc/%d/environ", pid)); err == nil {
+		env = env[:0]
+		for _, kv := range strings.Split(string(data), "\x00") {
+			if kv != "" && !strings.HasPrefix(kv, "PLUM_INSTANCE_ID=") {
+				env = append(env, kv)
+			}
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, opts.Command[0], opts.Command[1:]...)
+	cmd.Dir = dir
+	cmd.Env = env
+	cmd.Stdout, cmd.Stderr = opts.Stdout, opts.Stderr
+	// 独立进程组：取消时连同子进程一起结束
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	cmd.WaitDelay = 2 * time.Second
+	var stdin io.WriteCloser
+	if opts.Stdin != nil {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			return -1, err
+		}
+	}
+	if err := cmd.Start(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			// 与 shell 一致：命令不存在时退出码 127
+			fmt.Fprintln(opts.Stderr, err)
+			return 127, nil
+		}
+		return -1, err
+	}
+	if stdin != nil {
+		// 不等待标准输入复制结束（交互会话的输入在命令退出后才关闭）
+		go func() {
+			_, _ = io.Copy(stdin, opts.Stdin)
+			stdin.Close()
+		}()
+	}
+	err = cmd.Wait()
+	if ctx.Err() != nil {
+		return -1, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+// RunHook 实例启动前在应用目录中执行钩子命令（环境变量与实例相同，但不含 PLUM_INSTANCE_ID，
+// 避免钩子进程被识别为实例进程）
+func (m *ProcessManager) RunHook(ctx context.Context, app Assignment, appDir, command string, out io.Writer) (int, error) {
+	env := os.Environ()
+	for _, kv := range m.instanceEnv(app) {
+		if !strings.HasPrefix(kv, "PLUM_INSTANCE_ID=") {
+			env = append(env, kv)
+		}
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Dir = appDir
+	cmd.Env = env
+	cmd.Stdout, cmd.Stderr = out, out
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error { return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL) }
+	cmd.WaitDelay = 2 * time.Second
+	err := cmd.Run()
+	if ctx.Err() != nil {
+		return -1, ctx.Err()
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode(), nil
+	}
+	if err != nil {
+		return -1, err
+	}
+	return 0, nil
+}
+
+// applyLimits 创建（或复用）实例 cgroup，写入限制并移入进程；cgroup v2 不可用时只告警一次
+func (m *ProcessManager) applyLimits(instanceID string, limits Limits, pids []int) error {
+	if !cgroupAvailable() {
+		cgroupWarnOnce.Do(func() {
+			LogWarn("cgroup v2 not available at %s: resource limits are not enforced in process mode", cgroupRoot())
+		})
+		return nil
+	}
+	dir, err := ensureCgroup(instanceID)
+	if err != nil {
+		return err
+	}
+	if err := writeCgroupLimits(dir, limits, m.config.BaseDir); err != nil {
+		return err
+	}
+	for _, pid := range pids {
+		if err := os.WriteFile(filepath.Join(dir, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("move pid %d to cgroup: %w", pid, err)
+		}
+	}
+	return nil
+}
+
+// Usage 汇总实例所有进程（带有 PLUM_INSTANCE_ID 环境变量）的 CPU 时间和常驻内存
+func (m *ProcessManager) Usage(instanceID string) (AppUsage, error) {
+	var u AppUsage
+	for _, pid := range m.findPIDsByEnv(instanceID) {
+		stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+		if err != nil {
+			continue
+		}
+		// 字段从进程名 ")" 之后开始计：state 为第 3 个字段，utime / stime 为第 14 / 15 个
+		idx := strings.LastIndex(string(stat), ")")
+		if idx == -1 {
+			continue
+		}
+		fields := strings.Fields(string(stat[idx+1:]))
+		if len(fields) < 13 {
+			continue
+		}
+		utime, _ := strconv.ParseFloat(fields[11], 64)
+		stime, _ := strconv.ParseFloat(fields[12], 64)
+		u.CPUSeconds += (utime + stime) / 100 // USER_HZ
+		u.Processes++
+		if status, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid)); err == nil {
+			for _, line := range strings.Split(string(status), "\n") {
+				if f := strings.Fields(line); len(f) >= 2 && f[0] == "VmRSS:" {
+					kb, _ := strconv.ParseFloat(f[1], 64)
+					u.MemoryMB += kb / 1024
+				}
+			}
+		}
+	}
+	return u, nil
+}
+
 // ListRunning 列出所有运行中的实例ID
 func (m *ProcessManager) ListRunning() []string {
 	runningMap := make(map[string]bool)
 
+	m.mu.Lock()
+	started := make([]string, 0, len(m.processes))
 	for instanceID, cmd := range m.processes {
 		if cmd != nil && cmd.Process != nil {
-			if m.IsRunning(instanceID) {
-				runningMap[instanceID] = true
-			}
+			started = append(started, instanceID)
+		}
+	}
+	m.mu.Unlock()
+	for _, instanceID := range started {
+		if m.IsRunning(instanceID) {
+			runningMap[instanceID] = true
 		}
 	}
 
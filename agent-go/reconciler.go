@@ -1,12 +1,15 @@
 package main
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/manxisuo/plum/agent/agentpb"
 )
 
 // Reconciler 协调器
@@ -20,8 +23,31 @@ type Reconciler struct {
 	restartStartTimes  map[string]time.Time // 性能监控：记录重启开始时间
 	knownInstances     map[string]bool      // 已知实例列表（用于故障检测）
 	instanceTypes      map[string]string    // 实例类型映射：instanceID -> "image" 或 "zip"
-	completedInstances map[string]bool      // 已完成的镜像应用实例（避免重复标记）
 	registeredServices map[string]bool      // 已注册服务的实例（避免重复注册）
+	prober             *Prober              // 存活/就绪探针
+	metaProbes         map[string]Probes    // 实例 meta.ini 中声明的探针（启动时读取）
+	reportedReady      map[string]bool      // 已上报的就绪状态
+
+	restarts map[string]*restartState // 实例重启状态（重启策略与崩溃退避）
+
+	mu          sync.Mutex            // 保护实例状态（主循环与本地 HTTP 接口并发访问）
+	assignments map[string]Assignment // 最近一次同步的分配
+	held        map[string]bool       // 通过本地接口手动停止的实例（手动重启或期望状态变化前不自动拉起）
+	busy        map[string]bool       // 正在后台启动或停止的实例（同步时跳过，完成后再协调）
+
+	appliedLimits map[string]Limits // 运行中实例已施加的资源限制（变化时就地更新）
+	artifacts     *ArtifactCache    // ZIP 制品缓存（同一节点的实例共享）
+
+	lastKnown   []Assignment // 最近一次从 Controller 获取（或从本地恢复）的分配，离线时据此协调
+	lastKnownAt time.Time
+	savedState  []byte // 已写入 state.json 的内容（不含保存时间），用于跳过重复写盘
+
+	notify func()                    // 后台任务结束等需要立即同步时的回调
+	hooks  map[string]*instanceHooks // 实例的生命周期钩子与最近执行结果
+	hookMu sync.Mutex                // 保护钩子执行结果（钩子可在 r.mu 之外执行）
+	ports  *portAllocator            // 实例的宿主机端口
+
+	channel *ControlChannel // 控制通道（已连接时状态上报和服务注册经由该通道，否则使用 HTTP）
 }
 
 func NewReconciler(baseDir string, http *HTTPClient, controller string, nodeID string) *Reconciler {
@@ -40,10 +66,12 @@ func NewReconciler(baseDir string, http *HTTPClient, controller string, nodeID s
 	}
 
 	// 总是创建 DockerManager（镜像应用需要，ZIP 应用在 docker 模式下也需要）
-	dockerManager, err := NewDockerManager(config)
-	if err != nil {
+	// 注意：失败时必须保持接口为 nil，而不是包含 nil 指针的接口
+	var dockerManager AppManager
+	if dm, err := NewDockerManager(config); err != nil {
 		LogWarn("failed to create docker manager: %v (image apps will not work)", err)
-		dockerManager = nil
+	} else {
+		dockerManager = dm
 	}
 
 	// 根据环境变量创建 ProcessManager（ZIP 应用在 process 模式下需要）
@@ -55,7 +83,7 @@ func NewReconciler(baseDir string, http *HTTPClient, controller string, nodeID s
 		processManager = dockerManager
 	}
 
-	return &Reconciler{
+	r := &Reconciler{
 		baseDir:            baseDir,
 		http:               http,
 		controller:         controller,
@@ -65,9 +93,28 @@ func NewReconciler(baseDir string, http *HTTPClient, controller string, nodeID s
 		restartStartTimes:  make(map[string]time.Time),
 		knownInstances:     make(map[string]bool),
 		instanceTypes:      make(map[string]string),
-		completedInstances: make(map[string]bool),
+		restarts:           make(map[string]*restartState),
+		assignments:        make(map[string]Assignment),
+		held:               make(map[string]bool),
+		busy:               make(map[string]bool),
+		appliedLimits:      make(map[string]Limits),
+		hooks:              make(map[string]*instanceHooks),
+		ports:              newPortAllocator(),
+		artifacts:          NewArtifactCache(getEnv("AGENT_ARTIFACT_CACHE_DIR", filepath.Join(filepath.Dir(baseDir), "artifact-cache"))),
 		registeredServices: make(map[string]bool),
+		prober:             NewProber(),
+		metaProbes:         make(map[string]Probes),
+		reportedReady:      make(map[string]bool),
 	}
+	r.loadState()
+	return r
+}
+
+// SetNotify 探测结果变化、制品下载或实例后台任务结束时的回调（用于立即触发下一轮同步）
+func (r *Reconciler) SetNotify(fn func()) {
+	r.notify = fn
+	r.prober.SetOnChange(fn)
+	r.artifacts.SetOnDone(fn)
 }
 
 // getAppManager 根据应用类型返回对应的管理器
@@ -85,42 +132,59 @@ func (r *Reconciler) getAppManager(artifactType string) AppManager {
 func (r *Reconciler) getAppManagerByInstanceID(instanceID string) AppManager {
 	artifactType, exists := r.instanceTypes[instanceID]
 	if !exists {
-		// 如果不知道类型，默认尝试两个管理器
-		// 先检查 dockerManager（因为镜像应用必须用这个）
-		if r.dockerManager != nil && r.dockerManager.IsRunning(instanceID) {
-			return r.dockerManager
-		}
-		// 再检查 processManager
-		if r.processManager != nil && r.processManager.IsRunning(instanceID) {
-			return r.processManager
-		}
-		// 如果都不在运行，默认返回 processManager（向后兼容）
-		return r.processManager
+		return r.findAppManager(instanceID)
 	}
 	return r.getAppManager(artifactType)
 }
 
+// findAppManager 不知道实例类型时按运行情况查找管理器（只查询管理器，不需要持有 r.mu）
+func (r *Reconciler) findAppManager(instanceID string) AppManager {
+	// 如果不知道类型，默认尝试两个管理器
+	// 先检查 dockerManager（因为镜像应用必须用这个）
+	if r.dockerManager != nil && r.dockerManager.IsRunning(instanceID) {
+		return r.dockerManager
+	}
+	// 再检查 processManager
+	if r.processManager != nil && r.processManager.IsRunning(instanceID) {
+		return r.processManager
+	}
+	// 如果都不在运行，默认返回 processManager（向后兼容）
+	return r.processManager
+}
+
 // Sync 同步状态
 func (r *Reconciler) Sync(assignments []Assignment) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
 	keep := make(map[string]bool)
 	runningCount := 0
 
 	// 更新已知实例列表
 	newKnownInstances := make(map[string]bool)
+	r.assignments = make(map[string]Assignment, len(assignments))
 	for _, a := range assignments {
 		newKnownInstances[a.InstanceID] = true
+		r.assignments[a.InstanceID] = a
+		r.trackHooks(a)
 		if a.Desired == "Running" {
 			keep[a.InstanceID] = true
 			runningCount++
 		} else {
-			// 如果期望状态不是 Running，清除已完成标记（允许重新启动）
-			delete(r.completedInstances, a.InstanceID)
+			// 期望状态不是 Running，清除重启状态和手动停止标记（再次启动时重新计算）
+			delete(r.restarts, a.InstanceID)
+			delete(r.held, a.InstanceID)
+		}
+	}
+	// 清理不再存在的实例的重启状态
+	for instanceID := range r.restarts {
+		if !newKnownInstances[instanceID] {
+			delete(r.restarts, instanceID)
 		}
 	}
-	// 清理不再存在的实例的已完成标记
-	for instanceID := range r.completedInstances {
+	for instanceID := range r.held {
 		if !newKnownInstances[instanceID] {
-			delete(r.completedInstances, instanceID)
+			delete(r.held, instanceID)
 		}
 	}
 	r.knownInstances = newKnownInstances
@@ -144,10 +208,24 @@ func (r *Reconciler) Sync(assignments []Assignment) {
 			r.ensureRunning(a)
 		}
 	}
+
+	// 执行探针结果：存活失败时重启，就绪变化时上报
+	r.syncProbes(assignments, keep)
+
+	// 资源限制变化时就地更新
+	r.syncLimits(assignments, keep)
 }
 
-// ensureRunning 确保实例运行
+// ensureRunning 确保实例运行：持锁决定是否启动并准备配置和制品，
+// 解压、钩子和启动在实例的后台任务中执行（不占用同步循环和 r.mu）
 func (r *Reconciler) ensureRunning(a Assignment) {
+	if launch := r.prepareStart(a); launch != nil {
+		r.goInstance(a.InstanceID, launch)
+	}
+}
+
+// prepareStart 判断实例是否需要启动并做持锁部分的准备，返回在锁外执行的启动过程（不需要启动时返回 nil）
+func (r *Reconciler) prepareStart(a Assignment) func() {
 	// 确定 artifact 类型（默认为 zip，向后兼容）
 	artifactType := a.ArtifactType
 	if artifactType == "" {
@@ -163,67 +241,42 @@ func (r *Reconciler) ensureRunning(a Assignment) {
 		if artifactType == "image" {
 			LogError("DockerManager is nil! Cannot start image-based app.")
 		}
-		return
+		return nil
+	}
+
+	// 正在后台启动或停止，完成后再协调
+	if r.busy[a.InstanceID] {
+		return nil
 	}
 
 	// 检查是否已运行
 	if appManager.IsRunning(a.InstanceID) {
-		return // 应用已在运行
+		return nil // 应用已在运行
 	}
 
-	// 对于镜像应用，如果已经正常完成（exitCode: 0），不再自动重启
-	// 除非用户明确要求（通过重新部署）
-	// 注意：如果期望状态是 Running，应该清除已完成标记，允许重启
-	if artifactType == "image" {
-		if r.completedInstances[a.InstanceID] {
-			// 如果期望状态是 Running，清除已完成标记，允许重启
-			// 这可以处理容器意外退出后需要重启的情况
-			if a.Desired == "Running" {
-				LogInfo("Instance %s was marked as completed, but desired state is Running, clearing completed flag to allow restart", a.InstanceID)
-				delete(r.completedInstances, a.InstanceID)
-				// 继续执行启动流程
-			} else {
-				// 期望状态不是 Running，跳过重启（不打印日志，避免重复）
-				return
-			}
-		}
-		// 注意：不在这里检查容器状态，因为可能存在已停止的旧容器
-		// 应该在 StartApp 中处理已停止容器的删除，然后尝试启动新容器
-		// 如果新容器启动后立即退出，会在后续的故障检测中被发现并重启
+	// 按重启策略已终止、处于崩溃退避等待中，或已通过本地接口手动停止
+	if !r.canStart(a.InstanceID) || r.held[a.InstanceID] {
+		return nil
 	}
 
-	// 实例未运行，需要启动
-	LogInfo("Instance %s not running, will start", a.InstanceID)
-
-	// 记录实例类型
-	r.instanceTypes[a.InstanceID] = artifactType
-
-	// 性能监控：记录启动开始时间
-	r.restartStartTimes[a.InstanceID] = time.Now()
-
-	var appDir string
-	var err error
-
-	if artifactType == "image" {
-		// 镜像应用：直接使用 Docker 镜像启动，不需要下载 ZIP
-		LogInfo("Starting image-based app: %s:%s (InstanceID: %s)", a.ImageRepository, a.ImageTag, a.InstanceID)
-		if a.ImageRepository == "" || a.ImageTag == "" {
-			LogError("ImageRepository or ImageTag is empty! ArtifactType=%s, ImageRepository=%s, ImageTag=%s",
-				a.ArtifactType, a.ImageRepository, a.ImageTag)
-			return
-		}
-		appDir = "" // 镜像应用不需要 appDir
-		err = appManager.StartApp(a.InstanceID, a, appDir)
-	} else {
-		// ZIP 应用：下载、解压、启动
-		instDir := filepath.Join(r.baseDir, a.InstanceID)
-		EnsureDir(instDir)
+	// 从本地状态恢复的分配不含配置，等 Controller 重新下发后再启动
+	if a.ConfigWithheld {
+		LogDebug("Instance %s is waiting for its config from the controller", a.InstanceID)
+		return nil
+	}
 
-		zipPath := filepath.Join(instDir, "pkg.zip")
-		appDir = filepath.Join(instDir, "app")
+	if artifactType == "image" && (a.ImageRepository == "" || a.ImageTag == "") {
+		LogError("ImageRepository or ImageTag is empty! ArtifactType=%s, ImageRepository=%s, ImageTag=%s",
+			a.ArtifactType, a.ImageRepository, a.ImageTag)
+		return nil
+	}
 
-		// 下载artifact
-		if !FileExists(zipPath) {
+	// 镜像应用直接使用 Docker 镜像启动，不需要下载 ZIP；ZIP 应用从制品缓存取 ZIP，启动过程中解压
+	var appDir, zipPath string
+	if artifactType != "image" {
+		appDir = filepath.Join(r.baseDir, a.InstanceID, "app")
+		EnsureDir(appDir)
+		if !FileExists(filepath.Join(appDir, "start.sh")) {
 			artifactURL := a.ArtifactURL
 			// 规范化URL
 			if !strings.HasPrefix(artifactURL, "http://") && !strings.HasPrefix(artifactURL, "https://") {
@@ -234,52 +287,100 @@ func (r *Reconciler) ensureRunning(a Assignment) {
 				}
 			}
 
-			data, err := r.http.Get(artifactURL)
+			// 未缓存时在后台下载并校验，下载结束后的同步中再启动
+			var err error
+			zipPath, err = r.artifacts.Fetch(artifactURL, a.ArtifactSHA256, a.ArtifactSize)
 			if err != nil {
-				LogError("Failed to download artifact: %v", err)
-				return
-			}
-			if err := os.WriteFile(zipPath, data, 0644); err != nil {
-				LogError("Failed to save artifact: %v", err)
-				return
+				switch {
+				case errors.Is(err, errDownloadPending), errors.Is(err, errDownloadBackoff):
+					// 后台下载中或失败后退避中，之后的同步中再启动
+				case errors.Is(err, errChecksumMismatch):
+					LogError("Rejected artifact for instance %s: %v", a.InstanceID, err)
+					r.postExitStatus(a.InstanceID, "Failed", -1, false, ReasonChecksumMismatch)
+				default:
+					LogError("Failed to download artifact: %v", err)
+				}
+				return nil
 			}
-			LogInfo("Downloaded artifact to %s, size=%d", zipPath, len(data))
 		}
+	}
 
-		// 解压
-		EnsureDir(appDir)
-		startSh := filepath.Join(appDir, "start.sh")
-		if !FileExists(startSh) {
+	// 实例未运行，需要启动
+	LogInfo("Instance %s not running, will start", a.InstanceID)
+
+	// 记录实例类型
+	r.instanceTypes[a.InstanceID] = artifactType
+
+	// 性能监控：记录启动开始时间
+	r.restartStartTimes[a.InstanceID] = time.Now()
+
+	// 启动前写入配置文件（每次启动重建，配置更新由 Controller 替换实例生效）
+	if err := writeInstanceConfig(r.baseDir, a); err != nil {
+		LogError("Failed to write config for instance %s: %v", a.InstanceID, err)
+		return nil
+	}
+
+	h := r.hooks[a.InstanceID]
+	return func() { r.launch(a, appManager, appDir, zipPath, h) }
+}
+
+// launch 启动实例（不持有 r.mu，实例标记为 busy）：解压制品、分配端口、执行 preStart、启动、执行 postStart，
+// 成功后启动探针并上报 Running
+func (r *Reconciler) launch(a Assignment, appManager AppManager, appDir, zipPath string, h *instanceHooks) {
+	if appDir != "" {
+		// ZIP 应用：解压后确保启动脚本、钩子脚本和应用可执行文件有执行权限（容器模式也需要）
+		if zipPath != "" {
 			if err := UnzipFile(zipPath, appDir); err != nil {
 				LogError("Failed to unzip: %v", err)
 				return
 			}
 		}
-
-		// 确保start.sh有执行权限（容器模式也需要）
+		startSh := filepath.Join(appDir, "start.sh")
 		if FileExists(startSh) {
 			if err := os.Chmod(startSh, 0755); err != nil {
 				LogWarn("failed to chmod start.sh: %v", err)
 			}
 		}
+		prepareHookScripts(appDir)
 
-		// 确保应用可执行文件有执行权限
 		// 遍历应用目录，找到所有没有扩展名的文件（很可能是可执行文件）
 		// 或者检查文件是否是ELF可执行文件
 		if err := ensureExecutablePermissions(appDir); err != nil {
 			LogWarn("failed to set executable permissions: %v", err)
 		}
+	} else {
+		LogInfo("Starting image-based app: %s:%s (InstanceID: %s)", a.ImageRepository, a.ImageTag, a.InstanceID)
+	}
 
-		// 使用AppManager启动应用
-		err = appManager.StartApp(a.InstanceID, a, appDir)
+	// 分配端口，进程模式再执行 preStart 钩子，失败则不启动
+	r.mu.Lock()
+	reserved := r.reservePorts(&a, appDir)
+	r.mu.Unlock()
+	if !reserved || !r.runPreStart(a, appManager, appDir, h) {
+		return
 	}
 
-	if err != nil {
+	// 使用AppManager启动应用
+	if err := appManager.StartApp(a.InstanceID, a, appDir); err != nil {
 		LogError("Failed to start app: %v", err)
 		return
 	}
 
 	LogInfo("Started instance %s", a.InstanceID)
+	r.mu.Lock()
+	r.onStarted(a)
+	r.mu.Unlock()
+	if !r.runPostStart(a, appManager, h) {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.appliedLimits[a.InstanceID] = limitsOf(a)
+	// 先启动探针：配置了就绪探针的实例上报为未就绪
+	delete(r.metaProbes, a.InstanceID)
+	r.startProbes(a, appManager)
+	r.reportedReady[a.InstanceID] = r.prober.Ready(a.InstanceID)
 	r.postStatus(a.InstanceID, "Running", 0, true)
 
 	// 性能监控：记录重启时间
@@ -290,23 +391,59 @@ func (r *Reconciler) ensureRunning(a Assignment) {
 	}
 }
 
+// goInstance 在实例的后台任务中执行 fn（不持有 r.mu）：执行期间实例标记为 busy，同步跳过它，
+// 结束后触发下一轮同步。调用时持有 r.mu
+func (r *Reconciler) goInstance(instanceID string, fn func()) {
+	r.busy[instanceID] = true
+	go func() {
+		fn()
+		r.mu.Lock()
+		delete(r.busy, instanceID)
+		r.mu.Unlock()
+		if r.notify != nil {
+			r.notify()
+		}
+	}()
+}
+
+// runInstance 在当前 goroutine 中执行实例的启动 / 停止过程（本地接口等待结果时使用）：
+// 调用时持有 r.mu，执行期间释放锁并将实例标记为 busy
+func (r *Reconciler) runInstance(instanceID string, fn func()) {
+	r.busy[instanceID] = true
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.busy, instanceID)
+	}()
+	fn()
+}
+
+// stopInBackground 在后台停止实例（preStop 钩子和等待退出不占用同步循环），记录停止信号发送时间；
+// 停止失败时恢复为待停止，下一轮重试
+func (r *Reconciler) stopInBackground(appManager AppManager, instanceID string) {
+	r.stopSentTimes[instanceID] = time.Now().Unix()
+	h := r.hooks[instanceID]
+	r.goInstance(instanceID, func() {
+		if err := r.terminate(appManager, instanceID, h); err != nil {
+			LogError("Failed to stop app %s: %v", instanceID, err)
+			r.mu.Lock()
+			if _, ok := r.stopSentTimes[instanceID]; ok {
+				r.stopSentTimes[instanceID] = 0
+			}
+			r.mu.Unlock()
+		}
+	})
+}
+
 // ensureStoppedExcept 停止不需要的实例
 func (r *Reconciler) ensureStoppedExcept(keep map[string]bool) {
 	now := time.Now().Unix()
 
 	// 获取所有运行中的实例（合并两个管理器的结果）
 	// 这样可以发现所有运行中的实例，包括那些不在 assignments 中的（已删除的实例）
-	allRunning := make(map[string]bool)
-	if r.processManager != nil {
-		for _, id := range r.processManager.ListRunning() {
-			allRunning[id] = true
-		}
-	}
-	if r.dockerManager != nil {
-		for _, id := range r.dockerManager.ListRunning() {
-			allRunning[id] = true
-		}
-	}
+	allRunning := r.runningInstances()
+	r.pruneHooks(allRunning)
+	r.releasePorts(keep, allRunning)
 
 	// 检查需要停止的实例（已在 stopSentTimes 中的）
 	for instanceID := range r.stopSentTimes {
@@ -315,6 +452,9 @@ func (r *Reconciler) ensureStoppedExcept(keep map[string]bool) {
 			delete(r.stopSentTimes, instanceID)
 			continue
 		}
+		if r.busy[instanceID] {
+			continue
+		}
 
 		appManager := r.getAppManagerByInstanceID(instanceID)
 		if appManager == nil {
@@ -325,6 +465,8 @@ func (r *Reconciler) ensureStoppedExcept(keep map[string]bool) {
 			// 已经停止，清理状态
 			delete(r.stopSentTimes, instanceID)
 			delete(r.instanceTypes, instanceID)
+			removeInstanceConfig(r.baseDir, instanceID)
+			r.forgetProbes(instanceID)
 			r.postStatus(instanceID, "Stopped", 0, true)
 			r.deleteServices(instanceID)
 			continue
@@ -332,19 +474,17 @@ func (r *Reconciler) ensureStoppedExcept(keep map[string]bool) {
 
 		// 应用还在运行，需要停止
 		if r.stopSentTimes[instanceID] == 0 {
-			// 第一次尝试停止
-			if err := appManager.StopApp(instanceID); err != nil {
-				LogError("Failed to stop app %s: %v", instanceID, err)
-			} else {
-				r.stopSentTimes[instanceID] = now
-				LogInfo("Sent stop signal to instance %s", instanceID)
-			}
+			// 第一次尝试停止（preStop 钩子和等待退出在后台执行）
+			r.stopInBackground(appManager, instanceID)
+			LogInfo("Stopping instance %s", instanceID)
 		} else if now-r.stopSentTimes[instanceID] >= 5 {
 			// 5秒后强制停止（DockerManager已经处理了强制停止）
 			// 这里只需要清理状态
 			if !appManager.IsRunning(instanceID) {
 				delete(r.stopSentTimes, instanceID)
 				delete(r.instanceTypes, instanceID)
+				removeInstanceConfig(r.baseDir, instanceID)
+				r.forgetProbes(instanceID)
 				r.postStatus(instanceID, "Stopped", 0, true)
 				r.deleteServices(instanceID)
 				LogInfo("Stopped instance %s", instanceID)
@@ -355,8 +495,8 @@ func (r *Reconciler) ensureStoppedExcept(keep map[string]bool) {
 	// 检查所有运行中的实例，如果不在keep列表中，需要停止
 	// 这包括：1) Desired=Stopped 的实例，2) assignment 被删除的实例
 	for instanceID := range allRunning {
-		if keep[instanceID] {
-			continue // 应该在运行，跳过
+		if keep[instanceID] || r.busy[instanceID] {
+			continue // 应该在运行（或正在锁外停止），跳过
 		}
 
 		// 这个实例不应该运行，但正在运行，需要停止
@@ -371,12 +511,8 @@ func (r *Reconciler) ensureStoppedExcept(keep map[string]bool) {
 				appManager := r.getAppManagerByInstanceID(instanceID)
 				if appManager != nil {
 					// 立即尝试停止（不等待下一次循环）
-					if err := appManager.StopApp(instanceID); err != nil {
-						LogError("Failed to stop app %s: %v", instanceID, err)
-					} else {
-						r.stopSentTimes[instanceID] = now
-						LogInfo("Sent stop signal to instance %s (not in keep list, was known)", instanceID)
-					}
+					r.stopInBackground(appManager, instanceID)
+					LogInfo("Stopping instance %s (not in keep list, was known)", instanceID)
 				}
 			}
 		} else {
@@ -409,13 +545,8 @@ func (r *Reconciler) ensureStoppedExcept(keep map[string]bool) {
 				if shouldStop {
 					// 容器已经运行了一段时间，或者无法获取启动时间，停止它
 					if _, exists := r.stopSentTimes[instanceID]; !exists {
-						r.markForStop(instanceID)
-						if err := appManager.StopApp(instanceID); err != nil {
-							LogError("Failed to stop app %s: %v", instanceID, err)
-						} else {
-							r.stopSentTimes[instanceID] = now
-							LogInfo("Sent stop signal to instance %s (not in keep list, not in known instances, may be manually started or old container)", instanceID)
-						}
+						r.stopInBackground(appManager, instanceID)
+						LogInfo("Stopping instance %s (not in keep list, not in known instances, may be manually started or old container)", instanceID)
 					}
 				}
 			}
@@ -430,13 +561,33 @@ func (r *Reconciler) markForStop(instanceID string) {
 	}
 }
 
+// Fence 立即停止已被控制器接替的实例（节点失联期间已迁移到其它节点），
+// 收到心跳应答时先摘除服务，再在后台停止，避免新旧实例同时对外提供服务
+func (r *Reconciler) Fence(instanceIDs []string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, instanceID := range instanceIDs {
+		if r.stopSentTimes[instanceID] != 0 || r.busy[instanceID] {
+			continue // 已发送停止信号或正在停止
+		}
+		appManager := r.getAppManagerByInstanceID(instanceID)
+		if appManager == nil || !appManager.IsRunning(instanceID) {
+			continue
+		}
+		r.deleteServices(instanceID)
+		r.prober.Stop(instanceID)
+		r.stopInBackground(appManager, instanceID)
+		LogWarn("Fenced instance %s: superseded by failover while node was unreachable", instanceID)
+	}
+}
+
 // reapExited 检测并清理意外退出的应用
 // 这是故障检测的核心：检查所有应该运行的实例是否真的在运行
 func (r *Reconciler) reapExited(assignments []Assignment) {
 	// 检查所有期望运行但实际未运行的实例
 	for _, a := range assignments {
-		if a.Desired != "Running" {
-			continue // 只检查期望运行的实例
+		if a.Desired != "Running" || r.busy[a.InstanceID] {
+			continue // 只检查期望运行且不在锁外停止中的实例
 		}
 
 		// 检查实例是否真的在运行
@@ -450,66 +601,68 @@ func (r *Reconciler) reapExited(assignments []Assignment) {
 			continue
 		}
 
-		if !appManager.IsRunning(a.InstanceID) {
-			// 实例未运行，但期望运行
-			// 这可能是进程意外退出（被kill等）
-			// ProcessManager.IsRunning() 已经清理了内部状态，这里只需要上报
-
-			// 检查是否是我们主动停止的
-			if _, wasStopping := r.stopSentTimes[a.InstanceID]; !wasStopping {
-				// 检查是否已经标记为已完成（避免重复标记）
-				// 但如果期望状态是 Running，应该清除已完成标记，允许重启
-				if r.completedInstances[a.InstanceID] {
-					if a.Desired == "Running" {
-						// 期望状态是 Running，清除已完成标记，允许重启
-						LogInfo("Instance %s was marked as completed, but desired state is Running, clearing completed flag to allow restart", a.InstanceID)
-						delete(r.completedInstances, a.InstanceID)
-						// 继续执行故障检测和重启流程
-					} else {
-						// 期望状态不是 Running，跳过
-						continue
-					}
-				}
+		if appManager.IsRunning(a.InstanceID) {
+			r.observeRunning(a)
+			continue
+		}
 
-				// 不是主动停止的，获取退出状态
-				status, err := appManager.GetStatus(a.InstanceID)
-				exitCode := -1
-				if err == nil && !status.Running {
-					exitCode = status.ExitCode
-				}
+		// 实例未运行，但期望运行
+		// 这可能是进程意外退出（被kill等）
+		// ProcessManager.IsRunning() 已经清理了内部状态，这里只需要上报
 
-				// 判断应用类型
-				artifactType := a.ArtifactType
-				if artifactType == "" {
-					artifactType = "zip"
-				}
+		// 检查是否是我们主动停止的
+		if _, wasStopping := r.stopSentTimes[a.InstanceID]; wasStopping {
+			LogDebug("Instance %s is stopping (was marked for stop), skip restart", a.InstanceID)
+			continue
+		}
+		// 尚未启动，或退出已处理过（等待退避或按策略不再重启）
+		if !r.exitPending(a.InstanceID) {
+			continue
+		}
 
-				// 对于镜像应用，如果正常退出（exitCode: 0），视为"已完成"而不是"失败"
-				// 对于 ZIP 应用或异常退出，视为"失败"并重启
-				if artifactType == "image" && exitCode == 0 {
-					// 镜像应用正常退出，标记为已完成（只标记一次）
-					phase := "Completed"
-					healthy := true
-					r.postStatus(a.InstanceID, phase, exitCode, healthy)
-					r.completedInstances[a.InstanceID] = true // 记录已标记为已完成
-					LogInfo("Instance %s (image app) completed successfully (exitCode: %d), marked as Completed", a.InstanceID, exitCode)
-					// 不自动重启已完成的任务
-				} else {
-					// 异常退出或 ZIP 应用退出，视为失败
-					LogWarn("Detected instance %s process died unexpectedly (was not stopping, exitCode: %d)", a.InstanceID, exitCode)
-					phase := "Failed"
-					healthy := false
-					r.postStatus(a.InstanceID, phase, exitCode, healthy)
-					LogInfo("Reported instance %s as Failed, will restart in next ensureRunning", a.InstanceID)
-				}
+		// 不是主动停止的，获取退出状态
+		status, err := appManager.GetStatus(a.InstanceID)
+		exitCode := -1
+		if err == nil && !status.Running {
+			exitCode = status.ExitCode
+		}
 
-				// 清理停止标记（如果有）
-				delete(r.stopSentTimes, a.InstanceID)
-			} else {
-				LogDebug("Instance %s is stopping (was marked for stop), skip restart", a.InstanceID)
-			}
+		reason := ReasonError
+		if err == nil {
+			reason = exitReason(status)
+		}
+
+		// 按重启策略决定是否重启：需要退避时上报 CrashLoopBackOff，不再重启时上报 Completed / Failed
+		phase := r.onExit(a, exitCode)
+		healthy := phase == "Completed"
+		r.postExitStatus(a.InstanceID, phase, exitCode, healthy, reason)
+		if r.restarts[a.InstanceID].finished {
+			LogInfo("Instance %s exited (exitCode: %d, reason: %s), reported as %s, not restarting (restartPolicy: %s)", a.InstanceID, exitCode, reason, phase, a.RestartPolicy)
+		} else {
+			LogWarn("Detected instance %s process died unexpectedly (was not stopping, exitCode: %d, reason: %s), reported as %s", a.InstanceID, exitCode, reason, phase)
+		}
+	}
+}
+
+// runningInstances 合并两个管理器中运行中的实例
+func (r *Reconciler) runningInstances() map[string]bool {
+	allRunning := make(map[string]bool)
+	if r.processManager != nil {
+		for _, id := range r.processManager.ListRunning() {
+			allRunning[id] = true
 		}
 	}
+	if r.dockerManager != nil {
+		for _, id := range r.dockerManager.ListRunning() {
+			allRunning[id] = true
+		}
+	}
+	return allRunning
+}
+
+// RunningCount 运行中的实例数（心跳上报；只查询管理器，不需要持有 r.mu）
+func (r *Reconciler) RunningCount() int {
+	return len(r.runningInstances())
 }
 
 // StopAll 停止所有实例
@@ -518,26 +671,51 @@ func (r *Reconciler) StopAll() {
 	// 由于我们不知道所有实例ID，这里通过清空keep map来触发停止
 	r.Sync([]Assignment{})
 
-	// 等待最多7秒，让所有应用停止
-	for i := 0; i < 70; i++ {
+	// 等待后台启动 / 停止任务结束（preStop 钩子与宽限期），之后再最多等待7秒，让所有应用停止
+	for i := 0; i < 70; {
+		r.mu.Lock()
 		r.ensureStoppedExcept(make(map[string]bool))
+		pending := len(r.stopSentTimes)
+		busy := len(r.busy)
+		r.mu.Unlock()
 		time.Sleep(100 * time.Millisecond)
 		// 检查是否还有运行中的实例
 		// 由于无法直接获取所有实例，这里简化处理
-		if len(r.stopSentTimes) == 0 {
+		if pending == 0 && busy == 0 {
 			break
 		}
+		if busy == 0 {
+			i++
+		}
 	}
 }
 
 // postStatus 上报状态
 func (r *Reconciler) postStatus(instanceID, phase string, exitCode int, healthy bool) {
+	r.postExitStatus(instanceID, phase, exitCode, healthy, "")
+}
+
+// postExitStatus 上报状态，并附带退出原因（Completed | Error | OOMKilled）
+func (r *Reconciler) postExitStatus(instanceID, phase string, exitCode int, healthy bool, reason string) {
+	r.postStatusDetail(instanceID, phase, exitCode, healthy, reason, "")
+}
+
+// postStatusDetail 上报状态，附带退出原因及补充说明（如失败钩子的输出）
+func (r *Reconciler) postStatusDetail(instanceID, phase string, exitCode int, healthy bool, reason, message string) {
 	status := InstanceStatus{
 		InstanceID: instanceID,
 		Phase:      phase,
 		ExitCode:   exitCode,
 		Healthy:    healthy,
+		Ready:      healthy && r.prober.Ready(instanceID),
 		TsUnix:     time.Now().Unix(),
+
+		RestartCount: r.restartCount(instanceID),
+		Reason:       reason,
+		Message:      message,
+	}
+	if r.channel != nil && r.channel.SendStatus(status) == nil {
+		return
 	}
 	url := r.controller + "/v1/instances/status"
 	if err := r.http.PostJSON(url, status); err != nil {
@@ -545,11 +723,17 @@ func (r *Reconciler) postStatus(instanceID, phase string, exitCode int, healthy
 	}
 }
 
-// RegisterServices 注册服务
+// RegisterServices 注册服务（实例就绪后）
 // 采用增量注册模式：只注册meta.ini中定义的服务端点，不影响手动注册的其他服务
 // 这样可以在真实应用实例上手动注册额外服务，而不会被Agent覆盖
 // 使用缓存机制避免重复注册：如果实例已经注册过服务且容器还在运行，就跳过注册
 func (r *Reconciler) RegisterServices(instanceID, nodeID, ip string, assignment *Assignment) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	// 就绪探针通过前不注册服务端点
+	if !r.prober.Ready(instanceID) {
+		return
+	}
 	// 检查是否已经注册过服务
 	if r.registeredServices[instanceID] {
 		// 检查容器是否还在运行（如果容器重启了，需要重新注册）
@@ -563,7 +747,7 @@ func (r *Reconciler) RegisterServices(instanceID, nodeID, ip string, assignment
 					info, err := dm.client.ContainerInspect(dm.ctx, containerName)
 					if err == nil {
 						// 检查缓存的容器ID是否匹配
-						if cachedID, exists := dm.containers[instanceID]; exists {
+						if cachedID, exists := dm.container(instanceID); exists {
 							if cachedID != info.ID {
 								// 容器ID改变了，说明容器重启了，需要重新注册
 								LogDebug("Container ID changed for instance %s (old: %s, new: %s), re-registering services", instanceID, cachedID[:12], info.ID[:12])
@@ -647,46 +831,28 @@ func (r *Reconciler) RegisterServices(instanceID, nodeID, ip string, assignment
 		// 支持两种格式：
 		// 1. 简单格式：{"host": 4100, "container": 4100} - 使用 AppName 作为服务名称
 		// 2. 完整格式：{"serviceName": "planArea", "protocol": "http", "host": 4100, "container": 4100} - 使用指定的服务名称和协议
-		var portMaps []map[string]interface{}
-		if err := json.Unmarshal([]byte(assignment.PortMappings), &portMaps); err == nil {
-			// 默认服务名称（如果端口映射中没有指定）
-			defaultServiceName := assignment.AppName
-			if defaultServiceName == "" {
-				defaultServiceName = "unknown"
+		// host 为 "auto" 时使用 Agent 分配的端口（见下方 resolveEndpointPorts）
+		for _, pm := range parsePortMappings(*assignment) {
+			// 使用 host 端口（因为使用 host 网络模式时，容器端口就是 host 端口）
+			// 但如果没有 host 端口，使用 container 端口
+			port := pm.Host
+			if port <= 0 {
+				port = pm.Container
 			}
-			// 为每个端口映射创建一个服务端点
-			for _, pm := range portMaps {
-				hostPort, _ := pm["host"].(float64)
-				containerPort, _ := pm["container"].(float64)
-				// 使用 host 端口（因为使用 host 网络模式时，容器端口就是 host 端口）
-				// 但如果没有 host 端口，使用 container 端口
-				port := int(hostPort)
-				if port <= 0 {
-					port = int(containerPort)
-				}
-				if port > 0 {
-					// 优先使用端口映射中指定的服务名称和协议（与 meta.ini 格式一致）
-					serviceName, _ := pm["serviceName"].(string)
-					if serviceName == "" {
-						serviceName = defaultServiceName
-					}
-					protocol, _ := pm["protocol"].(string)
-					if protocol == "" {
-						protocol = "http" // 默认协议为 http
-					}
-					endpoints = append(endpoints, ServiceEndpoint{
-						ServiceName: serviceName,
-						Protocol:    protocol,
-						Port:        port,
-					})
-					LogDebug("Registered service endpoint from port mapping: %s:%s:%d (instance: %s)", serviceName, protocol, port, instanceID)
-				}
+			if port > 0 {
+				endpoints = append(endpoints, ServiceEndpoint{
+					ServiceName: pm.ServiceName,
+					Protocol:    pm.Protocol,
+					Port:        port,
+				})
+				LogDebug("Registered service endpoint from port mapping: %s:%s:%d (instance: %s)", pm.ServiceName, pm.Protocol, port, instanceID)
 			}
-		} else {
-			LogWarn("Failed to parse port mappings for instance %s: %v", instanceID, err)
 		}
 	}
 
+	// 使用实际分配的宿主机端口（auto 端口未分配时不注册）
+	endpoints = r.resolveEndpointPorts(instanceID, endpoints)
+
 	// 如果没有找到任何服务端点，标记为已处理（避免重复读取），然后返回
 	if len(endpoints) == 0 {
 		r.registeredServices[instanceID] = true
@@ -701,6 +867,11 @@ func (r *Reconciler) RegisterServices(instanceID, nodeID, ip string, assignment
 	}
 	// 使用增量注册模式（不传replace=true），只添加/更新服务端点
 	// 不会删除手动注册的其他服务端点
+	if r.channel != nil && r.channel.SendEndpoints(agentpb.EndpointRegistration_REGISTER, reg) == nil {
+		LogInfo("Registered %d service endpoint(s) for instance %s via control channel", len(endpoints), instanceID)
+		r.registeredServices[instanceID] = true
+		return
+	}
 	url := r.controller + "/v1/services/register"
 	if err := r.http.PostJSON(url, reg); err != nil {
 		LogError("Failed to register services for instance %s: %v", instanceID, err)
@@ -713,6 +884,9 @@ func (r *Reconciler) RegisterServices(instanceID, nodeID, ip string, assignment
 
 // HeartbeatServices 服务心跳
 func (r *Reconciler) HeartbeatServices(instanceID string) {
+	if r.channel != nil && r.channel.SendEndpoints(agentpb.EndpointRegistration_HEARTBEAT, ServiceRegistration{InstanceID: instanceID}) == nil {
+		return
+	}
 	url := r.controller + "/v1/services/heartbeat"
 	if err := r.http.PostJSON(url, HeartbeatRequest{InstanceID: instanceID}); err != nil {
 		LogError("Failed to heartbeat services: %v", err)
@@ -734,7 +908,7 @@ func (r *Reconciler) readMetaFromContainer(instanceID string) string {
 	}
 
 	// 获取容器ID
-	containerID, exists := dm.containers[instanceID]
+	containerID, exists := dm.container(instanceID)
 	if !exists {
 		// 尝试通过容器名查找
 		containerName := fmt.Sprintf("plum-app-%s", instanceID)
@@ -762,8 +936,10 @@ func (r *Reconciler) readMetaFromContainer(instanceID string) string {
 
 // deleteServices 删除服务
 func (r *Reconciler) deleteServices(instanceID string) {
-	url := fmt.Sprintf("%s/v1/services?instanceId=%s", r.controller, instanceID)
-	r.http.Delete(url)
+	if r.channel == nil || r.channel.SendEndpoints(agentpb.EndpointRegistration_DELETE, ServiceRegistration{InstanceID: instanceID}) != nil {
+		url := fmt.Sprintf("%s/v1/services?instanceId=%s", r.controller, instanceID)
+		r.http.Delete(url)
+	}
 	// 清除注册缓存，这样如果实例重新启动，可以重新注册服务
 	delete(r.registeredServices, instanceID)
 }
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+)
+
+// 重启策略与崩溃退避：实例意外退出后按部署的重启策略决定是否重启；
+// 连续崩溃时重启间隔指数增长（期间上报 CrashLoopBackOff），稳定运行一段时间后重置。
+
+const (
+	RestartAlways    = "Always"    // 总是重启（默认）
+	RestartOnFailure = "OnFailure" // 仅非 0 退出码时重启
+	RestartNever     = "Never"     // 不重启
+
+	PhaseCrashLoopBackOff = "CrashLoopBackOff"
+)
+
+// restartState 实例的重启状态
+type restartState struct {
+	count     int       // 累计重启次数（上报给 Controller）
+	crashes   int       // 连续崩溃次数（决定退避时长）
+	running   bool      // 已启动（或观察到在运行）且尚未处理其退出
+	startedAt time.Time // 最近一次启动时间
+	nextStart time.Time // 退避结束时间
+	finished  bool      // 按重启策略不再重启
+}
+
+func restartEnvSeconds(key string, def int) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return time.Duration(def) * time.Second
+}
+
+// restartBackoff 第 crashes 次连续崩溃后的等待时间：首次崩溃立即重启，
+// 之后为 base、2*base、4*base…，不超过上限
+func restartBackoff(crashes int) time.Duration {
+	if crashes <= 1 {
+		return 0
+	}
+	base := restartEnvSeconds("RESTART_BACKOFF_BASE_SEC", 10)
+	limit := restartEnvSeconds("RESTART_BACKOFF_MAX_SEC", 300)
+	d := base
+	for i := 2; i < crashes && d < limit; i++ {
+		d *= 2
+	}
+	return min(d, limit)
+}
+
+// shouldRestart 按重启策略判断退出的实例是否需要重启
+func shouldRestart(policy string, exitCode int) bool {
+	switch policy {
+	case RestartNever:
+		return false
+	case RestartOnFailure:
+		return exitCode != 0
+	default:
+		return true
+	}
+}
+
+// restartStateFor 取实例的重启状态，首次使用时以 Controller 记录的重启次数为起点
+func (r *Reconciler) restartStateFor(a Assignment) *restartState {
+	st, ok := r.restarts[a.InstanceID]
+	if !ok {
+		st = &restartState{count: a.RestartCount}
+		r.restarts[a.InstanceID] = st
+	}
+	return st
+}
+
+// observeRunning 记录实例在运行（Agent 重启后接管已在运行的实例）
+func (r *Reconciler) observeRunning(a Assignment) {
+	st := r.restartStateFor(a)
+	if !st.running {
+		st.running = true
+		st.startedAt = time.Now()
+	}
+}
+
+// onStarted 实例已启动：非首次启动计为一次重启
+func (r *Reconciler) onStarted(a Assignment) {
+	st := r.restartStateFor(a)
+	if !st.startedAt.IsZero() {
+		st.count++
+	}
+	st.running = true
+	st.startedAt = time.Now()
+	st.nextStart = time.Time{}
+}
+
+// exitPending 实例是否在运行后退出且尚未处理（未启动过或已处理过的退出返回 false）
+func (r *Reconciler) exitPending(instanceID string) bool {
+	st, ok := r.restarts[instanceID]
+	return ok && st.running
+}
+
+// onExit 处理实例的意外退出，返回应上报的阶段：
+// 不再重启时为 Completed / Failed，需要等待退避时为 CrashLoopBackOff
+func (r *Reconciler) onExit(a Assignment, exitCode int) string {
+	st := r.restartStateFor(a)
+	st.running = false
+	if time.Since(st.startedAt) >= restartEnvSeconds("RESTART_BACKOFF_RESET_SEC", 600) {
+		st.crashes = 0 // 稳定运行足够久，重新计算退避
+	}
+	phase := "Failed"
+	if exitCode == 0 {
+		phase = "Completed"
+	}
+	if !shouldRestart(a.RestartPolicy, exitCode) {
+		st.finished = true
+		return phase
+	}
+	st.crashes++
+	delay := restartBackoff(st.crashes)
+	st.nextStart = time.Now().Add(delay)
+	if delay > 0 {
+		LogWarn("Instance %s crashed %d times in a row, restarting in %v", a.InstanceID, st.crashes, delay)
+		return PhaseCrashLoopBackOff
+	}
+	return phase
+}
+
+// failStart 实例未能启动（钩子失败、端口冲突等）：计为一次启动尝试，连续失败时退避，
+// 按重启策略决定是否重试，并上报原因
+func (r *Reconciler) failStart(a Assignment, exitCode int, reason, message string) {
+	r.onStarted(a)
+	phase := r.onExit(a, exitCode)
+	if phase == "Completed" {
+		phase = "Failed"
+	}
+	r.postStatusDetail(a.InstanceID, phase, exitCode, false, reason, message)
+}
+
+// canStart 实例是否可以（重新）启动：未因重启策略终止且不在退避等待中
+func (r *Reconciler) canStart(instanceID string) bool {
+	st, ok := r.restarts[instanceID]
+	if !ok {
+		return true
+	}
+	return !st.finished && !time.Now().Before(st.nextStart)
+}
+
+// restartCount 实例的累计重启次数
+func (r *Reconciler) restartCount(instanceID string) int {
+	if st, ok := r.restarts[instanceID]; ok {
+		return st.count
+	}
+	return 0
+}
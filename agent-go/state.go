@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// 离线容错：每次成功获取分配后把分配和实例元数据（类型、重启次数、手动停止标记）保存到
+// <数据目录>/state.json。Controller 不可达时按最近一次的分配继续协调（崩溃重启等照常进行），
+// Agent 在 Controller 故障期间重启也能恢复应运行的实例；Controller 恢复后以其下发的分配为准。
+// 分配中的环境变量和配置文件可能含密钥，不写入本地状态：从本地恢复的实例在 Controller 重新下发配置前不启动
+// （已在运行的实例不受影响）。
+
+const stateFileName = "state.json"
+
+// persistedState 本地保存的分配与实例元数据
+type persistedState struct {
+	SavedAt       int64             `json:"savedAt"`
+	Assignments   []Assignment      `json:"assignments"`
+	InstanceTypes map[string]string `json:"instanceTypes,omitempty"`
+	Held          []string          `json:"held,omitempty"`
+
+	Ports map[string]map[string]int `json:"ports,omitempty"` // 实例分配的宿主机端口
+}
+
+func (r *Reconciler) statePath() string {
+	return filepath.Join(r.baseDir, stateFileName)
+}
+
+// loadState 读取本地保存的状态（启动时调用），恢复实例类型和手动停止标记
+func (r *Reconciler) loadState() {
+	data, err := os.ReadFile(r.statePath())
+	if err != nil {
+		if !os.IsNotExist(err) {
+			LogWarn("Failed to read agent state: %v", err)
+		}
+		return
+	}
+	var st persistedState
+	if err := json.Unmarshal(data, &st); err != nil {
+		LogWarn("Ignoring corrupt agent state %s: %v", r.statePath(), err)
+		return
+	}
+	for id, t := range st.InstanceTypes {
+		r.instanceTypes[id] = t
+	}
+	for _, id := range st.Held {
+		r.held[id] = true
+	}
+	for id, ports := range st.Ports {
+		r.ports.byInstance[id] = ports
+	}
+	r.lastKnown = st.Assignments
+	r.lastKnownAt = time.Unix(st.SavedAt, 0)
+	LogInfo("Loaded %d last-known assignments saved at %s", len(st.Assignments), r.lastKnownAt.Format(time.RFC3339))
+}
+
+// SaveState 记录最近一次从 Controller 获取的分配并保存到本地
+func (r *Reconciler) SaveState(assignments []Assignment) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lastKnown = assignments
+	r.lastKnownAt = time.Now()
+	r.persistState()
+}
+
+// persistState 保存分配和实例元数据（内容未变化时不写盘），调用方持有 r.mu
+func (r *Reconciler) persistState() {
+	if r.lastKnown == nil {
+		return
+	}
+	st := persistedState{
+		Assignments:   make([]Assignment, 0, len(r.lastKnown)),
+		InstanceTypes: make(map[string]string),
+	}
+	for _, a := range r.lastKnown {
+		// 以本地累计的重启次数为准（上报可能因网络失败而滞后）
+		if n := r.restartCount(a.InstanceID); n > a.RestartCount {
+			a.RestartCount = n
+		}
+		if a.Env != nil || a.ConfigFiles != nil {
+			a.Env, a.ConfigFiles, a.ConfigWithheld = nil, nil, true
+		}
+		st.Assignments = append(st.Assignments, a)
+		if t, ok := r.instanceTypes[a.InstanceID]; ok {
+			st.InstanceTypes[a.InstanceID] = t
+		}
+		if r.held[a.InstanceID] {
+			st.Held = append(st.Held, a.InstanceID)
+		}
+	}
+	sort.Strings(st.Held)
+	if len(r.ports.byInstance) > 0 {
+		st.Ports = r.ports.byInstance
+	}
+
+	content, err := json.Marshal(st)
+	if err != nil || bytes.Equal(content, r.savedState) {
+		return
+	}
+	st.SavedAt = r.lastKnownAt.Unix()
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return
+	}
+	tmp := r.statePath() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		LogWarn("Failed to save agent state: %v", err)
+		return
+	}
+	if err := os.Rename(tmp, r.statePath()); err != nil {
+		LogWarn("Failed to save agent state: %v", err)
+		return
+	}
+	r.savedState = content
+}
+
+// LastKnownAssignments 最近一次成功获取（或启动时从本地恢复）的分配及其时间，没有时返回 false
+func (r *Reconciler) LastKnownAssignments() ([]Assignment, time.Time, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.lastKnown == nil {
+		return nil, time.Time{}, false
+	}
+	return r.lastKnown, r.lastKnownAt, true
+}
+
+// SyncLastKnown Controller 不可达时按最近一次的分配协调（没有可用的分配时返回 false）
+func (r *Reconciler) SyncLastKnown() bool {
+	assignments, _, ok := r.LastKnownAssignments()
+	if !ok {
+		return false
+	}
+	r.Sync(assignments)
+	r.mu.Lock()
+	r.persistState()
+	r.mu.Unlock()
+	return true
+}
+
+// ReportRunning 重新上报运行中实例的状态（Controller 恢复后调用，离线期间的上报已丢失）
+func (r *Reconciler) ReportRunning() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, a := range r.assignments {
+		if a.Desired != "Running" {
+			continue
+		}
+		if appManager := r.getAppManager(a.ArtifactType); appManager != nil && appManager.IsRunning(id) {
+			r.postStatus(id, "Running", 0, true)
+		}
+	}
+}
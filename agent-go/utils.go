@@ -41,6 +41,23 @@ func (c *HTTPClient) PostJSON(url string, body interface{}) error {
 	return nil
 }
 
+// PostJSONResponse 发送 JSON 请求并把响应解析到 out
+func (c *HTTPClient) PostJSONResponse(url string, body interface{}, out interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
 func (c *HTTPClient) Get(url string) ([]byte, error) {
 	resp, err := c.client.Get(url)
 	if err != nil {
@@ -182,7 +199,7 @@ func ensureExecutablePermissions(appDir string) error {
 	return nil
 }
 
-// ParseMetaINI 解析meta.ini文件
+// ParseMetaINI 解析meta.ini文件（端口为 auto 的服务 Port 为 0，由 Agent 分配，见 ports.go）
 func ParseMetaINI(path string) ([]ServiceEndpoint, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -203,7 +220,7 @@ func ParseMetaINI(path string) ([]ServiceEndpoint, error) {
 		}
 		var port int
 		fmt.Sscanf(parts[2], "%d", &port)
-		if port > 0 {
+		if port > 0 || strings.TrimSpace(parts[2]) == "auto" {
 			endpoints = append(endpoints, ServiceEndpoint{
 				ServiceName: parts[0],
 				Protocol:    parts[1],
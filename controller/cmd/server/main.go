@@ -11,9 +11,11 @@ import (
 	"syscall"
 
 	"github.com/joho/godotenv"
+	"github.com/manxisuo/plum/controller/internal/deployment"
 	"github.com/manxisuo/plum/controller/internal/failover"
 	grpcserver "github.com/manxisuo/plum/controller/internal/grpc"
 	"github.com/manxisuo/plum/controller/internal/httpapi"
+	"github.com/manxisuo/plum/controller/internal/secrets"
 	"github.com/manxisuo/plum/controller/internal/store"
 	sqlitestore "github.com/manxisuo/plum/controller/internal/store/sqlite"
 	"github.com/manxisuo/plum/controller/internal/tasks"
@@ -79,6 +81,15 @@ func main() {
 		}
 	}()
 
+	// 密钥加密主密钥（CONTROLLER_SECRET_KEY 或 <CONTROLLER_DATA_DIR>/secret.key）
+	secretKeyDir := os.Getenv("CONTROLLER_DATA_DIR")
+	if secretKeyDir == "" {
+		secretKeyDir = "."
+	}
+	if err := secrets.Init(secretKeyDir); err != nil {
+		log.Fatalf("init secret key error: %v", err)
+	}
+
 	// Initialize builtin task definitions
 	if err := store.InitBuiltinTaskDefs(store.Current); err != nil {
 		log.Printf("Warning: failed to init builtin tasks: %v", err)
@@ -104,22 +115,28 @@ func main() {
 
 	// start failover loop
 	failover.Start()
+	// start deployment rollout loop
+	deployment.Start()
+	// start rebalancer (moves instances back after node recovery, cleans up fenced instances)
+	deployment.StartRebalancer()
 	// start tasks scheduler (minimal)
 	tasks.Start()
 	// start DAG orchestrator
 	httpapi.InitDAGOrchestrator(store.Current)
 
-	// start gRPC server for worker connections
+	// start gRPC server for worker connections and agent control channels
 	grpcAddr := os.Getenv("CONTROLLER_GRPC_ADDR")
 	if grpcAddr == "" {
 		// 默认监听所有 IPv4 接口，确保容器可以连接
 		grpcAddr = "0.0.0.0:9090"
 	}
-	grpcServer, err := grpcserver.StartServer(grpcAddr, store.Current)
+	agentChannel := httpapi.NewAgentChannel()
+	grpcServer, err := grpcserver.StartServer(grpcAddr, store.Current, agentChannel)
 	if err != nil {
 		log.Fatalf("Failed to start gRPC server: %v", err)
 	}
 	defer grpcServer.GracefulStop()
+	defer agentChannel.Close() // 先于 GracefulStop 执行：Agent 控制通道是长连接
 
 	// static file server for artifacts
 	dataDir := os.Getenv("CONTROLLER_DATA_DIR")
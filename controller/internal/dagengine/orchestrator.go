@@ -125,7 +125,7 @@ func (o *DAGOrchestrator) GetRunStatus(runID string) map[string]string {
 	}
 
 	// 已完成：从Task记录重建节点状态
-	tasks, err := o.store.ListTasks()
+	tasks, _, err := o.store.QueryTasks(store.TaskQuery{Selector: store.SelectorFromSet(map[string]string{"dagRunId": runID})})
 	if err != nil {
 		return nil
 	}
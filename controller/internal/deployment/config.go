@@ -0,0 +1,321 @@
+package deployment
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/manxisuo/plum/controller/internal/secrets"
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// 配置注入：部署条目可以设置环境变量，并引用配置集（ConfigMap）和密钥（Secret）。
+// 未设置 mountPath 时配置对象的每个键作为环境变量注入，否则每个键写成 mountPath 下的一个文件。
+// 条目的 ConfigHash 由环境变量和所引用对象的 resourceVersion 计算，是实例身份的一部分：
+// 配置对象更新后，引用它的部署记录新修订，由发布控制器按更新策略替换实例（受控重启）；
+// 实例按其 ConfigHash 收到配置快照，被替换前不会收到新内容。
+
+// ConfigSource 引用的配置集或密钥
+type ConfigSource struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath,omitempty"` // 文件挂载目录（绝对路径）；为空时作为环境变量注入
+}
+
+// ConfigFile 注入实例的配置文件
+type ConfigFile struct {
+	MountPath string `json:"mountPath"`
+	Name      string `json:"name"`
+	Content   []byte `json:"content"`
+	Secret    bool   `json:"secret,omitempty"` // 密钥文件（Agent 以 0600 权限写入）
+}
+
+var (
+	envNameRe   = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	configKeyRe = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+)
+
+// ValidConfigKey 配置对象的键：用作文件名，不能是 . 或 ..
+func ValidConfigKey(k string) bool {
+	return configKeyRe.MatchString(k) && k != "." && k != ".."
+}
+
+// LoadConfigData 读取配置对象的键值（密钥解密），不存在时返回 false
+func LoadConfigData(kind store.ConfigKind, name string) (map[string]string, int64, bool, error) {
+	o, ok, err := store.Current.GetConfigObject(kind, name)
+	if err != nil || !ok {
+		return nil, 0, ok, err
+	}
+	raw := o.Data
+	if kind == store.KindSecret {
+		if raw, err = secrets.Decrypt(o.Data); err != nil {
+			return nil, 0, false, err
+		}
+	}
+	var data map[string]string
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return nil, 0, false, err
+	}
+	return data, o.ResourceVersion, true, nil
+}
+
+// validateConfig 校验条目中的环境变量和配置引用
+func (e Entry) validateConfig() error {
+	for k := range e.Env {
+		if !envNameRe.MatchString(k) {
+			return fmt.Errorf("invalid env name %q", k)
+		}
+	}
+	mounts := map[string]bool{}
+	check := func(kind string, refs []ConfigSource) error {
+		for _, ref := range refs {
+			if ref.Name == "" {
+				return fmt.Errorf("%s name required", kind)
+			}
+			if ref.MountPath == "" {
+				continue
+			}
+			if !strings.HasPrefix(ref.MountPath, "/") || path.Clean(ref.MountPath) != ref.MountPath || ref.MountPath == "/" {
+				return fmt.Errorf("%s %s: mountPath must be a clean absolute path other than /", kind, ref.Name)
+			}
+			if mounts[ref.MountPath] {
+				return fmt.Errorf("mountPath %s used more than once", ref.MountPath)
+			}
+			mounts[ref.MountPath] = true
+		}
+		return nil
+	}
+	if err := check("configMap", e.ConfigMaps); err != nil {
+		return err
+	}
+	return check("secret", e.Secrets)
+}
+
+// configSnapshot 展开后的实例配置，按 ConfigHash 加密保存。摘要包含所引用对象的版本，
+// 同一摘要的内容不变：实例在被发布替换前（包括重启后）始终收到创建时的配置
+type configSnapshot struct {
+	Env   map[string]string `json:"env,omitempty"`
+	Files []ConfigFile      `json:"files,omitempty"`
+}
+
+// StampConfig 校验各条目的配置引用（引用的对象必须存在），计算 ConfigHash 并保存配置快照
+func StampConfig(spec []Entry) error {
+	for i := range spec {
+		if err := spec[i].validateConfig(); err != nil {
+			return err
+		}
+		hash, snap, err := resolveConfig(spec[i])
+		if err != nil {
+			return err
+		}
+		if hash != "" {
+			if err := saveSnapshot(hash, snap); err != nil {
+				return err
+			}
+		}
+		spec[i].ConfigHash = hash
+	}
+	return nil
+}
+
+// resolveConfig 一次读取条目引用的配置对象，返回摘要（环境变量和所引用对象版本；条目没有任何配置时为空，
+// 与旧实例兼容）和展开后的配置：环境变量按配置集、密钥、条目 env 依次覆盖，
+// 作为环境变量注入时不是合法变量名的键被忽略
+func resolveConfig(e Entry) (string, configSnapshot, error) {
+	var snap configSnapshot
+	if len(e.Env) == 0 && len(e.ConfigMaps) == 0 && len(e.Secrets) == 0 {
+		return "", snap, nil
+	}
+	h := sha256.New()
+	keys := make([]string, 0, len(e.Env))
+	for k := range e.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(h, "env\x00%s\x00%s\x00", k, e.Env[k])
+	}
+	env := map[string]string{}
+	for _, src := range []struct {
+		kind store.ConfigKind
+		refs []ConfigSource
+	}{{store.KindConfigMap, e.ConfigMaps}, {store.KindSecret, e.Secrets}} {
+		for _, ref := range src.refs {
+			data, version, ok, err := LoadConfigData(src.kind, ref.Name)
+			if err != nil {
+				return "", snap, fmt.Errorf("%s %s: %w", src.kind, ref.Name, err)
+			}
+			if !ok {
+				return "", snap, fmt.Errorf("%s %s not found", src.kind, ref.Name)
+			}
+			fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d\x00", src.kind, ref.Name, ref.MountPath, version)
+			keys := make([]string, 0, len(data))
+			for k := range data {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				if ref.MountPath != "" {
+					snap.Files = append(snap.Files, ConfigFile{MountPath: ref.MountPath, Name: k, Content: []byte(data[k]), Secret: src.kind == store.KindSecret})
+				} else if envNameRe.MatchString(k) {
+					env[k] = data[k]
+				}
+			}
+		}
+	}
+	for k, v := range e.Env {
+		env[k] = v
+	}
+	if len(env) > 0 {
+		snap.Env = env
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16], snap, nil
+}
+
+func saveSnapshot(hash string, snap configSnapshot) error {
+	b, _ := json.Marshal(snap)
+	sealed, err := secrets.Encrypt(b)
+	if err != nil {
+		return err
+	}
+	return store.Current.PutConfigSnapshot(hash, sealed)
+}
+
+// InstanceConfig 按实例创建时的 ConfigHash 取配置快照。快照缺失（升级前创建的实例）时，
+// 只有条目 e 当前展开的摘要仍与 hash 一致才下发并补存快照；配置已变化时返回 false，不下发新内容
+func InstanceConfig(e Entry, hash string) (map[string]string, []ConfigFile, bool, error) {
+	if hash == "" {
+		return nil, nil, false, nil
+	}
+	sealed, ok, err := store.Current.GetConfigSnapshot(hash)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	var snap configSnapshot
+	if ok {
+		b, err := secrets.Decrypt(sealed)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if err := json.Unmarshal(b, &snap); err != nil {
+			return nil, nil, false, err
+		}
+		return snap.Env, snap.Files, true, nil
+	}
+	current, snap, err := resolveConfig(e)
+	if err != nil || current != hash {
+		return nil, nil, false, err
+	}
+	if err := saveSnapshot(hash, snap); err != nil {
+		return nil, nil, false, err
+	}
+	return snap.Env, snap.Files, true, nil
+}
+
+// ConfigReferences 当前修订引用了配置对象的部署
+func ConfigReferences(kind store.ConfigKind, name string) ([]store.Deployment, error) {
+	deps, err := store.Current.ListDeployments()
+	if err != nil {
+		return nil, err
+	}
+	var out []store.Deployment
+	for _, d := range deps {
+		if d.Revision == 0 {
+			continue
+		}
+		spec, ok, err := LoadRevisionSpec(d.DeploymentID, d.Revision)
+		if err != nil || !ok {
+			continue
+		}
+		for _, e := range spec {
+			if e.references(kind, name) {
+				out = append(out, d)
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+func (e Entry) references(kind store.ConfigKind, name string) bool {
+	refs := e.ConfigMaps
+	if kind == store.KindSecret {
+		refs = e.Secrets
+	}
+	for _, ref := range refs {
+		if ref.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RefreshConfig 配置对象更新后，为引用它的部署记录新修订（ConfigHash 变化），
+// 由发布控制器按各部署的更新策略替换实例。返回记录了新修订的部署。
+func RefreshConfig(kind store.ConfigKind, name string) ([]string, error) {
+	deps, err := ConfigReferences(kind, name)
+	if err != nil {
+		return nil, err
+	}
+	var updated []string
+	var errs []error
+	for _, d := range deps {
+		ok, err := refreshDeploymentConfig(d.DeploymentID, kind, name)
+		if err != nil {
+			log.Printf("config: refresh deployment %s for %s %s: %v", d.DeploymentID, kind, name, err)
+			errs = append(errs, err)
+			continue
+		}
+		if ok {
+			updated = append(updated, d.DeploymentID)
+		}
+	}
+	if err := store.Current.PruneConfigSnapshots(); err != nil {
+		log.Printf("config: prune snapshots: %v", err)
+	}
+	return updated, errors.Join(errs...)
+}
+
+func refreshDeploymentConfig(deploymentID string, kind store.ConfigKind, name string) (bool, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	for attempt := 0; attempt < 3; attempt++ {
+		d, ok, err := store.Current.GetDeployment(deploymentID)
+		if err != nil || !ok || d.Revision == 0 {
+			return false, err
+		}
+		spec, ok, err := LoadRevisionSpec(deploymentID, d.Revision)
+		if err != nil || !ok {
+			return false, err
+		}
+		before := make([]string, len(spec))
+		for i, e := range spec {
+			before[i] = e.ConfigHash
+		}
+		if err := StampConfig(spec); err != nil {
+			return false, err
+		}
+		changed := false
+		for i, e := range spec {
+			changed = changed || e.ConfigHash != before[i]
+		}
+		if !changed {
+			return false, nil
+		}
+		// 修订与部署在同一事务内按版本条件写入，冲突时不留下修订
+		if err := SaveRevision(&d, spec, fmt.Sprintf("%s %s updated", kind, name), d.ResourceVersion); err != nil {
+			if errors.Is(err, store.ErrConflict) {
+				continue
+			}
+			return false, err
+		}
+		return true, nil
+	}
+	return false, store.ErrConflict
+}
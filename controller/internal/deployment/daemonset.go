@@ -0,0 +1,80 @@
+package deployment
+
+import (
+	"github.com/manxisuo/plum/controller/internal/failover"
+	"github.com/manxisuo/plum/controller/internal/scheduler"
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// 守护型部署（Kind 为 DaemonSet）：每个条目在所有匹配 placement.nodeSelector 的节点上各运行一个实例
+// （未设置 placement 时为全部节点），placement 的其它字段忽略。发布控制循环每轮按当前节点重新计算：
+// 新加入或标签变为匹配的节点补建实例，标签不再匹配、排空中或已删除的节点上的实例被删除。
+// 实例绑定节点：节点失联时保留其实例（不做故障转移），已封锁或容量不满足的节点不新建实例。
+
+// DefaultPlacement 未指定节点的条目改为不带约束的自动调度（任务：任意健康节点；守护型：全部节点）
+func DefaultPlacement(spec []Entry) {
+	for i, e := range spec {
+		if e.Placement != nil {
+			continue
+		}
+		nodes := 0
+		for _, n := range e.Replicas {
+			if n > 0 {
+				nodes++
+			}
+		}
+		if nodes == 0 {
+			spec[i].Replicas, spec[i].Placement = nil, &scheduler.Placement{}
+		}
+	}
+}
+
+// daemonSpec 把守护型部署的条目展开为每个匹配节点一个副本，返回展开后的规格和无法调度的节点数
+func (c cluster) daemonSpec(spec []Entry, current []store.Assignment) ([]Entry, int, error) {
+	nodes, err := store.Current.ListNodes()
+	if err != nil {
+		return nil, 0, err
+	}
+	out := make([]Entry, 0, len(spec))
+	unschedulable := 0
+	for _, e := range spec {
+		p := scheduler.Placement{}
+		if e.Placement != nil {
+			p = *e.Placement
+		}
+		sel, err := store.ParseSelector(p.NodeSelector)
+		if err != nil {
+			return nil, 0, err
+		}
+		existing := map[string]bool{}
+		for _, a := range current {
+			if assignmentKey(a) == e.key() {
+				existing[a.NodeID] = true
+			}
+		}
+		req := scheduler.RequirementFor(e.ArtifactURL)
+		replicas := map[string]int{}
+		for _, n := range nodes {
+			if n.Status == store.NodeDraining {
+				continue
+			}
+			cand := scheduler.NewCandidate(n, 0)
+			if !sel.Matches(cand.Labels) {
+				continue
+			}
+			switch {
+			case existing[n.NodeID]:
+				replicas[n.NodeID] = 1
+			case c.health[n.NodeID] != failover.Healthy, cand.Cordoned:
+				// 失联节点恢复后、封锁的节点解除封锁后再补建
+			case cand.Fits(req):
+				replicas[n.NodeID] = 1
+			default:
+				unschedulable++
+			}
+		}
+		e.Replicas = replicas
+		out = append(out, e)
+	}
+	return out, unschedulable, nil
+}
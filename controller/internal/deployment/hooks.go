@@ -0,0 +1,27 @@
+package deployment
+
+import "errors"
+
+// 实例生命周期钩子：由 Agent 执行。未配置命令时使用 ZIP 制品中的 prestart.sh / poststart.sh / prestop.sh（存在时）。
+//   - preStart：启动前在实例环境中执行，失败则不启动（按重启策略退避重试），可用于数据库迁移
+//   - postStart：启动后在实例中执行，失败则停止实例
+//   - preStop：停止前在实例中执行，耗时计入部署的 terminationGracePeriodSec，可用于刷写状态
+
+// MaxTerminationGracePeriodSec 部署停止宽限期上限
+const MaxTerminationGracePeriodSec = 600
+
+// Hooks 生命周期钩子命令（通过 sh -c 执行）
+type Hooks struct {
+	PreStart   string `json:"preStart,omitempty"`
+	PostStart  string `json:"postStart,omitempty"`
+	PreStop    string `json:"preStop,omitempty"`
+	TimeoutSec int    `json:"timeoutSec,omitempty"` // preStart / postStart 的超时，默认 60
+}
+
+// Validate 校验钩子配置
+func (h Hooks) Validate() error {
+	if h.TimeoutSec < 0 || h.TimeoutSec > MaxTerminationGracePeriodSec {
+		return errors.New("hooks.timeoutSec must be between 0 and 600")
+	}
+	return nil
+}
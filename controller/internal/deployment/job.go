@@ -0,0 +1,295 @@
+package deployment
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/manxisuo/plum/controller/internal/events"
+	"github.com/manxisuo/plum/controller/internal/notify"
+	"github.com/manxisuo/plum/controller/internal/scheduler"
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// 任务型部署（Kind 为 Job）：实例运行至结束而不是常驻。任务控制器随发布控制循环执行：
+// 保持最多 Parallelism 个实例运行，直到 Completions 个实例成功退出，部署状态变为 Succeeded；
+// 失败次数（失败退出的实例数加上按 OnFailure 策略的实例内重启次数）超过 BackoffLimit 时
+// 停止其余实例，部署状态变为 Failed。结束的实例计数后期望状态置为 Stopped，保留以便查看日志和退出原因。
+// 设置 TTLSecondsAfterFinished 时任务结束后到期自动删除部署。
+// 任务只有一个条目：replicas 列出可运行的节点（副本数大于 0 的节点），placement 给出调度约束
+// （其 replicas 忽略），两者都未设置时可调度到任意健康节点。
+
+const (
+	DefaultBackoffLimit = 6
+	maxJobCompletions   = 10000
+)
+
+// 实例上报的结束阶段（由 Agent 按重启策略判定）
+const (
+	phaseCompleted = "Completed"
+	phaseFailed    = "Failed"
+)
+
+// JobSpec 任务参数（更新时只修改提供的字段）
+type JobSpec struct {
+	Completions  *int `json:"completions,omitempty"`  // 需要成功完成的实例数，默认 1
+	Parallelism  *int `json:"parallelism,omitempty"`  // 同时运行的实例数上限，默认 1
+	BackoffLimit *int `json:"backoffLimit,omitempty"` // 允许的失败次数，默认 6
+	// TTLSecondsAfterFinished 任务结束后自动删除的等待时间（秒），-1 表示不删除（默认）
+	TTLSecondsAfterFinished *int `json:"ttlSecondsAfterFinished,omitempty"`
+}
+
+// JobStatus 任务进度
+type JobStatus struct {
+	Phase        string `json:"phase"` // Running | Stopped | Succeeded | Failed
+	Completions  int    `json:"completions"`
+	Parallelism  int    `json:"parallelism"`
+	BackoffLimit int    `json:"backoffLimit"`
+	Active       int    `json:"active"`    // 运行中（尚未结束）的实例数
+	Succeeded    int    `json:"succeeded"` // 已成功完成的实例数
+	Failed       int    `json:"failed"`    // 失败次数（含运行中实例的重启次数）
+	FinishedAt   int64  `json:"finishedAt,omitempty"`
+	// 结束后自动删除的时间（unix 秒，未设置 TTL 时省略）
+	ExpiresAt int64 `json:"expiresAt,omitempty"`
+}
+
+// NewJob 任务参数的默认值
+func NewJob(d *store.Deployment) {
+	d.Kind = store.KindJob
+	d.Completions, d.Parallelism = 1, 1
+	d.BackoffLimit = DefaultBackoffLimit
+	d.TTLSecondsAfterFinished = -1
+}
+
+// Apply 校验并写入任务参数
+func (s JobSpec) Apply(d *store.Deployment) error {
+	if s.Completions != nil && (*s.Completions < 1 || *s.Completions > maxJobCompletions) {
+		return fmt.Errorf("job.completions must be between 1 and %d", maxJobCompletions)
+	}
+	if s.Parallelism != nil && (*s.Parallelism < 1 || *s.Parallelism > maxJobCompletions) {
+		return fmt.Errorf("job.parallelism must be between 1 and %d", maxJobCompletions)
+	}
+	if s.BackoffLimit != nil && *s.BackoffLimit < 0 {
+		return errors.New("job.backoffLimit must be >= 0")
+	}
+	if s.TTLSecondsAfterFinished != nil && *s.TTLSecondsAfterFinished < -1 {
+		return errors.New("job.ttlSecondsAfterFinished must be >= 0, or -1 to keep the job")
+	}
+	if s.Completions != nil {
+		d.Completions = *s.Completions
+	}
+	if s.Parallelism != nil {
+		d.Parallelism = *s.Parallelism
+	}
+	if s.BackoffLimit != nil {
+		d.BackoffLimit = *s.BackoffLimit
+	}
+	if s.TTLSecondsAfterFinished != nil {
+		d.TTLSecondsAfterFinished = *s.TTLSecondsAfterFinished
+	}
+	return nil
+}
+
+// jobCounts 任务实例的统计
+type jobCounts struct {
+	active    []store.Assignment // 尚未结束的实例
+	finished  []store.JobResult  // 本轮新结束、待计数的实例
+	succeeded int
+	failed    int // 已结束实例的失败次数（计入部署）
+	restarts  int // 运行中实例的重启次数
+}
+
+// countJob 统计任务实例：期望状态为 Running 的实例按最近上报的阶段分为运行中和新结束，
+// 期望状态为 Stopped 的实例已计数（或随任务停止），不再统计
+func countJob(d store.Deployment) (jobCounts, error) {
+	c := jobCounts{succeeded: d.Succeeded, failed: d.Failed}
+	assigns, err := store.Current.ListAssignmentsForDeployment(d.DeploymentID)
+	if err != nil {
+		return c, err
+	}
+	for _, a := range Active(assigns) {
+		if a.Desired != store.DesiredRunning {
+			continue
+		}
+		st, ok, err := store.Current.LatestStatus(a.InstanceID)
+		if err != nil {
+			return c, err
+		}
+		switch {
+		case ok && st.Phase == phaseCompleted:
+			c.succeeded++
+			c.failed += st.RestartCount
+			c.finished = append(c.finished, store.JobResult{InstanceID: a.InstanceID, Succeeded: 1, Failed: st.RestartCount})
+		case ok && st.Phase == phaseFailed:
+			c.failed += st.RestartCount + 1
+			c.finished = append(c.finished, store.JobResult{InstanceID: a.InstanceID, Failed: st.RestartCount + 1})
+		default:
+			c.active = append(c.active, a)
+			if ok {
+				c.restarts += st.RestartCount
+			}
+		}
+	}
+	return c, nil
+}
+
+// reconcileJob 对任务型部署执行一步：计数结束的实例、判定任务结果、补足并行实例、到期删除
+func reconcileJob(d store.Deployment) error {
+	if d.Finished() {
+		if d.TTLSecondsAfterFinished >= 0 && time.Now().Unix() >= d.FinishedAt+int64(d.TTLSecondsAfterFinished) {
+			log.Printf("job: deleting finished job %s (%s), ttlSecondsAfterFinished=%d", d.DeploymentID, d.Name, d.TTLSecondsAfterFinished)
+			// 并发更新导致版本冲突时下一轮再删除
+			if err := Delete(d.DeploymentID, d.ResourceVersion); err != nil && !errors.Is(err, store.ErrConflict) {
+				return err
+			}
+			return nil
+		}
+		return nil
+	}
+	if d.Status != store.DeploymentRunning {
+		return nil
+	}
+	c, err := countJob(d)
+	if err != nil {
+		return err
+	}
+	if len(c.finished) > 0 {
+		// 计数与停止已结束的实例在同一事务内完成，不受其它部署写入影响
+		if d.Succeeded, d.Failed, err = store.Current.RecordJobResults(d.DeploymentID, c.finished); err != nil {
+			return err
+		}
+		c.succeeded, c.failed = d.Succeeded, d.Failed
+	}
+	switch {
+	case c.succeeded >= d.Completions:
+		return finishJob(d, store.DeploymentSucceeded, c)
+	case c.failed+c.restarts > d.BackoffLimit:
+		return finishJob(d, store.DeploymentFailed, c)
+	}
+	want := min(d.Parallelism, d.Completions-c.succeeded) - len(c.active)
+	if want <= 0 {
+		return nil
+	}
+	spec, ok, err := LoadRevisionSpec(d.DeploymentID, d.Revision)
+	if err != nil || !ok || len(spec) == 0 {
+		return err
+	}
+	cl, err := loadCluster()
+	if err != nil {
+		return err
+	}
+	e := spec[0]
+	appName, appVersion := ResolveArtifact(e.ArtifactURL)
+	var diff Diff
+	for _, nodeID := range cl.jobNodes(e, c.active, want) {
+		diff.Add = append(diff.Add, store.Assignment{
+			InstanceID:   store.Current.NewInstanceID(d.DeploymentID),
+			DeploymentID: d.DeploymentID,
+			NodeID:       nodeID,
+			Desired:      store.DesiredRunning,
+			ArtifactURL:  e.ArtifactURL,
+			StartCmd:     e.StartCmd,
+			AppName:      appName,
+			AppVersion:   appVersion,
+			ConfigHash:   e.ConfigHash,
+		})
+	}
+	return Apply(diff)
+}
+
+// finishJob 记录任务结果（运行中实例的重启次数计入失败次数）并停止仍在运行的实例
+func finishJob(d store.Deployment, result store.DeploymentStatus, c jobCounts) error {
+	nodes := map[string]bool{}
+	stop := make([]string, 0, len(c.active))
+	for _, a := range c.active {
+		stop = append(stop, a.InstanceID)
+		nodes[a.NodeID] = true
+	}
+	d.Failed += c.restarts
+	if err := store.Current.FinishJob(d.DeploymentID, result, c.restarts, time.Now().Unix(), stop); err != nil {
+		if errors.Is(err, store.ErrConflict) {
+			return nil // 任务已被并发停止或删除
+		}
+		return err
+	}
+	for nodeID := range nodes {
+		notify.Publish(nodeID)
+	}
+	e := store.Event{Type: events.JobSucceeded, Reason: string(result), DeploymentID: d.DeploymentID,
+		Message: fmt.Sprintf("%d/%d completions", d.Succeeded, d.Completions)}
+	if result == store.DeploymentFailed {
+		e.Type = events.JobFailed
+		e.Message = fmt.Sprintf("%d failures exceeded backoffLimit %d (%d/%d completions)", d.Failed, d.BackoffLimit, d.Succeeded, d.Completions)
+	}
+	log.Printf("job: %s (%s) %s: %s", d.DeploymentID, d.Name, result, e.Message)
+	events.Record(e)
+	return nil
+}
+
+// jobNodes 为任务的 n 个新实例选择节点：在条目允许的节点中优先本任务实例少、负载低的节点；
+// 可用节点不足时返回的节点数少于 n，其余实例在之后的循环中再创建
+func (c cluster) jobNodes(e Entry, active []store.Assignment, n int) []string {
+	p := scheduler.Placement{}
+	if e.Placement != nil {
+		p = *e.Placement
+	}
+	candidates := c.candidates
+	if len(e.Replicas) > 0 {
+		candidates = nil
+		for _, cand := range c.candidates {
+			if e.Replicas[cand.NodeID] > 0 {
+				candidates = append(candidates, cand)
+			}
+		}
+	}
+	existing := map[string]int{}
+	for _, a := range active {
+		existing[a.NodeID]++
+	}
+	p.Replicas = len(active) + n
+	placed, _ := scheduler.Place(p, scheduler.RequirementFor(e.ArtifactURL), candidates, existing)
+	ids := make([]string, 0, len(placed))
+	for id := range placed {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var out []string
+	for _, id := range ids {
+		for k := placed[id] - existing[id]; k > 0 && len(out) < n; k-- {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
+// JobStatusOf 任务进度（非任务型部署返回 false）
+func JobStatusOf(d store.Deployment) (JobStatus, bool, error) {
+	if !d.IsJob() {
+		return JobStatus{}, false, nil
+	}
+	js := JobStatus{
+		Phase:        string(d.Status),
+		Completions:  d.Completions,
+		Parallelism:  d.Parallelism,
+		BackoffLimit: d.BackoffLimit,
+		Succeeded:    d.Succeeded,
+		Failed:       d.Failed,
+		FinishedAt:   d.FinishedAt,
+	}
+	if d.Finished() {
+		if d.TTLSecondsAfterFinished >= 0 {
+			js.ExpiresAt = d.FinishedAt + int64(d.TTLSecondsAfterFinished)
+		}
+		return js, true, nil
+	}
+	c, err := countJob(d)
+	if err != nil {
+		return js, true, err
+	}
+	js.Active = len(c.active)
+	js.Succeeded = c.succeeded
+	js.Failed = c.failed + c.restarts
+	return js, true, nil
+}
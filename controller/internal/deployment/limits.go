@@ -0,0 +1,29 @@
+package deployment
+
+import "errors"
+
+// 实例资源限制：容器模式由 Docker 施加，进程模式由 Agent 通过 cgroup v2 施加。
+// 未设置的项不限制；内存超限被杀死的实例以 OOMKilled 原因上报。
+
+// Limits 资源限制
+type Limits struct {
+	CPUs       float64 `json:"cpus,omitempty"`       // CPU 核数，如 0.5
+	MemoryMB   int64   `json:"memoryMB,omitempty"`   // 内存上限
+	Pids       int64   `json:"pids,omitempty"`       // 进程（线程）数上限
+	IOReadBps  int64   `json:"ioReadBps,omitempty"`  // 实例数据目录所在磁盘的读带宽（字节/秒）
+	IOWriteBps int64   `json:"ioWriteBps,omitempty"` // 写带宽（字节/秒）
+}
+
+// Validate 校验资源限制
+func (l Limits) Validate() error {
+	if l.CPUs < 0 || l.MemoryMB < 0 || l.Pids < 0 || l.IOReadBps < 0 || l.IOWriteBps < 0 {
+		return errors.New("limits must be >= 0")
+	}
+	if l.CPUs > 0 && l.CPUs < 0.01 {
+		return errors.New("limits.cpus must be >= 0.01")
+	}
+	if l.MemoryMB > 0 && l.MemoryMB < 4 {
+		return errors.New("limits.memoryMB must be >= 4")
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package deployment
+
+import (
+	"sort"
+
+	"github.com/manxisuo/plum/controller/internal/failover"
+	"github.com/manxisuo/plum/controller/internal/scheduler"
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// cluster 一次调度所需的节点视图
+type cluster struct {
+	health     map[string]failover.NodeHealth
+	candidates []scheduler.Candidate
+}
+
+func loadCluster() (cluster, error) {
+	c := cluster{health: failover.ComputeHealth()}
+	nodes, err := store.Current.ListNodes()
+	if err != nil {
+		return c, err
+	}
+	for _, n := range nodes {
+		// 排空中的节点不参与调度：其上自动调度的副本会按滚动更新约束迁走
+		if c.health[n.NodeID] != failover.Healthy || n.Status == store.NodeDraining {
+			continue
+		}
+		load, err := store.Current.CountAssignmentsForNode(n.NodeID)
+		if err != nil {
+			return c, err
+		}
+		c.candidates = append(c.candidates, scheduler.NewCandidate(n, load))
+	}
+	return c, nil
+}
+
+// resolve 把带 Placement 的条目展开为具体的节点副本数，返回展开后的规格和无法调度的副本数。
+// 已有实例所在节点仍健康且满足约束时保持不动；心跳超时（Suspect / Unhealthy）节点上的副本仍计为已放置，
+// 只由故障转移在宽限期和法定数检查后迁移，这里不重新调度（否则绕过防抖，且与故障转移重复调度）。
+func (c cluster) resolve(spec []Entry, current []store.Assignment) ([]Entry, int) {
+	out := make([]Entry, 0, len(spec))
+	unschedulable := 0
+	for _, e := range spec {
+		if e.Placement == nil {
+			out = append(out, e)
+			continue
+		}
+		existing := map[string]int{}
+		for _, a := range current {
+			if assignmentKey(a) == e.key() {
+				existing[a.NodeID]++
+			}
+		}
+		p := *e.Placement
+		pinned := map[string]int{}
+		nodes := make([]string, 0, len(existing))
+		for node := range existing {
+			nodes = append(nodes, node)
+		}
+		sort.Strings(nodes)
+		for _, node := range nodes {
+			if h := c.health[node]; h != failover.Suspect && h != failover.Unhealthy {
+				continue
+			}
+			if k := min(existing[node], p.Replicas); k > 0 {
+				pinned[node] = k
+				p.Replicas -= k
+			}
+			delete(existing, node)
+		}
+		replicas, n := scheduler.Place(p, scheduler.RequirementFor(e.ArtifactURL), c.candidates, existing)
+		unschedulable += n
+		for node, k := range pinned {
+			replicas[node] = k
+		}
+		// 后续条目看到本条目新增的负载
+		for i := range c.candidates {
+			c.candidates[i].Load += replicas[c.candidates[i].NodeID] - existing[c.candidates[i].NodeID]
+		}
+		e.Replicas = replicas
+		out = append(out, e)
+	}
+	return out, unschedulable
+}
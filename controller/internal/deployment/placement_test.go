@@ -0,0 +1,46 @@
+package deployment
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/manxisuo/plum/controller/internal/failover"
+	"github.com/manxisuo/plum/controller/internal/scheduler"
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+func TestResolveKeepsReplicasOnUnreachableNodes(t *testing.T) {
+	c := cluster{
+		health: map[string]failover.NodeHealth{
+			"a": failover.Healthy, "b": failover.Healthy, "s": failover.Suspect, "u": failover.Unhealthy,
+		},
+		candidates: []scheduler.Candidate{{NodeID: "a"}, {NodeID: "b"}},
+	}
+	e := Entry{ArtifactURL: "/artifacts/app.zip", StartCmd: "run", Placement: &scheduler.Placement{Replicas: 3}}
+	on := func(node string) store.Assignment {
+		return store.Assignment{NodeID: node, ArtifactURL: e.ArtifactURL, StartCmd: e.StartCmd}
+	}
+	tests := []struct {
+		name              string
+		current           []store.Assignment
+		want              map[string]int
+		wantUnschedulable int
+	}{
+		{name: "suspect and unhealthy replicas count as placed", current: []store.Assignment{on("s"), on("u"), on("a")},
+			want: map[string]int{"s": 1, "u": 1, "a": 1}},
+		{name: "missing replicas go to healthy nodes", current: []store.Assignment{on("s")},
+			want: map[string]int{"s": 1, "a": 1, "b": 1}},
+		{name: "no replicas are added beyond the total", current: []store.Assignment{on("s"), on("s"), on("u"), on("u")},
+			want: map[string]int{"s": 2, "u": 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cl := c
+			cl.candidates = append([]scheduler.Candidate(nil), c.candidates...)
+			out, unschedulable := cl.resolve([]Entry{e}, tt.current)
+			if !reflect.DeepEqual(out[0].Replicas, tt.want) || unschedulable != tt.wantUnschedulable {
+				t.Errorf("resolve = %v, %d unschedulable; want %v, %d", out[0].Replicas, unschedulable, tt.want, tt.wantUnschedulable)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package deployment
+
+import (
+	"errors"
+	"fmt"
+)
+
+// 实例健康探针：由 Agent 按周期执行。存活探针连续失败时重启实例；
+// 就绪探针通过前实例不注册服务端点，也不计入发布进度中的可用实例。
+// 部署规格中的探针优先于应用 meta.ini 中声明的同类探针。
+
+// Probe 探针配置
+type Probe struct {
+	Type             string `json:"type"`                       // http | tcp | exec | grpc
+	Port             int    `json:"port,omitempty"`             // http / tcp / grpc
+	Path             string `json:"path,omitempty"`             // http，默认 /
+	Command          string `json:"command,omitempty"`          // exec：通过 sh -c 在实例环境中执行，退出码 0 为成功
+	Service          string `json:"service,omitempty"`          // grpc：健康检查的服务名（默认整体状态）
+	InitialDelaySec  int    `json:"initialDelaySec,omitempty"`  // 实例启动后首次探测前的等待时间
+	PeriodSec        int    `json:"periodSec,omitempty"`        // 探测间隔，默认 10
+	TimeoutSec       int    `json:"timeoutSec,omitempty"`       // 单次探测超时，默认 1
+	FailureThreshold int    `json:"failureThreshold,omitempty"` // 连续失败多少次判定失败，默认 3
+	SuccessThreshold int    `json:"successThreshold,omitempty"` // 连续成功多少次判定就绪，默认 1
+}
+
+// Probes 实例的存活探针和就绪探针
+type Probes struct {
+	Liveness  *Probe `json:"liveness,omitempty"`
+	Readiness *Probe `json:"readiness,omitempty"`
+}
+
+// Validate 校验探针配置
+func (p Probes) Validate() error {
+	for name, pr := range map[string]*Probe{"liveness": p.Liveness, "readiness": p.Readiness} {
+		if pr == nil {
+			continue
+		}
+		if err := pr.validate(); err != nil {
+			return fmt.Errorf("%s probe: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (p Probe) validate() error {
+	switch p.Type {
+	case "http", "tcp", "grpc":
+		if p.Port <= 0 || p.Port > 65535 {
+			return errors.New("port required (1-65535)")
+		}
+	case "exec":
+		if p.Command == "" {
+			return errors.New("command required")
+		}
+	default:
+		return fmt.Errorf("unknown type %q (http, tcp, exec, grpc)", p.Type)
+	}
+	if p.InitialDelaySec < 0 || p.PeriodSec < 0 || p.TimeoutSec < 0 || p.FailureThreshold < 0 || p.SuccessThreshold < 0 {
+		return errors.New("durations and thresholds must be >= 0")
+	}
+	return nil
+}
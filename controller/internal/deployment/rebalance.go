@@ -0,0 +1,477 @@
+package deployment
+
+import (
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/manxisuo/plum/controller/internal/events"
+	"github.com/manxisuo/plum/controller/internal/failover"
+	"github.com/manxisuo/plum/controller/internal/notify"
+	"github.com/manxisuo/plum/controller/internal/scheduler"
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// 重平衡：节点恢复后按策略把实例迁回故障转移前的节点（preferred），或把实例从最忙的节点迁到
+// 最闲的节点（even）。迁移先建后删：在目标节点创建新实例，新实例就绪后才删除源实例，
+// 同一时间只进行一次迁移。每轮还会清理原节点已确认停止的故障转移隔离实例，并排空 Draining 节点。
+
+const (
+	RebalanceNone      = "none"
+	RebalancePreferred = "preferred"
+	RebalanceEven      = "even"
+)
+
+// 迁移原因（记录在事件中）
+const (
+	reasonPreferred = "Preferred"
+	reasonEven      = "Even"
+	reasonDrain     = "Drain"
+	reasonRebalance = "Rebalance" // 控制器重启前开始的迁移
+)
+
+var (
+	moveStarted = map[string]time.Time{} // 迁移中的源实例 -> 开始时间
+	moveReasons = map[string]string{}    // 迁移中的源实例 -> 迁移原因
+	cooldown    = map[string]time.Time{} // 迁移超时的源实例 -> 可再次尝试的时间
+)
+
+func rebalancePolicy() string {
+	switch v := strings.ToLower(strings.TrimSpace(os.Getenv("REBALANCE_POLICY"))); v {
+	case RebalancePreferred, RebalanceEven:
+		return v
+	default:
+		return RebalanceNone
+	}
+}
+
+func rebalanceEnvSeconds(key string, def int) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return time.Duration(n) * time.Second
+		}
+	}
+	return time.Duration(def) * time.Second
+}
+
+// rebalanceThreshold even 策略下最忙与最闲节点的实例数至少相差多少才迁移
+func rebalanceThreshold() int {
+	if v := os.Getenv("REBALANCE_THRESHOLD"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 2 {
+			return n
+		}
+	}
+	return 2
+}
+
+// StartRebalancer 启动重平衡循环（策略为 none 时只清理隔离实例）
+func StartRebalancer() {
+	policy := rebalancePolicy()
+	log.Printf("rebalance: policy %s", policy)
+	go func() {
+		iv := rebalanceEnvSeconds("REBALANCE_INTERVAL_SEC", 10)
+		for {
+			time.Sleep(iv)
+			if err := rebalanceOnce(policy); err != nil {
+				log.Printf("rebalance: %v", err)
+			}
+		}
+	}()
+}
+
+func rebalanceOnce(policy string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	now := time.Now()
+	for id, until := range cooldown {
+		if now.After(until) {
+			delete(cooldown, id)
+		}
+	}
+	c, err := loadCluster()
+	if err != nil {
+		return err
+	}
+	deps, err := store.Current.ListDeployments()
+	if err != nil {
+		return err
+	}
+	byDep := map[string][]store.Assignment{}
+	moving := false
+	for _, d := range deps {
+		assigns, err := store.Current.ListAssignmentsForDeployment(d.DeploymentID)
+		if err != nil {
+			return err
+		}
+		byDep[d.DeploymentID] = assigns
+		for _, a := range assigns {
+			switch {
+			case a.Fenced():
+				if err := c.cleanupFenced(a); err != nil {
+					return err
+				}
+			case a.SupersededBy != "":
+				done, err := c.advanceMove(a)
+				if err != nil {
+					return err
+				}
+				moving = moving || !done
+			}
+		}
+	}
+	if err := c.drain(deps, byDep); err != nil {
+		return err
+	}
+	if policy == RebalanceNone || moving {
+		return nil
+	}
+	a, target, ok := c.pickMove(policy, deps, byDep)
+	if !ok {
+		return nil
+	}
+	reason := reasonEven
+	if policy == RebalancePreferred {
+		reason = reasonPreferred
+	}
+	return startMove(a, target, reason)
+}
+
+// cleanupFenced 原节点已恢复且确认停止（或节点已删除）的隔离实例不再需要保留
+func (c cluster) cleanupFenced(a store.Assignment) error {
+	if h, known := c.health[a.NodeID]; known {
+		if h != failover.Healthy {
+			return nil // 等待节点恢复后停止该实例
+		}
+		if st, ok, err := store.Current.LatestStatus(a.InstanceID); err != nil || (ok && st.Phase == "Running") {
+			return err
+		}
+	}
+	log.Printf("rebalance: removing fenced instance %s on %s (superseded by %s)", a.InstanceID, a.NodeID, a.SupersededBy)
+	events.Record(store.Event{
+		Type:          events.InstanceFenced,
+		Reason:        "Stopped",
+		NodeID:        a.NodeID,
+		DeploymentID:  a.DeploymentID,
+		InstanceID:    a.InstanceID,
+		NewInstanceID: a.SupersededBy,
+		Message:       "superseded instance confirmed stopped and removed",
+	})
+	return Apply(Diff{Remove: []store.Assignment{a}})
+}
+
+// advanceMove 推进一次迁移：新实例就绪后删除源实例；新实例丢失、所在节点不健康或超时则取消。
+// 返回迁移是否已结束。
+func (c cluster) advanceMove(src store.Assignment) (bool, error) {
+	// 新实例本身可能又被故障转移接替，沿链找到当前的接替者
+	repl, ok, err := store.Current.GetAssignment(src.SupersededBy)
+	for i := 0; err == nil && ok && repl.Fenced() && i < 8; i++ {
+		repl, ok, err = store.Current.GetAssignment(repl.SupersededBy)
+	}
+	if err != nil {
+		return false, err
+	}
+	started, tracked := moveStarted[src.InstanceID]
+	if !tracked {
+		started = time.Now()
+		moveStarted[src.InstanceID] = started
+		moveReasons[src.InstanceID] = reasonRebalance
+	}
+	reason := moveReasons[src.InstanceID]
+	if ok && !repl.Fenced() && repl.SupersededBy == "" && repl.InstanceID != src.SupersededBy {
+		if err := store.Current.UpdateAssignmentSupersededBy(src.InstanceID, repl.InstanceID); err != nil {
+			return false, err
+		}
+	}
+	switch {
+	case ok && c.ready(repl):
+		delete(moveStarted, src.InstanceID)
+		delete(moveReasons, src.InstanceID)
+		log.Printf("rebalance: moved instance %s (deployment %s) from %s to %s as %s", src.InstanceID, src.DeploymentID, src.NodeID, repl.NodeID, repl.InstanceID)
+		recordMove(events.InstanceMoved, reason, src, repl.InstanceID, repl.NodeID, time.Since(started), "replacement ready, source removed")
+		return true, Apply(Diff{Remove: []store.Assignment{src}})
+	case ok && c.health[repl.NodeID] == failover.Healthy && time.Since(started) < rebalanceEnvSeconds("REBALANCE_TIMEOUT_SEC", 120):
+		return false, nil
+	}
+	// 取消：删除未就绪的新实例，源实例继续提供服务
+	delete(moveStarted, src.InstanceID)
+	delete(moveReasons, src.InstanceID)
+	cooldown[src.InstanceID] = time.Now().Add(10 * rebalanceEnvSeconds("REBALANCE_INTERVAL_SEC", 10))
+	log.Printf("rebalance: move of instance %s (deployment %s) aborted", src.InstanceID, src.DeploymentID)
+	msg := "replacement lost"
+	if ok {
+		msg = "replacement not ready in time or its node is unhealthy"
+	}
+	recordMove(events.MoveAborted, reason, src, repl.InstanceID, repl.NodeID, time.Since(started), msg)
+	if ok && repl.SupersededBy == "" {
+		if err := Apply(Diff{Remove: []store.Assignment{repl}}); err != nil {
+			return true, err
+		}
+	}
+	return true, store.Current.UpdateAssignmentSupersededBy(src.InstanceID, "")
+}
+
+// ready 实例所在节点健康，且最新状态为 Running、存活且就绪
+func (c cluster) ready(a store.Assignment) bool {
+	if h := c.health[a.NodeID]; h != failover.Healthy && h != failover.Suspect {
+		return false
+	}
+	st, ok, err := store.Current.LatestStatus(a.InstanceID)
+	return err == nil && ok && st.Phase == "Running" && st.Healthy && st.Ready
+}
+
+// pickMove 按策略选出一次迁移：只考虑发布已完成、未暂停的运行中部署里已就绪的实例
+func (c cluster) pickMove(policy string, deps []store.Deployment, byDep map[string][]store.Assignment) (store.Assignment, string, bool) {
+	// 各健康节点上期望运行的实例数
+	load := map[string]int{}
+	for _, n := range c.candidates {
+		load[n.NodeID] = 0
+	}
+	type item struct {
+		d     store.Deployment
+		a     store.Assignment
+		entry *Entry
+	}
+	var items []item
+	for _, d := range deps {
+		if d.Status != store.DeploymentRunning {
+			continue
+		}
+		active := Active(byDep[d.DeploymentID])
+		for _, a := range active {
+			if a.Desired == store.DesiredRunning {
+				if _, ok := load[a.NodeID]; ok {
+					load[a.NodeID]++
+				}
+			}
+		}
+		// 任务实例运行至结束、守护型实例绑定节点，不参与重平衡
+		if d.IsJob() || d.IsDaemonSet() || d.Paused || d.Revision == 0 || d.ObservedRevision != d.Revision {
+			continue
+		}
+		spec, ok, err := LoadRevisionSpec(d.DeploymentID, d.Revision)
+		if err != nil || !ok {
+			continue
+		}
+		for _, a := range active {
+			if a.Desired != store.DesiredRunning || !cooldown[a.InstanceID].IsZero() || !c.ready(a) {
+				continue
+			}
+			it := item{d: d, a: a}
+			for i := range spec {
+				if spec[i].key() == assignmentKey(a) {
+					it.entry = &spec[i]
+				}
+			}
+			items = append(items, it)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].a.InstanceID < items[j].a.InstanceID })
+
+	switch policy {
+	case RebalancePreferred:
+		for _, it := range items {
+			p := it.a.PreferredNode
+			if p != "" && p != it.a.NodeID && c.allowed(it.a, it.entry, byDep[it.d.DeploymentID], p) {
+				return it.a, p, true
+			}
+		}
+	case RebalanceEven:
+		nodes := make([]string, 0, len(load))
+		for id := range load {
+			nodes = append(nodes, id)
+		}
+		sort.Slice(nodes, func(i, j int) bool {
+			if load[nodes[i]] != load[nodes[j]] {
+				return load[nodes[i]] > load[nodes[j]]
+			}
+			return nodes[i] < nodes[j]
+		})
+		threshold := rebalanceThreshold()
+		for _, from := range nodes {
+			for i := len(nodes) - 1; i >= 0 && load[from]-load[nodes[i]] >= threshold; i-- {
+				to := nodes[i]
+				for _, it := range items {
+					// 显式指定节点的副本只有在被故障转移移走后才参与均衡
+					explicit := it.entry == nil || it.entry.Placement == nil
+					if it.a.NodeID != from || (explicit && it.a.PreferredNode == "") {
+						continue
+					}
+					if c.allowed(it.a, it.entry, byDep[it.d.DeploymentID], to) {
+						return it.a, to, true
+					}
+				}
+			}
+		}
+	}
+	return store.Assignment{}, "", false
+}
+
+// allowed 实例能否迁到 target：目标节点健康且容量满足；自动调度条目迁移后仍须满足其约束
+func (c cluster) allowed(a store.Assignment, entry *Entry, assigns []store.Assignment, target string) bool {
+	req := scheduler.RequirementFor(a.ArtifactURL)
+	fits := false
+	for _, n := range c.candidates {
+		if n.NodeID == target {
+			fits = n.Fits(req)
+		}
+	}
+	if !fits {
+		return false
+	}
+	if entry == nil || entry.Placement == nil {
+		return true
+	}
+	moved := map[string]int{}
+	for _, s := range Active(assigns) {
+		if assignmentKey(s) == assignmentKey(a) {
+			moved[s.NodeID]++
+		}
+	}
+	moved[a.NodeID]--
+	moved[target]++
+	placed, _ := scheduler.Place(*entry.Placement, req, c.candidates, moved)
+	for nodeID, n := range moved {
+		if placed[nodeID] != n {
+			return false
+		}
+	}
+	return true
+}
+
+// drain 把排空中节点上显式指定节点的实例迁走（自动调度条目由发布控制器按滚动更新约束重新调度）。
+// 运行中的部署先建后删，每个部署同时迁移的实例数不超过 MaxSurge，暂停的部署等恢复后再迁移；
+// 未运行的实例直接迁移。
+func (c cluster) drain(deps []store.Deployment, byDep map[string][]store.Assignment) error {
+	nodes, err := store.Current.ListNodes()
+	if err != nil {
+		return err
+	}
+	draining := map[string]bool{}
+	for _, n := range nodes {
+		if n.Status == store.NodeDraining {
+			draining[n.NodeID] = true
+		}
+	}
+	if len(draining) == 0 {
+		return nil
+	}
+	for _, d := range deps {
+		if d.IsJob() {
+			continue // 任务实例就地运行至结束，新实例不会调度到排空中的节点
+		}
+		if d.IsDaemonSet() {
+			continue // 守护型部署不覆盖排空中的节点，其实例由发布控制循环删除
+		}
+		var spec []Entry
+		if d.Revision > 0 {
+			spec, _, _ = LoadRevisionSpec(d.DeploymentID, d.Revision)
+		}
+		active := Active(byDep[d.DeploymentID])
+		counts := map[string]int{}
+		for _, a := range active {
+			counts[a.NodeID]++
+		}
+		moving := len(byDep[d.DeploymentID]) - len(active)
+		for _, a := range byDep[d.DeploymentID] {
+			if a.Fenced() {
+				moving--
+			}
+		}
+		for _, a := range active {
+			if !draining[a.NodeID] || !cooldown[a.InstanceID].IsZero() || hasPlacement(spec, a) {
+				continue
+			}
+			running := d.Status == store.DeploymentRunning && a.Desired == store.DesiredRunning
+			if running && (d.Paused || moving >= max(d.MaxSurge, 1)) {
+				continue
+			}
+			target, ok := scheduler.Best(c.candidates, scheduler.RequirementFor(a.ArtifactURL), counts)
+			if !ok {
+				continue
+			}
+			if running {
+				if err := startMove(a, target.NodeID, reasonDrain); err != nil {
+					return err
+				}
+				moving++
+			} else {
+				moved := a
+				moved.InstanceID = store.Current.NewInstanceID(a.DeploymentID)
+				moved.NodeID = target.NodeID
+				log.Printf("rebalance: draining %s: moving stopped instance %s (deployment %s) to %s", a.NodeID, a.InstanceID, a.DeploymentID, target.NodeID)
+				recordMove(events.InstanceMoved, reasonDrain, a, moved.InstanceID, moved.NodeID, 0, "instance not running, moved directly")
+				if err := Apply(Diff{Add: []store.Assignment{moved}, Remove: []store.Assignment{a}}); err != nil {
+					return err
+				}
+			}
+			counts[target.NodeID]++
+			counts[a.NodeID]--
+			for i := range c.candidates {
+				if c.candidates[i].NodeID == target.NodeID {
+					c.candidates[i].Load++
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// hasPlacement 实例是否属于自动调度条目
+func hasPlacement(spec []Entry, a store.Assignment) bool {
+	for _, e := range spec {
+		if e.key() == assignmentKey(a) {
+			return e.Placement != nil
+		}
+	}
+	return false
+}
+
+// recordMove 记录一次重平衡 / 排空迁移事件
+func recordMove(typ string, reason string, src store.Assignment, newIID string, target string, d time.Duration, msg string) {
+	events.Record(store.Event{
+		Type:          typ,
+		Reason:        reason,
+		NodeID:        src.NodeID,
+		DeploymentID:  src.DeploymentID,
+		InstanceID:    src.InstanceID,
+		NewInstanceID: newIID,
+		FromNode:      src.NodeID,
+		ToNode:        target,
+		DurationMs:    d.Milliseconds(),
+		Message:       msg,
+	})
+}
+
+// startMove 在目标节点创建新实例，源实例在新实例就绪前继续运行
+func startMove(a store.Assignment, target string, reason string) error {
+	preferred := a.PreferredNode
+	if preferred == target {
+		preferred = ""
+	}
+	newIID := store.Current.NewInstanceID(a.DeploymentID)
+	if err := store.Current.AddAssignment(target, store.Assignment{
+		InstanceID:    newIID,
+		DeploymentID:  a.DeploymentID,
+		NodeID:        target,
+		Desired:       store.DesiredRunning,
+		ArtifactURL:   a.ArtifactURL,
+		StartCmd:      a.StartCmd,
+		AppName:       a.AppName,
+		AppVersion:    a.AppVersion,
+		ConfigHash:    a.ConfigHash,
+		PreferredNode: preferred,
+	}); err != nil {
+		return err
+	}
+	if err := store.Current.UpdateAssignmentSupersededBy(a.InstanceID, newIID); err != nil {
+		return err
+	}
+	moveStarted[a.InstanceID] = time.Now()
+	moveReasons[a.InstanceID] = reason
+	log.Printf("rebalance: moving instance %s (deployment %s) from %s to %s as %s", a.InstanceID, a.DeploymentID, a.NodeID, target, newIID)
+	notify.Publish(target)
+	return nil
+}
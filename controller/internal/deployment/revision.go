@@ -0,0 +1,124 @@
+package deployment
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// LoadRevisionSpec 读取指定修订的规格
+func LoadRevisionSpec(deploymentID string, revision int) ([]Entry, bool, error) {
+	rev, ok, err := store.Current.GetDeploymentRevision(deploymentID, revision)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	var spec []Entry
+	if err := json.Unmarshal([]byte(rev.SpecJSON), &spec); err != nil {
+		return nil, false, err
+	}
+	return spec, true, nil
+}
+
+// RecordRevision 在修订历史中追加一个规格快照并返回其修订号；
+// 与最新修订相同时不追加，直接返回最新修订号。
+func RecordRevision(deploymentID string, spec []Entry, cause string) (int, error) {
+	revs, err := store.Current.ListDeploymentRevisions(deploymentID)
+	if err != nil {
+		return 0, err
+	}
+	b, _ := json.Marshal(normalize(spec))
+	if n := len(revs); n > 0 {
+		if revs[n-1].SpecJSON == string(b) {
+			return revs[n-1].Revision, nil
+		}
+	}
+	next := 1
+	if n := len(revs); n > 0 {
+		next = revs[n-1].Revision + 1
+	}
+	err = store.Current.AddDeploymentRevision(store.DeploymentRevision{
+		DeploymentID: deploymentID,
+		Revision:     next,
+		SpecJSON:     string(b),
+		Cause:        cause,
+		CreatedAt:    time.Now().Unix(),
+	})
+	return next, err
+}
+
+// NewRevision spec 的修订快照（部署 ID 和修订号由调用方或存储填写）
+func NewRevision(spec []Entry, cause string) store.DeploymentRevision {
+	b, _ := json.Marshal(normalize(spec))
+	return store.DeploymentRevision{SpecJSON: string(b), Cause: cause, CreatedAt: time.Now().Unix()}
+}
+
+// SaveRevision 写入部署 d 并把目标修订指向 spec 的快照（与最新修订相同时不追加），两者在同一事务内完成；
+// ifMatch>0 时做版本校验，冲突时返回 store.ErrConflict 且不留下修订。成功后更新 d 的 Revision 和 ResourceVersion
+func SaveRevision(d *store.Deployment, spec []Entry, cause string, ifMatch int64) error {
+	snapshot := NewRevision(spec, cause)
+	snapshot.DeploymentID = d.DeploymentID
+	rev, rv, err := store.Current.UpdateDeploymentRevision(*d, snapshot, ifMatch)
+	if err != nil {
+		return err
+	}
+	d.Revision, d.ResourceVersion = rev, rv
+	return nil
+}
+
+// EffectiveSpec 部署当前生效的规格，用作 PATCH 的基础：
+// 有修订时取目标修订（发布完成后显式副本条目以现有实例为准，运行中的部署不计入
+// 故障转移后遗留的已停止实例），否则由 assignments 反推。
+func EffectiveSpec(d store.Deployment, assigns []store.Assignment) []Entry {
+	assigns = Active(assigns)
+	if d.Revision > 0 {
+		if spec, ok, _ := LoadRevisionSpec(d.DeploymentID, d.Revision); ok {
+			if d.ObservedRevision == d.Revision {
+				return steadySpec(spec, assigns, d.Status == store.DeploymentRunning)
+			}
+			return spec
+		}
+	}
+	return CurrentSpec(assigns)
+}
+
+// EnsureInitialRevision 为尚无修订记录的部署把当前 assignments 记录为修订 1
+func EnsureInitialRevision(d *store.Deployment) error {
+	if d.Revision > 0 {
+		return nil
+	}
+	assigns, err := store.Current.ListAssignmentsForDeployment(d.DeploymentID)
+	if err != nil {
+		return err
+	}
+	rev, err := RecordRevision(d.DeploymentID, CurrentSpec(Active(assigns)), "initial")
+	if err != nil {
+		return err
+	}
+	d.Revision = rev
+	return nil
+}
+
+// normalize 去掉副本数为 0 的节点，便于比较规格是否变化
+func normalize(spec []Entry) []Entry {
+	out := make([]Entry, 0, len(spec))
+	for _, e := range spec {
+		if e.Placement != nil {
+			e.Replicas = nil
+			out = append(out, e)
+			continue
+		}
+		r := map[string]int{}
+		for nodeID, n := range e.Replicas {
+			if n > 0 {
+				r[nodeID] = n
+			}
+		}
+		if len(r) == 0 {
+			continue
+		}
+		e.Replicas = r
+		out = append(out, e)
+	}
+	return out
+}
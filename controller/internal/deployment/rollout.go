@@ -0,0 +1,273 @@
+package deployment
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// 发布控制器：周期性地把部署的 assignments 向目标修订推进。
+// rolling 策略按 MaxSurge 分批创建新实例，新实例就绪（Running、存活且就绪探针通过）后
+// 再在 MaxUnavailable 允许的范围内删除旧实例；recreate 策略先删除全部旧实例再创建新实例。
+
+const (
+	RolloutProgressing = "Progressing"
+	RolloutComplete    = "Complete"
+	RolloutPaused      = "Paused"
+)
+
+// RolloutStatus 发布进度
+type RolloutStatus struct {
+	Revision int    `json:"revision"`
+	Phase    string `json:"phase"`   // Progressing | Complete | Paused
+	Desired  int    `json:"desired"` // 目标修订的期望副本数
+	Updated  int    `json:"updated"` // 已符合目标修订的实例数
+	Ready    int    `json:"ready"`   // 已符合目标修订且就绪的实例数
+	Old      int    `json:"old"`     // 待删除的旧实例数
+	// 因约束或节点不足而无法调度的副本数（自动调度条目）
+	Unschedulable int `json:"unschedulable,omitempty"`
+}
+
+var mu sync.Mutex
+
+func rolloutIntervalSeconds() int {
+	if v := os.Getenv("ROLLOUT_INTERVAL_SEC"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 1
+}
+
+// Start 启动发布控制循环
+func Start() {
+	go func() {
+		iv := time.Duration(rolloutIntervalSeconds()) * time.Second
+		for {
+			time.Sleep(iv)
+			deps, err := store.Current.ListDeployments()
+			if err != nil {
+				log.Printf("rollout: list deployments error: %v", err)
+				continue
+			}
+			for _, d := range deps {
+				if d.Paused || d.Revision == 0 {
+					continue
+				}
+				if err := Reconcile(d.DeploymentID); err != nil {
+					log.Printf("rollout: deployment %s: %v", d.DeploymentID, err)
+				}
+			}
+		}
+	}()
+}
+
+// Reconcile 对单个部署执行一步发布
+func Reconcile(deploymentID string) error {
+	mu.Lock()
+	defer mu.Unlock()
+	d, ok, err := store.Current.GetDeployment(deploymentID)
+	if err != nil || !ok || d.Paused || d.Revision == 0 {
+		return err
+	}
+	if d.IsJob() {
+		return reconcileJob(d)
+	}
+	pl, ok, err := targetPlan(d, "")
+	if err != nil || !ok {
+		return err
+	}
+	if pl.diff.Empty() {
+		if d.ObservedRevision != d.Revision {
+			// 发布完成；并发更新导致版本冲突时下一轮再记录
+			d.ObservedRevision = d.Revision
+			if _, err := store.Current.UpdateDeployment(d, d.ResourceVersion); err != nil && !errors.Is(err, store.ErrConflict) {
+				return err
+			}
+		}
+		return nil
+	}
+	diff := pl.diff
+	// 已停止的部署（Agent 不会运行其实例）没有可用性要求，直接收敛
+	if d.Status != store.DeploymentRunning {
+		return Apply(diff)
+	}
+	if d.Strategy == store.StrategyRecreate {
+		if len(diff.Remove) > 0 {
+			return Apply(Diff{Remove: diff.Remove})
+		}
+		return Apply(Diff{Add: diff.Add})
+	}
+	return Apply(rollingStep(diff, d, pl.isReady))
+}
+
+// PlaceInitial 立即创建目标修订中缺少的实例（创建部署时的首次调度）
+func PlaceInitial(deploymentID string, desired store.DesiredState) error {
+	mu.Lock()
+	defer mu.Unlock()
+	d, ok, err := store.Current.GetDeployment(deploymentID)
+	if err != nil || !ok {
+		return err
+	}
+	pl, ok, err := targetPlan(d, desired)
+	if err != nil || !ok {
+		return err
+	}
+	return Apply(Diff{Add: pl.diff.Add})
+}
+
+// plan 当前 assignments 到目标修订的差异及计算时的节点视图
+type plan struct {
+	diff          Diff
+	unschedulable int
+	cluster       cluster
+}
+
+// rollingStep 计算滚动更新的一步：在 MaxSurge / MaxUnavailable 约束内创建新实例、删除旧实例
+func rollingStep(diff Diff, d store.Deployment, isReady func(store.Assignment) bool) Diff {
+	desired := len(diff.Keep) + len(diff.Add)
+	total := len(diff.Keep) + len(diff.Remove)
+	available := 0
+	for _, a := range diff.Keep {
+		if isReady(a) {
+			available++
+		}
+	}
+	var step Diff
+	// 未就绪的旧实例不计入可用数，可直接删除
+	var readyOld []store.Assignment
+	for _, a := range diff.Remove {
+		if isReady(a) {
+			readyOld = append(readyOld, a)
+			available++
+		} else {
+			step.Remove = append(step.Remove, a)
+		}
+	}
+	total -= len(step.Remove)
+	minAvailable := desired - d.MaxUnavailable
+	for _, a := range readyOld {
+		if available <= minAvailable {
+			break
+		}
+		step.Remove = append(step.Remove, a)
+		available--
+		total--
+	}
+	if n := desired + d.MaxSurge - total; n > 0 {
+		if n > len(diff.Add) {
+			n = len(diff.Add)
+		}
+		step.Add = diff.Add[:n]
+	}
+	return step
+}
+
+// targetPlan 计算当前 assignments 到目标修订的差异（自动调度条目按当前节点重新计算位置，
+// 守护型部署见 daemonSpec）。
+// desired 为空时由 desiredFor 推断新实例的期望状态。
+func targetPlan(d store.Deployment, desired store.DesiredState) (plan, bool, error) {
+	spec, ok, err := LoadRevisionSpec(d.DeploymentID, d.Revision)
+	if err != nil || !ok {
+		return plan{}, ok, err
+	}
+	assigns, err := store.Current.ListAssignmentsForDeployment(d.DeploymentID)
+	if err != nil {
+		return plan{}, false, err
+	}
+	assigns = Active(assigns)
+	c, err := loadCluster()
+	if err != nil {
+		return plan{}, false, err
+	}
+	if desired == "" {
+		desired = desiredFor(d, assigns)
+	}
+	var unschedulable int
+	if d.IsDaemonSet() {
+		if spec, unschedulable, err = c.daemonSpec(spec, assigns); err != nil {
+			return plan{}, false, err
+		}
+	} else {
+		if d.ObservedRevision == d.Revision {
+			spec = steadySpec(spec, assigns, false)
+		}
+		spec, unschedulable = c.resolve(spec, assigns)
+	}
+	return plan{
+		diff:          ComputeDiff(d.DeploymentID, assigns, spec, desired),
+		unschedulable: unschedulable,
+		cluster:       c,
+	}, true, nil
+}
+
+// steadySpec 发布完成后，显式副本条目以现有实例为准（故障转移可能已把实例迁移到其它节点），
+// 只有自动调度条目继续按约束调整。skipStopped 时不计入期望停止的实例。
+func steadySpec(spec []Entry, current []store.Assignment, skipStopped bool) []Entry {
+	out := make([]Entry, 0, len(spec))
+	for _, e := range spec {
+		if e.Placement == nil {
+			replicas := map[string]int{}
+			for _, a := range current {
+				if assignmentKey(a) == e.key() && !(skipStopped && a.Desired == store.DesiredStopped) {
+					replicas[a.NodeID]++
+				}
+			}
+			e.Replicas = replicas
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// desiredFor 新实例的期望状态：运行中的部署为 Running；已停止的部署与现有实例保持一致
+// （新建部署状态为 Stopped，但其实例的期望状态为 Running，启动后直接生效）。
+func desiredFor(d store.Deployment, assigns []store.Assignment) store.DesiredState {
+	if d.Status == store.DeploymentRunning {
+		return store.DesiredRunning
+	}
+	for _, a := range assigns {
+		if a.Desired == store.DesiredRunning {
+			return store.DesiredRunning
+		}
+	}
+	return store.DesiredStopped
+}
+
+// isReady 见 cluster.ready
+func (pl plan) isReady(a store.Assignment) bool { return pl.cluster.ready(a) }
+
+// Status 返回部署的发布进度
+func Status(d store.Deployment) (RolloutStatus, error) {
+	rs := RolloutStatus{Revision: d.Revision, Phase: RolloutComplete}
+	// 任务型部署没有发布过程，进度见 JobStatusOf
+	if d.Revision == 0 || d.IsJob() {
+		return rs, nil
+	}
+	pl, _, err := targetPlan(d, "")
+	if err != nil {
+		return rs, err
+	}
+	diff := pl.diff
+	rs.Unschedulable = pl.unschedulable
+	rs.Desired = len(diff.Keep) + len(diff.Add)
+	rs.Updated = len(diff.Keep)
+	rs.Old = len(diff.Remove)
+	for _, a := range diff.Keep {
+		if pl.isReady(a) {
+			rs.Ready++
+		}
+	}
+	switch {
+	case d.Paused:
+		rs.Phase = RolloutPaused
+	case !diff.Empty():
+		rs.Phase = RolloutProgressing
+	}
+	return rs, nil
+}
@@ -0,0 +1,60 @@
+package deployment
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// instances 生成 n 个实例，ID 为 prefix-0 ... prefix-(n-1)
+func instances(prefix string, n int) []store.Assignment {
+	out := make([]store.Assignment, n)
+	for i := range out {
+		out[i] = store.Assignment{InstanceID: fmt.Sprintf("%s-%d", prefix, i)}
+	}
+	return out
+}
+
+func TestRollingStep(t *testing.T) {
+	tests := []struct {
+		name           string
+		keep, remove   int
+		add            int
+		notReady       []string // 未就绪的实例
+		surge, unavail int
+		wantAdd        int
+		wantRemove     []string
+	}{
+		{name: "surge only adds one new instance first", remove: 3, add: 3, surge: 1, wantAdd: 1},
+		{name: "unavailable allows removing one ready old instance", remove: 3, add: 3, surge: 1, unavail: 1, wantAdd: 2, wantRemove: []string{"old-0"}},
+		{name: "zero surge replaces within unavailable budget", remove: 3, add: 3, unavail: 1, wantAdd: 1, wantRemove: []string{"old-0"}},
+		{name: "unready old instances are removed without budget", remove: 3, add: 3, surge: 1, notReady: []string{"old-1"}, wantAdd: 2, wantRemove: []string{"old-1"}},
+		{name: "unready new instances keep old ones", keep: 2, remove: 1, add: 1, surge: 1, notReady: []string{"new-1"}, wantAdd: 1},
+		{name: "ready new instances release the last old one", keep: 3, remove: 1, surge: 1, wantRemove: []string{"old-0"}},
+		{name: "surge never exceeds pending additions", remove: 1, add: 1, surge: 5, wantAdd: 1},
+		{name: "no surge and no unavailable makes no progress", remove: 2, add: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			diff := Diff{Keep: instances("new", tt.keep), Remove: instances("old", tt.remove), Add: instances("add", tt.add)}
+			notReady := map[string]bool{}
+			for _, id := range tt.notReady {
+				notReady[id] = true
+			}
+			d := store.Deployment{MaxSurge: tt.surge, MaxUnavailable: tt.unavail}
+			step := rollingStep(diff, d, func(a store.Assignment) bool { return !notReady[a.InstanceID] })
+
+			if len(step.Add) != tt.wantAdd {
+				t.Errorf("added %d instances, want %d", len(step.Add), tt.wantAdd)
+			}
+			var removed []string
+			for _, a := range step.Remove {
+				removed = append(removed, a.InstanceID)
+			}
+			if fmt.Sprint(removed) != fmt.Sprint(tt.wantRemove) {
+				t.Errorf("removed %v, want %v", removed, tt.wantRemove)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package deployment
+
+import (
+	"log"
+	"sort"
+	"strings"
+
+	"github.com/manxisuo/plum/controller/internal/notify"
+	"github.com/manxisuo/plum/controller/internal/scheduler"
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// Entry 部署的一个条目：同一制品 + 启动命令在各节点上的副本数。
+// 设置 Placement 时由控制器自动选择节点，Replicas 在每次发布循环中重新计算。
+type Entry struct {
+	ArtifactURL string               `json:"artifactUrl"`
+	StartCmd    string               `json:"startCmd"`
+	Replicas    map[string]int       `json:"replicas"` // nodeId -> replica count
+	Placement   *scheduler.Placement `json:"placement,omitempty"`
+	Probes      *Probes              `json:"probes,omitempty"` // 存活/就绪探针（修改探针不重建实例）
+	Limits      *Limits              `json:"limits,omitempty"` // 资源限制（修改后由 Agent 就地更新，不重建实例）
+	Hooks       *Hooks               `json:"hooks,omitempty"`  // 生命周期钩子（修改钩子不重建实例）
+
+	// 配置注入（见 config.go）；ConfigHash 由控制器计算，变化时替换实例
+	Env        map[string]string `json:"env,omitempty"`
+	ConfigMaps []ConfigSource    `json:"configMaps,omitempty"`
+	Secrets    []ConfigSource    `json:"secrets,omitempty"`
+	ConfigHash string            `json:"configHash,omitempty"`
+}
+
+func (e Entry) key() string { return e.ArtifactURL + "\x00" + e.StartCmd + "\x00" + e.ConfigHash }
+
+// EntryFor 部署当前修订中与制品 + 启动命令对应的条目（探针、资源限制等随分配下发给 Agent）；
+// 优先匹配配置摘要相同的条目，配置更新后尚未替换的旧实例取同一制品的条目
+func EntryFor(deploymentID string, artifactURL string, startCmd string, configHash string) (Entry, bool) {
+	d, ok, err := store.Current.GetDeployment(deploymentID)
+	if err != nil || !ok || d.Revision == 0 {
+		return Entry{}, false
+	}
+	spec, ok, err := LoadRevisionSpec(deploymentID, d.Revision)
+	if err != nil || !ok {
+		return Entry{}, false
+	}
+	var fallback *Entry
+	for i, e := range spec {
+		if e.ArtifactURL == artifactURL && e.StartCmd == startCmd {
+			if e.ConfigHash == configHash {
+				return e, true
+			}
+			if fallback == nil {
+				fallback = &spec[i]
+			}
+		}
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return Entry{}, false
+}
+
+func assignmentKey(a store.Assignment) string {
+	return a.ArtifactURL + "\x00" + a.StartCmd + "\x00" + a.ConfigHash
+}
+
+// Active 过滤掉已被接替的实例（故障转移隔离的旧实例、重平衡迁移中的源实例），
+// 它们由故障转移和重平衡负责收尾，不参与部署规格计算
+func Active(assigns []store.Assignment) []store.Assignment {
+	out := make([]store.Assignment, 0, len(assigns))
+	for _, a := range assigns {
+		if a.SupersededBy == "" {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// CurrentSpec 从现有 assignments 反推部署规格（按制品和启动命令分组）
+func CurrentSpec(assigns []store.Assignment) []Entry {
+	byKey := map[string]*Entry{}
+	var keys []string
+	for _, a := range assigns {
+		k := assignmentKey(a)
+		e, ok := byKey[k]
+		if !ok {
+			e = &Entry{ArtifactURL: a.ArtifactURL, StartCmd: a.StartCmd, ConfigHash: a.ConfigHash, Replicas: map[string]int{}}
+			byKey[k] = e
+			keys = append(keys, k)
+		}
+		e.Replicas[a.NodeID]++
+	}
+	sort.Strings(keys)
+	out := make([]Entry, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, *byKey[k])
+	}
+	return out
+}
+
+// Diff 从当前 assignments 到目标规格需要的变更
+type Diff struct {
+	Keep   []store.Assignment
+	Remove []store.Assignment
+	Add    []store.Assignment
+}
+
+// Empty 没有任何需要执行的变更
+func (d Diff) Empty() bool { return len(d.Remove) == 0 && len(d.Add) == 0 }
+
+// ComputeDiff 计算 assignment 差异：每个节点上已符合规格（制品、启动命令和配置相同）的实例尽量保留，
+// 多余或不符合的实例删除，不足的部分新建（新实例的期望状态为 desired）。
+func ComputeDiff(deploymentID string, current []store.Assignment, spec []Entry, desired store.DesiredState) Diff {
+	var d Diff
+	// 每个 (条目, 节点) 还需要的副本数
+	need := map[string]map[string]int{}
+	for _, e := range spec {
+		m, ok := need[e.key()]
+		if !ok {
+			m = map[string]int{}
+			need[e.key()] = m
+		}
+		for nodeID, n := range e.Replicas {
+			if n > 0 {
+				m[nodeID] += n
+			}
+		}
+	}
+	// 稳定顺序：先保留运行中的实例
+	sorted := append([]store.Assignment(nil), current...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Desired != store.DesiredStopped && sorted[j].Desired == store.DesiredStopped
+	})
+	for _, a := range sorted {
+		if m := need[assignmentKey(a)]; m != nil && m[a.NodeID] > 0 {
+			m[a.NodeID]--
+			d.Keep = append(d.Keep, a)
+			continue
+		}
+		d.Remove = append(d.Remove, a)
+	}
+	for _, e := range spec {
+		appName, appVersion := ResolveArtifact(e.ArtifactURL)
+		nodes := make([]string, 0, len(e.Replicas))
+		for nodeID := range e.Replicas {
+			nodes = append(nodes, nodeID)
+		}
+		sort.Strings(nodes)
+		m := need[e.key()]
+		for _, nodeID := range nodes {
+			for ; m[nodeID] > 0; m[nodeID]-- {
+				d.Add = append(d.Add, store.Assignment{
+					InstanceID:   store.Current.NewInstanceID(deploymentID),
+					DeploymentID: deploymentID,
+					NodeID:       nodeID,
+					Desired:      desired,
+					ArtifactURL:  e.ArtifactURL,
+					StartCmd:     e.StartCmd,
+					AppName:      appName,
+					AppVersion:   appVersion,
+					ConfigHash:   e.ConfigHash,
+				})
+			}
+		}
+	}
+	return d
+}
+
+// Apply 执行差异并通知受影响节点上的 Agent
+func Apply(d Diff) error {
+	nodes := map[string]bool{}
+	for _, a := range d.Add {
+		if err := store.Current.AddAssignment(a.NodeID, a); err != nil {
+			return err
+		}
+		nodes[a.NodeID] = true
+	}
+	for _, a := range d.Remove {
+		_ = store.Current.DeleteEndpointsForInstance(a.InstanceID)
+		_ = store.Current.DeleteStatusesForInstance(a.InstanceID)
+		if err := store.Current.DeleteAssignment(a.InstanceID); err != nil {
+			return err
+		}
+		nodes[a.NodeID] = true
+	}
+	for nodeID := range nodes {
+		notify.Publish(nodeID)
+	}
+	return nil
+}
+
+// Delete 级联删除部署（连同 assignments 与相关 statuses），并通知涉及的 Agent 停止实例；
+// ifMatch>0 时版本不符返回 store.ErrConflict
+func Delete(deploymentID string, ifMatch int64) error {
+	assigns, err := store.Current.ListAssignmentsForDeployment(deploymentID)
+	if err != nil {
+		return err
+	}
+	nodes := map[string]bool{}
+	for _, a := range assigns {
+		nodes[a.NodeID] = true
+	}
+	if err := store.Current.DeleteDeployment(deploymentID, ifMatch); err != nil {
+		return err
+	}
+	if err := store.Current.PruneConfigSnapshots(); err != nil {
+		log.Printf("deployment: prune config snapshots: %v", err)
+	}
+	for nodeID := range nodes {
+		notify.Publish(nodeID)
+	}
+	return nil
+}
+
+// ResolveArtifact 根据制品地址查找应用名和版本（image://{artifactId} 或 ZIP 路径）
+func ResolveArtifact(artifactURL string) (string, string) {
+	if strings.HasPrefix(artifactURL, "image://") {
+		if art, ok, _ := store.Current.GetArtifact(strings.TrimPrefix(artifactURL, "image://")); ok {
+			return art.AppName, art.Version
+		}
+	} else if artifactURL != "" {
+		if art, ok, _ := store.Current.GetArtifactByPath(artifactURL); ok {
+			return art.AppName, art.Version
+		}
+	}
+	return "", ""
+}
+
+// ArtifactURLForVersion 查找同一应用指定版本的制品地址，找不到返回 false
+func ArtifactURLForVersion(artifactURL string, version string) (string, bool) {
+	appName, _ := ResolveArtifact(artifactURL)
+	if appName == "" {
+		return "", false
+	}
+	arts, err := store.Current.ListArtifacts()
+	if err != nil {
+		return "", false
+	}
+	for _, art := range arts {
+		if art.AppName != appName || art.Version != version {
+			continue
+		}
+		if art.Type == "image" {
+			return "image://" + art.ArtifactID, true
+		}
+		return art.Path, true
+	}
+	return "", false
+}
@@ -0,0 +1,75 @@
+package events
+
+import (
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/manxisuo/plum/controller/internal/notify"
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// 集群事件：节点健康状态变化、故障转移和重平衡的每次迁移、制品校验失败都会持久化，供 /v1/events 审计。
+
+// 事件类型
+const (
+	NodeHealthChanged  = "NodeHealthChanged"  // Reason 为新的健康状态，Message 含原状态
+	NodeStatusChanged  = "NodeStatusChanged"  // cordon / uncordon / drain，Reason 为新的调度状态
+	InstanceMigrated   = "InstanceMigrated"   // 故障转移：实例从故障节点迁移到其它节点
+	MigrationFailed    = "MigrationFailed"    // 故障转移：找不到可用节点或创建实例失败
+	InstanceMoved      = "InstanceMoved"      // 重平衡 / 排空：新实例就绪后删除源实例
+	MoveAborted        = "MoveAborted"        // 重平衡 / 排空：新实例未就绪，取消迁移
+	InstanceFenced     = "InstanceFenced"     // 故障转移隔离的旧实例已确认停止并清理
+	MigrationSuspended = "MigrationSuspended" // 健康节点不足法定比例，暂停故障转移
+	MigrationResumed   = "MigrationResumed"   // 恢复法定比例，继续故障转移
+
+	ArtifactChecksumMismatch = "ArtifactChecksumMismatch" // Agent 下载的制品 SHA256 校验失败，实例未启动
+	ConfigChanged            = "ConfigChanged"            // 配置集 / 密钥更新，引用它的部署记录新修订并替换实例
+	HookFailed               = "HookFailed"               // 实例的 preStart / postStart 钩子失败，Reason 为失败的钩子，Message 为输出末尾
+	PortConflict             = "PortConflict"             // 实例的固定端口已被占用或动态端口范围耗尽，实例未启动
+	JobSucceeded             = "JobSucceeded"             // 任务型部署完成了要求数量的实例
+	JobFailed                = "JobFailed"                // 任务型部署的失败次数超过 backoffLimit，Message 含失败次数
+)
+
+var (
+	pruneMu   sync.Mutex
+	lastPrune time.Time
+)
+
+// retention 事件保留时长
+func retention() time.Duration {
+	if v := os.Getenv("EVENT_RETENTION_HOURS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return time.Duration(n) * time.Hour
+		}
+	}
+	return 7 * 24 * time.Hour
+}
+
+// Record 持久化事件并通知订阅者；写入失败只记录日志，不影响调用方
+func Record(e store.Event) {
+	now := time.Now()
+	if e.Time == 0 {
+		e.Time = now.Unix()
+	}
+	if _, err := store.Current.AppendEvent(e); err != nil {
+		log.Printf("events: append %s error: %v", e.Type, err)
+		return
+	}
+	notify.PublishEvents()
+
+	// 每小时清理一次过期事件
+	pruneMu.Lock()
+	due := now.Sub(lastPrune) >= time.Hour
+	if due {
+		lastPrune = now
+	}
+	pruneMu.Unlock()
+	if due {
+		if err := store.Current.PruneEvents(now.Add(-retention()).Unix()); err != nil {
+			log.Printf("events: prune error: %v", err)
+		}
+	}
+}
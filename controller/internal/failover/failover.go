@@ -1,15 +1,17 @@
 package failover
 
 import (
+	"fmt"
 	"log"
-	"math/rand"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/manxisuo/plum/controller/internal/events"
 	"github.com/manxisuo/plum/controller/internal/notify"
+	"github.com/manxisuo/plum/controller/internal/scheduler"
 	"github.com/manxisuo/plum/controller/internal/store"
 )
 
@@ -58,36 +60,52 @@ func parseBool(v string) bool {
 }
 
 // Start launches a background loop that performs failover for assignments on Unhealthy nodes.
+// 关闭自动迁移时仍持续评估节点健康，以便记录健康状态变化事件。
 func Start() {
-	if !enabled() {
+	on := enabled()
+	if !on {
 		log.Printf("failover: disabled by env")
-		return
 	}
-	rand.Seed(time.Now().UnixNano())
 	go func() {
 		iv := time.Duration(intervalSeconds()) * time.Second
-		ttl := ttlSeconds()
 		for {
 			time.Sleep(iv)
-			doOneLoop(ttl)
+			if on {
+				doOneLoop()
+			} else {
+				ComputeHealth()
+			}
 		}
 	}()
 }
 
-func doOneLoop(ttlSec int64) {
+// quorumRatio 执行迁移所需的健康节点最低比例
+func quorumRatio() float64 {
+	if v := os.Getenv("FAILOVER_QUORUM_RATIO"); v != "" {
+		if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 && f <= 1 {
+			return f
+		}
+	}
+	return 0.5
+}
+
+// quorumLost 上一轮是否因健康节点不足而放弃迁移（仅用于避免重复日志）
+var quorumLost bool
+
+func doOneLoop() {
 	nodes, err := store.Current.ListNodes()
 	if err != nil {
 		log.Printf("failover: list nodes error: %v", err)
 		return
 	}
-	now := time.Now()
+	health := evaluate(nodes, time.Now())
 	healthySet := make(map[string]bool)
 	unhealthy := make([]string, 0)
 	for _, n := range nodes {
-		delta := now.Unix() - n.LastSeen.Unix()
-		if delta <= ttlSec {
+		switch health[n.NodeID] {
+		case Healthy:
 			healthySet[n.NodeID] = true
-		} else {
+		case Unhealthy:
 			unhealthy = append(unhealthy, n.NodeID)
 		}
 	}
@@ -95,6 +113,22 @@ func doOneLoop(ttlSec int64) {
 		// nothing to migrate to
 		return
 	}
+	// 大多数节点同时失联时更可能是控制器侧网络分区，此时迁移会把实例集中到少数节点并造成双跑
+	if float64(len(healthySet)) < quorumRatio()*float64(len(nodes)) {
+		if !quorumLost && len(unhealthy) > 0 {
+			msg := fmt.Sprintf("only %d of %d nodes healthy (below quorum %.2f), suspect controller-side partition", len(healthySet), len(nodes), quorumRatio())
+			log.Printf("failover: %s, migration suspended", msg)
+			events.Record(store.Event{Type: events.MigrationSuspended, Reason: "QuorumLost", Message: msg})
+		}
+		quorumLost = true
+		return
+	}
+	if quorumLost {
+		msg := fmt.Sprintf("quorum regained (%d of %d nodes healthy)", len(healthySet), len(nodes))
+		log.Printf("failover: %s, migration resumed", msg)
+		events.Record(store.Event{Type: events.MigrationResumed, Reason: "QuorumRegained", Message: msg})
+		quorumLost = false
+	}
 	for _, bad := range unhealthy {
 		migrateNode(bad, healthySet)
 	}
@@ -106,17 +140,15 @@ func migrateNode(badNode string, healthySet map[string]bool) {
 		log.Printf("failover: list assignments for %s error: %v", badNode, err)
 		return
 	}
-	healthyNodes := make([]string, 0, len(healthySet))
-	for id := range healthySet {
-		healthyNodes = append(healthyNodes, id)
-	}
-	// random helper
-	pick := func() (string, bool) {
-		if len(healthyNodes) == 0 {
-			return "", false
-		}
-		return healthyNodes[rand.Intn(len(healthyNodes))], true
+	candidates, err := healthyCandidates(healthySet)
+	if err != nil {
+		log.Printf("failover: load candidates error: %v", err)
+		return
 	}
+	// 本轮已选定的目标：deploymentId -> nodeId -> 新实例数
+	picked := map[string]map[string]int{}
+	// 守护型部署的实例绑定节点，不迁移（节点恢复后继续运行）：deploymentId -> 是否守护型
+	daemon := map[string]bool{}
 	// 优化：并行迁移多个应用，减少迁移延迟
 	var wg sync.WaitGroup
 	semaphore := make(chan struct{}, 5) // 限制并发数为5，避免过载
@@ -126,9 +158,38 @@ func migrateNode(badNode string, healthySet map[string]bool) {
 		if a.Desired != store.DesiredRunning {
 			continue
 		}
+		isDaemon, seen := daemon[a.DeploymentID]
+		if !seen {
+			if d, ok, err := store.Current.GetDeployment(a.DeploymentID); err == nil && ok {
+				isDaemon = d.IsDaemonSet()
+			}
+			daemon[a.DeploymentID] = isDaemon
+		}
+		if isDaemon {
+			continue
+		}
+		// 重平衡迁移中的源实例：接替者已存在，只需隔离
+		if a.SupersededBy != "" {
+			_ = store.Current.SupersedeAssignment(a.InstanceID, a.SupersededBy)
+			_ = store.Current.DeleteEndpointsForInstance(a.InstanceID)
+			continue
+		}
+		// 顺序选择目标节点，使后续选择能看到已选目标带来的负载
+		target, ok := pickTarget(a, candidates, picked)
+		if ok {
+			if picked[a.DeploymentID] == nil {
+				picked[a.DeploymentID] = map[string]int{}
+			}
+			picked[a.DeploymentID][target]++
+			for i := range candidates {
+				if candidates[i].NodeID == target {
+					candidates[i].Load++
+				}
+			}
+		}
 
 		wg.Add(1)
-		go func(assignment store.Assignment) {
+		go func(assignment store.Assignment, target string, ok bool) {
 			defer wg.Done()
 
 			// 性能监控：记录迁移开始时间
@@ -138,14 +199,27 @@ func migrateNode(badNode string, healthySet map[string]bool) {
 			semaphore <- struct{}{}
 			defer func() { <-semaphore }()
 
-			// Stop old assignment first (idempotent)
-			_ = store.Current.UpdateAssignmentDesired(assignment.InstanceID, store.DesiredStopped)
-			target, ok := pick()
-			if !ok || target == badNode {
+			if !ok {
+				// Stop old assignment (idempotent)
+				_ = store.Current.UpdateAssignmentDesired(assignment.InstanceID, store.DesiredStopped)
+				log.Printf("failover: no node fits instance %s (deployment %s)", assignment.InstanceID, assignment.DeploymentID)
+				events.Record(store.Event{
+					Type:         events.MigrationFailed,
+					Reason:       "NoSchedulableNode",
+					NodeID:       badNode,
+					DeploymentID: assignment.DeploymentID,
+					InstanceID:   assignment.InstanceID,
+					FromNode:     badNode,
+					Message:      "no healthy node fits the instance; it stays stopped",
+				})
 				return
 			}
-			// Create new assignment on target
+			// 隔离旧实例：置为 Stopped 并记录接替者。节点恢复后 Agent 会先停止它，
+			// 在此之前其状态上报不计为健康，服务端点也不再对外提供
 			newIID := store.Current.NewInstanceID(assignment.DeploymentID)
+			_ = store.Current.SupersedeAssignment(assignment.InstanceID, newIID)
+			_ = store.Current.DeleteEndpointsForInstance(assignment.InstanceID)
+			// Create new assignment on target
 			err := store.Current.AddAssignment(target, store.Assignment{
 				InstanceID:   newIID,
 				DeploymentID: assignment.DeploymentID,
@@ -155,9 +229,23 @@ func migrateNode(badNode string, healthySet map[string]bool) {
 				StartCmd:     assignment.StartCmd,
 				AppName:      assignment.AppName,    // 复制应用名称
 				AppVersion:   assignment.AppVersion, // 复制应用版本
+				ConfigHash:   assignment.ConfigHash,
+				// 记住原节点，重平衡时可迁回
+				PreferredNode: preferredNode(assignment),
 			})
 			if err != nil {
 				log.Printf("failover: add assignment %s->%s error: %v", assignment.InstanceID, target, err)
+				events.Record(store.Event{
+					Type:          events.MigrationFailed,
+					Reason:        "CreateFailed",
+					NodeID:        badNode,
+					DeploymentID:  assignment.DeploymentID,
+					InstanceID:    assignment.InstanceID,
+					NewInstanceID: newIID,
+					FromNode:      badNode,
+					ToNode:        target,
+					Message:       err.Error(),
+				})
 			} else {
 				// 性能监控：记录迁移完成时间
 				migrationDuration := time.Since(migrationStart)
@@ -169,31 +257,89 @@ func migrateNode(badNode string, healthySet map[string]bool) {
 				}
 
 				log.Printf("failover: migrated instance %s (deployment %s) from %s to %s as %s", assignment.InstanceID, assignment.DeploymentID, badNode, target, newIID)
+				events.Record(store.Event{
+					Type:          events.InstanceMigrated,
+					Reason:        "NodeUnhealthy",
+					NodeID:        badNode,
+					DeploymentID:  assignment.DeploymentID,
+					InstanceID:    assignment.InstanceID,
+					NewInstanceID: newIID,
+					FromNode:      badNode,
+					ToNode:        target,
+					DurationMs:    migrationDuration.Milliseconds(),
+					Message:       fmt.Sprintf("migrated from %s to %s", badNode, target),
+				})
 				notify.Publish(target)
 			}
-		}(a)
+		}(a, target, ok)
 	}
 
 	// 等待所有迁移完成
 	wg.Wait()
 }
 
-// ComputeHealth returns nodeId -> health for current nodes.
-func ComputeHealth() map[string]NodeHealth {
-	ttl := ttlSeconds()
-	now := time.Now()
-	out := make(map[string]NodeHealth)
+// preferredNode 实例迁移后应记住的原节点（多次迁移时保留最初的节点）
+func preferredNode(a store.Assignment) string {
+	if a.PreferredNode != "" {
+		return a.PreferredNode
+	}
+	return a.NodeID
+}
+
+// healthyCandidates 健康节点及其已分配实例数
+func healthyCandidates(healthySet map[string]bool) ([]scheduler.Candidate, error) {
 	nodes, err := store.Current.ListNodes()
 	if err != nil {
-		return out
+		return nil, err
 	}
+	var out []scheduler.Candidate
 	for _, n := range nodes {
-		delta := now.Unix() - n.LastSeen.Unix()
-		if delta <= ttl {
-			out[n.NodeID] = Healthy
-		} else {
-			out[n.NodeID] = Unhealthy
+		if !healthySet[n.NodeID] || n.Status == store.NodeDraining {
+			continue
+		}
+		load, err := store.Current.CountAssignmentsForNode(n.NodeID)
+		if err != nil {
+			return nil, err
 		}
+		out = append(out, scheduler.NewCandidate(n, load))
+	}
+	return out, nil
+}
+
+// pickTarget 为故障节点上的实例选择迁移目标：
+// 自动调度的条目按其约束重新计算位置，其余实例选择容量满足要求、负载最低且同部署实例最少的节点。
+func pickTarget(a store.Assignment, candidates []scheduler.Candidate, picked map[string]map[string]int) (string, bool) {
+	req := scheduler.RequirementFor(a.ArtifactURL)
+	siblings, _ := store.Current.ListAssignmentsForDeployment(a.DeploymentID)
+	counts := map[string]int{}
+	for nodeID, n := range picked[a.DeploymentID] {
+		counts[nodeID] += n
+	}
+	p := scheduler.PlacementFor(a.DeploymentID, a.ArtifactURL, a.StartCmd)
+	for _, s := range siblings {
+		if s.Desired == store.DesiredStopped || s.SupersededBy != "" || (p != nil && (s.ArtifactURL != a.ArtifactURL || s.StartCmd != a.StartCmd)) {
+			continue
+		}
+		counts[s.NodeID]++
+	}
+	if p == nil {
+		best, ok := scheduler.Best(candidates, req, counts)
+		return best.NodeID, ok
+	}
+	placed, _ := scheduler.Place(*p, req, candidates, counts)
+	for _, c := range candidates {
+		if placed[c.NodeID] > counts[c.NodeID] {
+			return c.NodeID, true
+		}
+	}
+	return "", false
+}
+
+// ComputeHealth returns nodeId -> health for current nodes.
+func ComputeHealth() map[string]NodeHealth {
+	nodes, err := store.Current.ListNodes()
+	if err != nil {
+		return make(map[string]NodeHealth)
 	}
-	return out
+	return evaluate(nodes, time.Now())
 }
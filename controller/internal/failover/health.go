@@ -0,0 +1,126 @@
+package failover
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/manxisuo/plum/controller/internal/events"
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// 节点健康状态机（防抖）：心跳超时的节点先进入 Suspect，持续超过宽限期才判定为 Unhealthy；
+// Unhealthy 节点恢复心跳后需稳定一段时间才重新视为 Healthy，避免网络抖动导致反复迁移。
+
+// Suspect 心跳已超时但仍在宽限期内：不再调度新实例，也不迁移已有实例
+const Suspect NodeHealth = "Suspect"
+
+// nodeState 需要跨轮次记住的状态：节点从 Unhealthy 恢复的过程
+type nodeState struct {
+	unhealthy  bool
+	freshSince time.Time  // Unhealthy 节点恢复心跳的时间（零值表示尚未恢复）
+	last       NodeHealth // 上一次评估的结果（用于记录状态变化事件）
+}
+
+var (
+	healthMu  sync.Mutex
+	states    = map[string]*nodeState{}
+	startedAt = time.Now()
+)
+
+// graceSeconds 心跳超时后判定 Unhealthy 前的宽限期
+func graceSeconds() int64 {
+	if v := os.Getenv("FAILOVER_GRACE_SEC"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return int64(n)
+		}
+	}
+	return 5
+}
+
+// recoverySeconds Unhealthy 节点需持续心跳多久才恢复为 Healthy
+func recoverySeconds() int64 {
+	if v := os.Getenv("NODE_RECOVERY_SEC"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return int64(n)
+		}
+	}
+	return 10
+}
+
+// evaluate 根据心跳时间推进各节点的健康状态
+func evaluate(nodes []store.Node, now time.Time) map[string]NodeHealth {
+	ttl := time.Duration(ttlSeconds()) * time.Second
+	grace := time.Duration(graceSeconds()) * time.Second
+	recovery := time.Duration(recoverySeconds()) * time.Second
+
+	var changes []store.Event
+	defer func() {
+		for _, e := range changes {
+			events.Record(e)
+		}
+	}()
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	out := make(map[string]NodeHealth, len(nodes))
+	seen := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		seen[n.NodeID] = true
+		st, ok := states[n.NodeID]
+		if !ok {
+			st = &nodeState{}
+			states[n.NodeID] = st
+		}
+		age := now.Sub(n.LastSeen)
+		// 超时起点不早于控制器启动时间：控制器重启后给所有节点一个完整的宽限期重新上报心跳
+		staleSince := n.LastSeen.Add(ttl)
+		if staleSince.Before(startedAt) {
+			staleSince = startedAt
+		}
+		dead := age > ttl && now.Sub(staleSince) >= grace
+
+		if st.unhealthy {
+			switch {
+			case dead:
+				st.freshSince = time.Time{}
+			case age <= ttl && st.freshSince.IsZero():
+				st.freshSince = now
+			}
+			if !st.freshSince.IsZero() && now.Sub(st.freshSince) >= recovery {
+				st.unhealthy = false
+				st.freshSince = time.Time{}
+				log.Printf("failover: node %s recovered after %v of steady heartbeats", n.NodeID, recovery)
+			}
+		} else if dead {
+			st.unhealthy = true
+			log.Printf("failover: node %s unhealthy (last heartbeat %v ago)", n.NodeID, age.Truncate(time.Second))
+		}
+
+		switch {
+		case st.unhealthy:
+			out[n.NodeID] = Unhealthy
+		case age > ttl:
+			out[n.NodeID] = Suspect
+		default:
+			out[n.NodeID] = Healthy
+		}
+		if h := out[n.NodeID]; st.last != "" && h != st.last {
+			changes = append(changes, store.Event{
+				Type:    events.NodeHealthChanged,
+				Reason:  string(h),
+				NodeID:  n.NodeID,
+				Message: fmt.Sprintf("%s -> %s (last heartbeat %v ago)", st.last, h, age.Truncate(time.Second)),
+			})
+		}
+		st.last = out[n.NodeID]
+	}
+	for id := range states {
+		if !seen[id] {
+			delete(states, id)
+		}
+	}
+	return out
+}
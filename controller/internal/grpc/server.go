@@ -314,8 +314,8 @@ func GetServer() *TaskStreamServer {
 	return globalServer
 }
 
-// StartServer 启动 gRPC 服务器
-func StartServer(addr string, s store.Store) (*grpc.Server, error) {
+// StartServer 启动 gRPC 服务器；agentService 非空时同时提供 Agent 控制通道
+func StartServer(addr string, s store.Store, agentService proto.AgentServiceServer) (*grpc.Server, error) {
 	// 如果 addr 是 0.0.0.0，强制使用 IPv4（tcp4）以确保容器可以连接
 	network := "tcp"
 	if strings.HasPrefix(addr, "0.0.0.0:") {
@@ -338,6 +338,9 @@ func StartServer(addr string, s store.Store) (*grpc.Server, error) {
 	taskServer := NewTaskStreamServer(s)
 	globalServer = taskServer
 	proto.RegisterTaskServiceServer(grpcServer, taskServer)
+	if agentService != nil {
+		proto.RegisterAgentServiceServer(grpcServer, agentService)
+	}
 
 	go func() {
 		log.Printf("[gRPC] Server listening on %s", addr)
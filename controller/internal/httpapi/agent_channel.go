@@ -0,0 +1,296 @@
+package httpapi
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/manxisuo/plum/controller/internal/notify"
+	"github.com/manxisuo/plum/controller/internal/store"
+	"github.com/manxisuo/plum/controller/proto"
+)
+
+// Agent 控制通道（gRPC AgentService.Connect）：每个节点一条双向流。
+// Controller 在分配变化时（notify）立即推送期望状态增量，只包含变化的分配和已删除的实例；
+// Agent 通过同一条流发送心跳、实例状态和服务端点，处理逻辑与对应的 HTTP 接口相同。
+// 续传令牌 "<epoch>-<seq>"：Agent 重连时携带，与 Controller 记录的最后一次下发一致时只补发增量，
+// 否则（Controller 重启、Agent 漏收增量等）下发全量。
+
+// agentResyncInterval 没有通知时定期比对分配，兜底未触发通知的变化
+const agentResyncInterval = 30 * time.Second
+
+// AgentChannel AgentService 服务端
+type AgentChannel struct {
+	proto.UnimplementedAgentServiceServer
+
+	epoch string
+
+	mu     sync.Mutex
+	nodes  map[string]*agentSession
+	closed bool
+}
+
+// agentSession 节点的下发记录（跨连接保留，用于续传）
+type agentSession struct {
+	seq    uint64
+	sent   map[string][32]byte // instanceID -> 已下发分配的摘要
+	cancel func()              // 关闭当前连接（同一节点的新连接接替旧连接）
+}
+
+func NewAgentChannel() *AgentChannel {
+	return &AgentChannel{
+		epoch: strconv.FormatInt(time.Now().UnixNano(), 36),
+		nodes: make(map[string]*agentSession),
+	}
+}
+
+// Connect 实现双向流：第一条消息须为 hello
+func (c *AgentChannel) Connect(stream proto.AgentService_ConnectServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	hello := first.GetHello()
+	if hello == nil || hello.GetNodeId() == "" {
+		return fmt.Errorf("first message must be hello with nodeId")
+	}
+	nodeID := hello.GetNodeId()
+
+	done := make(chan struct{})
+	var closeOnce sync.Once
+	closeConn := func() { closeOnce.Do(func() { close(done) }) }
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return status.Error(codes.Unavailable, "controller shutting down")
+	}
+	sess := c.nodes[nodeID]
+	if sess == nil {
+		sess = &agentSession{}
+		c.nodes[nodeID] = sess
+	}
+	if sess.cancel != nil {
+		sess.cancel()
+	}
+	sess.cancel = closeConn
+	full := hello.GetResumeToken() == "" || hello.GetResumeToken() != c.token(sess)
+	if full {
+		sess.sent = nil
+	}
+	c.mu.Unlock()
+	log.Printf("[AgentChannel] node %s connected (resume=%v)", nodeID, !full)
+
+	// 发送串行化：推送循环与心跳应答共用一条流
+	var sendMu sync.Mutex
+	send := func(msg *proto.ControllerMessage) error {
+		sendMu.Lock()
+		defer sendMu.Unlock()
+		return stream.Send(msg)
+	}
+
+	errCh := make(chan error, 2)
+	go func() { errCh <- c.pushLoop(nodeID, sess, full, send, done) }()
+	go func() { errCh <- c.recvLoop(nodeID, stream, send) }()
+
+	select {
+	case err = <-errCh:
+	case <-done:
+		err = fmt.Errorf("connection closed by controller")
+	case <-stream.Context().Done():
+		err = stream.Context().Err()
+	}
+	closeConn()
+	c.mu.Lock()
+	if c.nodes[nodeID] == sess {
+		sess.cancel = nil
+	}
+	c.mu.Unlock()
+	log.Printf("[AgentChannel] node %s disconnected: %v", nodeID, err)
+	return err
+}
+
+// Close 关闭所有 Agent 连接并拒绝新连接（gRPC GracefulStop 前调用，否则会一直等待长连接结束）
+func (c *AgentChannel) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for _, sess := range c.nodes {
+		if sess.cancel != nil {
+			sess.cancel()
+		}
+	}
+}
+
+func (c *AgentChannel) token(sess *agentSession) string {
+	return fmt.Sprintf("%s-%d", c.epoch, sess.seq)
+}
+
+// pushLoop 首次下发全量或续传增量，之后在分配变化时推送增量
+func (c *AgentChannel) pushLoop(nodeID string, sess *agentSession, full bool, send func(*proto.ControllerMessage) error, done chan struct{}) error {
+	ch, cancel := notify.Subscribe(nodeID)
+	defer cancel()
+	ticker := time.NewTicker(agentResyncInterval)
+	defer ticker.Stop()
+
+	force := true // 连接后总是发送一次（全量或空增量），告知 Agent 新的令牌
+	for {
+		delta, err := c.buildDelta(nodeID, sess, full, force)
+		if err != nil {
+			log.Printf("[AgentChannel] list assignments for %s failed: %v", nodeID, err)
+		} else if delta != nil {
+			if err := send(&proto.ControllerMessage{Message: &proto.ControllerMessage_Delta{Delta: delta}}); err != nil {
+				return err
+			}
+		}
+		full, force = false, false
+		select {
+		case <-done:
+			return nil
+		case _, ok := <-ch:
+			if !ok {
+				return nil
+			}
+		case <-ticker.C:
+		}
+	}
+}
+
+// buildDelta 比对节点当前分配与已下发记录，没有变化且非强制时返回 nil
+func (c *AgentChannel) buildDelta(nodeID string, sess *agentSession, full, force bool) (*proto.DesiredStateDelta, error) {
+	assigns, err := store.Current.ListAssignmentsForNode(nodeID)
+	if err != nil {
+		return nil, err
+	}
+	items := assignmentDTOs(assigns)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if full || sess.sent == nil {
+		full = true
+		sess.sent = make(map[string][32]byte)
+	}
+	delta := &proto.DesiredStateDelta{Full: full}
+	current := make(map[string]bool, len(items))
+	for _, item := range items {
+		current[item.InstanceID] = true
+		data, err := json.Marshal(item)
+		if err != nil {
+			continue
+		}
+		sum := assignmentDigest(item)
+		if prev, ok := sess.sent[item.InstanceID]; ok && prev == sum && !full {
+			continue
+		}
+		sess.sent[item.InstanceID] = sum
+		delta.Upserts = append(delta.Upserts, data)
+	}
+	for id := range sess.sent {
+		if !current[id] {
+			delete(sess.sent, id)
+			delta.Removed = append(delta.Removed, id)
+		}
+	}
+	sort.Strings(delta.Removed)
+	if !full && !force && len(delta.Upserts) == 0 && len(delta.Removed) == 0 {
+		return nil, nil
+	}
+	sess.seq++
+	delta.ResumeToken = c.token(sess)
+	return delta, nil
+}
+
+// assignmentDigest 分配的摘要，不含 Agent 自己上报的状态字段（避免每次状态上报都触发下发）
+func assignmentDigest(a Assignment) [32]byte {
+	a.Phase, a.Healthy, a.Ready, a.LastReport, a.RestartCount = "", false, false, 0, 0
+	data, _ := json.Marshal(a)
+	return sha256.Sum256(data)
+}
+
+// recvLoop 处理 Agent 发来的心跳、状态和服务端点
+func (c *AgentChannel) recvLoop(nodeID string, stream proto.AgentService_ConnectServer, send func(*proto.ControllerMessage) error) error {
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		switch m := msg.Message.(type) {
+		case *proto.AgentMessage_Heartbeat:
+			hb := m.Heartbeat
+			hello := NodeHello{NodeID: nodeID, IP: hb.GetIp(), Labels: hb.GetLabels(), APIPort: int(hb.GetApiPort())}
+			if raw := hb.GetResourcesJson(); len(raw) > 0 {
+				var res store.NodeResources
+				if err := json.Unmarshal(raw, &res); err == nil {
+					hello.Resources = &res
+				}
+			}
+			ack := recordHeartbeat(hello)
+			if err := send(&proto.ControllerMessage{Message: &proto.ControllerMessage_Lease{
+				Lease: &proto.LeaseAck{TtlSec: ack.TTLSec, Fenced: ack.Fenced},
+			}}); err != nil {
+				return err
+			}
+		case *proto.AgentMessage_Status:
+			st := m.Status
+			ready := st.GetReady()
+			applyStatusUpdate(StatusUpdate{
+				InstanceID:   st.GetInstanceId(),
+				Phase:        st.GetPhase(),
+				ExitCode:     st.GetExitCode(),
+				Healthy:      st.GetHealthy(),
+				Ready:        &ready,
+				TsUnix:       st.GetTsUnix(),
+				RestartCount: int(st.GetRestartCount()),
+				Reason:       st.GetReason(),
+				Message:      st.GetMessage(),
+			})
+		case *proto.AgentMessage_Endpoints:
+			handleEndpointRegistration(nodeID, m.Endpoints)
+		}
+	}
+}
+
+// handleEndpointRegistration 服务端点注册 / 心跳 / 删除（已被隔离的实例忽略注册和心跳）
+func handleEndpointRegistration(nodeID string, reg *proto.EndpointRegistration) {
+	iid := reg.GetInstanceId()
+	if iid == "" {
+		return
+	}
+	var err error
+	switch reg.GetOp() {
+	case proto.EndpointRegistration_DELETE:
+		err = store.Current.DeleteEndpointsForInstance(iid)
+	case proto.EndpointRegistration_HEARTBEAT:
+		if fenced(iid) {
+			return
+		}
+		err = heartbeatEndpoints(HeartbeatRequest{InstanceID: iid})
+	default:
+		if fenced(iid) {
+			return
+		}
+		req := RegisterRequest{InstanceID: iid, NodeID: reg.GetNodeId(), IP: reg.GetIp()}
+		if req.NodeID == "" {
+			req.NodeID = nodeID
+		}
+		for _, e := range reg.GetEndpoints() {
+			req.Endpoints = append(req.Endpoints, EndpointDTO{
+				ServiceName: e.GetServiceName(),
+				Protocol:    e.GetProtocol(),
+				Port:        int(e.GetPort()),
+			})
+		}
+		err = registerEndpoints(req, true)
+	}
+	if err != nil {
+		log.Printf("[AgentChannel] endpoint %s for instance %s failed: %v", reg.GetOp(), iid, err)
+	}
+}
@@ -42,6 +42,10 @@ func handleAssignmentByID(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		if a, ok, _ := store.Current.GetAssignment(id); ok {
+			if a.Fenced() && d == store.DesiredRunning {
+				http.Error(w, "instance superseded by "+a.SupersededBy, http.StatusConflict)
+				return
+			}
 			notify.Publish(a.NodeID)
 		}
 		if err := store.Current.UpdateAssignmentDesired(id, d); err != nil {
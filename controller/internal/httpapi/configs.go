@@ -0,0 +1,237 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/manxisuo/plum/controller/internal/deployment"
+	"github.com/manxisuo/plum/controller/internal/events"
+	"github.com/manxisuo/plum/controller/internal/secrets"
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// 配置集（/v1/configmaps）与密钥（/v1/secrets）：键值数据，由部署条目引用后以环境变量或文件注入实例。
+// 密钥加密存储，读取时只返回键名。更新后引用它的部署记录新修订，按更新策略替换实例。
+
+// maxConfigObjectBytes 单个配置对象请求体上限
+const maxConfigObjectBytes = 1 << 20
+
+var configNameRe = regexp.MustCompile(`^[A-Za-z0-9]([A-Za-z0-9._-]*[A-Za-z0-9])?$`)
+
+type ConfigObjectRequest struct {
+	Name string            `json:"name"` // 仅 POST 使用
+	Data map[string]string `json:"data"`
+	// 期望的 resourceVersion（可选，不匹配返回 409）
+	ResourceVersion int64 `json:"resourceVersion,omitempty"`
+}
+
+type ConfigObjectDTO struct {
+	Name      string            `json:"name"`
+	Data      map[string]string `json:"data,omitempty"` // 密钥不返回值
+	Keys      []string          `json:"keys"`
+	UpdatedAt int64             `json:"updatedAt"`
+	// 当前 resourceVersion
+	ResourceVersion int64 `json:"resourceVersion"`
+	// 引用该对象的部署
+	UsedBy []string `json:"usedBy,omitempty"`
+}
+
+func handleConfigMaps(w http.ResponseWriter, r *http.Request) {
+	handleConfigObjects(w, r, store.KindConfigMap)
+}
+
+func handleConfigMapByName(w http.ResponseWriter, r *http.Request) {
+	handleConfigObjectByName(w, r, store.KindConfigMap, strings.TrimPrefix(r.URL.Path, "/v1/configmaps/"))
+}
+
+func handleSecrets(w http.ResponseWriter, r *http.Request) {
+	handleConfigObjects(w, r, store.KindSecret)
+}
+
+func handleSecretByName(w http.ResponseWriter, r *http.Request) {
+	handleConfigObjectByName(w, r, store.KindSecret, strings.TrimPrefix(r.URL.Path, "/v1/secrets/"))
+}
+
+// GET 列表 / POST 创建（已存在返回 409）
+func handleConfigObjects(w http.ResponseWriter, r *http.Request, kind store.ConfigKind) {
+	switch r.Method {
+	case http.MethodGet:
+		objs, err := store.Current.ListConfigObjects(kind)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		out := make([]ConfigObjectDTO, 0, len(objs))
+		for _, o := range objs {
+			dto, err := configObjectDTO(o, false)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			out = append(out, dto)
+		}
+		writeJSON(w, out)
+	case http.MethodPost:
+		req, ok := decodeConfigObject(w, r)
+		if !ok {
+			return
+		}
+		if !configNameRe.MatchString(req.Name) {
+			http.Error(w, "invalid name", http.StatusBadRequest)
+			return
+		}
+		if _, exists, err := store.Current.GetConfigObject(kind, req.Name); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		} else if exists {
+			http.Error(w, "already exists", http.StatusConflict)
+			return
+		}
+		putConfigObject(w, kind, req.Name, req.Data, 0, false)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// GET / PUT（创建或更新）/ DELETE（仍被部署引用时返回 409）
+func handleConfigObjectByName(w http.ResponseWriter, r *http.Request, kind store.ConfigKind, name string) {
+	if !configNameRe.MatchString(name) {
+		http.Error(w, "invalid name", http.StatusBadRequest)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		o, ok, err := store.Current.GetConfigObject(kind, name)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		dto, err := configObjectDTO(o, true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		setETag(w, o.ResourceVersion)
+		writeJSON(w, dto)
+	case http.MethodPut:
+		req, ok := decodeConfigObject(w, r)
+		if !ok {
+			return
+		}
+		ifMatch, fromHeader, err := expectedVersion(r, req.ResourceVersion, configVersion(kind, name))
+		if err != nil {
+			writeIfMatchError(w, err)
+			return
+		}
+		putConfigObject(w, kind, name, req.Data, ifMatch, fromHeader)
+	case http.MethodDelete:
+		ifMatch, fromHeader, err := expectedVersion(r, 0, configVersion(kind, name))
+		if err != nil {
+			writeIfMatchError(w, err)
+			return
+		}
+		deps, err := deployment.ConfigReferences(kind, name)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if len(deps) > 0 {
+			http.Error(w, "in use by deployment "+deps[0].DeploymentID, http.StatusConflict)
+			return
+		}
+		if err := store.Current.DeleteConfigObject(kind, name, ifMatch); err != nil {
+			writeVersionError(w, err, fromHeader)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func decodeConfigObject(w http.ResponseWriter, r *http.Request) (ConfigObjectRequest, bool) {
+	var req ConfigObjectRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxConfigObjectBytes)).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return req, false
+	}
+	for k := range req.Data {
+		if !deployment.ValidConfigKey(k) {
+			http.Error(w, "invalid key "+k, http.StatusBadRequest)
+			return req, false
+		}
+	}
+	return req, true
+}
+
+// putConfigObject 保存配置对象（密钥加密），并让引用它的部署替换实例
+func putConfigObject(w http.ResponseWriter, kind store.ConfigKind, name string, data map[string]string, ifMatch int64, fromHeader bool) {
+	if data == nil {
+		data = map[string]string{}
+	}
+	raw, _ := json.Marshal(data)
+	if kind == store.KindSecret {
+		enc, err := secrets.Encrypt(raw)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		raw = enc
+	}
+	rv, err := store.Current.PutConfigObject(store.ConfigObject{Kind: kind, Name: name, Data: raw}, ifMatch)
+	if err != nil {
+		writeVersionError(w, err, fromHeader)
+		return
+	}
+	updated, err := deployment.RefreshConfig(kind, name)
+	for _, id := range updated {
+		events.Record(store.Event{
+			Type:         events.ConfigChanged,
+			Reason:       string(kind),
+			DeploymentID: id,
+			Message:      string(kind) + " " + name + " updated, replacing instances",
+		})
+		_ = deployment.Reconcile(id)
+	}
+	setETag(w, rv)
+	resp := map[string]any{
+		"name":            name,
+		"resourceVersion": rv,
+	}
+	if len(updated) > 0 {
+		resp["rollouts"] = updated
+	}
+	if err != nil {
+		resp["warning"] = err.Error()
+	}
+	writeJSON(w, resp)
+}
+
+func configObjectDTO(o store.ConfigObject, withUsage bool) (ConfigObjectDTO, error) {
+	data, _, _, err := deployment.LoadConfigData(o.Kind, o.Name)
+	if err != nil {
+		return ConfigObjectDTO{}, err
+	}
+	dto := ConfigObjectDTO{Name: o.Name, Keys: make([]string, 0, len(data)), UpdatedAt: o.UpdatedAt, ResourceVersion: o.ResourceVersion}
+	for k := range data {
+		dto.Keys = append(dto.Keys, k)
+	}
+	sort.Strings(dto.Keys)
+	if o.Kind == store.KindConfigMap {
+		dto.Data = data
+	}
+	if withUsage {
+		deps, _ := deployment.ConfigReferences(o.Kind, o.Name)
+		for _, d := range deps {
+			dto.UsedBy = append(dto.UsedBy, d.DeploymentID)
+		}
+	}
+	return dto, nil
+}
@@ -6,7 +6,8 @@ func withCORS(h http.HandlerFunc) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
         w.Header().Set("Access-Control-Allow-Origin", "*")
         w.Header().Set("Access-Control-Allow-Methods", "GET,POST,PUT,PATCH,DELETE,OPTIONS")
-        w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, If-Match")
+        w.Header().Set("Access-Control-Expose-Headers", "X-Next-Cursor, ETag")
         if r.Method == http.MethodOptions {
             w.WriteHeader(http.StatusNoContent)
             return
@@ -66,7 +66,11 @@ func handleDAGWorkflowByID(w http.ResponseWriter, r *http.Request) {
 
 // 列出所有DAG工作流
 func handleListDAGWorkflows(w http.ResponseWriter, r *http.Request) {
-	dags, err := store.Current.ListWorkflowDAGs()
+	sel, ok := parseLabelSelector(w, r)
+	if !ok {
+		return
+	}
+	dags, err := store.Current.ListWorkflowDAGsBySelector(sel)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
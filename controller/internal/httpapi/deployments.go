@@ -2,10 +2,14 @@ package httpapi
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strings"
 
+	"github.com/manxisuo/plum/controller/internal/deployment"
 	"github.com/manxisuo/plum/controller/internal/notify"
+	"github.com/manxisuo/plum/controller/internal/scheduler"
 	"github.com/manxisuo/plum/controller/internal/store"
 )
 
@@ -13,8 +17,11 @@ type DeploymentDTO struct {
 	DeploymentID string            `json:"deploymentId"`
 	Name         string            `json:"name"`
 	Labels       map[string]string `json:"labels"`
-	Status       string            `json:"status"` // Stopped | Running
+	Status       string            `json:"status"` // Stopped | Running，任务结束后为 Succeeded | Failed
+	Kind         string            `json:"kind"`   // Service | Job | DaemonSet
 	Instances    int               `json:"instances"`
+	// 当前 resourceVersion
+	ResourceVersion int64 `json:"resourceVersion"`
 }
 
 func handleDeployments(w http.ResponseWriter, r *http.Request) {
@@ -33,7 +40,11 @@ func handleListDeployments(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	deployments, err := store.Current.ListDeployments()
+	sel, ok := parseLabelSelector(w, r)
+	if !ok {
+		return
+	}
+	deployments, err := store.Current.ListDeploymentsBySelector(sel)
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
@@ -42,11 +53,13 @@ func handleListDeployments(w http.ResponseWriter, r *http.Request) {
 	for _, t := range deployments {
 		assigns, _ := store.Current.ListAssignmentsForDeployment(t.DeploymentID)
 		out = append(out, DeploymentDTO{
-			DeploymentID: t.DeploymentID,
-			Name:         t.Name,
-			Labels:       t.Labels,
-			Status:       string(t.Status),
-			Instances:    len(assigns),
+			DeploymentID:    t.DeploymentID,
+			Name:            t.Name,
+			Labels:          t.Labels,
+			Status:          string(t.Status),
+			Kind:            string(t.Kind),
+			Instances:       len(assigns),
+			ResourceVersion: t.ResourceVersion,
 		})
 	}
 	writeJSON(w, out)
@@ -59,14 +72,33 @@ func handleDeploymentByID(w http.ResponseWriter, r *http.Request) {
 		http.NotFound(w, r)
 		return
 	}
+	// 子资源：/v1/deployments/{id}/history、/v1/deployments/{id}/rollback
+	if i := strings.Index(id, "/"); i >= 0 {
+		sub := id[i+1:]
+		id = id[:i]
+		switch sub {
+		case "history":
+			handleDeploymentHistory(w, r, id)
+		case "rollback":
+			handleDeploymentRollback(w, r, id)
+		default:
+			http.NotFound(w, r)
+		}
+		return
+	}
 
-	// 处理action参数（启动/停止部署）
+	// 处理action参数（启动/停止部署、暂停/恢复发布）
 	action := r.URL.Query().Get("action")
-	if action == "start" || action == "stop" {
+	switch action {
+	case "start", "stop", "pause", "resume":
 		if r.Method != http.MethodPost {
 			http.Error(w, "method not allowed, use POST", http.StatusMethodNotAllowed)
 			return
 		}
+		if action == "pause" || action == "resume" {
+			handleDeploymentPause(w, r, id, action == "pause")
+			return
+		}
 		handleDeploymentAction(w, r, id, action)
 		return
 	}
@@ -92,6 +124,19 @@ func handleDeploymentByID(w http.ResponseWriter, r *http.Request) {
 				"appName":      a.AppName,
 				"appVersion":   a.AppVersion,
 			}
+			if a.SupersededBy != "" {
+				item["supersededBy"] = a.SupersededBy
+			}
+			if st, ok, _ := store.Current.LatestStatus(a.InstanceID); ok {
+				item["phase"] = st.Phase // 含 CrashLoopBackOff（崩溃后等待退避重启）
+				item["restartCount"] = st.RestartCount
+				if st.Reason != "" {
+					item["reason"] = st.Reason // 如 OOMKilled
+				}
+				if st.Message != "" {
+					item["message"] = st.Message // 如失败钩子的输出
+				}
+			}
 			
 			// 获取 artifact 信息（类型、镜像信息等）
 			var artifact store.Artifact
@@ -131,17 +176,83 @@ func handleDeploymentByID(w http.ResponseWriter, r *http.Request) {
 			}
 			assignmentsWithArtifact = append(assignmentsWithArtifact, item)
 		}
-		writeJSON(w, map[string]any{"deployment": t, "assignments": assignmentsWithArtifact})
-	case http.MethodPatch:
-		var body struct {
-			Name   string            `json:"name"`
-			Labels map[string]string `json:"labels"`
+		rollout, _ := deployment.Status(t)
+		resp := map[string]any{"deployment": t, "assignments": assignmentsWithArtifact, "rollout": rollout}
+		if job, ok, _ := deployment.JobStatusOf(t); ok {
+			resp["job"] = job
 		}
-		if err := jsonNewDecoder(w, r, &body); err != nil {
+		setETag(w, t.ResourceVersion)
+		writeJSON(w, resp)
+	case http.MethodPatch, http.MethodPut:
+		handleUpdateDeployment(w, r, id)
+	case http.MethodDelete:
+		ifMatch, fromHeader, err := expectedVersion(r, 0, deploymentVersion(id))
+		if err != nil {
+			writeIfMatchError(w, err)
+			return
+		}
+		// 级联删除并通知所有涉及的节点，让Agent立即停止进程（版本校验与删除在同一事务内）
+		if err := deployment.Delete(id, ifMatch); err != nil {
+			writeVersionError(w, err, fromHeader)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// UpdateDeploymentRequest 原地更新部署。
+// PUT 需提供完整规格（entries 或 artifactUrl+replicas），标签整体替换；
+// PATCH 只修改提供的字段，replicas/artifactUrl 简写仅适用于单条目部署。
+// 规格变化时记录新修订，由发布控制器按更新策略逐步替换实例。
+type UpdateDeploymentRequest struct {
+	Name            string               `json:"name"`
+	Labels          map[string]string    `json:"labels"`
+	Entries         []deployment.Entry   `json:"entries"`
+	Replicas        map[string]int       `json:"replicas"`
+	StartCmd        *string              `json:"startCmd"`
+	ArtifactURL     string               `json:"artifactUrl"`
+	ArtifactVersion string               `json:"artifactVersion"` // 切换到同一应用的其他版本
+	Placement       *scheduler.Placement `json:"placement"`       // 改为自动调度（与 replicas 互斥）
+	Probes          *deployment.Probes   `json:"probes"`          // 单条目简写：替换存活/就绪探针
+	Limits          *deployment.Limits   `json:"limits"`          // 单条目简写：替换资源限制
+	Hooks           *deployment.Hooks    `json:"hooks"`           // 单条目简写：替换生命周期钩子
+	Strategy        *UpdateStrategyDTO   `json:"strategy"`
+	ChangeCause     string               `json:"changeCause"` // 记录在修订历史中
+	ResourceVersion int64                `json:"resourceVersion,omitempty"`
+	// RestartPolicy Always | OnFailure | Never（不重启运行中的实例，下次退出时生效）
+	RestartPolicy string `json:"restartPolicy"`
+	// TerminationGracePeriodSec 停止实例的宽限期（秒，下次停止时生效）
+	TerminationGracePeriodSec *int `json:"terminationGracePeriodSec"`
+	// Job 任务参数（仅任务型部署，只修改提供的字段；规格变化只影响之后创建的实例）
+	Job *deployment.JobSpec `json:"job"`
+}
+
+func handleUpdateDeployment(w http.ResponseWriter, r *http.Request, id string) {
+	var body UpdateDeploymentRequest
+	if err := jsonNewDecoder(w, r, &body); err != nil {
+		return
+	}
+	ifMatch, fromHeader, err := expectedVersion(r, body.ResourceVersion, deploymentVersion(id))
+	if err != nil {
+		writeIfMatchError(w, err)
+		return
+	}
+	cause := body.ChangeCause
+	if cause == "" {
+		cause = "update"
+	}
+	// 修订与部署在同一事务内按版本条件写入；未指定版本时以读取到的版本为准，
+	// 与后台写入（发布进度、任务计数等）冲突时重新读取后重试
+	var t store.Deployment
+	for attempt := 0; ; attempt++ {
+		var ok bool
+		t, ok, err = store.Current.GetDeployment(id)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
 			return
 		}
-		// simple: get and recreate name/labels in store deployment (no dedicated update yet)
-		t, ok, _ := store.Current.GetDeployment(id)
 		if !ok {
 			http.NotFound(w, r)
 			return
@@ -151,47 +262,280 @@ func handleDeploymentByID(w http.ResponseWriter, r *http.Request) {
 			http.Error(w, "deployment name is immutable", http.StatusBadRequest)
 			return
 		}
-		if body.Labels != nil {
-			t.Labels = body.Labels
-			// emulate labels update via delete+insert (keeping same name)
-			_ = store.Current.DeleteDeployment(id)
-			newID, _, err := store.Current.CreateDeployment(t.Name, t.Labels)
-			if err != nil {
-				http.Error(w, "db error", http.StatusInternalServerError)
-				return
-			}
-			writeJSON(w, map[string]any{"deploymentId": newID})
+		assigns, err := store.Current.ListAssignmentsForDeployment(id)
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
 			return
 		}
-		w.WriteHeader(http.StatusNoContent)
-	case http.MethodDelete:
-		// 级联删除：先删 assignments 与相关 statuses，再删 deployment
-		assigns, _ := store.Current.ListAssignmentsForDeployment(id)
-		// 收集所有涉及的节点ID，用于通知Agent
-		nodeIDs := make(map[string]bool)
-		for _, a := range assigns {
-			nodeIDs[a.NodeID] = true
-			_ = store.Current.DeleteStatusesForInstance(a.InstanceID)
+		spec, err := applyDeploymentUpdate(&t, r.Method == http.MethodPut, body, assigns)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
 		}
-		_ = store.Current.DeleteAssignmentsForDeployment(id)
-		_ = store.Current.DeleteDeployment(id)
-		// 通知所有涉及的节点，让Agent立即停止进程
-		for nodeID := range nodeIDs {
-			notify.Publish(nodeID)
+		if err := deployment.EnsureInitialRevision(&t); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
 		}
-		w.WriteHeader(http.StatusNoContent)
+		expected := ifMatch
+		if expected == 0 {
+			expected = t.ResourceVersion
+		}
+		err = deployment.SaveRevision(&t, spec, cause, expected)
+		if err == nil {
+			break
+		}
+		if ifMatch == 0 && errors.Is(err, store.ErrConflict) && attempt < 2 {
+			continue
+		}
+		writeVersionError(w, err, fromHeader)
+		return
+	}
+	rev, rv := t.Revision, t.ResourceVersion
+	if err := deployment.Reconcile(id); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	t, _, _ = store.Current.GetDeployment(id)
+	rollout, _ := deployment.Status(t)
+	setETag(w, rv)
+	writeJSON(w, map[string]any{
+		"deploymentId":    id,
+		"resourceVersion": rv,
+		"revision":        rev,
+		"rollout":         rollout,
+	})
+}
+
+// applyDeploymentUpdate 把更新请求写入 t（更新策略、重启策略、任务参数、标签等），返回目标规格；错误均为请求错误
+func applyDeploymentUpdate(t *store.Deployment, replace bool, body UpdateDeploymentRequest, assigns []store.Assignment) ([]deployment.Entry, error) {
+	// 以当前生效的规格为基础（保留自动调度约束）
+	spec, err := buildDeploymentSpec(replace, body, deployment.EffectiveSpec(*t, assigns))
+	if err != nil {
+		return nil, err
+	}
+	if err := applyStrategy(t, body.Strategy); err != nil {
+		return nil, err
+	}
+	if err := applyRestartPolicy(t, body.RestartPolicy); err != nil {
+		return nil, err
+	}
+	if err := applyTerminationGrace(t, body.TerminationGracePeriodSec); err != nil {
+		return nil, err
+	}
+	if t.IsJob() {
+		if len(spec) != 1 {
+			return nil, errors.New("job deployment requires exactly one entry")
+		}
+		deployment.DefaultPlacement(spec)
+	}
+	if t.IsDaemonSet() {
+		if err := validateDaemonSpec(spec); err != nil {
+			return nil, err
+		}
+		deployment.DefaultPlacement(spec)
+	}
+	if body.Job != nil {
+		if !t.IsJob() {
+			return nil, errors.New("job is only valid for kind Job")
+		}
+		if err := body.Job.Apply(t); err != nil {
+			return nil, err
+		}
+	}
+	if replace || body.Labels != nil {
+		t.Labels = body.Labels
+	}
+	return spec, nil
+}
+
+// buildDeploymentSpec 根据请求和当前规格计算目标规格
+func buildDeploymentSpec(replace bool, body UpdateDeploymentRequest, current []deployment.Entry) ([]deployment.Entry, error) {
+	spec := current
+	if len(body.Entries) > 0 {
+		spec = body.Entries
+	} else if replace || body.ArtifactURL != "" || body.Replicas != nil || body.Placement != nil || body.Probes != nil || body.Limits != nil || body.Hooks != nil {
+		// 单条目简写
+		if len(current) > 1 {
+			return nil, errors.New("deployment has multiple entries, use entries")
+		}
+		e := deployment.Entry{}
+		if len(current) == 1 {
+			e = current[0]
+		}
+		if body.ArtifactURL != "" {
+			e.ArtifactURL = body.ArtifactURL
+		}
+		if body.Replicas != nil && body.Placement != nil {
+			return nil, errors.New("replicas and placement are mutually exclusive")
+		}
+		if body.Replicas != nil {
+			e.Replicas, e.Placement = body.Replicas, nil
+		}
+		if body.Placement != nil {
+			e.Replicas, e.Placement = nil, body.Placement
+		}
+		if body.Probes != nil || replace {
+			e.Probes = body.Probes
+		}
+		if body.Limits != nil || replace {
+			e.Limits = body.Limits
+		}
+		if body.Hooks != nil || replace {
+			e.Hooks = body.Hooks
+		}
+		spec = []deployment.Entry{e}
+	}
+	if replace && len(body.Entries) == 0 && (body.ArtifactURL == "" || (body.Replicas == nil && body.Placement == nil)) {
+		return nil, errors.New("PUT requires entries or artifactUrl+replicas/placement")
+	}
+	out := make([]deployment.Entry, 0, len(spec))
+	for _, e := range spec {
+		if body.StartCmd != nil && len(body.Entries) == 0 {
+			e.StartCmd = *body.StartCmd
+		}
+		if body.ArtifactVersion != "" {
+			url, ok := deployment.ArtifactURLForVersion(e.ArtifactURL, body.ArtifactVersion)
+			if !ok {
+				return nil, fmt.Errorf("artifact version %s not found for %s", body.ArtifactVersion, e.ArtifactURL)
+			}
+			e.ArtifactURL = url
+		}
+		if e.ArtifactURL == "" {
+			return nil, errors.New("artifactUrl required")
+		}
+		if err := validateEntryReplicas(e.Replicas, e.Placement); err != nil {
+			return nil, err
+		}
+		if e.Probes != nil {
+			if err := e.Probes.Validate(); err != nil {
+				return nil, err
+			}
+		}
+		if e.Limits != nil {
+			if err := e.Limits.Validate(); err != nil {
+				return nil, err
+			}
+		}
+		if e.Hooks != nil {
+			if err := e.Hooks.Validate(); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, e)
+	}
+	if err := deployment.StampConfig(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// validateEntryReplicas 校验条目的副本设置：显式节点副本数或自动调度约束二选一
+func validateEntryReplicas(replicas map[string]int, placement *scheduler.Placement) error {
+	if placement != nil {
+		if len(replicas) > 0 {
+			return errors.New("replicas and placement are mutually exclusive")
+		}
+		return placement.Validate()
+	}
+	for nodeID, n := range replicas {
+		if nodeID == "" || n < 0 {
+			return errors.New("invalid replicas")
+		}
+	}
+	return nil
+}
+
+// applyRestartPolicy 将请求中的重启策略写入部署（为空时保持不变，默认 Always）
+func applyRestartPolicy(t *store.Deployment, p string) error {
+	switch store.RestartPolicy(p) {
+	case "":
+		if t.RestartPolicy == "" {
+			t.RestartPolicy = store.RestartAlways
+		}
+	case store.RestartAlways:
+		if t.IsJob() {
+			return errors.New("job restartPolicy must be OnFailure or Never")
+		}
+		t.RestartPolicy = store.RestartAlways
+	case store.RestartOnFailure, store.RestartNever:
+		t.RestartPolicy = store.RestartPolicy(p)
 	default:
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return fmt.Errorf("invalid restartPolicy %q (Always, OnFailure, Never)", p)
+	}
+	return nil
+}
+
+// applyKind 校验部署类型并写入类型相关的参数（创建时调用，先于 applyStrategy）：
+// 任务只允许一个条目，重启策略默认 Never、不允许 Always；守护型条目不允许 replicas，
+// 滚动更新默认逐个节点替换（maxSurge 0、maxUnavailable 1）
+func applyKind(t *store.Deployment, kind string, job *deployment.JobSpec, spec []deployment.Entry) error {
+	switch store.DeploymentKind(kind) {
+	case "", store.KindService:
+		if job != nil {
+			return errors.New("job is only valid for kind Job")
+		}
+		t.Kind = store.KindService
+		return nil
+	case store.KindDaemonSet:
+		if job != nil {
+			return errors.New("job is only valid for kind Job")
+		}
+		if err := validateDaemonSpec(spec); err != nil {
+			return err
+		}
+		t.Kind = store.KindDaemonSet
+		t.MaxSurge, t.MaxUnavailable = 0, 1
+		return nil
+	case store.KindJob:
+	default:
+		return fmt.Errorf("invalid kind %q (Service, Job, DaemonSet)", kind)
+	}
+	if len(spec) != 1 {
+		return errors.New("job deployment requires exactly one entry")
+	}
+	deployment.NewJob(t)
+	t.RestartPolicy = store.RestartNever
+	if job != nil {
+		return job.Apply(t)
 	}
+	return nil
+}
+
+// validateDaemonSpec 守护型部署的条目由 placement.nodeSelector 选择节点，不允许指定 replicas
+func validateDaemonSpec(spec []deployment.Entry) error {
+	for _, e := range spec {
+		for _, n := range e.Replicas {
+			if n > 0 {
+				return errors.New("daemonset entries use placement.nodeSelector instead of replicas")
+			}
+		}
+	}
+	return nil
+}
+
+// applyTerminationGrace 将请求中的停止宽限期写入部署（未提供时保持不变）
+func applyTerminationGrace(t *store.Deployment, sec *int) error {
+	if sec == nil {
+		return nil
+	}
+	if *sec < 0 || *sec > deployment.MaxTerminationGracePeriodSec {
+		return fmt.Errorf("terminationGracePeriodSec must be between 0 and %d", deployment.MaxTerminationGracePeriodSec)
+	}
+	t.TerminationGracePeriodSec = *sec
+	return nil
 }
 
 // handleDeploymentAction 处理部署的启动/停止操作
 func handleDeploymentAction(w http.ResponseWriter, r *http.Request, id string, action string) {
-	_, ok, _ := store.Current.GetDeployment(id)
+	t, ok, _ := store.Current.GetDeployment(id)
 	if !ok {
 		http.NotFound(w, r)
 		return
 	}
+	if t.Finished() {
+		http.Error(w, "job already "+strings.ToLower(string(t.Status)), http.StatusConflict)
+		return
+	}
 
 	var newStatus store.DeploymentStatus
 	if action == "start" {
@@ -218,12 +562,21 @@ func handleDeploymentAction(w http.ResponseWriter, r *http.Request, id string, a
 		for nodeID := range nodeIDs {
 			notify.Publish(nodeID)
 		}
+	} else if t.IsJob() {
+		// 任务：已结束或随停止而中断的实例保持停止，由任务控制器按 parallelism 创建新实例
+		if err := deployment.Reconcile(id); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
 	} else {
 		// 如果是启动操作，将所有实例的Desired状态设为Running
 		assigns, _ := store.Current.ListAssignmentsForDeployment(id)
 		// 收集所有涉及的节点ID，用于通知Agent
 		nodeIDs := make(map[string]bool)
 		for _, a := range assigns {
+			if a.Fenced() {
+				continue // 已被故障转移接替的实例保持停止
+			}
 			nodeIDs[a.NodeID] = true
 			_ = store.Current.UpdateAssignmentDesired(a.InstanceID, store.DesiredRunning)
 		}
@@ -96,7 +96,11 @@ func handleListEmbeddedWorkers(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	workers, err := store.Current.ListEmbeddedWorkers()
+	sel, ok := parseLabelSelector(w, r)
+	if !ok {
+		return
+	}
+	workers, err := store.Current.ListEmbeddedWorkersBySelector(sel)
 	if err != nil {
 		fmt.Printf("Failed to list embedded workers: %v\n", err)
 		http.Error(w, "list failed", http.StatusInternalServerError)
@@ -0,0 +1,134 @@
+package httpapi
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// 乐观并发控制：resourceVersion 以 ETag 形式返回，写操作通过 If-Match 头
+// 或请求体中的 resourceVersion 字段携带期望版本。
+// If-Match 不匹配返回 412（If-Match: * 要求资源存在，可列出多个 ETag），请求体版本不匹配返回 409。
+
+func setETag(w http.ResponseWriter, rv int64) {
+	if rv > 0 {
+		w.Header().Set("ETag", `"`+strconv.FormatInt(rv, 10)+`"`)
+	}
+}
+
+var (
+	errInvalidIfMatch     = errors.New("invalid If-Match header")
+	errPreconditionFailed = errors.New("precondition failed: resourceVersion mismatch")
+)
+
+// versionLookup 读取资源当前的 resourceVersion，资源不存在时返回 false
+type versionLookup func() (int64, bool, error)
+
+// expectedVersion 返回期望的 resourceVersion（0 表示不做校验）以及是否来自 If-Match 头。
+// If-Match: * 要求资源存在；多个 ETag 时与当前版本比较，匹配则以当前版本作为写入条件
+// （写入前被并发修改仍返回 412）。前置条件不满足时返回 errPreconditionFailed
+func expectedVersion(r *http.Request, bodyVersion int64, current versionLookup) (int64, bool, error) {
+	h := strings.TrimSpace(r.Header.Get("If-Match"))
+	if h == "" {
+		return bodyVersion, false, nil
+	}
+	var want []int64
+	if h != "*" {
+		for _, tag := range strings.Split(h, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			rv, err := strconv.ParseInt(strings.Trim(tag, `"`), 10, 64)
+			if err != nil || rv <= 0 {
+				return 0, true, errInvalidIfMatch
+			}
+			want = append(want, rv)
+		}
+		if len(want) == 1 {
+			return want[0], true, nil
+		}
+	}
+	rv, ok, err := current()
+	if err != nil {
+		return 0, true, err
+	}
+	if !ok || (want != nil && !slices.Contains(want, rv)) {
+		return 0, true, errPreconditionFailed
+	}
+	return rv, true, nil
+}
+
+// writeIfMatchError 将 expectedVersion 的错误映射为 400 / 412，其它错误返回 500
+func writeIfMatchError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errInvalidIfMatch):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, errPreconditionFailed):
+		http.Error(w, err.Error(), http.StatusPreconditionFailed)
+	default:
+		http.Error(w, "db error", http.StatusInternalServerError)
+	}
+}
+
+// deploymentVersion 部署的当前版本
+func deploymentVersion(id string) versionLookup {
+	return func() (int64, bool, error) {
+		d, ok, err := store.Current.GetDeployment(id)
+		return d.ResourceVersion, ok, err
+	}
+}
+
+// taskDefVersion 任务定义的当前版本
+func taskDefVersion(id string) versionLookup {
+	return func() (int64, bool, error) {
+		td, ok, err := store.Current.GetTaskDef(id)
+		return td.ResourceVersion, ok, err
+	}
+}
+
+// kvVersion 键的当前版本
+func kvVersion(namespace, key string) versionLookup {
+	return func() (int64, bool, error) {
+		kv, ok, err := store.Current.GetKV(namespace, key)
+		return kv.ResourceVersion, ok, err
+	}
+}
+
+// configVersion 配置集 / 密钥的当前版本
+func configVersion(kind store.ConfigKind, name string) versionLookup {
+	return func() (int64, bool, error) {
+		o, ok, err := store.Current.GetConfigObject(kind, name)
+		return o.ResourceVersion, ok, err
+	}
+}
+
+// endpointVersion 服务端点（按主键，含不健康的端点）的当前版本
+func endpointVersion(serviceName, instanceID, ip string, port int, protocol string) versionLookup {
+	return func() (int64, bool, error) {
+		eps, err := store.Current.ListAllEndpointsByService(serviceName)
+		if err != nil {
+			return 0, false, err
+		}
+		for _, ep := range eps {
+			if ep.InstanceID == instanceID && ep.IP == ip && ep.Port == port && ep.Protocol == protocol {
+				return ep.ResourceVersion, true, nil
+			}
+		}
+		return 0, false, nil
+	}
+}
+
+// writeVersionError 将版本冲突映射为 412/409，其它错误返回 500
+func writeVersionError(w http.ResponseWriter, err error, fromHeader bool) {
+	if !errors.Is(err, store.ErrConflict) {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if fromHeader {
+		http.Error(w, "precondition failed: resourceVersion mismatch", http.StatusPreconditionFailed)
+		return
+	}
+	http.Error(w, "conflict: resourceVersion mismatch", http.StatusConflict)
+}
@@ -0,0 +1,183 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/manxisuo/plum/controller/internal/notify"
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// EventDTO 集群事件
+type EventDTO struct {
+	ID            int64  `json:"id"`
+	Time          int64  `json:"time"` // unix 秒
+	Type          string `json:"type"`
+	Reason        string `json:"reason,omitempty"`
+	NodeID        string `json:"nodeId,omitempty"`
+	DeploymentID  string `json:"deploymentId,omitempty"`
+	InstanceID    string `json:"instanceId,omitempty"`
+	NewInstanceID string `json:"newInstanceId,omitempty"`
+	FromNode      string `json:"fromNode,omitempty"`
+	ToNode        string `json:"toNode,omitempty"`
+	DurationMs    int64  `json:"durationMs,omitempty"`
+	Message       string `json:"message,omitempty"`
+}
+
+func toEventDTO(e store.Event) EventDTO {
+	return EventDTO{
+		ID:            e.ID,
+		Time:          e.Time,
+		Type:          e.Type,
+		Reason:        e.Reason,
+		NodeID:        e.NodeID,
+		DeploymentID:  e.DeploymentID,
+		InstanceID:    e.InstanceID,
+		NewInstanceID: e.NewInstanceID,
+		FromNode:      e.FromNode,
+		ToNode:        e.ToNode,
+		DurationMs:    e.DurationMs,
+		Message:       e.Message,
+	}
+}
+
+// parseEventQuery 解析事件过滤参数：
+// type(逗号分隔)、nodeId、deploymentId、instanceId、since/until(unix 秒)、afterId、beforeId、order(asc|desc)、limit
+func parseEventQuery(r *http.Request) (store.EventQuery, error) {
+	v := r.URL.Query()
+	q := store.EventQuery{
+		NodeID:       v.Get("nodeId"),
+		DeploymentID: v.Get("deploymentId"),
+		InstanceID:   v.Get("instanceId"),
+		Limit:        100,
+	}
+	if s := v.Get("type"); s != "" {
+		for _, t := range strings.Split(s, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				q.Types = append(q.Types, t)
+			}
+		}
+	}
+	for name, dst := range map[string]*int64{"since": &q.Since, "until": &q.Until, "afterId": &q.AfterID, "beforeId": &q.BeforeID} {
+		if s := v.Get(name); s != "" {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || n < 0 {
+				return q, fmt.Errorf("invalid %s", name)
+			}
+			*dst = n
+		}
+	}
+	switch strings.ToLower(v.Get("order")) {
+	case "", "desc":
+	case "asc":
+		q.Ascending = true
+	default:
+		return q, fmt.Errorf("invalid order")
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 || n > 1000 {
+			return q, fmt.Errorf("invalid limit (1-1000)")
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
+
+// GET /v1/events
+func handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	q, err := parseEventQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	list, err := store.Current.QueryEvents(q)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]EventDTO, 0, len(list))
+	for _, e := range list {
+		out = append(out, toEventDTO(e))
+	}
+	writeJSON(w, out)
+}
+
+// GET /v1/events/stream：按相同的过滤条件推送新事件（SSE）。
+// 支持 Last-Event-ID 头或 afterId 参数从指定事件之后继续。
+func handleEventsSSE(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "stream unsupported", http.StatusInternalServerError)
+		return
+	}
+	q, err := parseEventQuery(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if id := r.Header.Get("Last-Event-ID"); id != "" {
+		if n, err := strconv.ParseInt(id, 10, 64); err == nil {
+			q.AfterID = n
+		}
+	}
+	q.Ascending = true
+	q.BeforeID = 0
+	if q.AfterID == 0 {
+		// 未指定起点时只推送订阅之后的新事件
+		latest, err := store.Current.QueryEvents(store.EventQuery{Limit: 1})
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if len(latest) > 0 {
+			q.AfterID = latest[0].ID
+		}
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	ch, cancel := notify.SubscribeEvents()
+	defer cancel()
+	// initial ping
+	_, _ = w.Write([]byte("event: ping\n"))
+	_, _ = w.Write([]byte("data: init\n\n"))
+	flusher.Flush()
+	for {
+		// 订阅后先补发一次，避免遗漏订阅前后写入的事件
+		for {
+			list, err := store.Current.QueryEvents(q)
+			if err != nil {
+				return
+			}
+			for _, e := range list {
+				data, _ := json.Marshal(toEventDTO(e))
+				_, _ = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+				q.AfterID = e.ID
+			}
+			flusher.Flush()
+			if len(list) < q.Limit {
+				break
+			}
+		}
+		select {
+		case _, ok := <-ch:
+			if !ok {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -2,32 +2,43 @@ package httpapi
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/manxisuo/plum/controller/internal/deployment"
+	"github.com/manxisuo/plum/controller/internal/events"
 	"github.com/manxisuo/plum/controller/internal/failover"
 	"github.com/manxisuo/plum/controller/internal/notify"
+	"github.com/manxisuo/plum/controller/internal/scheduler"
 	"github.com/manxisuo/plum/controller/internal/store"
 )
 
 type NodeHello struct {
-	NodeID string            `json:"nodeId"`
-	IP     string            `json:"ip"`
-	Labels map[string]string `json:"labels"`
+	NodeID    string               `json:"nodeId"`
+	IP        string               `json:"ip"`
+	Labels    map[string]string    `json:"labels"`
+	Resources *store.NodeResources `json:"resources,omitempty"` // CPU / 内存 / 磁盘 / 实例数等
+	APIPort   int                  `json:"apiPort,omitempty"`   // Agent 本地 HTTP 接口端口
 }
 
 type NodeDTO struct {
-	NodeID   string            `json:"nodeId"`
-	IP       string            `json:"ip"`
-	Labels   map[string]string `json:"labels"`
-	LastSeen int64             `json:"lastSeen"`
+	NodeID    string               `json:"nodeId"`
+	IP        string               `json:"ip"`
+	Labels    map[string]string    `json:"labels"`
+	LastSeen  int64                `json:"lastSeen"`
+	Resources *store.NodeResources `json:"resources,omitempty"`
+	Status    string               `json:"status"`    // Schedulable | Cordoned | Draining
+	Instances int                  `json:"instances"` // 节点上的实例数（不含已被故障转移隔离的实例）
+	APIPort   int                  `json:"apiPort,omitempty"`
 }
 
 type LeaseAck struct {
-	TTLSec int64 `json:"ttlSec"`
+	TTLSec int64    `json:"ttlSec"`
+	Fenced []string `json:"fenced,omitempty"` // 已被故障转移接替、Agent 须立即停止的实例
 }
 
 type Assignment struct {
@@ -44,7 +55,24 @@ type Assignment struct {
 	PortMappings    string `json:"portMappings,omitempty"` // JSON string
 	Phase           string `json:"phase"`
 	Healthy         bool   `json:"healthy"`
+	Ready           bool   `json:"ready"` // 就绪探针已通过（未配置就绪探针时与 healthy 相同）
 	LastReport      int64  `json:"lastReportAt"`
+	RestartCount    int    `json:"restartCount"`
+	RestartPolicy   string `json:"restartPolicy,omitempty"` // Always | OnFailure | Never
+
+	Probes *deployment.Probes `json:"probes,omitempty"` // 部署规格中的探针，Agent 与 meta.ini 中的探针合并
+	Limits *deployment.Limits `json:"limits,omitempty"` // 资源限制（CPU / 内存 / 进程数 / IO）
+	Hooks  *deployment.Hooks  `json:"hooks,omitempty"`  // 生命周期钩子（未配置的钩子使用制品中的同名脚本）
+	// TerminationGracePeriodSec 停止实例时 preStop 钩子与优雅退出的总时限（0 使用 Agent 默认值）
+	TerminationGracePeriodSec int `json:"terminationGracePeriodSec,omitempty"`
+
+	ArtifactSHA256 string `json:"artifactSha256,omitempty"` // ZIP 制品的 SHA256，Agent 下载后校验
+	ArtifactSize   int64  `json:"artifactSize,omitempty"`
+
+	// 注入实例的环境变量和配置文件（来自部署条目的 env、configMaps 和 secrets）
+	Env         map[string]string       `json:"env,omitempty"`
+	ConfigFiles []deployment.ConfigFile `json:"configFiles,omitempty"`
+	ConfigHash  string                  `json:"configHash,omitempty"`
 }
 
 type Assignments struct {
@@ -55,23 +83,52 @@ type StatusUpdate struct {
 	InstanceID string `json:"instanceId"`
 	Phase      string `json:"phase"`
 	ExitCode   int32  `json:"exitCode"`
-	Healthy    bool   `json:"healthy"`
+	Healthy    bool   `json:"healthy"`         // 存活
+	Ready      *bool  `json:"ready,omitempty"` // 就绪（旧版 Agent 不上报，视为与 healthy 相同）
 	TsUnix     int64  `json:"tsUnix"`
+	// RestartCount Agent 重启该实例的累计次数
+	RestartCount int `json:"restartCount"`
+	// Reason 退出原因：OOMKilled | Error | Completed | PreStartHookFailed | PostStartHookFailed | PortConflict
+	Reason string `json:"reason,omitempty"`
+	// Message 原因的补充说明（如失败钩子的输出末尾）
+	Message string `json:"message,omitempty"`
 }
 
 type CreateDeploymentRequest struct {
-	Name     string                  `json:"name"`
-	Artifact string                  `json:"artifactUrl"` // legacy 单条
-	StartCmd string                  `json:"startCmd"`    // legacy 单条
-	Replicas map[string]int          `json:"replicas"`    // legacy: nodeId -> replica count
-	Labels   map[string]string       `json:"labels"`
-	Entries  []CreateDeploymentEntry `json:"entries"` // 新：多条目
+	Name      string                  `json:"name"`
+	Artifact  string                  `json:"artifactUrl"` // legacy 单条
+	StartCmd  string                  `json:"startCmd"`    // legacy 单条
+	Replicas  map[string]int          `json:"replicas"`    // legacy: nodeId -> replica count
+	Placement *scheduler.Placement    `json:"placement"`   // legacy 单条：自动调度（代替 replicas）
+	Probes    *deployment.Probes      `json:"probes"`      // legacy 单条：存活/就绪探针
+	Limits    *deployment.Limits      `json:"limits"`      // legacy 单条：资源限制
+	Hooks     *deployment.Hooks       `json:"hooks"`       // legacy 单条：生命周期钩子
+	Labels    map[string]string       `json:"labels"`
+	Entries   []CreateDeploymentEntry `json:"entries"`  // 新：多条目
+	Strategy  *UpdateStrategyDTO      `json:"strategy"` // 更新策略，默认 rolling（maxSurge=1, maxUnavailable=0）
+	// RestartPolicy 实例退出后的重启策略：Always（默认）| OnFailure | Never
+	RestartPolicy string `json:"restartPolicy"`
+	// TerminationGracePeriodSec 停止实例时 preStop 钩子与优雅退出的总时限（秒，0 使用 Agent 默认值）
+	TerminationGracePeriodSec *int `json:"terminationGracePeriodSec"`
+	// Kind Service（默认）| Job | DaemonSet：任务型部署的实例运行至完成，只允许一个条目，重启策略默认 Never；
+	// 守护型部署在每个匹配条目 placement.nodeSelector 的节点上运行一个实例，条目不允许 replicas
+	Kind string              `json:"kind"`
+	Job  *deployment.JobSpec `json:"job"` // 任务参数：completions、parallelism、backoffLimit、ttlSecondsAfterFinished
 }
 
 type CreateDeploymentEntry struct {
-	Artifact string         `json:"artifactUrl"`
-	StartCmd string         `json:"startCmd"`
-	Replicas map[string]int `json:"replicas"` // nodeId -> replica
+	Artifact  string               `json:"artifactUrl"`
+	StartCmd  string               `json:"startCmd"`
+	Replicas  map[string]int       `json:"replicas"`  // nodeId -> replica
+	Placement *scheduler.Placement `json:"placement"` // 自动调度：总副本数 + 约束，由控制器选择节点
+	Probes    *deployment.Probes   `json:"probes"`    // 存活/就绪探针（由 Agent 执行）
+	Limits    *deployment.Limits   `json:"limits"`    // 资源限制（CPU / 内存 / 进程数 / IO）
+	Hooks     *deployment.Hooks    `json:"hooks"`     // 生命周期钩子 preStart / postStart / preStop
+
+	// 环境变量及引用的配置集 / 密钥（无 mountPath 时作为环境变量注入）
+	Env        map[string]string         `json:"env"`
+	ConfigMaps []deployment.ConfigSource `json:"configMaps"`
+	Secrets    []deployment.ConfigSource `json:"secrets"`
 }
 
 func handleHealthz(w http.ResponseWriter, r *http.Request) {
@@ -89,30 +146,45 @@ func handleHeartbeat(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
+	writeJSON(w, recordHeartbeat(hello))
+}
+
+// recordHeartbeat 记录节点心跳，返回租约和需要隔离的实例（HTTP 心跳与 Agent 控制通道共用）
+func recordHeartbeat(hello NodeHello) LeaseAck {
 	now := time.Now()
 	_ = store.Current.UpsertNode(hello.NodeID, store.Node{
-		NodeID:   hello.NodeID,
-		IP:       hello.IP,
-		Labels:   hello.Labels,
-		LastSeen: now,
+		NodeID:    hello.NodeID,
+		IP:        hello.IP,
+		Labels:    hello.Labels,
+		LastSeen:  now,
+		Resources: hello.Resources,
+		APIPort:   hello.APIPort,
 	})
+	fencedIDs, _ := store.Current.ListSupersededForNode(hello.NodeID)
 	// For walking skeleton, fixed TTL
-	writeJSON(w, LeaseAck{TTLSec: 15})
+	return LeaseAck{TTLSec: 15, Fenced: fencedIDs}
 }
 
 func handleNodes(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		nodes, _ := store.Current.ListNodes()
+		sel, ok := parseLabelSelector(w, r)
+		if !ok {
+			return
+		}
+		nodes, _ := store.Current.ListNodesBySelector(sel)
 		health := failover.ComputeHealth()
 		out := make([]map[string]any, 0, len(nodes))
 		for _, n := range nodes {
 			out = append(out, map[string]any{
-				"nodeId":   n.NodeID,
-				"ip":       n.IP,
-				"labels":   n.Labels,
-				"lastSeen": n.LastSeen.Unix(),
-				"health":   string(health[n.NodeID]),
+				"nodeId":    n.NodeID,
+				"ip":        n.IP,
+				"labels":    n.Labels,
+				"lastSeen":  n.LastSeen.Unix(),
+				"health":    string(health[n.NodeID]),
+				"resources": n.Resources,
+				"status":    nodeStatus(n),
+				"apiPort":   n.APIPort,
 			})
 		}
 		writeJSON(w, out)
@@ -122,12 +194,20 @@ func handleNodes(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleNodeByID(w http.ResponseWriter, r *http.Request) {
-	// path: /v1/nodes/{id}
+	// path: /v1/nodes/{id}、/v1/nodes/{id}/cordon|uncordon|drain、/v1/nodes/{id}/instances
 	id := r.URL.Path[len("/v1/nodes/"):]
 	if id == "" {
 		http.NotFound(w, r)
 		return
 	}
+	if i := strings.Index(id, "/"); i >= 0 {
+		if id[i+1:] == "instances" {
+			handleNodeInstances(w, r, id[:i])
+			return
+		}
+		handleNodeOperation(w, r, id[:i], id[i+1:])
+		return
+	}
 	switch r.Method {
 	case http.MethodGet:
 		n, ok, _ := store.Current.GetNode(id)
@@ -135,13 +215,19 @@ func handleNodeByID(w http.ResponseWriter, r *http.Request) {
 			http.NotFound(w, r)
 			return
 		}
-		writeJSON(w, NodeDTO{NodeID: n.NodeID, IP: n.IP, Labels: n.Labels, LastSeen: n.LastSeen.Unix()})
+		writeJSON(w, NodeDTO{NodeID: n.NodeID, IP: n.IP, Labels: n.Labels, LastSeen: n.LastSeen.Unix(), Resources: n.Resources, Status: nodeStatus(n), Instances: nodeInstances(n.NodeID), APIPort: n.APIPort})
 	case http.MethodDelete:
-		// 若有 assignments 引用该节点，拒绝删除
-		if n, _ := store.Current.CountAssignmentsForNode(id); n > 0 {
+		// 若有 assignments 引用该节点，拒绝删除（已被故障转移隔离的实例除外，随节点一并清理）
+		fencedIDs, _ := store.Current.ListSupersededForNode(id)
+		if n, _ := store.Current.CountAssignmentsForNode(id); n > len(fencedIDs) {
 			http.Error(w, "node in use", http.StatusConflict)
 			return
 		}
+		for _, iid := range fencedIDs {
+			_ = store.Current.DeleteEndpointsForInstance(iid)
+			_ = store.Current.DeleteStatusesForInstance(iid)
+			_ = store.Current.DeleteAssignment(iid)
+		}
 		_ = store.Current.DeleteNode(id)
 		w.WriteHeader(http.StatusNoContent)
 	default:
@@ -166,7 +252,13 @@ func handleGetAssignments(w http.ResponseWriter, r *http.Request) {
 			assigns = assigns[:n]
 		}
 	}
-	res := Assignments{Items: make([]Assignment, 0, len(assigns))}
+	writeJSON(w, Assignments{Items: assignmentDTOs(assigns)})
+}
+
+// assignmentDTOs 补充部署规格和制品信息，生成下发给 Agent 的分配（HTTP 与 Agent 控制通道共用）
+func assignmentDTOs(assigns []store.Assignment) []Assignment {
+	items := make([]Assignment, 0, len(assigns))
+	deps := map[string]store.Deployment{}
 	for _, a := range assigns {
 		st, ok, _ := store.Current.LatestStatus(a.InstanceID)
 		item := Assignment{
@@ -178,6 +270,24 @@ func handleGetAssignments(w http.ResponseWriter, r *http.Request) {
 			AppName:      a.AppName,
 			AppVersion:   a.AppVersion,
 		}
+		if e, ok := deployment.EntryFor(a.DeploymentID, a.ArtifactURL, a.StartCmd, a.ConfigHash); ok {
+			item.Probes, item.Limits, item.Hooks = e.Probes, e.Limits, e.Hooks
+			// 配置按实例创建时的摘要取快照下发：配置更新后旧实例保持原内容，由发布替换
+			env, files, ok, err := deployment.InstanceConfig(e, a.ConfigHash)
+			if err != nil {
+				log.Printf("resolve config for instance %s: %v", a.InstanceID, err)
+			}
+			if ok {
+				item.Env, item.ConfigFiles, item.ConfigHash = env, files, a.ConfigHash
+			}
+		}
+		d, cached := deps[a.DeploymentID]
+		if !cached {
+			d, _, _ = store.Current.GetDeployment(a.DeploymentID)
+			deps[a.DeploymentID] = d
+		}
+		item.RestartPolicy = string(d.RestartPolicy)
+		item.TerminationGracePeriodSec = d.TerminationGracePeriodSec
 
 		// 获取 artifact 信息（类型、镜像信息等）
 		var artifact store.Artifact
@@ -213,17 +323,22 @@ func handleGetAssignments(w http.ResponseWriter, r *http.Request) {
 				item.ImageRepository = artifact.ImageRepository
 				item.ImageTag = artifact.ImageTag
 				item.PortMappings = artifact.PortMappings
+			} else {
+				item.ArtifactSHA256 = artifact.SHA256
+				item.ArtifactSize = artifact.SizeBytes
 			}
 		}
 
 		if ok {
 			item.Phase = st.Phase
 			item.Healthy = st.Healthy
+			item.Ready = st.Ready
+			item.RestartCount = st.RestartCount
 			item.LastReport = st.TsUnix
 		}
-		res.Items = append(res.Items, item)
+		items = append(items, item)
 	}
-	writeJSON(w, res)
+	return items
 }
 
 func handleStatusUpdate(w http.ResponseWriter, r *http.Request) {
@@ -236,14 +351,62 @@ func handleStatusUpdate(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "bad request", http.StatusBadRequest)
 		return
 	}
+	applyStatusUpdate(su)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// applyStatusUpdate 记录实例状态上报（HTTP 与 Agent 控制通道共用）
+func applyStatusUpdate(su StatusUpdate) {
+	// 已自行结束（Completed / Failed）的实例停止后保留其结束状态，如计数后置为 Stopped 的任务实例
+	if su.Phase == "Stopped" {
+		if last, ok, _ := store.Current.LatestStatus(su.InstanceID); ok && (last.Phase == "Completed" || last.Phase == "Failed") {
+			return
+		}
+	}
+	ready := su.Healthy
+	if su.Ready != nil {
+		ready = su.Healthy && *su.Ready
+	}
+	// 已被隔离的实例（原节点恢复后仍在运行的旧实例）不计为健康
+	if fenced(su.InstanceID) {
+		su.Healthy, ready = false, false
+	}
 	_ = store.Current.AppendStatus(su.InstanceID, store.InstanceStatus{
 		InstanceID: su.InstanceID,
 		Phase:      su.Phase,
 		ExitCode:   int(su.ExitCode),
 		Healthy:    su.Healthy,
+		Ready:      ready,
 		TsUnix:     su.TsUnix,
+
+		RestartCount: su.RestartCount,
+		Reason:       su.Reason,
+		Message:      su.Message,
 	})
-	w.WriteHeader(http.StatusNoContent)
+	switch su.Reason {
+	case "PreStartHookFailed", "PostStartHookFailed":
+		recordInstanceEvent(events.HookFailed, su)
+	case events.PortConflict, events.ArtifactChecksumMismatch:
+		recordInstanceEvent(su.Reason, su)
+	}
+}
+
+// recordInstanceEvent 记录实例上报的失败事件（节点、部署取自实例；上报未附说明时注明制品和节点）
+func recordInstanceEvent(typ string, su StatusUpdate) {
+	e := store.Event{Type: typ, Reason: su.Reason, InstanceID: su.InstanceID, Message: su.Message}
+	if a, ok, _ := store.Current.GetAssignment(su.InstanceID); ok {
+		e.NodeID, e.DeploymentID = a.NodeID, a.DeploymentID
+		if e.Message == "" {
+			e.Message = "artifact " + a.ArtifactURL + " on node " + a.NodeID
+		}
+	}
+	events.Record(e)
+}
+
+// fenced 实例是否已被故障转移接替
+func fenced(instanceID string) bool {
+	a, ok, _ := store.Current.GetAssignment(instanceID)
+	return ok && a.Fenced()
 }
 
 func handleCreateDeployment(w http.ResponseWriter, r *http.Request) {
@@ -259,61 +422,163 @@ func handleCreateDeployment(w http.ResponseWriter, r *http.Request) {
 	// 规范化为 entries（兼容旧格式），startCmd 可选
 	entries := req.Entries
 	if len(entries) == 0 {
-		if req.Name == "" || req.Artifact == "" || len(req.Replicas) == 0 {
+		if req.Name == "" || req.Artifact == "" || (len(req.Replicas) == 0 && req.Placement == nil) {
 			http.Error(w, "missing fields", http.StatusBadRequest)
 			return
 		}
-		entries = []CreateDeploymentEntry{{Artifact: req.Artifact, StartCmd: req.StartCmd, Replicas: req.Replicas}}
+		entries = []CreateDeploymentEntry{{Artifact: req.Artifact, StartCmd: req.StartCmd, Replicas: req.Replicas, Placement: req.Placement, Probes: req.Probes, Limits: req.Limits, Hooks: req.Hooks}}
+	}
+	spec := make([]deployment.Entry, 0, len(entries))
+	hasPlacement := false
+	for _, e := range entries {
+		if err := validateEntryReplicas(e.Replicas, e.Placement); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if e.Probes != nil {
+			if err := e.Probes.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if e.Limits != nil {
+			if err := e.Limits.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if e.Hooks != nil {
+			if err := e.Hooks.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if e.Artifact == "" {
+			continue
+		}
+		spec = append(spec, deployment.Entry{
+			ArtifactURL: e.Artifact, StartCmd: e.StartCmd, Replicas: e.Replicas, Placement: e.Placement, Probes: e.Probes, Limits: e.Limits, Hooks: e.Hooks,
+			Env: e.Env, ConfigMaps: e.ConfigMaps, Secrets: e.Secrets,
+		})
+		hasPlacement = hasPlacement || e.Placement != nil
 	}
 	if req.Name == "" {
 		http.Error(w, "name required", http.StatusBadRequest)
 		return
 	}
-	deploymentID, instances, _ := store.Current.CreateDeployment(req.Name, req.Labels)
-	for _, e := range entries {
-		if e.Artifact == "" || len(e.Replicas) == 0 {
+	if err := deployment.StampConfig(spec); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	strategy := store.Deployment{MaxSurge: 1}
+	if err := applyKind(&strategy, req.Kind, req.Job, spec); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := applyStrategy(&strategy, req.Strategy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strategy.IsJob() || strategy.IsDaemonSet() {
+		deployment.DefaultPlacement(spec)
+		// 守护型部署的实例由控制器按匹配的节点创建
+		hasPlacement = strategy.IsDaemonSet()
+	}
+	if err := applyRestartPolicy(&strategy, req.RestartPolicy); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := applyTerminationGrace(&strategy, req.TerminationGracePeriodSec); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var assigns []store.Assignment
+	for _, e := range spec {
+		// 任务实例由任务控制器按 parallelism 创建
+		if len(e.Replicas) == 0 || strategy.IsJob() {
 			continue
 		}
+		// 从artifact URL中获取app信息
+		var appName, appVersion string
+		var artifact store.Artifact
+		var found bool
+		var err error
+		// 检查是否是镜像应用的标识符格式 image://{artifactId}
+		if strings.HasPrefix(e.ArtifactURL, "image://") {
+			artifact, found, err = store.Current.GetArtifact(strings.TrimPrefix(e.ArtifactURL, "image://"))
+		} else if e.ArtifactURL != "" {
+			// 通过路径查找（ZIP 应用）
+			artifact, found, err = store.Current.GetArtifactByPath(e.ArtifactURL)
+		}
+		if err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if found {
+			appName, appVersion = artifact.AppName, artifact.Version
+		}
 		for nodeID, replicas := range e.Replicas {
 			for i := 0; i < replicas; i++ {
-				iid := store.Current.NewInstanceID(deploymentID)
-				// 从artifact URL中获取app信息
-				var appName, appVersion string
-				artifactURL := e.Artifact
-				var artifact store.Artifact
-
-				// 检查是否是镜像应用的标识符格式 image://{artifactId}
-				if strings.HasPrefix(e.Artifact, "image://") {
-					artifactID := strings.TrimPrefix(e.Artifact, "image://")
-					if art, ok, _ := store.Current.GetArtifact(artifactID); ok {
-						artifact = art
-						appName = artifact.AppName
-						appVersion = artifact.Version
-					}
-				} else if e.Artifact != "" {
-					// 通过路径查找（ZIP 应用）
-					if art, ok, _ := store.Current.GetArtifactByPath(e.Artifact); ok {
-						artifact = art
-						appName = artifact.AppName
-						appVersion = artifact.Version
-					}
-				}
-
-				_ = store.Current.AddAssignment(nodeID, store.Assignment{
-					InstanceID:   iid,
-					DeploymentID: deploymentID,
-					NodeID:       nodeID,
-					Desired:      store.DesiredRunning,
-					ArtifactURL:  artifactURL,
-					StartCmd:     e.StartCmd,
-					AppName:      appName,
-					AppVersion:   appVersion,
+				assigns = append(assigns, store.Assignment{
+					NodeID:      nodeID,
+					Desired:     store.DesiredRunning,
+					ArtifactURL: e.ArtifactURL,
+					StartCmd:    e.StartCmd,
+					AppName:     appName,
+					AppVersion:  appVersion,
+					ConfigHash:  e.ConfigHash,
 				})
-				notify.Publish(nodeID)
-				instances = append(instances, iid)
 			}
 		}
 	}
+	// 部署（含更新策略、任务参数）、显式副本的实例和初始修订在同一事务内创建
+	d := strategy
+	d.Name, d.Labels = req.Name, req.Labels
+	rev := deployment.NewRevision(spec, "initial")
+	deploymentID, instances, err := store.Current.CreateDeployment(d, assigns, &rev)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	for _, a := range assigns {
+		notify.Publish(a.NodeID)
+	}
+	// 任务创建后立即开始运行
+	if strategy.IsJob() {
+		if err := store.Current.UpdateDeploymentStatus(deploymentID, store.DeploymentRunning); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		if err := deployment.Reconcile(deploymentID); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		hasPlacement = false
+		instances = instances[:0]
+		assigns, _ := store.Current.ListAssignmentsForDeployment(deploymentID)
+		for _, a := range assigns {
+			instances = append(instances, a.InstanceID)
+		}
+	}
+	// 守护型部署创建后即运行，之后加入的节点上补建的实例同样期望运行
+	if strategy.IsDaemonSet() {
+		if err := store.Current.UpdateDeploymentStatus(deploymentID, store.DeploymentRunning); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+	}
+	// 自动调度条目：由控制器选择节点并立即创建实例
+	if hasPlacement {
+		if err := deployment.PlaceInitial(deploymentID, store.DesiredRunning); err != nil {
+			http.Error(w, "db error", http.StatusInternalServerError)
+			return
+		}
+		assigns, _ := store.Current.ListAssignmentsForDeployment(deploymentID)
+		instances = instances[:0]
+		for _, a := range assigns {
+			instances = append(instances, a.InstanceID)
+		}
+	}
 	writeJSON(w, map[string]any{
 		"deploymentId": deploymentID,
 		"instances":    instances,
@@ -327,6 +592,16 @@ func writeJSON(w http.ResponseWriter, v any) {
 	}
 }
 
+// parseLabelSelector 解析 labelSelector 查询参数，失败时返回 400
+func parseLabelSelector(w http.ResponseWriter, r *http.Request) (store.Selector, bool) {
+	sel, err := store.ParseSelector(r.URL.Query().Get("labelSelector"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	return sel, true
+}
+
 // ---- Tasks (Phase A minimal) ----
 
 type CreateTaskRequest struct {
@@ -344,11 +619,26 @@ type CreateTaskRequest struct {
 func handleTasks(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodGet:
-		list, err := store.Current.ListTasks()
+		q, err := parseTaskQuery(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		list, next, err := store.Current.QueryTasks(q)
+		if err == store.ErrInvalidCursor {
+			http.Error(w, "invalid cursor", http.StatusBadRequest)
+			return
+		}
 		if err != nil {
 			http.Error(w, "db error", http.StatusInternalServerError)
 			return
 		}
+		if next != "" {
+			w.Header().Set("X-Next-Cursor", next)
+		}
+		if list == nil {
+			list = []store.Task{}
+		}
 		writeJSON(w, list)
 	case http.MethodPost:
 		var req CreateTaskRequest
@@ -386,6 +676,61 @@ func handleTasks(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// parseTaskQuery 解析 GET /v1/tasks 的查询参数：
+// state(逗号分隔)、executor、name、labelSelector、originTaskId、
+// createdAfter/createdBefore(unix 秒)、sort、order(asc|desc)、limit、cursor
+func parseTaskQuery(r *http.Request) (store.TaskQuery, error) {
+	v := r.URL.Query()
+	q := store.TaskQuery{
+		Executor:     v.Get("executor"),
+		Name:         v.Get("name"),
+		OriginTaskID: v.Get("originTaskId"),
+		Cursor:       v.Get("cursor"),
+	}
+	if s := v.Get("state"); s != "" {
+		for _, st := range strings.Split(s, ",") {
+			if st = strings.TrimSpace(st); st != "" {
+				q.States = append(q.States, st)
+			}
+		}
+	}
+	sel, err := store.ParseSelector(v.Get("labelSelector"))
+	if err != nil {
+		return q, err
+	}
+	q.Selector = sel
+	for name, dst := range map[string]*int64{"createdAfter": &q.CreatedAfter, "createdBefore": &q.CreatedBefore} {
+		if s := v.Get(name); s != "" {
+			n, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return q, fmt.Errorf("invalid %s", name)
+			}
+			*dst = n
+		}
+	}
+	switch sortBy := v.Get("sort"); sortBy {
+	case "", "createdAt", "startedAt", "finishedAt", "name":
+		q.SortBy = sortBy
+	default:
+		return q, fmt.Errorf("invalid sort: %s", sortBy)
+	}
+	switch strings.ToLower(v.Get("order")) {
+	case "", "desc":
+	case "asc":
+		q.Ascending = true
+	default:
+		return q, fmt.Errorf("invalid order")
+	}
+	if s := v.Get("limit"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return q, fmt.Errorf("invalid limit")
+		}
+		q.Limit = n
+	}
+	return q, nil
+}
+
 func handleTaskByID(w http.ResponseWriter, r *http.Request) {
 	id := r.URL.Path[len("/v1/tasks/"):]
 	if id == "" {
@@ -700,8 +1045,13 @@ func handleTaskDefs(w http.ResponseWriter, r *http.Request) {
 			writeJSON(w, map[string]any{"referenced": n})
 			return
 		}
-		if err := store.Current.DeleteTaskDef(id); err != nil {
-			http.Error(w, "db error", http.StatusInternalServerError)
+		ifMatch, fromHeader, err := expectedVersion(r, 0, taskDefVersion(id))
+		if err != nil {
+			writeIfMatchError(w, err)
+			return
+		}
+		if err := store.Current.DeleteTaskDef(id, ifMatch); err != nil {
+			writeVersionError(w, err, fromHeader)
 			return
 		}
 		w.WriteHeader(http.StatusNoContent)
@@ -727,7 +1077,10 @@ func handleTaskDefByID(w http.ResponseWriter, r *http.Request) {
 			http.NotFound(w, r)
 			return
 		}
+		setETag(w, td.ResourceVersion)
 		writeJSON(w, td)
+	case http.MethodPut, http.MethodPatch:
+		handleUpdateTaskDef(w, r, id)
 	case http.MethodPost: // action run
 		if r.URL.Query().Get("action") == "run" {
 			td, ok, err := store.Current.GetTaskDef(id)
@@ -775,6 +1128,81 @@ func handleTaskDefByID(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// UpdateTaskDefRequest 更新任务定义；PATCH 时未提供的字段保持不变
+type UpdateTaskDefRequest struct {
+	Name            *string            `json:"name"`
+	Executor        *string            `json:"executor"`
+	TargetKind      *string            `json:"targetKind"`
+	TargetRef       *string            `json:"targetRef"`
+	Labels          *map[string]string `json:"labels"`
+	DefaultPayload  *map[string]any    `json:"defaultPayload"`
+	ResourceVersion int64              `json:"resourceVersion,omitempty"`
+}
+
+func handleUpdateTaskDef(w http.ResponseWriter, r *http.Request, id string) {
+	var req UpdateTaskDefRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	ifMatch, fromHeader, err := expectedVersion(r, req.ResourceVersion, taskDefVersion(id))
+	if err != nil {
+		writeIfMatchError(w, err)
+		return
+	}
+	td, ok, err := store.Current.GetTaskDef(id)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if td.Labels != nil && td.Labels["builtin"] == "true" {
+		http.Error(w, "cannot modify builtin task", http.StatusForbidden)
+		return
+	}
+	if req.Name != nil && *req.Name != td.Name {
+		if *req.Name == "" || strings.HasPrefix(*req.Name, "builtin.") {
+			http.Error(w, "invalid task name", http.StatusBadRequest)
+			return
+		}
+		if _, exists, _ := store.Current.GetTaskDefByName(*req.Name); exists {
+			http.Error(w, "task name already exists", http.StatusConflict)
+			return
+		}
+		td.Name = *req.Name
+	}
+	if req.Executor != nil {
+		td.Executor = *req.Executor
+	}
+	if req.TargetKind != nil {
+		td.TargetKind = *req.TargetKind
+	}
+	if req.TargetRef != nil {
+		td.TargetRef = *req.TargetRef
+	}
+	if req.Labels != nil {
+		td.Labels = *req.Labels
+	}
+	if req.DefaultPayload != nil {
+		td.DefaultPayloadJSON = ""
+		if *req.DefaultPayload != nil {
+			if bs, err := json.Marshal(*req.DefaultPayload); err == nil {
+				td.DefaultPayloadJSON = string(bs)
+			}
+		}
+	}
+	rv, err := store.Current.UpdateTaskDef(td, ifMatch)
+	if err != nil {
+		writeVersionError(w, err, fromHeader)
+		return
+	}
+	setETag(w, rv)
+	writeJSON(w, map[string]any{"defId": id, "resourceVersion": rv})
+}
+
 // ---- Workers (embedded) ----
 
 type RegisterWorkerRequest struct {
@@ -0,0 +1,141 @@
+package httpapi
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// 实例查看与操作转发到所在节点 Agent 的本地 HTTP 接口；
+// Agent 配置了 AGENT_API_TOKEN 时，Controller 须配置相同的令牌，查看 / 日志请求由 Controller 附带该令牌。
+// 停止 / 重启 / exec 须由调用方携带 Authorization: Bearer <AGENT_API_TOKEN>，Controller 校验后原样转发，
+// 不代为附带令牌；Controller 未配置令牌时拒绝这些操作。
+// 交互式 exec 为 WebSocket，拒绝跨源升级，由反向代理原样转发协议升级。
+
+// handleInstanceByID /v1/instances/{id}、/v1/instances/{id}/logs、/v1/instances/{id}/stop|restart|exec
+func handleInstanceByID(w http.ResponseWriter, r *http.Request) {
+	rest := strings.TrimPrefix(r.URL.Path, "/v1/instances/")
+	id, sub, _ := strings.Cut(rest, "/")
+	if id == "" {
+		http.NotFound(w, r)
+		return
+	}
+	switch sub {
+	case "", "logs":
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+	case "exec":
+		// POST 非交互执行；GET + Upgrade: websocket 交互执行
+		if r.Method != http.MethodPost && !(r.Method == http.MethodGet && strings.EqualFold(r.Header.Get("Upgrade"), "websocket")) {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+	case "stop", "restart":
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	a, ok, err := store.Current.GetAssignment(id)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "instance not found", http.StatusNotFound)
+		return
+	}
+	privileged := sub != "" && sub != "logs"
+	if privileged && !authorizeInstanceOp(w, r) {
+		return
+	}
+	path := "/v1/instances/" + url.PathEscape(id)
+	if sub != "" {
+		path += "/" + sub
+	}
+	proxyToAgent(w, r, a.NodeID, path, !privileged)
+}
+
+// authorizeInstanceOp 校验停止 / 重启 / exec 的调用方令牌，WebSocket 升级还须同源
+func authorizeInstanceOp(w http.ResponseWriter, r *http.Request) bool {
+	token := os.Getenv("AGENT_API_TOKEN")
+	if token == "" {
+		http.Error(w, "instance operations require AGENT_API_TOKEN on the controller", http.StatusForbidden)
+		return false
+	}
+	got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			u, err := url.Parse(origin)
+			if err != nil || !strings.EqualFold(u.Host, r.Host) {
+				http.Error(w, "cross-origin websocket rejected", http.StatusForbidden)
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// handleNodeInstances GET /v1/nodes/{id}/instances：节点 Agent 上的实例（进程 / 容器、资源占用）
+func handleNodeInstances(w http.ResponseWriter, r *http.Request, nodeID string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	proxyToAgent(w, r, nodeID, "/v1/instances", true)
+}
+
+// proxyToAgent 将请求转发给节点的 Agent（流式响应立即刷新，如 follow 日志）；
+// attachToken 为 false 时转发调用方自己的 Authorization（Origin 已校验，不再转发）
+func proxyToAgent(w http.ResponseWriter, r *http.Request, nodeID string, path string, attachToken bool) {
+	n, ok, err := store.Current.GetNode(nodeID)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "node not found", http.StatusNotFound)
+		return
+	}
+	if n.APIPort == 0 {
+		http.Error(w, "agent api not available on node "+nodeID, http.StatusServiceUnavailable)
+		return
+	}
+	target, _ := url.Parse(fmt.Sprintf("http://%s:%d", n.IP, n.APIPort))
+	token := os.Getenv("AGENT_API_TOKEN")
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			pr.Out.URL.Path = path
+			pr.Out.URL.RawPath = ""
+			if !attachToken {
+				pr.Out.Header.Del("Origin")
+				return
+			}
+			pr.Out.Header.Del("Authorization")
+			if token != "" {
+				pr.Out.Header.Set("Authorization", "Bearer "+token)
+			}
+		},
+		FlushInterval: -1,
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			http.Error(w, "agent unreachable: "+err.Error(), http.StatusBadGateway)
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
@@ -14,6 +14,8 @@ import (
 type KVPutRequest struct {
 	Value string `json:"value"`
 	Type  string `json:"type"` // string|int|double|bool
+	// 期望的 resourceVersion（可选，不匹配返回 409）
+	ResourceVersion int64 `json:"resourceVersion,omitempty"`
 }
 
 type KVPutBatchRequest struct {
@@ -30,6 +32,8 @@ type KVDTO struct {
 	Value     string `json:"value"`
 	Type      string `json:"type"`
 	UpdatedAt int64  `json:"updatedAt"`
+	// 当前 resourceVersion
+	ResourceVersion int64 `json:"resourceVersion"`
 }
 
 // PUT /v1/kv/{namespace}/{key}
@@ -80,19 +84,27 @@ func handleKVPut(w http.ResponseWriter, r *http.Request, namespace, key string)
 		return
 	}
 
-	if err := store.Current.PutKV(namespace, key, req.Value, req.Type); err != nil {
-		http.Error(w, "db error", http.StatusInternalServerError)
+	ifMatch, fromHeader, err := expectedVersion(r, req.ResourceVersion, kvVersion(namespace, key))
+	if err != nil {
+		writeIfMatchError(w, err)
+		return
+	}
+	rv, err := store.Current.PutKV(namespace, key, req.Value, req.Type, ifMatch)
+	if err != nil {
+		writeVersionError(w, err, fromHeader)
 		return
 	}
 
 	// 发送SSE通知
 	notify.PublishKV(namespace, key, req.Value, req.Type)
 
+	setETag(w, rv)
 	writeJSON(w, map[string]any{
-		"namespace": namespace,
-		"key":       key,
-		"value":     req.Value,
-		"type":      req.Type,
+		"namespace":       namespace,
+		"key":             key,
+		"value":           req.Value,
+		"type":            req.Type,
+		"resourceVersion": rv,
 	})
 }
 
@@ -107,18 +119,25 @@ func handleKVGet(w http.ResponseWriter, r *http.Request, namespace, key string)
 		return
 	}
 
+	setETag(w, kv.ResourceVersion)
 	writeJSON(w, KVDTO{
-		Namespace: kv.Namespace,
-		Key:       kv.Key,
-		Value:     kv.Value,
-		Type:      kv.Type,
-		UpdatedAt: kv.UpdatedAt,
+		Namespace:       kv.Namespace,
+		Key:             kv.Key,
+		Value:           kv.Value,
+		Type:            kv.Type,
+		UpdatedAt:       kv.UpdatedAt,
+		ResourceVersion: kv.ResourceVersion,
 	})
 }
 
 func handleKVDelete(w http.ResponseWriter, r *http.Request, namespace, key string) {
-	if err := store.Current.DeleteKV(namespace, key); err != nil {
-		http.Error(w, "db error", http.StatusInternalServerError)
+	ifMatch, fromHeader, err := expectedVersion(r, 0, kvVersion(namespace, key))
+	if err != nil {
+		writeIfMatchError(w, err)
+		return
+	}
+	if err := store.Current.DeleteKV(namespace, key, ifMatch); err != nil {
+		writeVersionError(w, err, fromHeader)
 		return
 	}
 
@@ -161,11 +180,12 @@ func handleKVByNamespace(w http.ResponseWriter, r *http.Request) {
 	out := make([]KVDTO, 0, len(kvs))
 	for _, kv := range kvs {
 		dto := KVDTO{
-			Namespace: kv.Namespace,
-			Key:       kv.Key,
-			Value:     kv.Value,
-			Type:      kv.Type,
-			UpdatedAt: kv.UpdatedAt,
+			Namespace:       kv.Namespace,
+			Key:             kv.Key,
+			Value:           kv.Value,
+			Type:            kv.Type,
+			UpdatedAt:       kv.UpdatedAt,
+			ResourceVersion: kv.ResourceVersion,
 		}
 
 		// 对于bytes类型，只返回长度信息，不返回完整内容（优化响应大小）
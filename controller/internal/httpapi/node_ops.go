@@ -0,0 +1,74 @@
+package httpapi
+
+import (
+	"net/http"
+
+	"github.com/manxisuo/plum/controller/internal/events"
+	"github.com/manxisuo/plum/controller/internal/notify"
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// NodeStatusDTO 节点调度状态变更的响应
+type NodeStatusDTO struct {
+	NodeID    string `json:"nodeId"`
+	Status    string `json:"status"`    // Schedulable | Cordoned | Draining
+	Instances int    `json:"instances"` // 节点上尚未迁走的实例数（不含已被故障转移隔离的实例）
+}
+
+// handleNodeOperation POST /v1/nodes/{id}/cordon|uncordon|drain
+func handleNodeOperation(w http.ResponseWriter, r *http.Request, id string, op string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var status store.NodeStatus
+	switch op {
+	case "cordon":
+		status = store.NodeCordoned
+	case "uncordon":
+		status = store.NodeSchedulable
+	case "drain":
+		status = store.NodeDraining
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	n, ok, err := store.Current.GetNode(id)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if err := store.Current.SetNodeStatus(id, status); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if prev := nodeStatus(n); prev != string(status) {
+		events.Record(store.Event{
+			Type:    events.NodeStatusChanged,
+			Reason:  string(status),
+			NodeID:  id,
+			Message: prev + " -> " + string(status),
+		})
+	}
+	notify.Publish(id)
+	writeJSON(w, NodeStatusDTO{NodeID: id, Status: string(status), Instances: nodeInstances(id)})
+}
+
+// nodeStatus 节点调度状态（旧数据为空时视为 Schedulable）
+func nodeStatus(n store.Node) string {
+	if n.Status == "" {
+		return string(store.NodeSchedulable)
+	}
+	return string(n.Status)
+}
+
+// nodeInstances 节点上尚未迁走的实例数
+func nodeInstances(id string) int {
+	n, _ := store.Current.CountAssignmentsForNode(id)
+	fencedIDs, _ := store.Current.ListSupersededForNode(id)
+	return n - len(fencedIDs)
+}
@@ -0,0 +1,175 @@
+package httpapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/manxisuo/plum/controller/internal/deployment"
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// UpdateStrategyDTO 部署更新策略
+type UpdateStrategyDTO struct {
+	Type           string `json:"type"` // rolling | recreate
+	MaxUnavailable *int   `json:"maxUnavailable,omitempty"`
+	MaxSurge       *int   `json:"maxSurge,omitempty"`
+}
+
+type DeploymentRevisionDTO struct {
+	Revision  int                `json:"revision"`
+	Entries   []deployment.Entry `json:"entries"`
+	Cause     string             `json:"cause"`
+	CreatedAt int64              `json:"createdAt"`
+	Current   bool               `json:"current"`
+}
+
+// applyStrategy 将请求中的更新策略写入部署（未提供的字段保持不变）
+func applyStrategy(t *store.Deployment, s *UpdateStrategyDTO) error {
+	if t.Strategy == "" {
+		t.Strategy = store.StrategyRolling
+	}
+	if s == nil {
+		return nil
+	}
+	switch store.UpdateStrategy(s.Type) {
+	case "":
+	case store.StrategyRolling, store.StrategyRecreate:
+		t.Strategy = store.UpdateStrategy(s.Type)
+	default:
+		return fmt.Errorf("invalid strategy type %q", s.Type)
+	}
+	if s.MaxUnavailable != nil {
+		t.MaxUnavailable = *s.MaxUnavailable
+	}
+	if s.MaxSurge != nil {
+		t.MaxSurge = *s.MaxSurge
+	}
+	if t.MaxUnavailable < 0 || t.MaxSurge < 0 {
+		return errors.New("maxUnavailable and maxSurge must be >= 0")
+	}
+	if t.Strategy == store.StrategyRolling && t.MaxUnavailable == 0 && t.MaxSurge == 0 {
+		return errors.New("maxUnavailable and maxSurge cannot both be 0")
+	}
+	return nil
+}
+
+// handleDeploymentHistory GET /v1/deployments/{id}/history
+func handleDeploymentHistory(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	t, ok, _ := store.Current.GetDeployment(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	revs, err := store.Current.ListDeploymentRevisions(id)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	out := make([]DeploymentRevisionDTO, 0, len(revs))
+	for _, rev := range revs {
+		dto := DeploymentRevisionDTO{
+			Revision:  rev.Revision,
+			Cause:     rev.Cause,
+			CreatedAt: rev.CreatedAt,
+			Current:   rev.Revision == t.Revision,
+		}
+		_ = json.Unmarshal([]byte(rev.SpecJSON), &dto.Entries)
+		out = append(out, dto)
+	}
+	writeJSON(w, out)
+}
+
+// handleDeploymentRollback POST /v1/deployments/{id}/rollback?revision=N
+// 以修订 N 的规格创建一个新修订并开始发布；未指定 revision 时回滚到上一个修订。
+func handleDeploymentRollback(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	t, ok, _ := store.Current.GetDeployment(id)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	ifMatch, fromHeader, err := expectedVersion(r, 0, deploymentVersion(id))
+	if err != nil {
+		writeIfMatchError(w, err)
+		return
+	}
+	if ifMatch == 0 {
+		ifMatch = t.ResourceVersion
+	}
+	if err := deployment.EnsureInitialRevision(&t); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	target := t.Revision - 1
+	if v := r.URL.Query().Get("revision"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "invalid revision", http.StatusBadRequest)
+			return
+		}
+		target = n
+	} else if target < 1 {
+		http.Error(w, "no previous revision", http.StatusConflict)
+		return
+	}
+	spec, ok, err := deployment.LoadRevisionSpec(id, target)
+	if err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		http.Error(w, "revision not found", http.StatusNotFound)
+		return
+	}
+	// 修订与部署在同一事务内按版本条件写入，冲突时不留下修订
+	if err := deployment.SaveRevision(&t, spec, fmt.Sprintf("rollback to %d", target), ifMatch); err != nil {
+		writeVersionError(w, err, fromHeader)
+		return
+	}
+	rev, rv := t.Revision, t.ResourceVersion
+	_ = deployment.Reconcile(id)
+	setETag(w, rv)
+	writeJSON(w, map[string]any{
+		"deploymentId":    id,
+		"revision":        rev,
+		"resourceVersion": rv,
+	})
+}
+
+// handleDeploymentPause 暂停 / 恢复发布
+func handleDeploymentPause(w http.ResponseWriter, r *http.Request, id string, paused bool) {
+	if _, ok, _ := store.Current.GetDeployment(id); !ok {
+		http.NotFound(w, r)
+		return
+	}
+	ifMatch, fromHeader, err := expectedVersion(r, 0, deploymentVersion(id))
+	if err != nil {
+		writeIfMatchError(w, err)
+		return
+	}
+	// 只更新暂停标志，不覆盖并发写入的其它字段
+	rv, err := store.Current.SetDeploymentPaused(id, paused, ifMatch)
+	if err != nil {
+		writeVersionError(w, err, fromHeader)
+		return
+	}
+	if !paused {
+		_ = deployment.Reconcile(id)
+	}
+	setETag(w, rv)
+	writeJSON(w, map[string]any{
+		"deploymentId":    id,
+		"paused":          paused,
+		"resourceVersion": rv,
+	})
+}
@@ -19,6 +19,9 @@ func RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/v1/nodes/heartbeat", withCORS(handleHeartbeat))
 	mux.HandleFunc("/v1/nodes", withCORS(handleNodes))
 	mux.HandleFunc("/v1/nodes/", withCORS(handleNodeByID))
+	// events (failover / rebalance audit)
+	mux.HandleFunc("/v1/events", withCORS(handleEvents))
+	mux.HandleFunc("/v1/events/stream", withCORS(handleEventsSSE))
 	// services (register/discovery)
 	mux.HandleFunc("/v1/services/register", withCORS(handleRegisterEndpoints))
 	mux.HandleFunc("/v1/services/heartbeat", withCORS(handleHeartbeatEndpoints))
@@ -36,6 +39,7 @@ func RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/v1/assignments", withCORS(handleGetAssignments))
 	mux.HandleFunc("/v1/assignments/", withCORS(handleAssignmentByID))
 	mux.HandleFunc("/v1/instances/status", withCORS(handleStatusUpdate))
+	mux.HandleFunc("/v1/instances/", withCORS(handleInstanceByID)) // /v1/instances/{id}[/logs|stop|restart|exec]（转发到 Agent）
 	// embedded workers
 	mux.HandleFunc("/v1/workers/register", withCORS(handleRegisterWorker))
 	mux.HandleFunc("/v1/workers/heartbeat", withCORS(handleHeartbeatWorker))
@@ -59,6 +63,11 @@ func RegisterRoutes(mux *http.ServeMux) {
 	// deployments
 	mux.HandleFunc("/v1/deployments", withCORS(handleDeployments))
 	mux.HandleFunc("/v1/deployments/", withCORS(handleDeploymentByID))
+	// config maps & secrets (injected into deployment instances)
+	mux.HandleFunc("/v1/configmaps", withCORS(handleConfigMaps))
+	mux.HandleFunc("/v1/configmaps/", withCORS(handleConfigMapByName))
+	mux.HandleFunc("/v1/secrets", withCORS(handleSecrets))
+	mux.HandleFunc("/v1/secrets/", withCORS(handleSecretByName))
 	// tasks (Phase A minimal)
 	mux.HandleFunc("/v1/tasks", withCORS(handleTasks))
 	mux.HandleFunc("/v1/tasks/", withCORS(handleTaskByID))
@@ -26,6 +26,8 @@ type EndpointDTO struct {
 	Labels      map[string]string `json:"labels"`
 	Healthy     bool              `json:"healthy"`
 	LastSeen    int64             `json:"lastSeen"`
+	// 更新时可携带期望的 resourceVersion（不匹配返回 409）
+	ResourceVersion int64 `json:"resourceVersion,omitempty"`
 }
 
 type RegisterRequest struct {
@@ -49,16 +51,17 @@ var discoveryCache = struct {
 
 func endpointToDTO(e store.Endpoint) EndpointDTO {
 	return EndpointDTO{
-		ServiceName: e.ServiceName,
-		InstanceID:  e.InstanceID,
-		NodeID:      e.NodeID,
-		IP:          e.IP,
-		Port:        e.Port,
-		Protocol:    e.Protocol,
-		Version:     e.Version,
-		Labels:      e.Labels,
-		Healthy:     e.Healthy,
-		LastSeen:    e.LastSeen,
+		ServiceName:     e.ServiceName,
+		InstanceID:      e.InstanceID,
+		NodeID:          e.NodeID,
+		IP:              e.IP,
+		Port:            e.Port,
+		Protocol:        e.Protocol,
+		Version:         e.Version,
+		Labels:          e.Labels,
+		Healthy:         e.Healthy,
+		LastSeen:        e.LastSeen,
+		ResourceVersion: e.ResourceVersion,
 	}
 }
 
@@ -93,7 +96,24 @@ func handleRegisterEndpoints(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing instanceId", http.StatusBadRequest)
 		return
 	}
+	if fenced(req.InstanceID) {
+		http.Error(w, "instance superseded", http.StatusConflict)
+		return
+	}
+
+	// 判断是否是手动注册：
+	// 手动注册时（没有 replace=true 参数）需要立即进行健康检查
+	isManualRegistration := r.URL.Query().Get("replace") != "true"
+
+	if err := registerEndpoints(req, isManualRegistration); err != nil {
+		http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
 
+// registerEndpoints 注册实例的服务端点，checkHealth 时立即检查端点健康（HTTP 与 Agent 控制通道共用）
+func registerEndpoints(req RegisterRequest, checkHealth bool) error {
 	// nodeID可以为空（手动注册可以使用默认值）
 	if req.NodeID == "" {
 		req.NodeID = "manual"
@@ -106,10 +126,6 @@ func handleRegisterEndpoints(w http.ResponseWriter, r *http.Request) {
 	// 3. 如果同一个端点（主键相同）重复注册，会自动更新（INSERT OR REPLACE）
 	now := time.Now().Unix()
 
-	// 判断是否是手动注册：
-	// 手动注册时（没有 replace=true 参数）需要立即进行健康检查
-	isManualRegistration := r.URL.Query().Get("replace") != "true"
-
 	for _, e := range req.Endpoints {
 		ep := store.Endpoint{
 			ServiceName: e.ServiceName,
@@ -125,7 +141,7 @@ func handleRegisterEndpoints(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// 对于手动注册的端点，立即进行健康检查
-		if isManualRegistration {
+		if checkHealth {
 			isHealthy := checkEndpointHealth(ep.IP, ep.Port, ep.Protocol)
 			ep.Healthy = isHealthy
 
@@ -137,11 +153,10 @@ func handleRegisterEndpoints(w http.ResponseWriter, r *http.Request) {
 
 		if err := store.Current.AddEndpoint(ep); err != nil {
 			log.Printf("service register failed to add endpoint: %v", err)
-			http.Error(w, "db error: "+err.Error(), http.StatusInternalServerError)
-			return
+			return err
 		}
 	}
-	w.WriteHeader(http.StatusNoContent)
+	return nil
 }
 
 func handleHeartbeatEndpoints(w http.ResponseWriter, r *http.Request) {
@@ -158,16 +173,26 @@ func handleHeartbeatEndpoints(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "missing instanceId", http.StatusBadRequest)
 		return
 	}
+	if fenced(req.InstanceID) {
+		http.Error(w, "instance superseded", http.StatusConflict)
+		return
+	}
+	if err := heartbeatEndpoints(req); err != nil {
+		http.Error(w, "db error", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// heartbeatEndpoints 刷新实例端点的健康状态和最后心跳时间（HTTP 与 Agent 控制通道共用）
+func heartbeatEndpoints(req HeartbeatRequest) error {
 	// if health overrides provided, update
 	if len(req.Health) > 0 {
 		eps := make([]store.Endpoint, 0, len(req.Health))
 		for _, e := range req.Health {
 			eps = append(eps, store.Endpoint{ServiceName: e.ServiceName, InstanceID: req.InstanceID, IP: e.IP, Port: e.Port, Protocol: e.Protocol, Healthy: e.Healthy})
 		}
-		if err := store.Current.UpdateEndpointHealthForInstance(req.InstanceID, eps); err != nil {
-			http.Error(w, "db error", http.StatusInternalServerError)
-			return
-		}
+		return store.Current.UpdateEndpointHealthForInstance(req.InstanceID, eps)
 	} else {
 		// 如果没有提供健康覆盖，自动检查并更新健康状态
 		// 这样可以处理注册时服务未启动的情况
@@ -198,27 +223,18 @@ func handleHeartbeatEndpoints(w http.ResponseWriter, r *http.Request) {
 			if len(updatedEps) > 0 {
 				if err := store.Current.UpdateEndpointHealthForInstance(req.InstanceID, updatedEps); err != nil {
 					// 如果更新失败，只更新 LastSeen
-					if err := store.Current.TouchEndpointsForInstance(req.InstanceID, time.Now().Unix()); err != nil {
-						http.Error(w, "db error", http.StatusInternalServerError)
-						return
-					}
+					return store.Current.TouchEndpointsForInstance(req.InstanceID, time.Now().Unix())
 				}
 			} else {
 				// 如果没有找到端点，只更新 LastSeen
-				if err := store.Current.TouchEndpointsForInstance(req.InstanceID, time.Now().Unix()); err != nil {
-					http.Error(w, "db error", http.StatusInternalServerError)
-					return
-				}
+				return store.Current.TouchEndpointsForInstance(req.InstanceID, time.Now().Unix())
 			}
 		} else {
 			// 如果无法获取服务列表，只更新 LastSeen
-			if err := store.Current.TouchEndpointsForInstance(req.InstanceID, time.Now().Unix()); err != nil {
-				http.Error(w, "db error", http.StatusInternalServerError)
-				return
-			}
+			return store.Current.TouchEndpointsForInstance(req.InstanceID, time.Now().Unix())
 		}
 	}
-	w.WriteHeader(http.StatusNoContent)
+	return nil
 }
 
 func handleDeleteEndpoints(w http.ResponseWriter, r *http.Request) {
@@ -255,7 +271,7 @@ func handleDiscover(w http.ResponseWriter, r *http.Request) {
 		}
 		out := make([]EndpointDTO, 0, len(eps))
 		for _, e := range eps {
-			out = append(out, EndpointDTO{ServiceName: e.ServiceName, InstanceID: e.InstanceID, NodeID: e.NodeID, IP: e.IP, Port: e.Port, Protocol: e.Protocol, Version: e.Version, Labels: e.Labels, Healthy: e.Healthy, LastSeen: e.LastSeen})
+			out = append(out, endpointToDTO(e))
 		}
 		writeJSON(w, out)
 		return
@@ -271,7 +287,7 @@ func handleDiscover(w http.ResponseWriter, r *http.Request) {
 	}
 	out := make([]EndpointDTO, 0, len(eps))
 	for _, e := range eps {
-		out = append(out, EndpointDTO{ServiceName: e.ServiceName, InstanceID: e.InstanceID, NodeID: e.NodeID, IP: e.IP, Port: e.Port, Protocol: e.Protocol, Version: e.Version, Labels: e.Labels, Healthy: e.Healthy, LastSeen: e.LastSeen})
+		out = append(out, endpointToDTO(e))
 	}
 	// optional: max endpoints
 	if lim := r.URL.Query().Get("limit"); lim != "" {
@@ -377,7 +393,11 @@ func handleListServices(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
-	names, err := store.Current.ListServices()
+	sel, ok := parseLabelSelector(w, r)
+	if !ok {
+		return
+	}
+	names, err := store.Current.ListServicesBySelector(sel)
 	if err != nil {
 		http.Error(w, "db error", http.StatusInternalServerError)
 		return
@@ -420,8 +440,13 @@ func handleDeleteEndpoint(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := store.Current.DeleteEndpoint(serviceName, instanceID, ip, port, protocol); err != nil {
-		http.Error(w, "db error", http.StatusInternalServerError)
+	ifMatch, fromHeader, err := expectedVersion(r, 0, endpointVersion(serviceName, instanceID, ip, port, protocol))
+	if err != nil {
+		writeIfMatchError(w, err)
+		return
+	}
+	if err := store.Current.DeleteEndpoint(serviceName, instanceID, ip, port, protocol, ifMatch); err != nil {
+		writeVersionError(w, err, fromHeader)
 		return
 	}
 	w.WriteHeader(http.StatusNoContent)
@@ -479,11 +504,18 @@ func handleUpdateEndpoint(w http.ResponseWriter, r *http.Request) {
 		LastSeen:    time.Now().Unix(),
 	}
 
-	if err := store.Current.UpdateEndpoint(serviceName, instanceID, oldIP, oldPort, oldProtocol, ep); err != nil {
-		http.Error(w, "db error", http.StatusInternalServerError)
+	ifMatch, fromHeader, err := expectedVersion(r, epDTO.ResourceVersion, endpointVersion(serviceName, instanceID, oldIP, oldPort, oldProtocol))
+	if err != nil {
+		writeIfMatchError(w, err)
+		return
+	}
+	rv, err := store.Current.UpdateEndpoint(serviceName, instanceID, oldIP, oldPort, oldProtocol, ep, ifMatch)
+	if err != nil {
+		writeVersionError(w, err, fromHeader)
 		return
 	}
 
+	setETag(w, rv)
 	w.WriteHeader(http.StatusNoContent)
 }
 
@@ -59,14 +59,18 @@ func generateOpenAPIDoc() map[string]any {
 			},
 			"/v1/nodes": OA{
 				"get": OA{
-					"summary":   "获取所有节点",
-					"responses": OA{"200": OA{"description": "节点列表"}},
+					"summary": "获取所有节点",
+					"parameters": []OA{
+						{"name": "labelSelector", "in": "query", "required": false, "schema": OA{"type": "string"}, "description": "标签选择器，如 env=prod,region in (a,b),!canary"},
+					},
+					"responses": OA{"200": OA{"description": "节点列表，含 health（Healthy | Suspect | Unhealthy）与 status（Schedulable | Cordoned | Draining）"}},
 				},
 			},
 			"/v1/nodes/heartbeat": OA{
 				"post": OA{
-					"summary":   "节点心跳",
-					"responses": OA{"200": OA{"description": "心跳确认"}},
+					"summary":     "节点心跳",
+					"description": "resources: {cpuCores, cpuLoad, memTotalMB, memAvailableMB, diskTotalMB, diskFreeMB, instances, arch, os, docker, images}，用于容量感知调度与故障转移",
+					"responses":   OA{"200": OA{"description": "心跳确认 {ttlSec, fenced}；fenced 为已被故障转移接替、Agent 须立即停止的实例"}},
 				},
 			},
 			"/v1/nodes/{id}": OA{
@@ -75,8 +79,62 @@ func generateOpenAPIDoc() map[string]any {
 					"responses": OA{"200": OA{"description": "节点信息"}},
 				},
 				"delete": OA{
-					"summary":   "删除节点",
-					"responses": OA{"204": OA{"description": "删除成功"}},
+					"summary":     "删除节点",
+					"description": "节点上仍有实例时返回 409；仅剩已被故障转移隔离的实例时一并清理",
+					"responses":   OA{"204": OA{"description": "删除成功"}},
+				},
+			},
+			"/v1/nodes/{id}/cordon": OA{
+				"post": OA{
+					"summary":     "封锁节点（维护模式）",
+					"description": "保留节点上已有实例，调度、故障转移和重平衡不再向该节点放置新实例",
+					"responses":   OA{"200": OA{"description": "{nodeId, status, instances}"}, "404": OA{"description": "节点不存在"}},
+				},
+			},
+			"/v1/nodes/{id}/instances": OA{
+				"get": OA{
+					"summary":   "节点上的实例（转发到节点 Agent：进程 PID / 容器 ID、退出码、重启次数、资源占用）",
+					"responses": OA{"200": OA{"description": "{items}"}, "502": OA{"description": "Agent 不可达"}, "503": OA{"description": "节点未启用 Agent 本地接口"}},
+				},
+			},
+			"/v1/nodes/{id}/uncordon": OA{
+				"post": OA{
+					"summary":     "解除封锁",
+					"description": "恢复为 Schedulable，同时停止排空",
+					"responses":   OA{"200": OA{"description": "{nodeId, status, instances}"}, "404": OA{"description": "节点不存在"}},
+				},
+			},
+			"/v1/nodes/{id}/drain": OA{
+				"post": OA{
+					"summary":     "排空节点",
+					"description": "封锁节点并迁走其上的实例：自动调度条目由发布控制器按滚动更新约束重新调度，其余实例先建后删，每个部署同时迁移不超过 maxSurge 个",
+					"responses":   OA{"200": OA{"description": "{nodeId, status, instances}"}, "404": OA{"description": "节点不存在"}},
+				},
+			},
+			"/v1/events": OA{
+				"get": OA{
+					"summary":     "查询集群事件",
+					"description": "节点健康/调度状态变化、实例迁移、再平衡移动、制品校验失败（ArtifactChecksumMismatch）等事件，默认按 id 倒序",
+					"parameters": []OA{
+						{"name": "type", "in": "query", "required": false, "schema": OA{"type": "string"}, "description": "事件类型，逗号分隔（如 InstanceMigrated,NodeHealthChanged）"},
+						{"name": "nodeId", "in": "query", "required": false, "schema": OA{"type": "string"}, "description": "节点ID（匹配 nodeId/fromNode/toNode）"},
+						{"name": "deploymentId", "in": "query", "required": false, "schema": OA{"type": "string"}, "description": "部署ID"},
+						{"name": "instanceId", "in": "query", "required": false, "schema": OA{"type": "string"}, "description": "实例ID（匹配 instanceId/newInstanceId）"},
+						{"name": "since", "in": "query", "required": false, "schema": OA{"type": "integer"}, "description": "起始时间（unix 秒）"},
+						{"name": "until", "in": "query", "required": false, "schema": OA{"type": "integer"}, "description": "截止时间（unix 秒）"},
+						{"name": "afterId", "in": "query", "required": false, "schema": OA{"type": "integer"}, "description": "只返回 id 大于该值的事件"},
+						{"name": "beforeId", "in": "query", "required": false, "schema": OA{"type": "integer"}, "description": "只返回 id 小于该值的事件（翻页）"},
+						{"name": "order", "in": "query", "required": false, "schema": OA{"type": "string", "enum": []string{"desc", "asc"}}, "description": "排序"},
+						{"name": "limit", "in": "query", "required": false, "schema": OA{"type": "integer"}, "description": "数量（默认 100，最大 1000）"},
+					},
+					"responses": OA{"200": OA{"description": "事件列表"}, "400": OA{"description": "参数错误"}},
+				},
+			},
+			"/v1/events/stream": OA{
+				"get": OA{
+					"summary":     "事件流（SSE）",
+					"description": "支持与 /v1/events 相同的过滤参数；通过 Last-Event-ID 头或 afterId 从指定事件之后续传，否则只推送新事件",
+					"responses":   OA{"200": OA{"description": "text/event-stream"}},
 				},
 			},
 			"/v1/apps": OA{
@@ -123,6 +181,50 @@ func generateOpenAPIDoc() map[string]any {
 					"responses": OA{"204": OA{"description": "更新成功"}},
 				},
 			},
+			"/v1/instances/{id}": OA{
+				"get": OA{
+					"summary":   "查看实例运行信息（转发到实例所在节点的 Agent）",
+					"responses": OA{"200": OA{"description": "{instanceId, type, running, pid, containerId, exitCode, ready, restartCount, manualStop, usage, hooks: [{hook, command, exitCode, output, error, startedAt, durationMs}], ports: {服务名: 宿主机端口}}"}, "404": OA{"description": "实例不存在"}, "502": OA{"description": "Agent 不可达"}, "503": OA{"description": "节点未启用 Agent 本地接口"}},
+				},
+			},
+			"/v1/instances/{id}/stop": OA{
+				"post": OA{
+					"summary":     "手动停止实例",
+					"description": "停止后 Agent 不再自动拉起，直到手动重启或期望状态变化；调用方须携带 Authorization: Bearer <AGENT_API_TOKEN>（Controller 与 Agent 配置相同令牌）",
+					"responses":   OA{"200": OA{"description": "实例信息"}, "403": OA{"description": "Agent 未配置令牌"}, "409": OA{"description": "实例不在该节点的分配中"}},
+				},
+			},
+			"/v1/instances/{id}/restart": OA{
+				"post": OA{
+					"summary":     "手动重启实例（清除手动停止和崩溃退避）",
+					"description": "调用方须携带 Authorization: Bearer <AGENT_API_TOKEN>（Controller 与 Agent 配置相同令牌）",
+					"responses":   OA{"200": OA{"description": "实例信息"}, "403": OA{"description": "Agent 未配置令牌"}, "409": OA{"description": "实例期望状态不是 Running 或启动失败"}},
+				},
+			},
+			"/v1/instances/{id}/exec": OA{
+				"post": OA{
+					"summary":     "在实例中执行命令（非交互，转发到实例所在节点的 Agent）",
+					"description": "容器模式在容器内执行，进程模式在实例工作目录中以相同环境变量执行；调用方须携带 Authorization: Bearer <AGENT_API_TOKEN>（Controller 与 Agent 配置相同令牌）。交互式执行：GET 同一路径并升级为 WebSocket，参数 command（可重复）、tty（仅容器模式），客户端帧写入标准输入，输出以二进制帧返回，结束时发送文本帧 {exitCode}",
+					"parameters":  []OA{{"name": "id", "in": "path", "required": true, "schema": OA{"type": "string"}}},
+					"requestBody": OA{"required": true, "content": OA{"application/json": OA{"schema": OA{"type": "object", "properties": OA{
+						"command":    OA{"type": "array", "items": OA{"type": "string"}},
+						"stdin":      OA{"type": "string"},
+						"timeoutSec": OA{"type": "integer", "description": "默认 30，最长 600"},
+					}}}}},
+					"responses": OA{"200": OA{"description": "{exitCode, stdout, stderr, timedOut, truncated}"}, "403": OA{"description": "Agent 未配置令牌或禁用了 exec"}, "409": OA{"description": "实例未运行"}},
+				},
+			},
+			"/v1/instances/{id}/logs": OA{
+				"get": OA{
+					"summary": "获取实例日志（转发到实例所在节点的 Agent）",
+					"parameters": []OA{
+						{"name": "id", "in": "path", "required": true, "schema": OA{"type": "string"}},
+						{"name": "tail", "in": "query", "required": false, "schema": OA{"type": "integer"}, "description": "返回最后 N 行，默认 100"},
+						{"name": "follow", "in": "query", "required": false, "schema": OA{"type": "boolean"}, "description": "持续输出新日志"},
+					},
+					"responses": OA{"200": OA{"description": "日志文本（text/plain）"}, "404": OA{"description": "实例或日志不存在"}, "502": OA{"description": "Agent 不可达"}, "503": OA{"description": "节点未启用 Agent 本地接口"}},
+				},
+			},
 			"/v1/services/register": OA{
 				"post": OA{
 					"summary":   "注册服务端点",
@@ -146,7 +248,10 @@ func generateOpenAPIDoc() map[string]any {
 			},
 			"/v1/services/list": OA{
 				"get": OA{
-					"summary":   "获取服务列表",
+					"summary": "获取服务列表",
+					"parameters": []OA{
+						{"name": "labelSelector", "in": "query", "required": false, "schema": OA{"type": "string"}, "description": "标签选择器，如 env=prod,region in (a,b),!canary"},
+					},
 					"responses": OA{"200": OA{"description": "服务列表"}},
 				},
 			},
@@ -265,7 +370,10 @@ func generateOpenAPIDoc() map[string]any {
 			},
 			"/v1/embedded-workers": OA{
 				"get": OA{
-					"summary":   "获取嵌入式工作器列表",
+					"summary": "获取嵌入式工作器列表",
+					"parameters": []OA{
+						{"name": "labelSelector", "in": "query", "required": false, "schema": OA{"type": "string"}, "description": "标签选择器，如 env=prod,region in (a,b),!canary"},
+					},
 					"responses": OA{"200": OA{"description": "工作器列表"}},
 				},
 			},
@@ -281,31 +389,76 @@ func generateOpenAPIDoc() map[string]any {
 			},
 			"/v1/deployments": OA{
 				"get": OA{
-					"summary":   "获取部署列表",
+					"summary": "获取部署列表",
+					"parameters": []OA{
+						{"name": "labelSelector", "in": "query", "required": false, "schema": OA{"type": "string"}, "description": "标签选择器，如 env=prod,region in (a,b),!canary"},
+					},
 					"responses": OA{"200": OA{"description": "部署列表"}},
 				},
 				"post": OA{
-					"summary":   "创建部署",
-					"responses": OA{"200": OA{"description": "创建成功"}},
+					"summary":     "创建部署（replicas 指定各节点副本数，或 placement 由控制器自动调度；kind=Job 时为运行至完成的任务，kind=DaemonSet 时每个匹配节点一个实例）",
+					"description": "kind: Service（默认）|Job|DaemonSet；守护型部署的条目只用 placement.nodeSelector 选择节点（未设置 placement 时为全部节点，placement 其它字段忽略、不允许 replicas），创建后立即运行，节点加入或标签变为匹配时补建实例，标签不再匹配、排空或删除时删除实例，节点失联时不做故障转移，滚动更新默认 maxSurge 0、maxUnavailable 1；job: {completions（默认 1）, parallelism（默认 1）, backoffLimit（默认 6）, ttlSecondsAfterFinished（默认 -1 不删除）}，任务只允许一个条目，replicas 列出可运行的节点、placement 的 replicas 忽略，restartPolicy 默认 Never（不允许 Always），创建后立即运行，完成后部署状态为 Succeeded，失败次数超过 backoffLimit 时为 Failed；placement: {replicas, nodeSelector, spreadBy, antiAffinity, maxPerNode}；probes: {liveness, readiness}，每个探针 {type: http|tcp|exec|grpc, port, path, command, service, initialDelaySec, periodSec, timeoutSec, failureThreshold, successThreshold}；restartPolicy: Always（默认）|OnFailure|Never；terminationGracePeriodSec: 停止实例时 preStop 钩子与优雅退出的总时限（0-600 秒，0 使用 Agent 默认值）；hooks: {preStart, postStart, preStop, timeoutSec}（sh -c 执行，未配置时使用制品中的 prestart.sh / poststart.sh / prestop.sh）；limits: {cpus, memoryMB, pids, ioReadBps, ioWriteBps}（容器由 Docker、进程模式由 cgroup v2 施加）；env: {NAME: value}；configMaps / secrets: [{name, mountPath}]，无 mountPath 时各键作为环境变量注入，否则写成 mountPath 下的文件",
+					"responses":   OA{"200": OA{"description": "创建成功"}},
 				},
 			},
 			"/v1/deployments/{id}": OA{
 				"get": OA{
-					"summary":   "获取指定部署",
+					"summary":   "获取指定部署（含发布进度 rollout、任务进度 job {phase, completions, parallelism, backoffLimit, active, succeeded, failed, finishedAt, expiresAt}、实例阶段、重启次数与退出原因 reason：Completed|Error|OOMKilled|PreStartHookFailed|PostStartHookFailed|PortConflict，钩子失败或端口冲突时 message 为原因）",
 					"responses": OA{"200": OA{"description": "部署信息"}},
 				},
+				"post": OA{
+					"summary": "部署操作",
+					"parameters": []OA{
+						{"name": "action", "in": "query", "required": true, "schema": OA{"type": "string"}, "description": "start|stop|pause|resume（任务 stop 中断运行中的实例，start 按 parallelism 继续）"},
+					},
+					"responses": OA{"200": OA{"description": "操作成功"}, "409": OA{"description": "任务已结束"}, "412": OA{"description": "If-Match 不匹配（pause / resume）"}},
+				},
 				"patch": OA{
-					"summary":   "更新部署标签",
-					"responses": OA{"200": OA{"description": "更新成功"}},
+					"summary":     "部分更新部署（标签、副本、启动命令、制品版本、探针、资源限制、生命周期钩子、更新策略、重启策略、停止宽限期、任务参数 job），规格变化时按更新策略发布（只修改探针、资源限制或钩子不重建实例）",
+					"requestBody": OA{"required": true, "content": OA{"application/json": OA{"schema": OA{"type": "object"}}}},
+					"responses":   OA{"200": OA{"description": "更新成功，返回新修订号与发布进度"}, "409": OA{"description": "resourceVersion 冲突"}, "412": OA{"description": "If-Match 不匹配"}},
+				},
+				"put": OA{
+					"summary":     "整体更新部署规格",
+					"requestBody": OA{"required": true, "content": OA{"application/json": OA{"schema": OA{"type": "object"}}}},
+					"responses":   OA{"200": OA{"description": "更新成功，返回新修订号与发布进度"}, "409": OA{"description": "resourceVersion 冲突"}, "412": OA{"description": "If-Match 不匹配"}},
 				},
 				"delete": OA{
 					"summary":   "删除部署",
-					"responses": OA{"204": OA{"description": "删除成功"}},
+					"responses": OA{"204": OA{"description": "删除成功"}, "409": OA{"description": "resourceVersion 冲突"}, "412": OA{"description": "If-Match 不匹配"}},
+				},
+			},
+			"/v1/deployments/{id}/history": OA{
+				"get": OA{
+					"summary":   "获取部署修订历史",
+					"responses": OA{"200": OA{"description": "修订列表"}},
+				},
+			},
+			"/v1/deployments/{id}/rollback": OA{
+				"post": OA{
+					"summary": "回滚部署到指定修订",
+					"parameters": []OA{
+						{"name": "revision", "in": "query", "required": false, "schema": OA{"type": "integer"}, "description": "目标修订号，默认为上一个修订"},
+					},
+					"responses": OA{"200": OA{"description": "已创建新修订并开始发布"}, "404": OA{"description": "部署或修订不存在"}, "409": OA{"description": "未指定 revision 且没有上一个修订"}},
 				},
 			},
 			"/v1/tasks": OA{
 				"get": OA{
-					"summary":   "获取任务列表",
+					"summary": "获取任务列表",
+					"parameters": []OA{
+						{"name": "state", "in": "query", "required": false, "schema": OA{"type": "string"}, "description": "任务状态，逗号分隔"},
+						{"name": "executor", "in": "query", "required": false, "schema": OA{"type": "string"}, "description": "执行器"},
+						{"name": "name", "in": "query", "required": false, "schema": OA{"type": "string"}, "description": "任务名称"},
+						{"name": "labelSelector", "in": "query", "required": false, "schema": OA{"type": "string"}, "description": "标签选择器，如 env=prod,region in (a,b),!canary"},
+						{"name": "originTaskId", "in": "query", "required": false, "schema": OA{"type": "string"}, "description": "原始任务ID"},
+						{"name": "createdAfter", "in": "query", "required": false, "schema": OA{"type": "integer"}, "description": "创建时间下限（unix 秒）"},
+						{"name": "createdBefore", "in": "query", "required": false, "schema": OA{"type": "integer"}, "description": "创建时间上限（unix 秒）"},
+						{"name": "sort", "in": "query", "required": false, "schema": OA{"type": "string"}, "description": "排序字段：createdAt|startedAt|finishedAt|name"},
+						{"name": "order", "in": "query", "required": false, "schema": OA{"type": "string"}, "description": "asc|desc，默认 desc"},
+						{"name": "limit", "in": "query", "required": false, "schema": OA{"type": "integer"}, "description": "每页数量"},
+						{"name": "cursor", "in": "query", "required": false, "schema": OA{"type": "string"}, "description": "分页游标（来自响应头 X-Next-Cursor）"},
+					},
 					"responses": OA{"200": OA{"description": "任务列表"}},
 				},
 			},
@@ -365,7 +518,10 @@ func generateOpenAPIDoc() map[string]any {
 			},
 			"/v1/dag/workflows": OA{
 				"get": OA{
-					"summary":   "获取DAG工作流列表",
+					"summary": "获取DAG工作流列表",
+					"parameters": []OA{
+						{"name": "labelSelector", "in": "query", "required": false, "schema": OA{"type": "string"}, "description": "标签选择器，如 env=prod,region in (a,b),!canary"},
+					},
 					"responses": OA{"200": OA{"description": "DAG工作流列表"}},
 				},
 			},
@@ -392,6 +548,14 @@ func generateOpenAPIDoc() map[string]any {
 					"summary":   "获取指定任务定义",
 					"responses": OA{"200": OA{"description": "任务定义信息"}},
 				},
+				"patch": OA{
+					"summary": "更新任务定义",
+					"parameters": []OA{
+						{"name": "If-Match", "in": "header", "required": false, "schema": OA{"type": "string"}, "description": "期望的 resourceVersion（ETag，可用逗号列出多个；* 表示资源须存在），不匹配返回 412"},
+					},
+					"requestBody": OA{"required": true, "content": OA{"application/json": OA{"schema": OA{"type": "object"}}}},
+					"responses":   OA{"200": OA{"description": "更新成功"}, "409": OA{"description": "resourceVersion 冲突"}, "412": OA{"description": "If-Match 不匹配"}},
+				},
 			},
 			"/v1/kv/{namespace}/{key}": OA{
 				"get": OA{
@@ -407,17 +571,83 @@ func generateOpenAPIDoc() map[string]any {
 					"parameters": []OA{
 						{"name": "namespace", "in": "path", "required": true, "schema": OA{"type": "string"}, "description": "命名空间"},
 						{"name": "key", "in": "path", "required": true, "schema": OA{"type": "string"}, "description": "键名"},
+						{"name": "If-Match", "in": "header", "required": false, "schema": OA{"type": "string"}, "description": "期望的 resourceVersion（ETag，可用逗号列出多个；* 表示资源须存在），不匹配返回 412"},
 					},
 					"requestBody": OA{"required": true, "content": OA{"application/json": OA{"schema": OA{"type": "object"}}}},
-					"responses":   OA{"200": OA{"description": "设置成功"}},
+					"responses":   OA{"200": OA{"description": "设置成功"}, "409": OA{"description": "resourceVersion 冲突"}, "412": OA{"description": "If-Match 不匹配"}},
 				},
 				"delete": OA{
 					"summary": "删除键值对",
 					"parameters": []OA{
 						{"name": "namespace", "in": "path", "required": true, "schema": OA{"type": "string"}, "description": "命名空间"},
 						{"name": "key", "in": "path", "required": true, "schema": OA{"type": "string"}, "description": "键名"},
+						{"name": "If-Match", "in": "header", "required": false, "schema": OA{"type": "string"}, "description": "期望的 resourceVersion（ETag，可用逗号列出多个；* 表示资源须存在），不匹配返回 412"},
 					},
-					"responses": OA{"204": OA{"description": "删除成功"}},
+					"responses": OA{"204": OA{"description": "删除成功"}, "412": OA{"description": "If-Match 不匹配"}},
+				},
+			},
+			"/v1/configmaps": OA{
+				"get": OA{"summary": "列出配置集", "responses": OA{"200": OA{"description": "配置集列表"}}},
+				"post": OA{
+					"summary":     "创建配置集",
+					"requestBody": OA{"required": true, "content": OA{"application/json": OA{"schema": OA{"type": "object", "properties": OA{"name": OA{"type": "string"}, "data": OA{"type": "object", "additionalProperties": OA{"type": "string"}}}}}}},
+					"responses":   OA{"200": OA{"description": "创建成功"}, "409": OA{"description": "已存在"}},
+				},
+			},
+			"/v1/configmaps/{name}": OA{
+				"get": OA{
+					"summary":    "获取配置集",
+					"parameters": []OA{{"name": "name", "in": "path", "required": true, "schema": OA{"type": "string"}}},
+					"responses":  OA{"200": OA{"description": "配置集"}, "404": OA{"description": "不存在"}},
+				},
+				"put": OA{
+					"summary": "创建或更新配置集（引用它的部署记录新修订并按更新策略替换实例，旧实例被替换前保持原配置）",
+					"parameters": []OA{
+						{"name": "name", "in": "path", "required": true, "schema": OA{"type": "string"}},
+						{"name": "If-Match", "in": "header", "required": false, "schema": OA{"type": "string"}, "description": "期望的 resourceVersion（ETag，可用逗号列出多个；* 表示资源须存在），不匹配返回 412"},
+					},
+					"requestBody": OA{"required": true, "content": OA{"application/json": OA{"schema": OA{"type": "object", "properties": OA{"data": OA{"type": "object", "additionalProperties": OA{"type": "string"}}, "resourceVersion": OA{"type": "integer"}}}}}},
+					"responses":   OA{"200": OA{"description": "保存成功，rollouts 为开始替换实例的部署"}, "409": OA{"description": "resourceVersion 冲突"}, "412": OA{"description": "If-Match 不匹配"}},
+				},
+				"delete": OA{
+					"summary": "删除配置集",
+					"parameters": []OA{
+						{"name": "name", "in": "path", "required": true, "schema": OA{"type": "string"}},
+						{"name": "If-Match", "in": "header", "required": false, "schema": OA{"type": "string"}, "description": "期望的 resourceVersion（ETag，可用逗号列出多个；* 表示资源须存在），不匹配返回 412"},
+					},
+					"responses": OA{"204": OA{"description": "删除成功"}, "409": OA{"description": "仍被部署引用"}, "412": OA{"description": "If-Match 不匹配"}},
+				},
+			},
+			"/v1/secrets": OA{
+				"get": OA{"summary": "列出密钥（只返回键名）", "responses": OA{"200": OA{"description": "密钥列表"}}},
+				"post": OA{
+					"summary":     "创建密钥",
+					"requestBody": OA{"required": true, "content": OA{"application/json": OA{"schema": OA{"type": "object", "properties": OA{"name": OA{"type": "string"}, "data": OA{"type": "object", "additionalProperties": OA{"type": "string"}}}}}}},
+					"responses":   OA{"200": OA{"description": "创建成功"}, "409": OA{"description": "已存在"}},
+				},
+			},
+			"/v1/secrets/{name}": OA{
+				"get": OA{
+					"summary":    "获取密钥（只返回键名）",
+					"parameters": []OA{{"name": "name", "in": "path", "required": true, "schema": OA{"type": "string"}}},
+					"responses":  OA{"200": OA{"description": "密钥"}, "404": OA{"description": "不存在"}},
+				},
+				"put": OA{
+					"summary": "创建或更新密钥（引用它的部署记录新修订并按更新策略替换实例，旧实例被替换前保持原配置）",
+					"parameters": []OA{
+						{"name": "name", "in": "path", "required": true, "schema": OA{"type": "string"}},
+						{"name": "If-Match", "in": "header", "required": false, "schema": OA{"type": "string"}, "description": "期望的 resourceVersion（ETag，可用逗号列出多个；* 表示资源须存在），不匹配返回 412"},
+					},
+					"requestBody": OA{"required": true, "content": OA{"application/json": OA{"schema": OA{"type": "object", "properties": OA{"data": OA{"type": "object", "additionalProperties": OA{"type": "string"}}, "resourceVersion": OA{"type": "integer"}}}}}},
+					"responses":   OA{"200": OA{"description": "保存成功，rollouts 为开始替换实例的部署"}, "409": OA{"description": "resourceVersion 冲突"}, "412": OA{"description": "If-Match 不匹配"}},
+				},
+				"delete": OA{
+					"summary": "删除密钥",
+					"parameters": []OA{
+						{"name": "name", "in": "path", "required": true, "schema": OA{"type": "string"}},
+						{"name": "If-Match", "in": "header", "required": false, "schema": OA{"type": "string"}, "description": "期望的 resourceVersion（ETag，可用逗号列出多个；* 表示资源须存在），不匹配返回 412"},
+					},
+					"responses": OA{"204": OA{"description": "删除成功"}, "409": OA{"description": "仍被部署引用"}, "412": OA{"description": "If-Match 不匹配"}},
 				},
 			},
 		},
@@ -62,6 +62,15 @@ func PublishTasks() {
 	Publish("__tasks__")
 }
 
+// Events channel (global)
+func SubscribeEvents() (chan struct{}, func()) {
+	return Subscribe("__events__")
+}
+
+func PublishEvents() {
+	Publish("__events__")
+}
+
 // KV channel (per namespace)
 func SubscribeKV(namespace string) (chan struct{}, func()) {
 	return Subscribe("__kv__:" + namespace)
@@ -0,0 +1,167 @@
+package scheduler
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// 节点容量评估：根据心跳上报的资源判断节点能否运行实例以及负载高低。
+// 未上报资源的节点（旧版 Agent）视为满足要求，只按已分配实例数评分。
+
+const (
+	minMemAvailableMB = 64  // 可用内存低于此值的节点不再接收新实例
+	minDiskFreeMB     = 100 // 数据目录磁盘剩余低于此值的节点不再接收新实例
+	pressureWeight    = 4.0 // 资源满载相当于多承载的实例数
+	imagePullPenalty  = 0.5 // 节点本地没有所需镜像时的额外评分
+	labelPrefix       = "plum.io/"
+)
+
+// 由上报资源派生的内置节点标签，可用于 nodeSelector（如 plum.io/arch=arm64）
+const (
+	LabelArch   = labelPrefix + "arch"
+	LabelOS     = labelPrefix + "os"
+	LabelDocker = labelPrefix + "docker"
+)
+
+// Requirement 实例对节点的要求
+type Requirement struct {
+	Image string // 镜像应用的 repository:tag，ZIP 应用为空
+}
+
+// RequirementFor 根据制品地址得到实例对节点的要求
+func RequirementFor(artifactURL string) Requirement {
+	if !strings.HasPrefix(artifactURL, "image://") {
+		return Requirement{}
+	}
+	art, ok, _ := store.Current.GetArtifact(strings.TrimPrefix(artifactURL, "image://"))
+	if !ok {
+		// 找不到制品信息时至少要求节点能运行容器
+		return Requirement{Image: artifactURL}
+	}
+	return Requirement{Image: art.ImageRepository + ":" + art.ImageTag}
+}
+
+// NewCandidate 由节点和其上已分配的实例数构造候选节点
+func NewCandidate(n store.Node, load int) Candidate {
+	labels := make(map[string]string, len(n.Labels)+3)
+	for k, v := range n.Labels {
+		labels[k] = v
+	}
+	if r := n.Resources; r != nil {
+		labels[LabelArch] = r.Arch
+		labels[LabelOS] = r.OS
+		if r.Docker {
+			labels[LabelDocker] = "true"
+		} else {
+			labels[LabelDocker] = "false"
+		}
+	}
+	cordoned := n.Status != "" && n.Status != store.NodeSchedulable
+	return Candidate{NodeID: n.NodeID, Labels: labels, Load: load, Resources: n.Resources, Cordoned: cordoned}
+}
+
+// Fits 节点能否接收新实例（已封锁的节点不接收）
+func (c Candidate) Fits(req Requirement) bool {
+	if c.Cordoned {
+		return false
+	}
+	r := c.Resources
+	if r == nil {
+		return true
+	}
+	if req.Image != "" && !r.Docker {
+		return false
+	}
+	if r.MemTotalMB > 0 && r.MemAvailableMB < minMemAvailableMB {
+		return false
+	}
+	if r.DiskTotalMB > 0 && r.DiskFreeMB < minDiskFreeMB {
+		return false
+	}
+	return true
+}
+
+// Score 负载评分，越小越好：已分配实例数 + 资源压力（CPU、内存、磁盘使用率的最大值）
+func (c Candidate) Score(req Requirement) float64 {
+	s := float64(c.Load)
+	r := c.Resources
+	if r == nil {
+		return s
+	}
+	pressure := 0.0
+	if r.CPUCores > 0 {
+		pressure = max(pressure, r.CPULoad/float64(r.CPUCores))
+	}
+	if r.MemTotalMB > 0 {
+		pressure = max(pressure, 1-float64(r.MemAvailableMB)/float64(r.MemTotalMB))
+	}
+	if r.DiskTotalMB > 0 {
+		pressure = max(pressure, 1-float64(r.DiskFreeMB)/float64(r.DiskTotalMB))
+	}
+	s += pressureWeight * pressure
+	if req.Image != "" && !containsImage(r.Images, req.Image) {
+		s += imagePullPenalty
+	}
+	return s
+}
+
+func containsImage(images []string, image string) bool {
+	for _, img := range images {
+		if img == image {
+			return true
+		}
+	}
+	return false
+}
+
+// Best 为单个实例选择节点：优先同一部署实例较少的节点，其次评分最低的节点
+func Best(nodes []Candidate, req Requirement, sameDeployment map[string]int) (Candidate, bool) {
+	sorted := append([]Candidate(nil), nodes...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].NodeID < sorted[j].NodeID })
+	var best *Candidate
+	for i := range sorted {
+		n := &sorted[i]
+		if !n.Fits(req) {
+			continue
+		}
+		if best == nil || less(
+			[]float64{float64(sameDeployment[n.NodeID]), n.Score(req)},
+			[]float64{float64(sameDeployment[best.NodeID]), best.Score(req)}) {
+			best = n
+		}
+	}
+	if best == nil {
+		return Candidate{}, false
+	}
+	return *best, true
+}
+
+// PlacementFor 读取部署当前修订中与制品 + 启动命令对应条目的自动调度约束（没有时返回 nil）。
+// 修订规格为 deployment.Entry 列表的 JSON，这里只解析需要的字段。
+func PlacementFor(deploymentID string, artifactURL string, startCmd string) *Placement {
+	d, ok, err := store.Current.GetDeployment(deploymentID)
+	if err != nil || !ok || d.Revision == 0 {
+		return nil
+	}
+	rev, ok, err := store.Current.GetDeploymentRevision(deploymentID, d.Revision)
+	if err != nil || !ok {
+		return nil
+	}
+	var entries []struct {
+		ArtifactURL string     `json:"artifactUrl"`
+		StartCmd    string     `json:"startCmd"`
+		Placement   *Placement `json:"placement"`
+	}
+	if json.Unmarshal([]byte(rev.SpecJSON), &entries) != nil {
+		return nil
+	}
+	for _, e := range entries {
+		if e.ArtifactURL == artifactURL && e.StartCmd == startCmd {
+			return e.Placement
+		}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package scheduler
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// 副本自动调度：调用方只给出总副本数和约束，由控制器选择节点。
+
+// Placement 调度约束
+type Placement struct {
+	Replicas     int    `json:"replicas"`               // 总副本数
+	NodeSelector string `json:"nodeSelector,omitempty"` // 节点标签选择器，如 zone in (a,b),!gpu
+	SpreadBy     string `json:"spreadBy,omitempty"`     // 拓扑标签键，副本在其各取值间均匀分布
+	AntiAffinity bool   `json:"antiAffinity,omitempty"` // 副本互斥：每个节点最多一个副本
+	MaxPerNode   int    `json:"maxPerNode,omitempty"`   // 每个节点的副本上限（0 表示不限）
+}
+
+// Validate 校验约束
+func (p Placement) Validate() error {
+	if p.Replicas < 0 {
+		return errors.New("placement replicas must be >= 0")
+	}
+	if p.MaxPerNode < 0 {
+		return errors.New("placement maxPerNode must be >= 0")
+	}
+	if _, err := store.ParseSelector(p.NodeSelector); err != nil {
+		return fmt.Errorf("placement nodeSelector: %w", err)
+	}
+	return nil
+}
+
+// perNodeLimit 每个节点的副本上限（0 表示不限）
+func (p Placement) perNodeLimit() int {
+	if p.AntiAffinity && (p.MaxPerNode == 0 || p.MaxPerNode > 1) {
+		return 1
+	}
+	return p.MaxPerNode
+}
+
+// Candidate 可调度的（健康）节点
+type Candidate struct {
+	NodeID    string
+	Labels    map[string]string // 含由上报资源派生的内置标签
+	Load      int               // 节点上已分配的实例数
+	Resources *store.NodeResources
+	Cordoned  bool // 已封锁（维护或排空中）：保留已有副本，但不接收新副本
+}
+
+// Place 为 p.Replicas 个副本选择节点。existing 为各节点上已有的副本数，
+// 在满足约束的前提下尽量保持不动；新副本只放到容量满足 req 的节点，
+// 优先副本较少的拓扑域、再到负载评分较低的节点。
+// 返回 nodeId -> 副本数，以及因约束或容量不足而无法调度的副本数。
+func Place(p Placement, req Requirement, nodes []Candidate, existing map[string]int) (map[string]int, int) {
+	sel, err := store.ParseSelector(p.NodeSelector)
+	if err != nil {
+		return map[string]int{}, p.Replicas
+	}
+	limit := p.perNodeLimit()
+	var eligible []Candidate
+	for _, n := range nodes {
+		if !sel.Matches(n.Labels) {
+			continue
+		}
+		if _, ok := n.Labels[p.SpreadBy]; p.SpreadBy != "" && !ok {
+			continue
+		}
+		eligible = append(eligible, n)
+	}
+	sort.Slice(eligible, func(i, j int) bool { return eligible[i].NodeID < eligible[j].NodeID })
+
+	domain := func(n Candidate) string {
+		if p.SpreadBy == "" {
+			return ""
+		}
+		return n.Labels[p.SpreadBy]
+	}
+	out := map[string]int{}
+	perDomain := map[string]int{}
+	placed := 0
+	// 保留已有副本（不超过单节点上限）
+	for _, n := range eligible {
+		k := existing[n.NodeID]
+		if limit > 0 && k > limit {
+			k = limit
+		}
+		if k > 0 {
+			out[n.NodeID] = k
+			perDomain[domain(n)] += k
+			placed += k
+		}
+	}
+	// 多余副本：从副本最多的拓扑域 / 节点上移除
+	for placed > p.Replicas {
+		var victim *Candidate
+		for i := range eligible {
+			n := &eligible[i]
+			if out[n.NodeID] == 0 {
+				continue
+			}
+			if victim == nil || less(
+				[]float64{float64(perDomain[domain(*victim)]), float64(out[victim.NodeID]), victim.Score(req)},
+				[]float64{float64(perDomain[domain(*n)]), float64(out[n.NodeID]), n.Score(req)}) {
+				victim = n
+			}
+		}
+		out[victim.NodeID]--
+		perDomain[domain(*victim)]--
+		placed--
+	}
+	// 新副本：副本少的拓扑域优先，其次是本部署副本少、负载评分低的节点
+	for placed < p.Replicas {
+		var best *Candidate
+		for i := range eligible {
+			n := &eligible[i]
+			if (limit > 0 && out[n.NodeID] >= limit) || !n.Fits(req) {
+				continue
+			}
+			if best == nil || less(
+				[]float64{float64(perDomain[domain(*n)]), float64(out[n.NodeID]), n.Score(req)},
+				[]float64{float64(perDomain[domain(*best)]), float64(out[best.NodeID]), best.Score(req)}) {
+				best = n
+			}
+		}
+		if best == nil {
+			break
+		}
+		out[best.NodeID]++
+		best.Load++
+		perDomain[domain(*best)]++
+		placed++
+	}
+	for id, k := range out {
+		if k == 0 {
+			delete(out, id)
+		}
+	}
+	return out, p.Replicas - placed
+}
+
+// less 按字典序比较两组评分
+func less(a, b []float64) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package scheduler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlace(t *testing.T) {
+	zone := func(id, z string) Candidate { return Candidate{NodeID: id, Labels: map[string]string{"zone": z}} }
+	nodes := func(ids ...string) []Candidate {
+		out := make([]Candidate, 0, len(ids))
+		for _, id := range ids {
+			out = append(out, Candidate{NodeID: id, Labels: map[string]string{}})
+		}
+		return out
+	}
+	tests := []struct {
+		name              string
+		p                 Placement
+		nodes             []Candidate
+		existing          map[string]int
+		want              map[string]int
+		wantUnschedulable int
+	}{
+		{name: "replicas spread over nodes", p: Placement{Replicas: 3}, nodes: nodes("a", "b", "c"),
+			want: map[string]int{"a": 1, "b": 1, "c": 1}},
+		{name: "spread across topology domains", p: Placement{Replicas: 2, SpreadBy: "zone"},
+			nodes: []Candidate{zone("a", "x"), zone("b", "x"), zone("c", "y")},
+			want:  map[string]int{"a": 1, "c": 1}},
+		{name: "nodes without the spread label are skipped", p: Placement{Replicas: 2, SpreadBy: "zone"},
+			nodes: append(nodes("a"), zone("b", "x")),
+			want:  map[string]int{"b": 2}},
+		{name: "anti-affinity allows one replica per node", p: Placement{Replicas: 3, AntiAffinity: true}, nodes: nodes("a", "b"),
+			want: map[string]int{"a": 1, "b": 1}, wantUnschedulable: 1},
+		{name: "maxPerNode caps each node", p: Placement{Replicas: 5, MaxPerNode: 2}, nodes: nodes("a", "b"),
+			want: map[string]int{"a": 2, "b": 2}, wantUnschedulable: 1},
+		{name: "anti-affinity overrides a larger maxPerNode", p: Placement{Replicas: 2, AntiAffinity: true, MaxPerNode: 3}, nodes: nodes("a"),
+			want: map[string]int{"a": 1}, wantUnschedulable: 1},
+		{name: "node selector filters nodes", p: Placement{Replicas: 2, NodeSelector: "zone=y"},
+			nodes: []Candidate{zone("a", "x"), zone("b", "y")},
+			want:  map[string]int{"b": 2}},
+		{name: "existing replicas stay put", p: Placement{Replicas: 2}, nodes: nodes("a", "b", "c"),
+			existing: map[string]int{"c": 2}, want: map[string]int{"c": 2}},
+		{name: "existing replicas above the limit move", p: Placement{Replicas: 2, AntiAffinity: true}, nodes: nodes("a", "b"),
+			existing: map[string]int{"a": 2}, want: map[string]int{"a": 1, "b": 1}},
+		{name: "scale down removes from the busiest node", p: Placement{Replicas: 2}, nodes: nodes("a", "b"),
+			existing: map[string]int{"a": 2, "b": 1}, want: map[string]int{"a": 1, "b": 1}},
+		{name: "cordoned node keeps replicas but takes no new ones", p: Placement{Replicas: 2},
+			nodes:    []Candidate{{NodeID: "a"}, {NodeID: "b", Cordoned: true}},
+			existing: map[string]int{"b": 1}, want: map[string]int{"a": 1, "b": 1}},
+		{name: "invalid selector schedules nothing", p: Placement{Replicas: 2, NodeSelector: "zone in x"}, nodes: nodes("a"),
+			want: map[string]int{}, wantUnschedulable: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, unschedulable := Place(tt.p, Requirement{}, tt.nodes, tt.existing)
+			if !reflect.DeepEqual(got, tt.want) || unschedulable != tt.wantUnschedulable {
+				t.Errorf("Place = %v, %d unschedulable; want %v, %d", got, unschedulable, tt.want, tt.wantUnschedulable)
+			}
+		})
+	}
+}
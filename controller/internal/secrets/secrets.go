@@ -0,0 +1,126 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// 密钥数据的静态加密：AES-256-GCM，密文格式为 "v1:" + base64(nonce || ciphertext)。
+// 主密钥取自 CONTROLLER_SECRET_KEY（base64 编码的 32 字节，或任意口令经 SHA-256 派生），
+// 未设置时使用 <数据目录>/secret.key，不存在则自动生成（权限 0600，须与数据库一同备份）。
+
+const prefix = "v1:"
+
+var (
+	mu   sync.RWMutex
+	aead cipher.AEAD
+)
+
+// Init 加载主密钥（启动时调用）
+func Init(dataDir string) error {
+	key, err := loadKey(dataDir)
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	g, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	aead = g
+	mu.Unlock()
+	return nil
+}
+
+func loadKey(dataDir string) ([]byte, error) {
+	if v := strings.TrimSpace(os.Getenv("CONTROLLER_SECRET_KEY")); v != "" {
+		if k, err := base64.StdEncoding.DecodeString(v); err == nil && len(k) == 32 {
+			return k, nil
+		}
+		sum := sha256.Sum256([]byte(v))
+		return sum[:], nil
+	}
+	path := filepath.Join(dataDir, "secret.key")
+	if data, err := os.ReadFile(path); err == nil {
+		k, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(k) != 32 {
+			return nil, fmt.Errorf("invalid secret key file %s", path)
+		}
+		return k, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	k := make([]byte, 32)
+	if _, err := rand.Read(k); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(k)+"\n"), 0600); err != nil {
+		return nil, err
+	}
+	log.Printf("secrets: generated new encryption key %s (back it up together with the database)", path)
+	return k, nil
+}
+
+func current() (cipher.AEAD, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	if aead == nil {
+		return nil, errors.New("secret encryption key not initialized")
+	}
+	return aead, nil
+}
+
+// Encrypt 加密
+func Encrypt(plain []byte) ([]byte, error) {
+	g, err := current()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, g.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	sealed := g.Seal(nonce, nonce, plain, nil)
+	return []byte(prefix + base64.StdEncoding.EncodeToString(sealed)), nil
+}
+
+// Decrypt 解密（主密钥不匹配或数据被篡改时返回错误）
+func Decrypt(data []byte) ([]byte, error) {
+	g, err := current()
+	if err != nil {
+		return nil, err
+	}
+	s, ok := strings.CutPrefix(string(data), prefix)
+	if !ok {
+		return nil, errors.New("unknown secret encoding")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < g.NonceSize() {
+		return nil, errors.New("secret data too short")
+	}
+	plain, err := g.Open(nil, sealed[:g.NonceSize()], sealed[g.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt secret: %w", err)
+	}
+	return plain, nil
+}
@@ -0,0 +1,206 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// 标签选择器（Kubernetes 风格），例如：env=prod,region in (a,b),!canary
+
+type SelectorOperator string
+
+const (
+	SelectorEquals       SelectorOperator = "="     // key=value / key==value
+	SelectorNotEquals    SelectorOperator = "!="    // key!=value（key 不存在也匹配）
+	SelectorIn           SelectorOperator = "in"    // key in (a,b)
+	SelectorNotIn        SelectorOperator = "notin" // key notin (a,b)（key 不存在也匹配）
+	SelectorExists       SelectorOperator = "exists"
+	SelectorDoesNotExist SelectorOperator = "!"
+)
+
+// Requirement 单个选择条件
+type Requirement struct {
+	Key      string
+	Operator SelectorOperator
+	Values   []string
+}
+
+// Selector 多个条件之间为 AND 关系；空选择器匹配所有对象
+type Selector []Requirement
+
+// SelectorFromSet 由等值标签集合构造选择器（按键排序，保证结果稳定）
+func SelectorFromSet(labels map[string]string) Selector {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	sel := make(Selector, 0, len(keys))
+	for _, k := range keys {
+		sel = append(sel, Requirement{Key: k, Operator: SelectorEquals, Values: []string{labels[k]}})
+	}
+	return sel
+}
+
+// Empty 是否为空选择器
+func (s Selector) Empty() bool { return len(s) == 0 }
+
+// Matches 判断标签是否满足所有条件
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r Requirement) Matches(labels map[string]string) bool {
+	v, ok := labels[r.Key]
+	switch r.Operator {
+	case SelectorEquals, SelectorIn:
+		return ok && containsString(r.Values, v)
+	case SelectorNotEquals, SelectorNotIn:
+		return !ok || !containsString(r.Values, v)
+	case SelectorExists:
+		return ok
+	case SelectorDoesNotExist:
+		return !ok
+	}
+	return false
+}
+
+func (s Selector) String() string {
+	parts := make([]string, 0, len(s))
+	for _, r := range s {
+		switch r.Operator {
+		case SelectorExists:
+			parts = append(parts, r.Key)
+		case SelectorDoesNotExist:
+			parts = append(parts, "!"+r.Key)
+		case SelectorIn, SelectorNotIn:
+			parts = append(parts, r.Key+" "+string(r.Operator)+" ("+strings.Join(r.Values, ",")+")")
+		default:
+			parts = append(parts, r.Key+string(r.Operator)+strings.Join(r.Values, ""))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseSelector 解析选择器字符串，支持 =、==、!=、in、notin、key、!key
+func ParseSelector(s string) (Selector, error) {
+	var sel Selector
+	for _, part := range splitSelector(s) {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		r, err := parseRequirement(part)
+		if err != nil {
+			return nil, err
+		}
+		sel = append(sel, r)
+	}
+	return sel, nil
+}
+
+// splitSelector 按顶层逗号切分（括号内的逗号不切分）
+func splitSelector(s string) []string {
+	var out []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				out = append(out, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(out, s[start:])
+}
+
+func parseRequirement(part string) (Requirement, error) {
+	if strings.HasPrefix(part, "!") && !strings.Contains(part, "=") {
+		key := strings.TrimSpace(part[1:])
+		if err := validateSelectorKey(key); err != nil {
+			return Requirement{}, err
+		}
+		return Requirement{Key: key, Operator: SelectorDoesNotExist}, nil
+	}
+	for _, op := range []string{"!=", "==", "="} {
+		if i := strings.Index(part, op); i >= 0 {
+			key := strings.TrimSpace(part[:i])
+			val := strings.TrimSpace(part[i+len(op):])
+			if err := validateSelectorKey(key); err != nil {
+				return Requirement{}, err
+			}
+			if strings.ContainsAny(val, "=!() ") {
+				return Requirement{}, fmt.Errorf("invalid value %q in selector", val)
+			}
+			o := SelectorEquals
+			if op == "!=" {
+				o = SelectorNotEquals
+			}
+			return Requirement{Key: key, Operator: o, Values: []string{val}}, nil
+		}
+	}
+	fields := strings.Fields(part)
+	if len(fields) == 1 {
+		if err := validateSelectorKey(fields[0]); err != nil {
+			return Requirement{}, err
+		}
+		return Requirement{Key: fields[0], Operator: SelectorExists}, nil
+	}
+	// key in (a,b) / key notin (a,b)
+	key := fields[0]
+	if err := validateSelectorKey(key); err != nil {
+		return Requirement{}, err
+	}
+	rest := strings.TrimSpace(strings.TrimPrefix(part, key))
+	var op SelectorOperator
+	switch {
+	case strings.HasPrefix(rest, "notin"):
+		op, rest = SelectorNotIn, strings.TrimSpace(rest[len("notin"):])
+	case strings.HasPrefix(rest, "in"):
+		op, rest = SelectorIn, strings.TrimSpace(rest[len("in"):])
+	default:
+		return Requirement{}, fmt.Errorf("invalid selector requirement %q", part)
+	}
+	if !strings.HasPrefix(rest, "(") || !strings.HasSuffix(rest, ")") {
+		return Requirement{}, fmt.Errorf("invalid value set in %q", part)
+	}
+	var values []string
+	for _, v := range strings.Split(rest[1:len(rest)-1], ",") {
+		v = strings.TrimSpace(v)
+		if strings.ContainsAny(v, "=!() ") {
+			return Requirement{}, fmt.Errorf("invalid value %q in selector", v)
+		}
+		values = append(values, v)
+	}
+	if len(values) == 1 && values[0] == "" {
+		return Requirement{}, fmt.Errorf("empty value set in %q", part)
+	}
+	return Requirement{Key: key, Operator: op, Values: values}, nil
+}
+
+func validateSelectorKey(key string) error {
+	if key == "" || strings.ContainsAny(key, "=!(), \t") {
+		return fmt.Errorf("invalid label key %q in selector", key)
+	}
+	return nil
+}
+
+func containsString(list []string, v string) bool {
+	for _, x := range list {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
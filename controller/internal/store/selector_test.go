@@ -0,0 +1,82 @@
+package store
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSelector(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Selector
+		wantErr bool
+	}{
+		{in: "", want: nil},
+		{in: "env=prod", want: Selector{{Key: "env", Operator: SelectorEquals, Values: []string{"prod"}}}},
+		{in: "env==prod", want: Selector{{Key: "env", Operator: SelectorEquals, Values: []string{"prod"}}}},
+		{in: "env!=prod", want: Selector{{Key: "env", Operator: SelectorNotEquals, Values: []string{"prod"}}}},
+		{in: "gpu", want: Selector{{Key: "gpu", Operator: SelectorExists}}},
+		{in: "!canary", want: Selector{{Key: "canary", Operator: SelectorDoesNotExist}}},
+		{in: "zone in (a, b)", want: Selector{{Key: "zone", Operator: SelectorIn, Values: []string{"a", "b"}}}},
+		{in: "zone notin (a)", want: Selector{{Key: "zone", Operator: SelectorNotIn, Values: []string{"a"}}}},
+		{in: " env = prod , zone in (a,b),!canary ", want: Selector{
+			{Key: "env", Operator: SelectorEquals, Values: []string{"prod"}},
+			{Key: "zone", Operator: SelectorIn, Values: []string{"a", "b"}},
+			{Key: "canary", Operator: SelectorDoesNotExist},
+		}},
+		{in: "env=", want: Selector{{Key: "env", Operator: SelectorEquals, Values: []string{""}}}},
+		{in: "=prod", wantErr: true},
+		{in: "env=a=b", wantErr: true},
+		{in: "zone in a,b", wantErr: true},
+		{in: "zone in ()", wantErr: true},
+		{in: "zone between (a,b)", wantErr: true},
+		{in: "zone in (a b)", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseSelector(tt.in)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseSelector(%q) = %v, want error", tt.in, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSelector(%q): %v", tt.in, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseSelector(%q) = %#v, want %#v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	labels := map[string]string{"env": "prod", "zone": "a"}
+	tests := []struct {
+		sel  string
+		want bool
+	}{
+		{"", true},
+		{"env=prod", true},
+		{"env=dev", false},
+		{"env!=dev", true},
+		{"team!=x", true}, // 键不存在也匹配
+		{"zone in (a,b)", true},
+		{"zone notin (a,b)", false},
+		{"team notin (x)", true},
+		{"env", true},
+		{"team", false},
+		{"!team", true},
+		{"env=prod,!zone", false},
+	}
+	for _, tt := range tests {
+		sel, err := ParseSelector(tt.sel)
+		if err != nil {
+			t.Fatalf("ParseSelector(%q): %v", tt.sel, err)
+		}
+		if got := sel.Matches(labels); got != tt.want {
+			t.Errorf("%q matches %v = %v, want %v", tt.sel, labels, got, tt.want)
+		}
+	}
+}
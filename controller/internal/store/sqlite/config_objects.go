@@ -0,0 +1,100 @@
+package sqlitestore
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// 配置集与密钥
+
+func (s *sqliteStore) PutConfigObject(o store.ConfigObject, ifMatch int64) (int64, error) {
+	now := time.Now().Unix()
+	return s.withResourceVersion(func(tx *sql.Tx, rv int64) error {
+		if ifMatch > 0 {
+			res, err := tx.Exec(`UPDATE config_objects SET data=?, updated_at=?, resource_version=? WHERE kind=? AND name=? AND resource_version=?`,
+				o.Data, now, rv, o.Kind, o.Name, ifMatch)
+			if err != nil {
+				return err
+			}
+			return checkAffected(res)
+		}
+		_, err := tx.Exec(`
+			INSERT INTO config_objects(kind, name, data, updated_at, resource_version)
+			VALUES(?, ?, ?, ?, ?)
+			ON CONFLICT(kind, name) DO UPDATE SET
+				data=excluded.data,
+				updated_at=excluded.updated_at,
+				resource_version=excluded.resource_version
+		`, o.Kind, o.Name, o.Data, now, rv)
+		return err
+	})
+}
+
+func (s *sqliteStore) GetConfigObject(kind store.ConfigKind, name string) (store.ConfigObject, bool, error) {
+	var o store.ConfigObject
+	err := s.db.QueryRow(`SELECT kind, name, data, updated_at, resource_version FROM config_objects WHERE kind=? AND name=?`, kind, name).
+		Scan(&o.Kind, &o.Name, &o.Data, &o.UpdatedAt, &o.ResourceVersion)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.ConfigObject{}, false, nil
+		}
+		return store.ConfigObject{}, false, err
+	}
+	return o, true, nil
+}
+
+func (s *sqliteStore) ListConfigObjects(kind store.ConfigKind) ([]store.ConfigObject, error) {
+	rows, err := s.db.Query(`SELECT kind, name, data, updated_at, resource_version FROM config_objects WHERE kind=? ORDER BY name`, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []store.ConfigObject
+	for rows.Next() {
+		var o store.ConfigObject
+		if err := rows.Scan(&o.Kind, &o.Name, &o.Data, &o.UpdatedAt, &o.ResourceVersion); err != nil {
+			return nil, err
+		}
+		out = append(out, o)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) DeleteConfigObject(kind store.ConfigKind, name string, ifMatch int64) error {
+	if ifMatch > 0 {
+		res, err := s.db.Exec(`DELETE FROM config_objects WHERE kind=? AND name=? AND resource_version=?`, kind, name, ifMatch)
+		if err != nil {
+			return err
+		}
+		return checkAffected(res)
+	}
+	_, err := s.db.Exec(`DELETE FROM config_objects WHERE kind=? AND name=?`, kind, name)
+	return err
+}
+
+func (s *sqliteStore) PutConfigSnapshot(hash string, data []byte) error {
+	_, err := s.db.Exec(`INSERT OR IGNORE INTO config_snapshots(config_hash, data, created_at) VALUES(?,?,?)`, hash, data, time.Now().Unix())
+	return err
+}
+
+func (s *sqliteStore) GetConfigSnapshot(hash string) ([]byte, bool, error) {
+	var data []byte
+	err := s.db.QueryRow(`SELECT data FROM config_snapshots WHERE config_hash=?`, hash).Scan(&data)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+func (s *sqliteStore) PruneConfigSnapshots() error {
+	_, err := s.db.Exec(`DELETE FROM config_snapshots
+		WHERE config_hash NOT IN (SELECT config_hash FROM assignments WHERE config_hash IS NOT NULL)
+		AND NOT EXISTS (SELECT 1 FROM deployment_revisions r WHERE instr(r.spec_json, config_snapshots.config_hash) > 0)`)
+	return err
+}
@@ -36,10 +36,12 @@ func (s *sqliteStore) CreateWorkflowDAG(dag store.WorkflowDAG) (string, error) {
 		return "", err
 	}
 
+	labelsJSON, _ := json.Marshal(dag.Labels)
+
 	_, err = s.db.Exec(`
-		INSERT INTO workflow_dags(workflow_id, name, version, nodes, edges, start_nodes, created_at)
-		VALUES(?, ?, ?, ?, ?, ?, ?)
-	`, dag.WorkflowID, dag.Name, dag.Version, string(nodesJSON), string(edgesJSON), string(startNodesJSON), dag.CreatedAt)
+		INSERT INTO workflow_dags(workflow_id, name, version, nodes, edges, start_nodes, created_at, labels)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)
+	`, dag.WorkflowID, dag.Name, dag.Version, string(nodesJSON), string(edgesJSON), string(startNodesJSON), dag.CreatedAt, string(labelsJSON))
 
 	if err != nil {
 		return "", err
@@ -49,14 +51,14 @@ func (s *sqliteStore) CreateWorkflowDAG(dag store.WorkflowDAG) (string, error) {
 
 func (s *sqliteStore) GetWorkflowDAG(id string) (store.WorkflowDAG, bool, error) {
 	row := s.db.QueryRow(`
-		SELECT workflow_id, name, version, nodes, edges, start_nodes, created_at
+		SELECT workflow_id, name, version, nodes, edges, start_nodes, created_at, COALESCE(labels, '')
 		FROM workflow_dags WHERE workflow_id=?
 	`, id)
 
 	var dag store.WorkflowDAG
-	var nodesStr, edgesStr, startNodesStr string
+	var nodesStr, edgesStr, startNodesStr, labelsStr string
 
-	err := row.Scan(&dag.WorkflowID, &dag.Name, &dag.Version, &nodesStr, &edgesStr, &startNodesStr, &dag.CreatedAt)
+	err := row.Scan(&dag.WorkflowID, &dag.Name, &dag.Version, &nodesStr, &edgesStr, &startNodesStr, &dag.CreatedAt, &labelsStr)
 	if errors.Is(err, sql.ErrNoRows) {
 		return store.WorkflowDAG{}, false, nil
 	}
@@ -74,13 +76,14 @@ func (s *sqliteStore) GetWorkflowDAG(id string) (store.WorkflowDAG, bool, error)
 	if err := json.Unmarshal([]byte(startNodesStr), &dag.StartNodes); err != nil {
 		return store.WorkflowDAG{}, false, err
 	}
+	_ = json.Unmarshal([]byte(labelsStr), &dag.Labels)
 
 	return dag, true, nil
 }
 
 func (s *sqliteStore) ListWorkflowDAGs() ([]store.WorkflowDAG, error) {
 	rows, err := s.db.Query(`
-		SELECT workflow_id, name, version, nodes, edges, start_nodes, created_at
+		SELECT workflow_id, name, version, nodes, edges, start_nodes, created_at, COALESCE(labels, '')
 		FROM workflow_dags ORDER BY created_at DESC
 	`)
 	if err != nil {
@@ -91,15 +94,16 @@ func (s *sqliteStore) ListWorkflowDAGs() ([]store.WorkflowDAG, error) {
 	var dags []store.WorkflowDAG
 	for rows.Next() {
 		var dag store.WorkflowDAG
-		var nodesStr, edgesStr, startNodesStr string
+		var nodesStr, edgesStr, startNodesStr, labelsStr string
 
-		if err := rows.Scan(&dag.WorkflowID, &dag.Name, &dag.Version, &nodesStr, &edgesStr, &startNodesStr, &dag.CreatedAt); err != nil {
+		if err := rows.Scan(&dag.WorkflowID, &dag.Name, &dag.Version, &nodesStr, &edgesStr, &startNodesStr, &dag.CreatedAt, &labelsStr); err != nil {
 			return nil, err
 		}
 
 		_ = json.Unmarshal([]byte(nodesStr), &dag.Nodes)
 		_ = json.Unmarshal([]byte(edgesStr), &dag.Edges)
 		_ = json.Unmarshal([]byte(startNodesStr), &dag.StartNodes)
+		_ = json.Unmarshal([]byte(labelsStr), &dag.Labels)
 
 		dags = append(dags, dag)
 	}
@@ -111,3 +115,17 @@ func (s *sqliteStore) DeleteWorkflowDAG(id string) error {
 	_, err := s.db.Exec(`DELETE FROM workflow_dags WHERE workflow_id=?`, id)
 	return err
 }
+
+func (s *sqliteStore) ListWorkflowDAGsBySelector(sel store.Selector) ([]store.WorkflowDAG, error) {
+	dags, err := s.ListWorkflowDAGs()
+	if err != nil || sel.Empty() {
+		return dags, err
+	}
+	out := make([]store.WorkflowDAG, 0, len(dags))
+	for _, dag := range dags {
+		if sel.Matches(dag.Labels) {
+			out = append(out, dag)
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,46 @@
+package sqlitestore
+
+import (
+	"database/sql"
+	"errors"
+
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// 部署修订历史
+
+func (s *sqliteStore) AddDeploymentRevision(rev store.DeploymentRevision) error {
+	_, err := s.db.Exec(`INSERT INTO deployment_revisions(deployment_id, revision, spec_json, cause, created_at) VALUES(?,?,?,?,?)`,
+		rev.DeploymentID, rev.Revision, rev.SpecJSON, rev.Cause, rev.CreatedAt)
+	return err
+}
+
+func (s *sqliteStore) ListDeploymentRevisions(deploymentID string) ([]store.DeploymentRevision, error) {
+	rows, err := s.db.Query(`SELECT deployment_id, revision, spec_json, COALESCE(cause, ''), created_at FROM deployment_revisions WHERE deployment_id=? ORDER BY revision`, deploymentID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []store.DeploymentRevision
+	for rows.Next() {
+		var r store.DeploymentRevision
+		if err := rows.Scan(&r.DeploymentID, &r.Revision, &r.SpecJSON, &r.Cause, &r.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) GetDeploymentRevision(deploymentID string, revision int) (store.DeploymentRevision, bool, error) {
+	var r store.DeploymentRevision
+	err := s.db.QueryRow(`SELECT deployment_id, revision, spec_json, COALESCE(cause, ''), created_at FROM deployment_revisions WHERE deployment_id=? AND revision=?`, deploymentID, revision).
+		Scan(&r.DeploymentID, &r.Revision, &r.SpecJSON, &r.Cause, &r.CreatedAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.DeploymentRevision{}, false, nil
+		}
+		return store.DeploymentRevision{}, false, err
+	}
+	return r, true, nil
+}
@@ -0,0 +1,92 @@
+package sqlitestore
+
+import (
+	"strings"
+
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// 集群事件
+
+func (s *sqliteStore) AppendEvent(e store.Event) (int64, error) {
+	res, err := s.db.Exec(`INSERT INTO events(ts, type, reason, node_id, deployment_id, instance_id, new_instance_id, from_node, to_node, duration_ms, message)
+		VALUES(?,?,?,?,?,?,?,?,?,?,?)`,
+		e.Time, e.Type, e.Reason, e.NodeID, e.DeploymentID, e.InstanceID, e.NewInstanceID, e.FromNode, e.ToNode, e.DurationMs, e.Message)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func (s *sqliteStore) QueryEvents(q store.EventQuery) ([]store.Event, error) {
+	var where []string
+	var args []any
+	if len(q.Types) > 0 {
+		where = append(where, `type IN (?`+strings.Repeat(`,?`, len(q.Types)-1)+`)`)
+		for _, t := range q.Types {
+			args = append(args, t)
+		}
+	}
+	if q.NodeID != "" {
+		where = append(where, `(node_id=? OR from_node=? OR to_node=?)`)
+		args = append(args, q.NodeID, q.NodeID, q.NodeID)
+	}
+	if q.DeploymentID != "" {
+		where = append(where, `deployment_id=?`)
+		args = append(args, q.DeploymentID)
+	}
+	if q.InstanceID != "" {
+		where = append(where, `(instance_id=? OR new_instance_id=?)`)
+		args = append(args, q.InstanceID, q.InstanceID)
+	}
+	if q.Since > 0 {
+		where = append(where, `ts >= ?`)
+		args = append(args, q.Since)
+	}
+	if q.Until > 0 {
+		where = append(where, `ts < ?`)
+		args = append(args, q.Until)
+	}
+	if q.AfterID > 0 {
+		where = append(where, `id > ?`)
+		args = append(args, q.AfterID)
+	}
+	if q.BeforeID > 0 {
+		where = append(where, `id < ?`)
+		args = append(args, q.BeforeID)
+	}
+	query := `SELECT id, ts, type, COALESCE(reason, ''), COALESCE(node_id, ''), COALESCE(deployment_id, ''), COALESCE(instance_id, ''),
+		COALESCE(new_instance_id, ''), COALESCE(from_node, ''), COALESCE(to_node, ''), COALESCE(duration_ms, 0), COALESCE(message, '') FROM events`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	if q.Ascending {
+		query += ` ORDER BY id ASC`
+	} else {
+		query += ` ORDER BY id DESC`
+	}
+	if q.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, q.Limit)
+	}
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []store.Event
+	for rows.Next() {
+		var e store.Event
+		if err := rows.Scan(&e.ID, &e.Time, &e.Type, &e.Reason, &e.NodeID, &e.DeploymentID, &e.InstanceID,
+			&e.NewInstanceID, &e.FromNode, &e.ToNode, &e.DurationMs, &e.Message); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) PruneEvents(before int64) error {
+	_, err := s.db.Exec(`DELETE FROM events WHERE ts < ?`, before)
+	return err
+}
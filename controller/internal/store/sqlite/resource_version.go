@@ -0,0 +1,45 @@
+package sqlitestore
+
+import (
+	"database/sql"
+
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// 全局单调递增的 resourceVersion（所有可变对象共享一个序列）
+
+// nextResourceVersion 在事务内分配下一个 resourceVersion
+func nextResourceVersion(tx *sql.Tx) (int64, error) {
+	var rv int64
+	err := tx.QueryRow(`UPDATE resource_version_seq SET value=value+1 WHERE id=1 RETURNING value`).Scan(&rv)
+	return rv, err
+}
+
+// withResourceVersion 开启事务、分配新版本号并执行 fn，fn 返回错误时回滚
+func (s *sqliteStore) withResourceVersion(fn func(tx *sql.Tx, rv int64) error) (int64, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	rv, err := nextResourceVersion(tx)
+	if err != nil {
+		return 0, err
+	}
+	if err := fn(tx, rv); err != nil {
+		return 0, err
+	}
+	return rv, tx.Commit()
+}
+
+// checkAffected 条件更新/删除未命中任何行时视为版本冲突
+func checkAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return store.ErrConflict
+	}
+	return nil
+}
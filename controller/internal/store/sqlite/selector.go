@@ -0,0 +1,78 @@
+package sqlitestore
+
+import (
+	"encoding/json"
+
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// 标签选择器查询：标签以 JSON 存储，这里读出后在内存中匹配（数据量较小）
+
+func (s *sqliteStore) ListNodesBySelector(sel store.Selector) ([]store.Node, error) {
+	nodes, err := s.ListNodes()
+	if err != nil || sel.Empty() {
+		return nodes, err
+	}
+	out := make([]store.Node, 0, len(nodes))
+	for _, n := range nodes {
+		if sel.Matches(n.Labels) {
+			out = append(out, n)
+		}
+	}
+	return out, nil
+}
+
+func (s *sqliteStore) ListDeploymentsBySelector(sel store.Selector) ([]store.Deployment, error) {
+	deps, err := s.ListDeployments()
+	if err != nil || sel.Empty() {
+		return deps, err
+	}
+	out := make([]store.Deployment, 0, len(deps))
+	for _, d := range deps {
+		if sel.Matches(d.Labels) {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+func (s *sqliteStore) ListServicesBySelector(sel store.Selector) ([]string, error) {
+	if sel.Empty() {
+		return s.ListServices()
+	}
+	rows, err := s.db.Query(`SELECT service_name, labels FROM endpoints ORDER BY service_name`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var name, labelsStr string
+		if err := rows.Scan(&name, &labelsStr); err != nil {
+			return nil, err
+		}
+		if len(out) > 0 && out[len(out)-1] == name {
+			continue
+		}
+		var labels map[string]string
+		_ = json.Unmarshal([]byte(labelsStr), &labels)
+		if sel.Matches(labels) {
+			out = append(out, name)
+		}
+	}
+	return out, rows.Err()
+}
+
+func (s *sqliteStore) ListEmbeddedWorkersBySelector(sel store.Selector) ([]store.EmbeddedWorker, error) {
+	workers, err := s.ListEmbeddedWorkers()
+	if err != nil || sel.Empty() {
+		return workers, err
+	}
+	out := make([]store.EmbeddedWorker, 0, len(workers))
+	for _, w := range workers {
+		if sel.Matches(w.Labels) {
+			out = append(out, w)
+		}
+	}
+	return out, nil
+}
@@ -126,6 +126,10 @@ func migrate(db *sql.DB) error {
             labels TEXT,
             origin_task_id TEXT
         );`,
+		`CREATE INDEX IF NOT EXISTS idx_tasks_state ON tasks(state, created_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_tasks_executor ON tasks(executor, created_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_tasks_name ON tasks(name, created_at);`,
+		`CREATE INDEX IF NOT EXISTS idx_tasks_created ON tasks(created_at, task_id);`,
 		// Workers for embedded executor (legacy HTTP-based)
 		`CREATE TABLE IF NOT EXISTS workers (
             worker_id TEXT PRIMARY KEY,
@@ -220,6 +224,52 @@ func migrate(db *sql.DB) error {
 		);`,
 		`CREATE INDEX IF NOT EXISTS idx_kv_namespace ON distributed_kv(namespace);`,
 		`CREATE INDEX IF NOT EXISTS idx_kv_updated ON distributed_kv(updated_at);`,
+		// 全局 resourceVersion 序列（乐观并发控制）
+		`CREATE TABLE IF NOT EXISTS resource_version_seq (
+            id INTEGER PRIMARY KEY CHECK (id = 1),
+            value INTEGER NOT NULL
+		);`,
+		`INSERT OR IGNORE INTO resource_version_seq(id, value) VALUES(1, 1);`,
+		// 配置集与密钥（密钥数据加密保存）
+		`CREATE TABLE IF NOT EXISTS config_objects (
+            kind TEXT NOT NULL,
+            name TEXT NOT NULL,
+            data BLOB NOT NULL,
+            updated_at INTEGER NOT NULL,
+            resource_version INTEGER NOT NULL,
+            PRIMARY KEY(kind, name)
+		);`,
+		// 实例配置快照（按 ConfigHash，加密保存）
+		`CREATE TABLE IF NOT EXISTS config_snapshots (
+            config_hash TEXT PRIMARY KEY,
+            data BLOB NOT NULL,
+            created_at INTEGER NOT NULL
+		);`,
+		// 部署修订历史（滚动更新 / 回滚）
+		`CREATE TABLE IF NOT EXISTS deployment_revisions (
+            deployment_id TEXT NOT NULL,
+            revision INTEGER NOT NULL,
+            spec_json TEXT NOT NULL,
+            cause TEXT,
+            created_at INTEGER NOT NULL,
+            PRIMARY KEY(deployment_id, revision)
+		);`,
+		// 集群事件（节点健康变化、实例迁移）
+		`CREATE TABLE IF NOT EXISTS events (
+            id INTEGER PRIMARY KEY AUTOINCREMENT,
+            ts INTEGER NOT NULL,
+            type TEXT NOT NULL,
+            reason TEXT,
+            node_id TEXT,
+            deployment_id TEXT,
+            instance_id TEXT,
+            new_instance_id TEXT,
+            from_node TEXT,
+            to_node TEXT,
+            duration_ms INTEGER,
+            message TEXT
+		);`,
+		`CREATE INDEX IF NOT EXISTS idx_events_ts ON events(ts);`,
 		// Resources
 		`CREATE TABLE IF NOT EXISTS resources (
             resource_id TEXT PRIMARY KEY,
@@ -270,6 +320,9 @@ func migrate(db *sql.DB) error {
 	if err := ensureColumn(db, "tasks", "origin_task_id", "TEXT"); err != nil {
 		return err
 	}
+	if _, err := db.Exec(`CREATE INDEX IF NOT EXISTS idx_tasks_origin ON tasks(origin_task_id);`); err != nil {
+		return err
+	}
 	if err := ensureColumn(db, "task_defs", "default_payload_json", "TEXT"); err != nil {
 		return err
 	}
@@ -294,6 +347,71 @@ func migrate(db *sql.DB) error {
 	if err := ensureColumn(db, "assignments", "app_version", "TEXT"); err != nil {
 		return err
 	}
+	// DAG workflows labels (for label selectors)
+	if err := ensureColumn(db, "workflow_dags", "labels", "TEXT"); err != nil {
+		return err
+	}
+	// resourceVersion for optimistic concurrency; existing rows start at 1
+	for _, table := range []string{"distributed_kv", "deployments", "task_defs", "endpoints"} {
+		if err := ensureColumn(db, table, "resource_version", "INTEGER DEFAULT 1"); err != nil {
+			return err
+		}
+	}
+	// Node resources reported in heartbeats (JSON)
+	if err := ensureColumn(db, "nodes", "resources", "TEXT"); err != nil {
+		return err
+	}
+	// Failover fencing / rebalancing: instance that replaced this assignment, node it was moved away from;
+	// config_hash: version of the config maps / secrets / env the instance was created with
+	for _, c := range []string{"superseded_by", "preferred_node", "config_hash"} {
+		if err := ensureColumn(db, "assignments", c, "TEXT DEFAULT ''"); err != nil {
+			return err
+		}
+	}
+	// Node scheduling status (cordon / drain)
+	if err := ensureColumn(db, "nodes", "status", "TEXT DEFAULT 'Schedulable'"); err != nil {
+		return err
+	}
+	// Agent local HTTP API port reported in heartbeats
+	if err := ensureColumn(db, "nodes", "api_port", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	// Instance readiness reported separately from liveness (NULL for old rows: same as healthy)
+	if err := ensureColumn(db, "statuses", "ready", "INTEGER"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "statuses", "restart_count", "INTEGER DEFAULT 0"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "statuses", "reason", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	if err := ensureColumn(db, "statuses", "message", "TEXT DEFAULT ''"); err != nil {
+		return err
+	}
+	// Deployment rollout: update strategy, pause flag and target revision
+	for _, c := range [][2]string{
+		{"strategy", "TEXT DEFAULT 'rolling'"},
+		{"max_unavailable", "INTEGER DEFAULT 0"},
+		{"max_surge", "INTEGER DEFAULT 1"},
+		{"paused", "INTEGER DEFAULT 0"},
+		{"revision", "INTEGER DEFAULT 0"},
+		{"observed_revision", "INTEGER DEFAULT 0"},
+		{"restart_policy", "TEXT DEFAULT 'Always'"},
+		{"termination_grace_sec", "INTEGER DEFAULT 0"},
+		{"kind", "TEXT DEFAULT 'Service'"},
+		{"completions", "INTEGER DEFAULT 0"},
+		{"parallelism", "INTEGER DEFAULT 0"},
+		{"backoff_limit", "INTEGER DEFAULT 0"},
+		{"ttl_after_finished_sec", "INTEGER DEFAULT -1"},
+		{"job_succeeded", "INTEGER DEFAULT 0"},
+		{"job_failed", "INTEGER DEFAULT 0"},
+		{"finished_at", "INTEGER DEFAULT 0"},
+	} {
+		if err := ensureColumn(db, "deployments", c[0], c[1]); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -327,20 +445,43 @@ func ensureColumn(db *sql.DB, table string, col string, typ string) error {
 
 func (s *sqliteStore) UpsertNode(id string, n store.Node) error {
 	labelsJSON, _ := json.Marshal(n.Labels)
+	// 未上报资源时保留上一次的值
+	var resources any
+	if n.Resources != nil {
+		b, _ := json.Marshal(n.Resources)
+		resources = string(b)
+	}
 	_, err := s.db.Exec(
-		`INSERT INTO nodes(node_id, ip, labels, last_seen) VALUES(?,?,?,?)
-		 ON CONFLICT(node_id) DO UPDATE SET ip=excluded.ip, labels=excluded.labels, last_seen=excluded.last_seen`,
-		id, n.IP, string(labelsJSON), n.LastSeen.Unix(),
+		`INSERT INTO nodes(node_id, ip, labels, last_seen, resources, api_port) VALUES(?,?,?,?,?,?)
+		 ON CONFLICT(node_id) DO UPDATE SET ip=excluded.ip, labels=excluded.labels, last_seen=excluded.last_seen,
+		 resources=COALESCE(excluded.resources, nodes.resources), api_port=excluded.api_port`,
+		id, n.IP, string(labelsJSON), n.LastSeen.Unix(), resources, n.APIPort,
 	)
 	return err
 }
 
+func (s *sqliteStore) SetNodeStatus(id string, status store.NodeStatus) error {
+	_, err := s.db.Exec(`UPDATE nodes SET status=? WHERE node_id=?`, status, id)
+	return err
+}
+
+func scanNodeResources(n *store.Node, resources sql.NullString) {
+	if !resources.Valid || resources.String == "" {
+		return
+	}
+	var r store.NodeResources
+	if json.Unmarshal([]byte(resources.String), &r) == nil {
+		n.Resources = &r
+	}
+}
+
 func (s *sqliteStore) GetNode(id string) (store.Node, bool, error) {
-	row := s.db.QueryRow(`SELECT node_id, ip, labels, last_seen FROM nodes WHERE node_id=?`, id)
+	row := s.db.QueryRow(`SELECT node_id, ip, labels, last_seen, resources, COALESCE(status, 'Schedulable'), COALESCE(api_port, 0) FROM nodes WHERE node_id=?`, id)
 	var n store.Node
 	var labelsStr string
 	var last int64
-	if err := row.Scan(&n.NodeID, &n.IP, &labelsStr, &last); err != nil {
+	var resources sql.NullString
+	if err := row.Scan(&n.NodeID, &n.IP, &labelsStr, &last, &resources, &n.Status, &n.APIPort); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return store.Node{}, false, nil
 		}
@@ -348,11 +489,12 @@ func (s *sqliteStore) GetNode(id string) (store.Node, bool, error) {
 	}
 	_ = json.Unmarshal([]byte(labelsStr), &n.Labels)
 	n.LastSeen = time.Unix(last, 0)
+	scanNodeResources(&n, resources)
 	return n, true, nil
 }
 
 func (s *sqliteStore) ListNodes() ([]store.Node, error) {
-	rows, err := s.db.Query(`SELECT node_id, ip, labels, last_seen FROM nodes ORDER BY node_id`)
+	rows, err := s.db.Query(`SELECT node_id, ip, labels, last_seen, resources, COALESCE(status, 'Schedulable'), COALESCE(api_port, 0) FROM nodes ORDER BY node_id`)
 	if err != nil {
 		return nil, err
 	}
@@ -362,11 +504,13 @@ func (s *sqliteStore) ListNodes() ([]store.Node, error) {
 		var n store.Node
 		var labelsStr string
 		var last int64
-		if err := rows.Scan(&n.NodeID, &n.IP, &labelsStr, &last); err != nil {
+		var resources sql.NullString
+		if err := rows.Scan(&n.NodeID, &n.IP, &labelsStr, &last, &resources, &n.Status, &n.APIPort); err != nil {
 			return nil, err
 		}
 		_ = json.Unmarshal([]byte(labelsStr), &n.Labels)
 		n.LastSeen = time.Unix(last, 0)
+		scanNodeResources(&n, resources)
 		out = append(out, n)
 	}
 	return out, rows.Err()
@@ -380,7 +524,7 @@ func (s *sqliteStore) DeleteNode(id string) error {
 func (s *sqliteStore) ListAssignmentsForNode(nodeID string) ([]store.Assignment, error) {
 	// 只返回状态为Running的部署的实例
 	rows, err := s.db.Query(`
-		SELECT a.instance_id, a.deployment_id, a.node_id, a.desired, a.artifact_url, a.start_cmd, a.app_name, a.app_version 
+		SELECT a.instance_id, a.deployment_id, a.node_id, a.desired, a.artifact_url, a.start_cmd, a.app_name, a.app_version, COALESCE(a.superseded_by, ''), COALESCE(a.preferred_node, ''), COALESCE(a.config_hash, '') 
 		FROM assignments a 
 		INNER JOIN deployments d ON a.deployment_id = d.deployment_id 
 		WHERE a.node_id=? AND COALESCE(d.status, 'Stopped') = 'Running'`, nodeID)
@@ -391,7 +535,7 @@ func (s *sqliteStore) ListAssignmentsForNode(nodeID string) ([]store.Assignment,
 	var out []store.Assignment
 	for rows.Next() {
 		var a store.Assignment
-		if err := rows.Scan(&a.InstanceID, &a.DeploymentID, &a.NodeID, &a.Desired, &a.ArtifactURL, &a.StartCmd, &a.AppName, &a.AppVersion); err != nil {
+		if err := rows.Scan(&a.InstanceID, &a.DeploymentID, &a.NodeID, &a.Desired, &a.ArtifactURL, &a.StartCmd, &a.AppName, &a.AppVersion, &a.SupersededBy, &a.PreferredNode, &a.ConfigHash); err != nil {
 			return nil, err
 		}
 		out = append(out, a)
@@ -400,9 +544,9 @@ func (s *sqliteStore) ListAssignmentsForNode(nodeID string) ([]store.Assignment,
 }
 
 func (s *sqliteStore) GetAssignment(instanceID string) (store.Assignment, bool, error) {
-	row := s.db.QueryRow(`SELECT instance_id, deployment_id, node_id, desired, artifact_url, start_cmd, app_name, app_version FROM assignments WHERE instance_id=?`, instanceID)
+	row := s.db.QueryRow(`SELECT instance_id, deployment_id, node_id, desired, artifact_url, start_cmd, app_name, app_version, COALESCE(superseded_by, ''), COALESCE(preferred_node, ''), COALESCE(config_hash, '') FROM assignments WHERE instance_id=?`, instanceID)
 	var a store.Assignment
-	if err := row.Scan(&a.InstanceID, &a.DeploymentID, &a.NodeID, &a.Desired, &a.ArtifactURL, &a.StartCmd, &a.AppName, &a.AppVersion); err != nil {
+	if err := row.Scan(&a.InstanceID, &a.DeploymentID, &a.NodeID, &a.Desired, &a.ArtifactURL, &a.StartCmd, &a.AppName, &a.AppVersion, &a.SupersededBy, &a.PreferredNode, &a.ConfigHash); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return store.Assignment{}, false, nil
 		}
@@ -415,42 +559,73 @@ func (s *sqliteStore) AddAssignment(nodeID string, a store.Assignment) error {
 	if nodeID == "" {
 		return errors.New("nodeID required")
 	}
-	_, err := s.db.Exec(`INSERT INTO assignments(instance_id, deployment_id, node_id, desired, artifact_url, start_cmd, app_name, app_version) VALUES(?,?,?,?,?,?,?,?)`,
-		a.InstanceID, a.DeploymentID, nodeID, a.Desired, a.ArtifactURL, a.StartCmd, a.AppName, a.AppVersion,
+	_, err := s.db.Exec(`INSERT INTO assignments(instance_id, deployment_id, node_id, desired, artifact_url, start_cmd, app_name, app_version, preferred_node, config_hash) VALUES(?,?,?,?,?,?,?,?,?,?)`,
+		a.InstanceID, a.DeploymentID, nodeID, a.Desired, a.ArtifactURL, a.StartCmd, a.AppName, a.AppVersion, a.PreferredNode, a.ConfigHash,
 	)
 	return err
 }
 
 func (s *sqliteStore) AppendStatus(instanceID string, st store.InstanceStatus) error {
-	_, err := s.db.Exec(`INSERT INTO statuses(instance_id, phase, exit_code, healthy, ts_unix) VALUES(?,?,?,?,?)`,
-		instanceID, st.Phase, st.ExitCode, boolToInt(st.Healthy), st.TsUnix,
+	_, err := s.db.Exec(`INSERT INTO statuses(instance_id, phase, exit_code, healthy, ready, ts_unix, restart_count, reason, message) VALUES(?,?,?,?,?,?,?,?,?)`,
+		instanceID, st.Phase, st.ExitCode, boolToInt(st.Healthy), boolToInt(st.Ready), st.TsUnix, st.RestartCount, st.Reason, st.Message,
 	)
 	return err
 }
 
 func (s *sqliteStore) LatestStatus(instanceID string) (store.InstanceStatus, bool, error) {
-	row := s.db.QueryRow(`SELECT instance_id, phase, exit_code, healthy, ts_unix FROM statuses WHERE instance_id=? ORDER BY ts_unix DESC, id DESC LIMIT 1`, instanceID)
+	row := s.db.QueryRow(`SELECT instance_id, phase, exit_code, healthy, COALESCE(ready, healthy), ts_unix, COALESCE(restart_count, 0), COALESCE(reason, ''), COALESCE(message, '') FROM statuses WHERE instance_id=? ORDER BY ts_unix DESC, id DESC LIMIT 1`, instanceID)
 	var st store.InstanceStatus
-	var healthy int
-	if err := row.Scan(&st.InstanceID, &st.Phase, &st.ExitCode, &healthy, &st.TsUnix); err != nil {
+	var healthy, ready int
+	if err := row.Scan(&st.InstanceID, &st.Phase, &st.ExitCode, &healthy, &ready, &st.TsUnix, &st.RestartCount, &st.Reason, &st.Message); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return store.InstanceStatus{}, false, nil
 		}
 		return store.InstanceStatus{}, false, err
 	}
 	st.Healthy = healthy != 0
+	st.Ready = ready != 0
 	return st, true, nil
 }
 
 // Deployments helpers
-func (s *sqliteStore) CreateDeployment(name string, labels map[string]string) (string, []string, error) {
+func (s *sqliteStore) CreateDeployment(d store.Deployment, assigns []store.Assignment, rev *store.DeploymentRevision) (string, []string, error) {
 	id := newID()
-	labelsJSON, _ := json.Marshal(labels)
+	instances := make([]string, 0, len(assigns))
+	labelsJSON, _ := json.Marshal(d.Labels)
 	// 创建时默认状态为Stopped
-	if _, err := s.db.Exec(`INSERT INTO deployments(deployment_id, name, labels, status) VALUES(?,?,?,?)`, id, name, string(labelsJSON), store.DeploymentStopped); err != nil {
+	_, err := s.withResourceVersion(func(tx *sql.Tx, rv int64) error {
+		if _, err := tx.Exec(`INSERT INTO deployments(deployment_id, name, labels, status, resource_version) VALUES(?,?,?,?,?)`, id, d.Name, string(labelsJSON), store.DeploymentStopped, rv); err != nil {
+			return err
+		}
+		d.DeploymentID = id
+		if rev != nil {
+			d.Revision = 1
+			if _, err := tx.Exec(`INSERT INTO deployment_revisions(deployment_id, revision, spec_json, cause, created_at) VALUES(?,?,?,?,?)`,
+				id, d.Revision, rev.SpecJSON, rev.Cause, rev.CreatedAt); err != nil {
+				return err
+			}
+		}
+		if err := updateDeployment(tx, d, rv, 0); err != nil {
+			return err
+		}
+		for _, a := range assigns {
+			if a.NodeID == "" {
+				return errors.New("nodeID required")
+			}
+			a.DeploymentID, a.InstanceID = id, s.NewInstanceID(id)
+			if _, err := tx.Exec(`INSERT INTO assignments(instance_id, deployment_id, node_id, desired, artifact_url, start_cmd, app_name, app_version, preferred_node, config_hash) VALUES(?,?,?,?,?,?,?,?,?,?)`,
+				a.InstanceID, a.DeploymentID, a.NodeID, a.Desired, a.ArtifactURL, a.StartCmd, a.AppName, a.AppVersion, a.PreferredNode, a.ConfigHash,
+			); err != nil {
+				return err
+			}
+			instances = append(instances, a.InstanceID)
+		}
+		return nil
+	})
+	if err != nil {
 		return "", nil, err
 	}
-	return id, []string{}, nil
+	return id, instances, nil
 }
 
 func (s *sqliteStore) NewInstanceID(deploymentID string) string {
@@ -458,54 +633,224 @@ func (s *sqliteStore) NewInstanceID(deploymentID string) string {
 }
 
 func (s *sqliteStore) ListDeployments() ([]store.Deployment, error) {
-	rows, err := s.db.Query(`SELECT deployment_id, name, labels, COALESCE(status, 'Stopped') FROM deployments ORDER BY rowid DESC`)
+	rows, err := s.db.Query(`SELECT ` + deploymentColumns + ` FROM deployments ORDER BY rowid DESC`)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 	var out []store.Deployment
 	for rows.Next() {
-		var t store.Deployment
-		var labelsStr string
-		var statusStr string
-		if err := rows.Scan(&t.DeploymentID, &t.Name, &labelsStr, &statusStr); err != nil {
+		t, err := scanDeployment(rows)
+		if err != nil {
 			return nil, err
 		}
-		_ = json.Unmarshal([]byte(labelsStr), &t.Labels)
-		t.Status = store.DeploymentStatus(statusStr)
 		out = append(out, t)
 	}
 	return out, rows.Err()
 }
 
 func (s *sqliteStore) GetDeployment(id string) (store.Deployment, bool, error) {
-	row := s.db.QueryRow(`SELECT deployment_id, name, labels, COALESCE(status, 'Stopped') FROM deployments WHERE deployment_id=?`, id)
-	var t store.Deployment
-	var labelsStr string
-	var statusStr string
-	if err := row.Scan(&t.DeploymentID, &t.Name, &labelsStr, &statusStr); err != nil {
+	t, err := scanDeployment(s.db.QueryRow(`SELECT `+deploymentColumns+` FROM deployments WHERE deployment_id=?`, id))
+	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return store.Deployment{}, false, nil
 		}
 		return store.Deployment{}, false, err
 	}
+	return t, true, nil
+}
+
+const deploymentColumns = `deployment_id, name, labels, COALESCE(status, 'Stopped'), COALESCE(resource_version, 1),
+	COALESCE(strategy, 'rolling'), COALESCE(max_unavailable, 0), COALESCE(max_surge, 1), COALESCE(paused, 0), COALESCE(revision, 0), COALESCE(observed_revision, 0), COALESCE(restart_policy, 'Always'), COALESCE(termination_grace_sec, 0),
+	COALESCE(kind, 'Service'), COALESCE(completions, 0), COALESCE(parallelism, 0), COALESCE(backoff_limit, 0), COALESCE(ttl_after_finished_sec, -1), COALESCE(job_succeeded, 0), COALESCE(job_failed, 0), COALESCE(finished_at, 0)`
+
+func scanDeployment(row interface{ Scan(...any) error }) (store.Deployment, error) {
+	var t store.Deployment
+	var labelsStr, statusStr, strategy, restartPolicy, kind string
+	var paused int
+	if err := row.Scan(&t.DeploymentID, &t.Name, &labelsStr, &statusStr, &t.ResourceVersion,
+		&strategy, &t.MaxUnavailable, &t.MaxSurge, &paused, &t.Revision, &t.ObservedRevision, &restartPolicy, &t.TerminationGracePeriodSec,
+		&kind, &t.Completions, &t.Parallelism, &t.BackoffLimit, &t.TTLSecondsAfterFinished, &t.Succeeded, &t.Failed, &t.FinishedAt); err != nil {
+		return store.Deployment{}, err
+	}
 	_ = json.Unmarshal([]byte(labelsStr), &t.Labels)
 	t.Status = store.DeploymentStatus(statusStr)
-	return t, true, nil
+	t.Strategy = store.UpdateStrategy(strategy)
+	t.RestartPolicy = store.RestartPolicy(restartPolicy)
+	t.Kind = store.DeploymentKind(kind)
+	t.Paused = paused != 0
+	return t, nil
+}
+
+func (s *sqliteStore) UpdateDeployment(d store.Deployment, ifMatch int64) (int64, error) {
+	return s.withResourceVersion(func(tx *sql.Tx, rv int64) error {
+		return updateDeployment(tx, d, rv, ifMatch)
+	})
+}
+
+func (s *sqliteStore) UpdateDeploymentRevision(d store.Deployment, rev store.DeploymentRevision, ifMatch int64) (int, int64, error) {
+	rv, err := s.withResourceVersion(func(tx *sql.Tx, rv int64) error {
+		var latest int
+		var latestSpec string
+		err := tx.QueryRow(`SELECT revision, spec_json FROM deployment_revisions WHERE deployment_id=? ORDER BY revision DESC LIMIT 1`, d.DeploymentID).
+			Scan(&latest, &latestSpec)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		d.Revision = latest
+		if latest == 0 || latestSpec != rev.SpecJSON {
+			d.Revision = latest + 1
+			if _, err := tx.Exec(`INSERT INTO deployment_revisions(deployment_id, revision, spec_json, cause, created_at) VALUES(?,?,?,?,?)`,
+				d.DeploymentID, d.Revision, rev.SpecJSON, rev.Cause, rev.CreatedAt); err != nil {
+				return err
+			}
+		}
+		return updateDeployment(tx, d, rv, ifMatch)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return d.Revision, rv, nil
 }
 
-func (s *sqliteStore) DeleteDeployment(id string) error {
-	_, err := s.db.Exec(`DELETE FROM deployments WHERE deployment_id=?`, id)
-	return err
+func (s *sqliteStore) SetDeploymentPaused(id string, paused bool, ifMatch int64) (int64, error) {
+	return s.withResourceVersion(func(tx *sql.Tx, rv int64) error {
+		sqlStr := `UPDATE deployments SET paused=?, resource_version=? WHERE deployment_id=?`
+		args := []any{boolToInt(paused), rv, id}
+		if ifMatch > 0 {
+			sqlStr += ` AND resource_version=?`
+			args = append(args, ifMatch)
+		}
+		res, err := tx.Exec(sqlStr, args...)
+		if err != nil {
+			return err
+		}
+		return checkAffected(res)
+	})
+}
+
+// updateDeployment 在事务内写入部署的可变字段（ifMatch>0 时做版本校验）
+func updateDeployment(tx *sql.Tx, d store.Deployment, rv int64, ifMatch int64) error {
+	labelsJSON, _ := json.Marshal(d.Labels)
+	strategy := d.Strategy
+	if strategy == "" {
+		strategy = store.StrategyRolling
+	}
+	restartPolicy := d.RestartPolicy
+	if restartPolicy == "" {
+		restartPolicy = store.RestartAlways
+	}
+	kind := d.Kind
+	if kind == "" {
+		kind = store.KindService
+	}
+	paused := 0
+	if d.Paused {
+		paused = 1
+	}
+	sqlStr := `UPDATE deployments SET labels=?, strategy=?, max_unavailable=?, max_surge=?, paused=?, revision=?, observed_revision=?, restart_policy=?, termination_grace_sec=?,
+		kind=?, completions=?, parallelism=?, backoff_limit=?, ttl_after_finished_sec=?, resource_version=? WHERE deployment_id=?`
+	args := []any{string(labelsJSON), strategy, d.MaxUnavailable, d.MaxSurge, paused, d.Revision, d.ObservedRevision, restartPolicy, d.TerminationGracePeriodSec,
+		kind, d.Completions, d.Parallelism, d.BackoffLimit, d.TTLSecondsAfterFinished, rv, d.DeploymentID}
+	if ifMatch > 0 {
+		sqlStr += ` AND resource_version=?`
+		args = append(args, ifMatch)
+	}
+	res, err := tx.Exec(sqlStr, args...)
+	if err != nil {
+		return err
+	}
+	return checkAffected(res)
+}
+
+func (s *sqliteStore) DeleteDeployment(id string, ifMatch int64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if ifMatch > 0 {
+		res, err := tx.Exec(`DELETE FROM deployments WHERE deployment_id=? AND resource_version=?`, id, ifMatch)
+		if err != nil {
+			return err
+		}
+		if err := checkAffected(res); err != nil {
+			return err
+		}
+	} else if _, err := tx.Exec(`DELETE FROM deployments WHERE deployment_id=?`, id); err != nil {
+		return err
+	}
+	for _, q := range []string{
+		`DELETE FROM deployment_revisions WHERE deployment_id=?`,
+		`DELETE FROM statuses WHERE instance_id IN (SELECT instance_id FROM assignments WHERE deployment_id=?)`,
+		`DELETE FROM assignments WHERE deployment_id=?`,
+	} {
+		if _, err := tx.Exec(q, id); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
 }
 
 func (s *sqliteStore) UpdateDeploymentStatus(id string, status store.DeploymentStatus) error {
-	_, err := s.db.Exec(`UPDATE deployments SET status=? WHERE deployment_id=?`, status, id)
+	_, err := s.withResourceVersion(func(tx *sql.Tx, rv int64) error {
+		_, err := tx.Exec(`UPDATE deployments SET status=?, resource_version=? WHERE deployment_id=?`, status, rv, id)
+		return err
+	})
+	return err
+}
+
+func (s *sqliteStore) RecordJobResults(deploymentID string, results []store.JobResult) (int, int, error) {
+	var totalSucceeded, totalFailed int
+	_, err := s.withResourceVersion(func(tx *sql.Tx, rv int64) error {
+		succeeded, failed := 0, 0
+		for _, r := range results {
+			res, err := tx.Exec(`UPDATE assignments SET desired=? WHERE instance_id=? AND deployment_id=? AND desired=?`,
+				store.DesiredStopped, r.InstanceID, deploymentID, store.DesiredRunning)
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err != nil {
+				return err
+			} else if n == 0 {
+				continue // 已计数或已停止
+			}
+			succeeded += r.Succeeded
+			failed += r.Failed
+		}
+		err := tx.QueryRow(`UPDATE deployments SET job_succeeded=COALESCE(job_succeeded, 0)+?, job_failed=COALESCE(job_failed, 0)+?, resource_version=? WHERE deployment_id=?
+			RETURNING job_succeeded, job_failed`, succeeded, failed, rv, deploymentID).Scan(&totalSucceeded, &totalFailed)
+		if errors.Is(err, sql.ErrNoRows) {
+			return store.ErrConflict
+		}
+		return err
+	})
+	return totalSucceeded, totalFailed, err
+}
+
+func (s *sqliteStore) FinishJob(deploymentID string, status store.DeploymentStatus, failed int, finishedAt int64, stop []string) error {
+	_, err := s.withResourceVersion(func(tx *sql.Tx, rv int64) error {
+		// 只结束仍在运行的任务：并发的停止或删除不被覆盖
+		res, err := tx.Exec(`UPDATE deployments SET status=?, job_failed=COALESCE(job_failed, 0)+?, finished_at=?, resource_version=? WHERE deployment_id=? AND status=?`,
+			status, failed, finishedAt, rv, deploymentID, store.DeploymentRunning)
+		if err != nil {
+			return err
+		}
+		if err := checkAffected(res); err != nil {
+			return err
+		}
+		for _, id := range stop {
+			if _, err := tx.Exec(`UPDATE assignments SET desired=? WHERE instance_id=?`, store.DesiredStopped, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	return err
 }
 
 func (s *sqliteStore) ListAssignmentsForDeployment(deploymentID string) ([]store.Assignment, error) {
-	rows, err := s.db.Query(`SELECT instance_id, deployment_id, node_id, desired, artifact_url, start_cmd, app_name, app_version FROM assignments WHERE deployment_id=?`, deploymentID)
+	rows, err := s.db.Query(`SELECT instance_id, deployment_id, node_id, desired, artifact_url, start_cmd, app_name, app_version, COALESCE(superseded_by, ''), COALESCE(preferred_node, ''), COALESCE(config_hash, '') FROM assignments WHERE deployment_id=?`, deploymentID)
 	if err != nil {
 		return nil, err
 	}
@@ -513,7 +858,7 @@ func (s *sqliteStore) ListAssignmentsForDeployment(deploymentID string) ([]store
 	var out []store.Assignment
 	for rows.Next() {
 		var a store.Assignment
-		if err := rows.Scan(&a.InstanceID, &a.DeploymentID, &a.NodeID, &a.Desired, &a.ArtifactURL, &a.StartCmd, &a.AppName, &a.AppVersion); err != nil {
+		if err := rows.Scan(&a.InstanceID, &a.DeploymentID, &a.NodeID, &a.Desired, &a.ArtifactURL, &a.StartCmd, &a.AppName, &a.AppVersion, &a.SupersededBy, &a.PreferredNode, &a.ConfigHash); err != nil {
 			return nil, err
 		}
 		out = append(out, a)
@@ -533,6 +878,33 @@ func (s *sqliteStore) UpdateAssignmentDesired(instanceID string, desired store.D
 	return err
 }
 
+func (s *sqliteStore) SupersedeAssignment(instanceID string, by string) error {
+	_, err := s.db.Exec(`UPDATE assignments SET desired=?, superseded_by=? WHERE instance_id=?`, store.DesiredStopped, by, instanceID)
+	return err
+}
+
+func (s *sqliteStore) UpdateAssignmentSupersededBy(instanceID string, by string) error {
+	_, err := s.db.Exec(`UPDATE assignments SET superseded_by=? WHERE instance_id=?`, by, instanceID)
+	return err
+}
+
+func (s *sqliteStore) ListSupersededForNode(nodeID string) ([]string, error) {
+	rows, err := s.db.Query(`SELECT instance_id FROM assignments WHERE node_id=? AND desired=? AND COALESCE(superseded_by, '') <> ''`, nodeID, store.DesiredStopped)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
 func (s *sqliteStore) DeleteStatusesForInstance(instanceID string) error {
 	_, err := s.db.Exec(`DELETE FROM statuses WHERE instance_id=?`, instanceID)
 	return err
@@ -583,6 +955,11 @@ func (s *sqliteStore) ReplaceEndpointsForInstance(nodeID string, instanceID stri
 	if err != nil {
 		return err
 	}
+	rv, err := nextResourceVersion(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 	_, err = tx.Exec(`DELETE FROM endpoints WHERE instance_id=?`, instanceID)
 	if err != nil {
 		tx.Rollback()
@@ -590,8 +967,8 @@ func (s *sqliteStore) ReplaceEndpointsForInstance(nodeID string, instanceID stri
 	}
 	for _, e := range eps {
 		labelsJSON, _ := json.Marshal(e.Labels)
-		if _, err := tx.Exec(`INSERT INTO endpoints(service_name, instance_id, node_id, ip, port, protocol, version, labels, healthy, last_seen) VALUES(?,?,?,?,?,?,?,?,?,?)`,
-			e.ServiceName, instanceID, nodeID, e.IP, e.Port, e.Protocol, e.Version, string(labelsJSON), boolToInt(e.Healthy), e.LastSeen,
+		if _, err := tx.Exec(`INSERT INTO endpoints(service_name, instance_id, node_id, ip, port, protocol, version, labels, healthy, last_seen, resource_version) VALUES(?,?,?,?,?,?,?,?,?,?,?)`,
+			e.ServiceName, instanceID, nodeID, e.IP, e.Port, e.Protocol, e.Version, string(labelsJSON), boolToInt(e.Healthy), e.LastSeen, rv,
 		); err != nil {
 			tx.Rollback()
 			return err
@@ -606,6 +983,11 @@ func (s *sqliteStore) ReplaceEndpointsForInstanceAndService(nodeID string, insta
 	if err != nil {
 		return err
 	}
+	rv, err := nextResourceVersion(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
 	// 只删除该实例下指定服务的端点
 	_, err = tx.Exec(`DELETE FROM endpoints WHERE instance_id=? AND service_name=?`, instanceID, serviceName)
 	if err != nil {
@@ -618,8 +1000,8 @@ func (s *sqliteStore) ReplaceEndpointsForInstanceAndService(nodeID string, insta
 			continue // 跳过不属于该服务的端点（理论上不应该发生）
 		}
 		labelsJSON, _ := json.Marshal(e.Labels)
-		if _, err := tx.Exec(`INSERT INTO endpoints(service_name, instance_id, node_id, ip, port, protocol, version, labels, healthy, last_seen) VALUES(?,?,?,?,?,?,?,?,?,?)`,
-			e.ServiceName, instanceID, nodeID, e.IP, e.Port, e.Protocol, e.Version, string(labelsJSON), boolToInt(e.Healthy), e.LastSeen,
+		if _, err := tx.Exec(`INSERT INTO endpoints(service_name, instance_id, node_id, ip, port, protocol, version, labels, healthy, last_seen, resource_version) VALUES(?,?,?,?,?,?,?,?,?,?,?)`,
+			e.ServiceName, instanceID, nodeID, e.IP, e.Port, e.Protocol, e.Version, string(labelsJSON), boolToInt(e.Healthy), e.LastSeen, rv,
 		); err != nil {
 			tx.Rollback()
 			return err
@@ -657,48 +1039,62 @@ func (s *sqliteStore) AddEndpoint(ep store.Endpoint) error {
 	ts := time.Now().Unix()
 	// 使用 INSERT OR REPLACE（基于主键）
 	// 主键是 (service_name, instance_id, ip, port, protocol)
-	_, err := s.db.Exec(`INSERT OR REPLACE INTO endpoints(service_name, instance_id, node_id, ip, port, protocol, version, labels, healthy, last_seen) VALUES(?,?,?,?,?,?,?,?,?,?)`,
-		ep.ServiceName, ep.InstanceID, ep.NodeID, ep.IP, ep.Port, ep.Protocol, ep.Version, string(labelsJSON), boolToInt(ep.Healthy), ts)
+	_, err := s.withResourceVersion(func(tx *sql.Tx, rv int64) error {
+		_, err := tx.Exec(`INSERT OR REPLACE INTO endpoints(service_name, instance_id, node_id, ip, port, protocol, version, labels, healthy, last_seen, resource_version) VALUES(?,?,?,?,?,?,?,?,?,?,?)`,
+			ep.ServiceName, ep.InstanceID, ep.NodeID, ep.IP, ep.Port, ep.Protocol, ep.Version, string(labelsJSON), boolToInt(ep.Healthy), ts, rv)
+		return err
+	})
 	return err
 }
 
-// DeleteEndpoint 删除单个端点
-func (s *sqliteStore) DeleteEndpoint(serviceName string, instanceID string, ip string, port int, protocol string) error {
+// DeleteEndpoint 删除单个端点（ifMatch>0 时校验 resourceVersion）
+func (s *sqliteStore) DeleteEndpoint(serviceName string, instanceID string, ip string, port int, protocol string, ifMatch int64) error {
+	if ifMatch > 0 {
+		res, err := s.db.Exec(`DELETE FROM endpoints WHERE service_name=? AND instance_id=? AND ip=? AND port=? AND protocol=? AND resource_version=?`,
+			serviceName, instanceID, ip, port, protocol, ifMatch)
+		if err != nil {
+			return err
+		}
+		return checkAffected(res)
+	}
 	_, err := s.db.Exec(`DELETE FROM endpoints WHERE service_name=? AND instance_id=? AND ip=? AND port=? AND protocol=?`,
 		serviceName, instanceID, ip, port, protocol)
 	return err
 }
 
-// UpdateEndpoint 更新单个端点信息
-func (s *sqliteStore) UpdateEndpoint(serviceName string, instanceID string, oldIP string, oldPort int, oldProtocol string, ep store.Endpoint) error {
+// UpdateEndpoint 更新单个端点信息（ifMatch>0 时校验 resourceVersion）
+func (s *sqliteStore) UpdateEndpoint(serviceName string, instanceID string, oldIP string, oldPort int, oldProtocol string, ep store.Endpoint, ifMatch int64) (int64, error) {
 	labelsJSON, _ := json.Marshal(ep.Labels)
 	ts := time.Now().Unix()
-	// 如果主键字段发生变化，需要先删除旧记录，再插入新记录
-	if ep.ServiceName != serviceName || ep.InstanceID != instanceID || ep.IP != oldIP || ep.Port != oldPort || ep.Protocol != oldProtocol {
-		tx, err := s.db.Begin()
-		if err != nil {
-			return err
+	return s.withResourceVersion(func(tx *sql.Tx, rv int64) error {
+		if ifMatch > 0 {
+			var cur int64
+			err := tx.QueryRow(`SELECT COALESCE(resource_version, 1) FROM endpoints WHERE service_name=? AND instance_id=? AND ip=? AND port=? AND protocol=?`,
+				serviceName, instanceID, oldIP, oldPort, oldProtocol).Scan(&cur)
+			if errors.Is(err, sql.ErrNoRows) || (err == nil && cur != ifMatch) {
+				return store.ErrConflict
+			}
+			if err != nil {
+				return err
+			}
 		}
-		// 删除旧记录
-		_, err = tx.Exec(`DELETE FROM endpoints WHERE service_name=? AND instance_id=? AND ip=? AND port=? AND protocol=?`,
-			serviceName, instanceID, oldIP, oldPort, oldProtocol)
-		if err != nil {
-			tx.Rollback()
+		// 如果主键字段发生变化，需要先删除旧记录，再插入新记录
+		if ep.ServiceName != serviceName || ep.InstanceID != instanceID || ep.IP != oldIP || ep.Port != oldPort || ep.Protocol != oldProtocol {
+			// 删除旧记录
+			if _, err := tx.Exec(`DELETE FROM endpoints WHERE service_name=? AND instance_id=? AND ip=? AND port=? AND protocol=?`,
+				serviceName, instanceID, oldIP, oldPort, oldProtocol); err != nil {
+				return err
+			}
+			// 插入新记录
+			_, err := tx.Exec(`INSERT INTO endpoints(service_name, instance_id, node_id, ip, port, protocol, version, labels, healthy, last_seen, resource_version) VALUES(?,?,?,?,?,?,?,?,?,?,?)`,
+				ep.ServiceName, ep.InstanceID, ep.NodeID, ep.IP, ep.Port, ep.Protocol, ep.Version, string(labelsJSON), boolToInt(ep.Healthy), ts, rv)
 			return err
 		}
-		// 插入新记录
-		_, err = tx.Exec(`INSERT INTO endpoints(service_name, instance_id, node_id, ip, port, protocol, version, labels, healthy, last_seen) VALUES(?,?,?,?,?,?,?,?,?,?)`,
-			ep.ServiceName, ep.InstanceID, ep.NodeID, ep.IP, ep.Port, ep.Protocol, ep.Version, string(labelsJSON), boolToInt(ep.Healthy), ts)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-		return tx.Commit()
-	}
-	// 只更新非主键字段
-	_, err := s.db.Exec(`UPDATE endpoints SET node_id=?, version=?, labels=?, healthy=?, last_seen=? WHERE service_name=? AND instance_id=? AND ip=? AND port=? AND protocol=?`,
-		ep.NodeID, ep.Version, string(labelsJSON), boolToInt(ep.Healthy), ts, serviceName, instanceID, oldIP, oldPort, oldProtocol)
-	return err
+		// 只更新非主键字段
+		_, err := tx.Exec(`UPDATE endpoints SET node_id=?, version=?, labels=?, healthy=?, last_seen=?, resource_version=? WHERE service_name=? AND instance_id=? AND ip=? AND port=? AND protocol=?`,
+			ep.NodeID, ep.Version, string(labelsJSON), boolToInt(ep.Healthy), ts, rv, serviceName, instanceID, oldIP, oldPort, oldProtocol)
+		return err
+	})
 }
 
 func (s *sqliteStore) ListEndpointsByService(serviceName string, version string, protocol string) ([]store.Endpoint, error) {
@@ -706,7 +1102,7 @@ func (s *sqliteStore) ListEndpointsByService(serviceName string, version string,
 	if ttlThreshold < 0 {
 		ttlThreshold = 0
 	}
-	sqlStr := `SELECT service_name, instance_id, node_id, ip, port, protocol, version, labels, healthy, last_seen FROM endpoints WHERE service_name=? AND healthy=1 AND last_seen > ?`
+	sqlStr := `SELECT service_name, instance_id, node_id, ip, port, protocol, version, labels, healthy, last_seen, COALESCE(resource_version, 1) FROM endpoints WHERE service_name=? AND healthy=1 AND last_seen > ?`
 	args := []any{serviceName, ttlThreshold}
 	if version != "" {
 		sqlStr += ` AND version=?`
@@ -726,7 +1122,7 @@ func (s *sqliteStore) ListEndpointsByService(serviceName string, version string,
 		var e store.Endpoint
 		var labelsStr string
 		var healthy int
-		if err := rows.Scan(&e.ServiceName, &e.InstanceID, &e.NodeID, &e.IP, &e.Port, &e.Protocol, &e.Version, &labelsStr, &healthy, &e.LastSeen); err != nil {
+		if err := rows.Scan(&e.ServiceName, &e.InstanceID, &e.NodeID, &e.IP, &e.Port, &e.Protocol, &e.Version, &labelsStr, &healthy, &e.LastSeen, &e.ResourceVersion); err != nil {
 			return nil, err
 		}
 		_ = json.Unmarshal([]byte(labelsStr), &e.Labels)
@@ -738,7 +1134,7 @@ func (s *sqliteStore) ListEndpointsByService(serviceName string, version string,
 
 // ListAllEndpointsByService 列出服务的所有端点（包括不健康的，用于管理界面）
 func (s *sqliteStore) ListAllEndpointsByService(serviceName string) ([]store.Endpoint, error) {
-	rows, err := s.db.Query(`SELECT service_name, instance_id, node_id, ip, port, protocol, version, labels, healthy, last_seen FROM endpoints WHERE service_name=?`, serviceName)
+	rows, err := s.db.Query(`SELECT service_name, instance_id, node_id, ip, port, protocol, version, labels, healthy, last_seen, COALESCE(resource_version, 1) FROM endpoints WHERE service_name=?`, serviceName)
 	if err != nil {
 		return nil, err
 	}
@@ -748,7 +1144,7 @@ func (s *sqliteStore) ListAllEndpointsByService(serviceName string) ([]store.End
 		var e store.Endpoint
 		var labelsStr string
 		var healthy int
-		if err := rows.Scan(&e.ServiceName, &e.InstanceID, &e.NodeID, &e.IP, &e.Port, &e.Protocol, &e.Version, &labelsStr, &healthy, &e.LastSeen); err != nil {
+		if err := rows.Scan(&e.ServiceName, &e.InstanceID, &e.NodeID, &e.IP, &e.Port, &e.Protocol, &e.Version, &labelsStr, &healthy, &e.LastSeen, &e.ResourceVersion); err != nil {
 			return nil, err
 		}
 		_ = json.Unmarshal([]byte(labelsStr), &e.Labels)
@@ -1288,9 +1684,12 @@ func (s *sqliteStore) CreateTaskDef(td store.TaskDefinition) (string, error) {
 		td.DefID = newID()
 	}
 	labelsJSON, _ := json.Marshal(td.Labels)
-	_, err := s.db.Exec(`INSERT INTO task_defs(def_id, name, executor, target_kind, target_ref, labels, default_payload_json, created_at) VALUES(?,?,?,?,?,?,?,?)`,
-		td.DefID, td.Name, td.Executor, td.TargetKind, td.TargetRef, string(labelsJSON), td.DefaultPayloadJSON, time.Now().Unix(),
-	)
+	_, err := s.withResourceVersion(func(tx *sql.Tx, rv int64) error {
+		_, err := tx.Exec(`INSERT INTO task_defs(def_id, name, executor, target_kind, target_ref, labels, default_payload_json, created_at, resource_version) VALUES(?,?,?,?,?,?,?,?,?)`,
+			td.DefID, td.Name, td.Executor, td.TargetKind, td.TargetRef, string(labelsJSON), td.DefaultPayloadJSON, time.Now().Unix(), rv,
+		)
+		return err
+	})
 	if err != nil {
 		return "", err
 	}
@@ -1298,10 +1697,10 @@ func (s *sqliteStore) CreateTaskDef(td store.TaskDefinition) (string, error) {
 }
 
 func (s *sqliteStore) GetTaskDef(id string) (store.TaskDefinition, bool, error) {
-	row := s.db.QueryRow(`SELECT def_id, name, executor, target_kind, target_ref, labels, default_payload_json, created_at FROM task_defs WHERE def_id=?`, id)
+	row := s.db.QueryRow(`SELECT def_id, name, executor, target_kind, target_ref, labels, default_payload_json, created_at, COALESCE(resource_version, 1) FROM task_defs WHERE def_id=?`, id)
 	var td store.TaskDefinition
 	var labelsStr string
-	if err := row.Scan(&td.DefID, &td.Name, &td.Executor, &td.TargetKind, &td.TargetRef, &labelsStr, &td.DefaultPayloadJSON, &td.CreatedAt); err != nil {
+	if err := row.Scan(&td.DefID, &td.Name, &td.Executor, &td.TargetKind, &td.TargetRef, &labelsStr, &td.DefaultPayloadJSON, &td.CreatedAt, &td.ResourceVersion); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return store.TaskDefinition{}, false, nil
 		}
@@ -1312,10 +1711,10 @@ func (s *sqliteStore) GetTaskDef(id string) (store.TaskDefinition, bool, error)
 }
 
 func (s *sqliteStore) GetTaskDefByName(name string) (store.TaskDefinition, bool, error) {
-	row := s.db.QueryRow(`SELECT def_id, name, executor, target_kind, target_ref, labels, default_payload_json, created_at FROM task_defs WHERE name=?`, name)
+	row := s.db.QueryRow(`SELECT def_id, name, executor, target_kind, target_ref, labels, default_payload_json, created_at, COALESCE(resource_version, 1) FROM task_defs WHERE name=?`, name)
 	var td store.TaskDefinition
 	var labelsStr string
-	if err := row.Scan(&td.DefID, &td.Name, &td.Executor, &td.TargetKind, &td.TargetRef, &labelsStr, &td.DefaultPayloadJSON, &td.CreatedAt); err != nil {
+	if err := row.Scan(&td.DefID, &td.Name, &td.Executor, &td.TargetKind, &td.TargetRef, &labelsStr, &td.DefaultPayloadJSON, &td.CreatedAt, &td.ResourceVersion); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return store.TaskDefinition{}, false, nil
 		}
@@ -1326,7 +1725,7 @@ func (s *sqliteStore) GetTaskDefByName(name string) (store.TaskDefinition, bool,
 }
 
 func (s *sqliteStore) ListTaskDefs() ([]store.TaskDefinition, error) {
-	rows, err := s.db.Query(`SELECT def_id, name, executor, target_kind, target_ref, labels, default_payload_json, created_at FROM task_defs ORDER BY created_at DESC, def_id DESC`)
+	rows, err := s.db.Query(`SELECT def_id, name, executor, target_kind, target_ref, labels, default_payload_json, created_at, COALESCE(resource_version, 1) FROM task_defs ORDER BY created_at DESC, def_id DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -1335,7 +1734,7 @@ func (s *sqliteStore) ListTaskDefs() ([]store.TaskDefinition, error) {
 	for rows.Next() {
 		var td store.TaskDefinition
 		var labelsStr string
-		if err := rows.Scan(&td.DefID, &td.Name, &td.Executor, &td.TargetKind, &td.TargetRef, &labelsStr, &td.DefaultPayloadJSON, &td.CreatedAt); err != nil {
+		if err := rows.Scan(&td.DefID, &td.Name, &td.Executor, &td.TargetKind, &td.TargetRef, &labelsStr, &td.DefaultPayloadJSON, &td.CreatedAt, &td.ResourceVersion); err != nil {
 			return nil, err
 		}
 		_ = json.Unmarshal([]byte(labelsStr), &td.Labels)
@@ -1344,7 +1743,32 @@ func (s *sqliteStore) ListTaskDefs() ([]store.TaskDefinition, error) {
 	return out, rows.Err()
 }
 
-func (s *sqliteStore) DeleteTaskDef(id string) error {
+// UpdateTaskDef 更新任务定义的可变字段（名称、执行器、目标、标签、默认载荷）
+func (s *sqliteStore) UpdateTaskDef(td store.TaskDefinition, ifMatch int64) (int64, error) {
+	labelsJSON, _ := json.Marshal(td.Labels)
+	return s.withResourceVersion(func(tx *sql.Tx, rv int64) error {
+		sqlStr := `UPDATE task_defs SET name=?, executor=?, target_kind=?, target_ref=?, labels=?, default_payload_json=?, resource_version=? WHERE def_id=?`
+		args := []any{td.Name, td.Executor, td.TargetKind, td.TargetRef, string(labelsJSON), td.DefaultPayloadJSON, rv, td.DefID}
+		if ifMatch > 0 {
+			sqlStr += ` AND resource_version=?`
+			args = append(args, ifMatch)
+		}
+		res, err := tx.Exec(sqlStr, args...)
+		if err != nil {
+			return err
+		}
+		return checkAffected(res)
+	})
+}
+
+func (s *sqliteStore) DeleteTaskDef(id string, ifMatch int64) error {
+	if ifMatch > 0 {
+		res, err := s.db.Exec(`DELETE FROM task_defs WHERE def_id=? AND resource_version=?`, id, ifMatch)
+		if err != nil {
+			return err
+		}
+		return checkAffected(res)
+	}
 	_, err := s.db.Exec(`DELETE FROM task_defs WHERE def_id=?`, id)
 	return err
 }
@@ -1360,22 +1784,34 @@ func (s *sqliteStore) CountTasksByOrigin(defID string) (int, error) {
 
 // ---- DistributedKV ----
 
-func (s *sqliteStore) PutKV(namespace, key, value, valueType string) error {
-	_, err := s.db.Exec(`
-		INSERT INTO distributed_kv(namespace, key, value, type, updated_at)
-		VALUES(?, ?, ?, ?, ?)
-		ON CONFLICT(namespace, key) DO UPDATE SET
-			value=excluded.value,
-			type=excluded.type,
-			updated_at=excluded.updated_at
-	`, namespace, key, value, valueType, time.Now().Unix())
-	return err
+func (s *sqliteStore) PutKV(namespace, key, value, valueType string, ifMatch int64) (int64, error) {
+	now := time.Now().Unix()
+	return s.withResourceVersion(func(tx *sql.Tx, rv int64) error {
+		if ifMatch > 0 {
+			res, err := tx.Exec(`UPDATE distributed_kv SET value=?, type=?, updated_at=?, resource_version=? WHERE namespace=? AND key=? AND resource_version=?`,
+				value, valueType, now, rv, namespace, key, ifMatch)
+			if err != nil {
+				return err
+			}
+			return checkAffected(res)
+		}
+		_, err := tx.Exec(`
+			INSERT INTO distributed_kv(namespace, key, value, type, updated_at, resource_version)
+			VALUES(?, ?, ?, ?, ?, ?)
+			ON CONFLICT(namespace, key) DO UPDATE SET
+				value=excluded.value,
+				type=excluded.type,
+				updated_at=excluded.updated_at,
+				resource_version=excluded.resource_version
+		`, namespace, key, value, valueType, now, rv)
+		return err
+	})
 }
 
 func (s *sqliteStore) GetKV(namespace, key string) (store.DistributedKV, bool, error) {
-	row := s.db.QueryRow(`SELECT namespace, key, value, type, updated_at FROM distributed_kv WHERE namespace=? AND key=?`, namespace, key)
+	row := s.db.QueryRow(`SELECT namespace, key, value, type, updated_at, COALESCE(resource_version, 1) FROM distributed_kv WHERE namespace=? AND key=?`, namespace, key)
 	var kv store.DistributedKV
-	if err := row.Scan(&kv.Namespace, &kv.Key, &kv.Value, &kv.Type, &kv.UpdatedAt); err != nil {
+	if err := row.Scan(&kv.Namespace, &kv.Key, &kv.Value, &kv.Type, &kv.UpdatedAt, &kv.ResourceVersion); err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return store.DistributedKV{}, false, nil
 		}
@@ -1384,13 +1820,20 @@ func (s *sqliteStore) GetKV(namespace, key string) (store.DistributedKV, bool, e
 	return kv, true, nil
 }
 
-func (s *sqliteStore) DeleteKV(namespace, key string) error {
+func (s *sqliteStore) DeleteKV(namespace, key string, ifMatch int64) error {
+	if ifMatch > 0 {
+		res, err := s.db.Exec(`DELETE FROM distributed_kv WHERE namespace=? AND key=? AND resource_version=?`, namespace, key, ifMatch)
+		if err != nil {
+			return err
+		}
+		return checkAffected(res)
+	}
 	_, err := s.db.Exec(`DELETE FROM distributed_kv WHERE namespace=? AND key=?`, namespace, key)
 	return err
 }
 
 func (s *sqliteStore) ListKVByNamespace(namespace string) ([]store.DistributedKV, error) {
-	rows, err := s.db.Query(`SELECT namespace, key, value, type, updated_at FROM distributed_kv WHERE namespace=? ORDER BY key ASC`, namespace)
+	rows, err := s.db.Query(`SELECT namespace, key, value, type, updated_at, COALESCE(resource_version, 1) FROM distributed_kv WHERE namespace=? ORDER BY key ASC`, namespace)
 	if err != nil {
 		return nil, err
 	}
@@ -1398,7 +1841,7 @@ func (s *sqliteStore) ListKVByNamespace(namespace string) ([]store.DistributedKV
 	var out []store.DistributedKV
 	for rows.Next() {
 		var kv store.DistributedKV
-		if err := rows.Scan(&kv.Namespace, &kv.Key, &kv.Value, &kv.Type, &kv.UpdatedAt); err != nil {
+		if err := rows.Scan(&kv.Namespace, &kv.Key, &kv.Value, &kv.Type, &kv.UpdatedAt, &kv.ResourceVersion); err != nil {
 			return nil, err
 		}
 		out = append(out, kv)
@@ -1407,7 +1850,7 @@ func (s *sqliteStore) ListKVByNamespace(namespace string) ([]store.DistributedKV
 }
 
 func (s *sqliteStore) ListKVByPrefix(namespace, prefix string) ([]store.DistributedKV, error) {
-	rows, err := s.db.Query(`SELECT namespace, key, value, type, updated_at FROM distributed_kv WHERE namespace=? AND key LIKE ? ORDER BY key ASC`, namespace, prefix+"%")
+	rows, err := s.db.Query(`SELECT namespace, key, value, type, updated_at, COALESCE(resource_version, 1) FROM distributed_kv WHERE namespace=? AND key LIKE ? ORDER BY key ASC`, namespace, prefix+"%")
 	if err != nil {
 		return nil, err
 	}
@@ -1415,7 +1858,7 @@ func (s *sqliteStore) ListKVByPrefix(namespace, prefix string) ([]store.Distribu
 	var out []store.DistributedKV
 	for rows.Next() {
 		var kv store.DistributedKV
-		if err := rows.Scan(&kv.Namespace, &kv.Key, &kv.Value, &kv.Type, &kv.UpdatedAt); err != nil {
+		if err := rows.Scan(&kv.Namespace, &kv.Key, &kv.Value, &kv.Type, &kv.UpdatedAt, &kv.ResourceVersion); err != nil {
 			return nil, err
 		}
 		out = append(out, kv)
@@ -1430,13 +1873,18 @@ func (s *sqliteStore) PutKVBatch(namespace string, kvs []store.DistributedKV) er
 	}
 	defer tx.Rollback()
 
+	rv, err := nextResourceVersion(tx)
+	if err != nil {
+		return err
+	}
 	stmt, err := tx.Prepare(`
-		INSERT INTO distributed_kv(namespace, key, value, type, updated_at)
-		VALUES(?, ?, ?, ?, ?)
+		INSERT INTO distributed_kv(namespace, key, value, type, updated_at, resource_version)
+		VALUES(?, ?, ?, ?, ?, ?)
 		ON CONFLICT(namespace, key) DO UPDATE SET
 			value=excluded.value,
 			type=excluded.type,
-			updated_at=excluded.updated_at
+			updated_at=excluded.updated_at,
+			resource_version=excluded.resource_version
 	`)
 	if err != nil {
 		return err
@@ -1445,7 +1893,7 @@ func (s *sqliteStore) PutKVBatch(namespace string, kvs []store.DistributedKV) er
 
 	now := time.Now().Unix()
 	for _, kv := range kvs {
-		if _, err := stmt.Exec(namespace, kv.Key, kv.Value, kv.Type, now); err != nil {
+		if _, err := stmt.Exec(namespace, kv.Key, kv.Value, kv.Type, now, rv); err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,204 @@
+package sqlitestore
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"github.com/manxisuo/plum/controller/internal/store"
+)
+
+// 任务查询（带过滤、排序和游标分页）
+
+const taskColumns = `task_id, name, executor, target_kind, target_ref, state, payload_json, result_json, error, timeout_sec, max_retries, attempt, scheduled_on, created_at, started_at, finished_at, labels, origin_task_id`
+
+// 允许排序的字段：API 名称 -> 列名
+var taskSortColumns = map[string]string{
+	"":           "created_at",
+	"createdAt":  "created_at",
+	"startedAt":  "started_at",
+	"finishedAt": "finished_at",
+	"name":       "name",
+}
+
+// taskCursor 游标内容：上一页最后一条记录的排序值和 task_id
+type taskCursor struct {
+	Sort string          `json:"s"`
+	Val  json.RawMessage `json:"v"`
+	ID   string          `json:"id"`
+}
+
+func encodeTaskCursor(sortBy string, t store.Task) string {
+	var v any
+	switch taskSortColumns[sortBy] {
+	case "started_at":
+		v = t.StartedAt
+	case "finished_at":
+		v = t.FinishedAt
+	case "name":
+		v = t.Name
+	default:
+		v = t.CreatedAt
+	}
+	raw, _ := json.Marshal(v)
+	bs, _ := json.Marshal(taskCursor{Sort: sortBy, Val: raw, ID: t.TaskID})
+	return base64.RawURLEncoding.EncodeToString(bs)
+}
+
+func decodeTaskCursor(sortBy string, s string) (any, string, error) {
+	bs, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, "", store.ErrInvalidCursor
+	}
+	var c taskCursor
+	if err := json.Unmarshal(bs, &c); err != nil || c.Sort != sortBy || c.ID == "" {
+		return nil, "", store.ErrInvalidCursor
+	}
+	if taskSortColumns[sortBy] == "name" {
+		var v string
+		if err := json.Unmarshal(c.Val, &v); err != nil {
+			return nil, "", store.ErrInvalidCursor
+		}
+		return v, c.ID, nil
+	}
+	var v int64
+	if err := json.Unmarshal(c.Val, &v); err != nil {
+		return nil, "", store.ErrInvalidCursor
+	}
+	return v, c.ID, nil
+}
+
+// jsonLabelPath 构造 json_extract 使用的路径，键名加引号以支持 "." 等字符
+func jsonLabelPath(key string) string {
+	return `$."` + strings.ReplaceAll(key, `"`, `\"`) + `"`
+}
+
+// selectorRequirementSQL 将单个标签选择条件翻译为针对 JSON 标签列的 SQL 条件
+func selectorRequirementSQL(column string, r store.Requirement) (string, []any) {
+	labels := `COALESCE(NULLIF(` + column + `,''),'{}')`
+	path := jsonLabelPath(r.Key)
+	val := `json_extract(` + labels + `, ?)`
+	placeholders := func() (string, []any) {
+		args := []any{path}
+		for _, v := range r.Values {
+			args = append(args, v)
+		}
+		return `(?` + strings.Repeat(`,?`, len(r.Values)-1) + `)`, args
+	}
+	switch r.Operator {
+	case store.SelectorEquals:
+		return val + `=?`, []any{path, r.Values[0]}
+	case store.SelectorNotEquals:
+		return `(json_type(` + labels + `, ?) IS NULL OR ` + val + `<>?)`, []any{path, path, r.Values[0]}
+	case store.SelectorIn:
+		ph, args := placeholders()
+		return val + ` IN ` + ph, args
+	case store.SelectorNotIn:
+		ph, args := placeholders()
+		return `(json_type(` + labels + `, ?) IS NULL OR ` + val + ` NOT IN ` + ph + `)`, append([]any{path}, args...)
+	case store.SelectorExists:
+		return `json_type(` + labels + `, ?) IS NOT NULL`, []any{path}
+	case store.SelectorDoesNotExist:
+		return `json_type(` + labels + `, ?) IS NULL`, []any{path}
+	}
+	return `0`, nil
+}
+
+func (s *sqliteStore) QueryTasks(q store.TaskQuery) ([]store.Task, string, error) {
+	col, ok := taskSortColumns[q.SortBy]
+	if !ok {
+		return nil, "", errors.New("unsupported sort field: " + q.SortBy)
+	}
+	where := []string{}
+	args := []any{}
+	if len(q.States) > 0 {
+		where = append(where, `state IN (?`+strings.Repeat(`,?`, len(q.States)-1)+`)`)
+		for _, st := range q.States {
+			args = append(args, st)
+		}
+	}
+	if q.Executor != "" {
+		where = append(where, `executor=?`)
+		args = append(args, q.Executor)
+	}
+	if q.Name != "" {
+		where = append(where, `name=?`)
+		args = append(args, q.Name)
+	}
+	if q.OriginTaskID != "" {
+		where = append(where, `origin_task_id=?`)
+		args = append(args, q.OriginTaskID)
+	}
+	if q.CreatedAfter > 0 {
+		where = append(where, `created_at>=?`)
+		args = append(args, q.CreatedAfter)
+	}
+	if q.CreatedBefore > 0 {
+		where = append(where, `created_at<?`)
+		args = append(args, q.CreatedBefore)
+	}
+	// 标签以 JSON 存储，使用 json_extract 过滤
+	for _, r := range q.Selector {
+		cond, condArgs := selectorRequirementSQL("labels", r)
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+	op, dir := "<", "DESC"
+	if q.Ascending {
+		op, dir = ">", "ASC"
+	}
+	if q.Cursor != "" {
+		v, id, err := decodeTaskCursor(q.SortBy, q.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		where = append(where, `(`+col+op+`? OR (`+col+`=? AND task_id`+op+`?))`)
+		args = append(args, v, v, id)
+	}
+	sqlStr := `SELECT ` + taskColumns + ` FROM tasks`
+	if len(where) > 0 {
+		sqlStr += ` WHERE ` + strings.Join(where, ` AND `)
+	}
+	sqlStr += ` ORDER BY ` + col + ` ` + dir + `, task_id ` + dir
+	if q.Limit > 0 {
+		// 多取一条用于判断是否还有下一页
+		sqlStr += ` LIMIT ?`
+		args = append(args, q.Limit+1)
+	}
+	rows, err := s.db.Query(sqlStr, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	var out []store.Task
+	for rows.Next() {
+		t, err := scanTask(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		out = append(out, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	next := ""
+	if q.Limit > 0 && len(out) > q.Limit {
+		out = out[:q.Limit]
+		next = encodeTaskCursor(q.SortBy, out[len(out)-1])
+	}
+	return out, next, nil
+}
+
+func scanTask(rows *sql.Rows) (store.Task, error) {
+	var t store.Task
+	var labelsStr string
+	var origin sql.NullString
+	if err := rows.Scan(&t.TaskID, &t.Name, &t.Executor, &t.TargetKind, &t.TargetRef, &t.State, &t.PayloadJSON, &t.ResultJSON, &t.Error, &t.TimeoutSec, &t.MaxRetries, &t.Attempt, &t.ScheduledOn, &t.CreatedAt, &t.StartedAt, &t.FinishedAt, &labelsStr, &origin); err != nil {
+		return store.Task{}, err
+	}
+	_ = json.Unmarshal([]byte(labelsStr), &t.Labels)
+	t.OriginTaskID = origin.String
+	return t, nil
+}
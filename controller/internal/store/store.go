@@ -1,12 +1,42 @@
 package store
 
-import "time"
+import (
+	"errors"
+	"time"
+)
 
 type Node struct {
-	NodeID   string
-	IP       string
-	Labels   map[string]string
-	LastSeen time.Time
+	NodeID    string
+	IP        string
+	Labels    map[string]string
+	LastSeen  time.Time
+	Resources *NodeResources // 最近一次心跳上报的资源（旧版 Agent 不上报时为 nil）
+	Status    NodeStatus     // 调度状态，心跳不会修改
+	APIPort   int            // Agent 本地 HTTP 接口端口（0 表示未启用）
+}
+
+// NodeStatus 节点调度状态
+type NodeStatus string
+
+const (
+	NodeSchedulable NodeStatus = "Schedulable" // 正常接收新实例
+	NodeCordoned    NodeStatus = "Cordoned"    // 维护模式：保留已有实例，不再接收新实例
+	NodeDraining    NodeStatus = "Draining"    // 不再接收新实例，并把已有实例迁移到其它节点
+)
+
+// NodeResources Agent 在心跳中上报的节点资源与运行环境
+type NodeResources struct {
+	CPUCores       int      `json:"cpuCores"`
+	CPULoad        float64  `json:"cpuLoad"` // 1 分钟平均负载
+	MemTotalMB     int64    `json:"memTotalMB"`
+	MemAvailableMB int64    `json:"memAvailableMB"`
+	DiskTotalMB    int64    `json:"diskTotalMB"` // Agent 数据目录所在磁盘
+	DiskFreeMB     int64    `json:"diskFreeMB"`
+	Instances      int      `json:"instances"` // 正在运行的实例数
+	Arch           string   `json:"arch"`      // amd64 / arm64 ...
+	OS             string   `json:"os"`
+	Docker         bool     `json:"docker"`           // 能否运行镜像应用
+	Images         []string `json:"images,omitempty"` // 本地已有镜像（repository:tag）
 }
 
 type DesiredState string
@@ -26,14 +56,30 @@ type Assignment struct {
 	StartCmd     string
 	AppName      string // 应用名称
 	AppVersion   string // 应用版本
+	// 接替该实例的新实例ID：期望状态为 Stopped 时表示已被故障转移隔离，
+	// 为 Running 时表示重平衡迁移中（新实例就绪后删除本实例）
+	SupersededBy  string
+	PreferredNode string // 故障转移前所在的节点（重平衡时优先迁回）
+
+	ConfigHash string // 创建实例时的配置版本（环境变量、配置集、密钥），变化后由发布控制器替换实例
 }
 
+// Fenced 实例已被故障转移接替，原节点恢复后必须停止
+func (a Assignment) Fenced() bool { return a.SupersededBy != "" && a.Desired == DesiredStopped }
+
 type InstanceStatus struct {
 	InstanceID string
 	Phase      string
 	ExitCode   int
-	Healthy    bool
+	Healthy    bool // 存活
+	Ready      bool // 就绪（就绪探针已通过）
 	TsUnix     int64
+	// RestartCount Agent 重启该实例的累计次数
+	RestartCount int
+	// Reason 退出原因：OOMKilled（内存超限被杀）| Error | Completed | PreStartHookFailed | PostStartHookFailed | PortConflict，运行中为空
+	Reason string
+	// Message 原因的补充说明（如失败钩子的输出末尾）
+	Message string
 }
 
 // Task (short job) minimal model for Phase A
@@ -58,6 +104,30 @@ type Task struct {
 	OriginTaskID string // for grouping reruns; empty means original
 }
 
+// TaskQuery filters, sorts and paginates tasks at the store level.
+// Zero values mean "no filter".
+type TaskQuery struct {
+	States        []string // match any of these states
+	Executor      string   // service | embedded | os_process
+	Name          string   // exact task name
+	Selector      Selector // label selector, evaluated in SQL
+	OriginTaskID  string   // tasks rerun from / created by this origin
+	CreatedAfter  int64    // created_at >= CreatedAfter (unix seconds)
+	CreatedBefore int64    // created_at < CreatedBefore (unix seconds)
+	SortBy        string   // createdAt (default) | startedAt | finishedAt | name
+	Ascending     bool     // default is descending
+	Limit         int      // page size; <= 0 means no limit
+	Cursor        string   // opaque cursor returned by previous page
+}
+
+// ErrConflict is returned by conditional writes when the stored
+// resourceVersion no longer matches the expected one.
+var ErrConflict = errors.New("resource version conflict")
+
+// ErrInvalidCursor is returned when a pagination cursor cannot be decoded
+// or was produced for a different sort order.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
 type Artifact struct {
 	ArtifactID     string
 	AppName        string
@@ -77,6 +147,27 @@ type DeploymentStatus string
 const (
 	DeploymentStopped DeploymentStatus = "Stopped"
 	DeploymentRunning DeploymentStatus = "Running"
+	// 任务型部署结束后的状态
+	DeploymentSucceeded DeploymentStatus = "Succeeded"
+	DeploymentFailed    DeploymentStatus = "Failed"
+)
+
+// DeploymentKind 部署类型
+type DeploymentKind string
+
+const (
+	KindService DeploymentKind = "Service" // 常驻服务（默认）：按副本数保持实例运行
+	KindJob     DeploymentKind = "Job"     // 任务：实例运行至完成，见 deployment 包中的任务控制器
+	// 守护：在每个匹配条目 nodeSelector 的节点上运行一个实例，节点加入或标签变化时自动增删
+	KindDaemonSet DeploymentKind = "DaemonSet"
+)
+
+// UpdateStrategy 部署更新策略
+type UpdateStrategy string
+
+const (
+	StrategyRolling  UpdateStrategy = "rolling"  // 逐步替换，受 MaxUnavailable / MaxSurge 约束
+	StrategyRecreate UpdateStrategy = "recreate" // 先停掉全部旧实例再创建新实例
 )
 
 type Deployment struct {
@@ -84,6 +175,116 @@ type Deployment struct {
 	Name         string
 	Labels       map[string]string
 	Status       DeploymentStatus // Stopped | Running
+	// ResourceVersion 每次写入单调递增，用于乐观并发控制（ETag / If-Match）
+	ResourceVersion int64
+	// 发布（rollout）相关
+	Strategy       UpdateStrategy
+	MaxUnavailable int  // 滚动更新期间允许不可用的实例数
+	MaxSurge       int  // 滚动更新期间允许超出期望副本数的实例数
+	Paused         bool // 暂停发布
+	Revision       int  // 目标修订版本号（0 表示尚无修订记录）
+	// ObservedRevision 已发布完成的修订号；与 Revision 相同时显式副本条目不再被调整（交由故障转移维护）
+	ObservedRevision int
+	RestartPolicy    RestartPolicy // 实例退出后 Agent 是否重启
+
+	// TerminationGracePeriodSec 停止实例时 preStop 钩子与优雅退出的总时限（秒，0 使用 Agent 默认值）
+	TerminationGracePeriodSec int
+
+	// 任务型部署（Kind 为 Job）
+	Kind         DeploymentKind // Service（默认）| Job | DaemonSet
+	Completions  int            // 需要成功完成的实例数
+	Parallelism  int            // 同时运行的实例数上限
+	BackoffLimit int            // 允许的失败次数（含实例内重启），超过后任务失败
+	// TTLSecondsAfterFinished 任务结束后自动删除的等待时间（秒，-1 表示不删除）
+	TTLSecondsAfterFinished int
+	// 任务计数只由 RecordJobResults / FinishJob 累加，UpdateDeployment 不写入
+	Succeeded  int   // 已成功完成的实例数
+	Failed     int   // 已结束实例的失败次数（失败退出和实例内重启）
+	FinishedAt int64 // 任务结束时间（unix 秒）
+}
+
+// JobResult 结束的任务实例计入部署的结果
+type JobResult struct {
+	InstanceID string
+	Succeeded  int // 成功完成为 1
+	Failed     int // 失败次数（失败退出和实例内重启）
+}
+
+// IsJob 是否为任务型部署
+func (d Deployment) IsJob() bool { return d.Kind == KindJob }
+
+// IsDaemonSet 是否为守护型部署
+func (d Deployment) IsDaemonSet() bool { return d.Kind == KindDaemonSet }
+
+// Finished 任务是否已结束（成功或失败）
+func (d Deployment) Finished() bool {
+	return d.Status == DeploymentSucceeded || d.Status == DeploymentFailed
+}
+
+// RestartPolicy 实例退出后的重启策略（由 Agent 执行，连续崩溃时指数退避）
+type RestartPolicy string
+
+const (
+	RestartAlways    RestartPolicy = "Always"    // 总是重启（默认）
+	RestartOnFailure RestartPolicy = "OnFailure" // 仅非 0 退出码时重启
+	RestartNever     RestartPolicy = "Never"     // 不重启
+)
+
+// DeploymentRevision 部署的一次修订（规格快照）
+type DeploymentRevision struct {
+	DeploymentID string
+	Revision     int
+	SpecJSON     string // deployment.Entry 列表的 JSON
+	Cause        string
+	CreatedAt    int64
+}
+
+// ConfigKind 配置对象类型
+type ConfigKind string
+
+const (
+	KindConfigMap ConfigKind = "ConfigMap"
+	KindSecret    ConfigKind = "Secret"
+)
+
+// ConfigObject 配置集或密钥：键值数据由部署条目引用，以环境变量或文件注入实例
+type ConfigObject struct {
+	Kind      ConfigKind
+	Name      string
+	Data      []byte // 键值 JSON；密钥为加密后的密文
+	UpdatedAt int64
+	// ResourceVersion 每次写入单调递增
+	ResourceVersion int64
+}
+
+// Event 集群事件：节点健康状态变化、实例迁移等，用于审计故障转移和重平衡
+type Event struct {
+	ID            int64
+	Time          int64  // unix 秒
+	Type          string // 见 events 包中的事件类型
+	Reason        string
+	NodeID        string
+	DeploymentID  string
+	InstanceID    string // 迁移前的实例
+	NewInstanceID string // 迁移后的实例
+	FromNode      string
+	ToNode        string
+	DurationMs    int64
+	Message       string
+}
+
+// EventQuery 事件过滤条件，零值表示不过滤
+type EventQuery struct {
+	Types        []string // 匹配任一类型
+	NodeID       string   // 匹配 NodeID / FromNode / ToNode
+	DeploymentID string
+	InstanceID   string // 匹配 InstanceID / NewInstanceID
+	Since        int64  // Time >= Since
+	Until        int64  // Time < Until
+	AfterID      int64  // ID > AfterID（增量读取，按 ID 升序）
+	BeforeID     int64  // ID < BeforeID（向前翻页）
+	Ascending    bool   // 默认按 ID 降序（最新在前）
+	Limit        int    // <= 0 表示不限
 }
 
 // Service endpoint exposed by an instance
@@ -98,6 +299,8 @@ type Endpoint struct {
 	Labels      map[string]string
 	Healthy     bool
 	LastSeen    int64
+	// ResourceVersion 端点定义变更时递增（心跳不改变）
+	ResourceVersion int64
 }
 
 // Embedded Worker capability (executor=embedded) - Legacy HTTP-based
@@ -207,6 +410,7 @@ type WorkflowDAG struct {
 	Nodes      map[string]WorkflowNode
 	Edges      []WorkflowEdge
 	StartNodes []string
+	Labels     map[string]string
 	CreatedAt  int64
 }
 
@@ -301,7 +505,10 @@ type Store interface {
 	UpsertNode(id string, n Node) error
 	GetNode(id string) (Node, bool, error)
 	ListNodes() ([]Node, error)
+	ListNodesBySelector(sel Selector) ([]Node, error)
 	DeleteNode(id string) error
+	// SetNodeStatus 设置节点调度状态（cordon / uncordon / drain）
+	SetNodeStatus(id string, status NodeStatus) error
 	ListAssignmentsForNode(nodeID string) ([]Assignment, error)
 	GetAssignment(instanceID string) (Assignment, bool, error)
 	AddAssignment(nodeID string, a Assignment) error
@@ -309,12 +516,20 @@ type Store interface {
 	DeleteStatusesForInstance(instanceID string) error
 	DeleteAssignmentsForDeployment(deploymentID string) error
 	UpdateAssignmentDesired(instanceID string, desired DesiredState) error
+	// SupersedeAssignment 故障转移：将实例置为 Stopped 并记录接替它的新实例
+	SupersedeAssignment(instanceID string, by string) error
+	// UpdateAssignmentSupersededBy 只更新接替者（重平衡迁移的开始与取消）
+	UpdateAssignmentSupersededBy(instanceID string, by string) error
+	// ListSupersededForNode 节点上已被接替、需要隔离（停止）的实例
+	ListSupersededForNode(nodeID string) ([]string, error)
 	AppendStatus(instanceID string, st InstanceStatus) error
 	LatestStatus(instanceID string) (InstanceStatus, bool, error)
 
 	CountAssignmentsByArtifactPath(path string) (int, error)
 	CountAssignmentsForNode(nodeID string) (int, error)
-	CreateDeployment(name string, labels map[string]string) (string, []string, error)
+	// CreateDeployment 在一个事务内创建部署（状态为 Stopped，写入 d 的名称、标签、更新策略和任务参数等）、
+	// 其实例 assigns（DeploymentID 和 InstanceID 由存储生成）和修订 1（rev 为 nil 时不记录），返回部署 ID 和实例 ID
+	CreateDeployment(d Deployment, assigns []Assignment, rev *DeploymentRevision) (string, []string, error)
 	NewInstanceID(deploymentID string) string
 
 	SaveArtifact(a Artifact) (string, error)
@@ -324,10 +539,46 @@ type Store interface {
 	DeleteArtifact(id string) error
 
 	ListDeployments() ([]Deployment, error)
+	ListDeploymentsBySelector(sel Selector) ([]Deployment, error)
+	// UpdateDeployment 原地更新部署的标签、更新策略、暂停标志和目标修订（名称不可变、任务计数不变），ifMatch>0 时做版本校验
+	UpdateDeployment(d Deployment, ifMatch int64) (int64, error)
+	// UpdateDeploymentRevision 同 UpdateDeployment，并在同一事务内追加修订 rev（规格与最新修订相同时不追加）、
+	// 把目标修订指向它；版本冲突时不留下修订。返回目标修订号和新的 resourceVersion
+	UpdateDeploymentRevision(d Deployment, rev DeploymentRevision, ifMatch int64) (int, int64, error)
+	// SetDeploymentPaused 只更新暂停标志，ifMatch>0 时做版本校验
+	SetDeploymentPaused(id string, paused bool, ifMatch int64) (int64, error)
 	GetDeployment(id string) (Deployment, bool, error)
-	DeleteDeployment(id string) error
+	// DeleteDeployment 在一个事务内删除部署及其修订、实例和实例状态；ifMatch>0 时做版本校验
+	DeleteDeployment(id string, ifMatch int64) error
 	UpdateDeploymentStatus(id string, status DeploymentStatus) error
+	// RecordJobResults 在一个事务内把结束的任务实例期望状态置为 Stopped 并把其结果累加到部署计数
+	// （已不是期望运行的实例跳过，每个实例只计一次），返回累加后的成功数和失败次数
+	RecordJobResults(deploymentID string, results []JobResult) (int, int, error)
+	// FinishJob 在一个事务内记录任务结果（状态、结束时间，失败次数累加 failed）并停止 stop 中的实例；
+	// 任务已不在运行（并发停止或删除）时返回 ErrConflict，不做修改
+	FinishJob(deploymentID string, status DeploymentStatus, failed int, finishedAt int64, stop []string) error
 	ListAssignmentsForDeployment(deploymentID string) ([]Assignment, error)
+	AddDeploymentRevision(rev DeploymentRevision) error
+	ListDeploymentRevisions(deploymentID string) ([]DeploymentRevision, error)
+	GetDeploymentRevision(deploymentID string, revision int) (DeploymentRevision, bool, error)
+
+	// Config maps / secrets
+	// PutConfigObject 创建或更新配置对象并返回新的 resourceVersion；ifMatch>0 时要求已存在且版本相等
+	PutConfigObject(o ConfigObject, ifMatch int64) (int64, error)
+	GetConfigObject(kind ConfigKind, name string) (ConfigObject, bool, error)
+	ListConfigObjects(kind ConfigKind) ([]ConfigObject, error)
+	DeleteConfigObject(kind ConfigKind, name string, ifMatch int64) error
+	// 实例配置快照（按 ConfigHash，内容不可变，加密保存）
+	PutConfigSnapshot(hash string, data []byte) error
+	GetConfigSnapshot(hash string) ([]byte, bool, error)
+	// PruneConfigSnapshots 删除不再被实例或修订引用的快照
+	PruneConfigSnapshots() error
+
+	// Events
+	AppendEvent(e Event) (int64, error)
+	QueryEvents(q EventQuery) ([]Event, error)
+	// PruneEvents 删除 Time 早于 before 的事件
+	PruneEvents(before int64) error
 
 	// Services / discovery
 	ReplaceEndpointsForInstance(nodeID string, instanceID string, eps []Endpoint) error
@@ -339,18 +590,23 @@ type Store interface {
 	// 添加单个端点（如果已存在则更新，不删除其他端点）
 	AddEndpoint(ep Endpoint) error
 	// 删除单个端点（通过主键）
-	DeleteEndpoint(serviceName string, instanceID string, ip string, port int, protocol string) error
-	// 更新单个端点信息
-	UpdateEndpoint(serviceName string, instanceID string, oldIP string, oldPort int, oldProtocol string, ep Endpoint) error
+	// ifMatch>0 时要求当前 resourceVersion 相等，否则返回 ErrConflict
+	DeleteEndpoint(serviceName string, instanceID string, ip string, port int, protocol string, ifMatch int64) error
+	// 更新单个端点信息，返回新的 resourceVersion
+	UpdateEndpoint(serviceName string, instanceID string, oldIP string, oldPort int, oldProtocol string, ep Endpoint, ifMatch int64) (int64, error)
 	ListEndpointsByService(serviceName string, version string, protocol string) ([]Endpoint, error)
 	// 列出服务的所有端点（包括不健康的，用于管理界面）
 	ListAllEndpointsByService(serviceName string) ([]Endpoint, error)
 	ListServices() ([]string, error)
+	// 列出至少有一个端点标签匹配选择器的服务
+	ListServicesBySelector(sel Selector) ([]string, error)
 
 	// Tasks (Phase A minimal)
 	CreateTask(t Task) (string, error)
 	GetTask(id string) (Task, bool, error)
 	ListTasks() ([]Task, error)
+	// QueryTasks 按条件查询任务，返回当前页和下一页游标（为空表示没有更多数据）
+	QueryTasks(q TaskQuery) ([]Task, string, error)
 	DeleteTask(id string) error
 	UpdateTaskState(id string, state string) error
 	UpdateTaskRunning(id string, startedAt int64, scheduledOn string, attempt int) error
@@ -367,6 +623,7 @@ type Store interface {
 	RegisterEmbeddedWorker(w EmbeddedWorker) error
 	HeartbeatEmbeddedWorker(workerID string, lastSeen int64) error
 	ListEmbeddedWorkers() ([]EmbeddedWorker, error)
+	ListEmbeddedWorkersBySelector(sel Selector) ([]EmbeddedWorker, error)
 	GetEmbeddedWorker(workerID string) (EmbeddedWorker, bool, error)
 	DeleteEmbeddedWorker(workerID string) error
 
@@ -401,6 +658,7 @@ type Store interface {
 	CreateWorkflowDAG(dag WorkflowDAG) (string, error)
 	GetWorkflowDAG(id string) (WorkflowDAG, bool, error)
 	ListWorkflowDAGs() ([]WorkflowDAG, error)
+	ListWorkflowDAGsBySelector(sel Selector) ([]WorkflowDAG, error)
 	DeleteWorkflowDAG(id string) error
 
 	// TaskDefinition (for reusable task templates)
@@ -408,15 +666,18 @@ type Store interface {
 	GetTaskDef(id string) (TaskDefinition, bool, error)
 	GetTaskDefByName(name string) (TaskDefinition, bool, error)
 	ListTaskDefs() ([]TaskDefinition, error)
-	DeleteTaskDef(id string) error
+	// UpdateTaskDef 更新任务定义（按 DefID），ifMatch>0 时做版本校验
+	UpdateTaskDef(td TaskDefinition, ifMatch int64) (int64, error)
+	DeleteTaskDef(id string, ifMatch int64) error
 
 	// References
 	CountTasksByOrigin(defID string) (int, error)
 
 	// DistributedKV (namespace-based key-value store)
-	PutKV(namespace, key, value, valueType string) error
+	// PutKV 写入键值并返回新的 resourceVersion；ifMatch>0 时要求键已存在且版本相等
+	PutKV(namespace, key, value, valueType string, ifMatch int64) (int64, error)
 	GetKV(namespace, key string) (DistributedKV, bool, error)
-	DeleteKV(namespace, key string) error
+	DeleteKV(namespace, key string, ifMatch int64) error
 	ListKVByNamespace(namespace string) ([]DistributedKV, error)
 	ListKVByPrefix(namespace, prefix string) ([]DistributedKV, error)
 	PutKVBatch(namespace string, kvs []DistributedKV) error
@@ -436,6 +697,7 @@ type TaskDefinition struct {
 	// DefaultPayloadJSON stores the default input for runs created from this definition
 	DefaultPayloadJSON string
 	CreatedAt          int64
+	ResourceVersion    int64
 }
 
 // DistributedKV stores key-value pairs with namespace isolation
@@ -445,6 +707,8 @@ type DistributedKV struct {
 	Value     string
 	Type      string // string|int|double|bool
 	UpdatedAt int64
+	// ResourceVersion 每次写入单调递增
+	ResourceVersion int64
 }
 
 var Current Store